@@ -0,0 +1,83 @@
+// Package atomictx decodes the imports and exports carried in the ExtData
+// of C-Chain blocks.
+package atomictx
+
+import (
+	"fmt"
+
+	cparams "github.com/ava-labs/avalanchego/graft/coreth/params"
+	"github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/atomic"
+	ccustomtypes "github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/customtypes"
+	"github.com/ava-labs/avalanchego/ids"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/params"
+
+	"block_fetcher/store"
+)
+
+// Decoder turns the ExtData of a block into store.AtomicTxs. The indexer
+// uses it to build the atomic tx index and the RPC methods to cover the
+// blocks the indexer has not reached yet.
+type Decoder struct {
+	chainCfg    *params.ChainConfig
+	avaxAssetID ids.ID
+}
+
+// NewDecoder returns a Decoder for the chain with config chainCfg, whose
+// fees are burned in avaxAssetID.
+func NewDecoder(chainCfg *params.ChainConfig, avaxAssetID ids.ID) *Decoder {
+	return &Decoder{chainCfg: chainCfg, avaxAssetID: avaxAssetID}
+}
+
+// BlockAtomicTxs returns the imports and exports in block's ExtData, nil if
+// it has none. The codec version follows the block's rules, the way the
+// executor decodes them before applying them.
+func (d *Decoder) BlockAtomicTxs(block *ethtypes.Block) ([]store.AtomicTx, error) {
+	extData := ccustomtypes.BlockExtData(block)
+	if len(extData) == 0 {
+		return nil, nil
+	}
+	rules := d.chainCfg.Rules(block.Number(), cparams.IsMergeTODO, block.Time())
+	isAP5 := false
+	if rulesExtra := cparams.GetRulesExtra(rules); rulesExtra != nil {
+		isAP5 = rulesExtra.AvalancheRules.IsApricotPhase5
+	}
+	atomicTxs, err := atomic.ExtractAtomicTxs(extData, isAP5, atomic.Codec)
+	if err != nil {
+		return nil, fmt.Errorf("extract atomic txs: %w", err)
+	}
+	out := make([]store.AtomicTx, 0, len(atomicTxs))
+	for _, atx := range atomicTxs {
+		burned, err := atx.UnsignedAtomicTx.Burned(d.avaxAssetID)
+		if err != nil {
+			return nil, fmt.Errorf("atomic tx %s: %w", atx.ID(), err)
+		}
+		rec := store.AtomicTx{TxID: atx.ID(), Burned: burned}
+		switch utx := atx.UnsignedAtomicTx.(type) {
+		case *atomic.UnsignedImportTx:
+			rec.Chain = utx.SourceChain
+			for _, o := range utx.Outs {
+				rec.Transfers = append(rec.Transfers, store.AtomicTransfer{
+					Address: o.Address,
+					AssetID: o.AssetID,
+					Amount:  o.Amount,
+				})
+			}
+		case *atomic.UnsignedExportTx:
+			rec.Export = true
+			rec.Chain = utx.DestinationChain
+			for _, in := range utx.Ins {
+				rec.Transfers = append(rec.Transfers, store.AtomicTransfer{
+					Address: in.Address,
+					AssetID: in.AssetID,
+					Amount:  in.Amount,
+					Nonce:   in.Nonce,
+				})
+			}
+		default:
+			return nil, fmt.Errorf("atomic tx %s: unknown type %T", atx.ID(), utx)
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
@@ -0,0 +1,163 @@
+package atomictx
+
+import (
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+
+	cparams "github.com/ava-labs/avalanchego/graft/coreth/params"
+	"github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/atomic"
+	ccustomtypes "github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/customtypes"
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/vms/components/avax"
+	"github.com/ava-labs/avalanchego/vms/secp256k1fx"
+	"github.com/ava-labs/libevm/common"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/params"
+	"github.com/ava-labs/libevm/trie"
+
+	"block_fetcher/store"
+)
+
+func TestMain(m *testing.M) {
+	ccustomtypes.Register()
+	cparams.RegisterExtras()
+	os.Exit(m.Run())
+}
+
+var (
+	testAVAX   = ids.ID{0xaa}
+	testANT    = ids.ID{0xbb}
+	testXChain = ids.ID{0x58}
+)
+
+// testImport imports 1000 nAVAX, crediting 990 to 0x01 and burning 10, and
+// 5 of testANT to 0x02.
+func testImport() *atomic.Tx {
+	return &atomic.Tx{UnsignedAtomicTx: &atomic.UnsignedImportTx{
+		SourceChain: testXChain,
+		ImportedInputs: []*avax.TransferableInput{
+			{
+				UTXOID: avax.UTXOID{TxID: ids.ID{1}},
+				Asset:  avax.Asset{ID: testAVAX},
+				In:     &secp256k1fx.TransferInput{Amt: 1000, Input: secp256k1fx.Input{SigIndices: []uint32{0}}},
+			},
+			{
+				UTXOID: avax.UTXOID{TxID: ids.ID{2}},
+				Asset:  avax.Asset{ID: testANT},
+				In:     &secp256k1fx.TransferInput{Amt: 5, Input: secp256k1fx.Input{SigIndices: []uint32{0}}},
+			},
+		},
+		Outs: []atomic.EVMOutput{
+			{Address: common.Address{19: 1}, Amount: 990, AssetID: testAVAX},
+			{Address: common.Address{19: 2}, Amount: 5, AssetID: testANT},
+		},
+	}}
+}
+
+// testExport exports 500 nAVAX from 0x03 at nonce 7, burning 20.
+func testExport() *atomic.Tx {
+	return &atomic.Tx{UnsignedAtomicTx: &atomic.UnsignedExportTx{
+		DestinationChain: testXChain,
+		Ins: []atomic.EVMInput{
+			{Address: common.Address{19: 3}, Amount: 500, AssetID: testAVAX, Nonce: 7},
+		},
+		ExportedOutputs: []*avax.TransferableOutput{{
+			Asset: avax.Asset{ID: testAVAX},
+			Out: &secp256k1fx.TransferOutput{
+				Amt:          480,
+				OutputOwners: secp256k1fx.OutputOwners{Threshold: 1, Addrs: []ids.ShortID{{1}}},
+			},
+		}},
+	}}
+}
+
+// testBlock returns a block at time 1 carrying txs in its ExtData, batched
+// if batch is set, the way blocks from ApricotPhase5 on do.
+func testBlock(t *testing.T, batch bool, txs ...*atomic.Tx) *ethtypes.Block {
+	t.Helper()
+	var (
+		extData []byte
+		err     error
+	)
+	if batch {
+		extData, err = atomic.Codec.Marshal(atomic.CodecVersion, txs)
+	} else {
+		extData, err = atomic.Codec.Marshal(atomic.CodecVersion, txs[0])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := &ethtypes.Header{Number: big.NewInt(1), Time: 1, Difficulty: big.NewInt(1)}
+	return ccustomtypes.NewBlockWithExtData(header, nil, nil, nil, trie.NewStackTrie(nil), extData, true)
+}
+
+// txID returns the ID the decoder gives tx.
+func txID(t *testing.T, tx *atomic.Tx) [32]byte {
+	t.Helper()
+	if err := tx.Sign(atomic.Codec, nil); err != nil {
+		t.Fatal(err)
+	}
+	return tx.ID()
+}
+
+func TestBlockAtomicTxs(t *testing.T) {
+	imp, exp := testImport(), testExport()
+	want := []store.AtomicTx{
+		{
+			TxID:   txID(t, imp),
+			Chain:  testXChain,
+			Burned: 10,
+			Transfers: []store.AtomicTransfer{
+				{Address: [20]byte{19: 1}, AssetID: testAVAX, Amount: 990},
+				{Address: [20]byte{19: 2}, AssetID: testANT, Amount: 5},
+			},
+		},
+		{
+			TxID:   txID(t, exp),
+			Export: true,
+			Chain:  testXChain,
+			Burned: 20,
+			Transfers: []store.AtomicTransfer{
+				{Address: [20]byte{19: 3}, AssetID: testAVAX, Amount: 500, Nonce: 7},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		chainCfg *params.ChainConfig
+		block    *ethtypes.Block
+		want     []store.AtomicTx
+	}{
+		{"batch", cparams.TestChainConfig, testBlock(t, true, imp, exp), want},
+		{"single before ApricotPhase5", cparams.TestApricotPhase4Config, testBlock(t, false, imp), want[:1]},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := NewDecoder(tc.chainCfg, testAVAX).BlockAtomicTxs(tc.block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %+v\nwant %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBlockAtomicTxsEmpty(t *testing.T) {
+	block := ethtypes.NewBlockWithHeader(&ethtypes.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1)})
+	got, err := NewDecoder(cparams.TestChainConfig, testAVAX).BlockAtomicTxs(block)
+	if err != nil || got != nil {
+		t.Fatalf("block without ExtData: %v, %v", got, err)
+	}
+}
+
+// A batch encoding under pre-ApricotPhase5 rules is not a single tx.
+func TestBlockAtomicTxsWrongCodec(t *testing.T) {
+	block := testBlock(t, true, testImport(), testExport())
+	if _, err := NewDecoder(cparams.TestApricotPhase4Config, testAVAX).BlockAtomicTxs(block); err == nil {
+		t.Fatal("decoded a batch with pre-ApricotPhase5 rules")
+	}
+}
@@ -0,0 +1,132 @@
+package chainquery
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	corethcore "github.com/ava-labs/avalanchego/graft/coreth/core"
+	"github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/core/vm"
+	"github.com/ava-labs/libevm/params"
+)
+
+// Gas price oracle settings, matching coreth's DefaultFullGPOConfig.
+const (
+	GPOBlocks     = 40
+	GPOPercentile = 40
+)
+
+var (
+	GPOMinPrice = big.NewInt(1) // acp176.MinGasPrice
+	GPOMaxPrice = big.NewInt(150 * params.GWei)
+)
+
+// SuggestTip samples the effective tips of every transaction in the last
+// GPOBlocks blocks up to head and returns the GPOPercentile-th one, clamped
+// to [GPOMinPrice, GPOMaxPrice].
+func SuggestTip(head uint64, readBlock func(num uint64) (*types.Block, error)) (*big.Int, error) {
+	var tips []*big.Int
+	for num := head; num > 0 && head-num < GPOBlocks; num-- {
+		block, err := readBlock(num)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range block.Transactions() {
+			tip, err := t.EffectiveGasTip(block.BaseFee())
+			if err != nil || tip.Sign() < 0 {
+				tip = new(big.Int)
+			}
+			tips = append(tips, tip)
+		}
+	}
+
+	price := new(big.Int).Set(GPOMinPrice)
+	if len(tips) > 0 {
+		sort.Slice(tips, func(i, j int) bool { return tips[i].Cmp(tips[j]) < 0 })
+		price = tips[(len(tips)-1)*GPOPercentile/100]
+	}
+	if price.Cmp(GPOMaxPrice) > 0 {
+		price = new(big.Int).Set(GPOMaxPrice)
+	}
+	if price.Cmp(GPOMinPrice) < 0 {
+		price = new(big.Int).Set(GPOMinPrice)
+	}
+	return price, nil
+}
+
+// GasCap is the highest gas limit EstimateGas should try: gas if set, else
+// gasLimit, lowered to what the sender's balance less value covers at a
+// non-zero feeCap. balance is only read in that last case.
+func GasCap(gas, gasLimit uint64, feeCap, value *big.Int, balance func() (*big.Int, error)) (uint64, error) {
+	hi := gas
+	if hi == 0 {
+		hi = gasLimit
+	}
+	if feeCap == nil || feeCap.Sign() == 0 {
+		return hi, nil
+	}
+	b, err := balance()
+	if err != nil {
+		return 0, err
+	}
+	available := new(big.Int).Set(b)
+	if value != nil {
+		if value.Cmp(available) >= 0 {
+			return 0, fmt.Errorf("insufficient funds for transfer")
+		}
+		available.Sub(available, value)
+	}
+	allowance := available.Div(available, feeCap)
+	if allowance.IsUint64() && allowance.Uint64() < hi {
+		hi = allowance.Uint64()
+	}
+	return hi, nil
+}
+
+// EstimateGas binary-searches the lowest gas limit up to hi at which run
+// succeeds, following go-ethereum's estimator: the lower bound starts just
+// under the gas used at hi. run executes the call with the given limit.
+// A call that fails at hi for a reason other than running out of gas is
+// reported with callErr.
+func EstimateGas(hi uint64, run func(gas uint64) (*corethcore.ExecutionResult, error), callErr func(*corethcore.ExecutionResult) error) (uint64, error) {
+	try := func(gas uint64) (*corethcore.ExecutionResult, bool, error) {
+		result, err := run(gas)
+		if err != nil {
+			if errors.Is(err, corethcore.ErrIntrinsicGas) {
+				return nil, true, nil // too low
+			}
+			return nil, false, err
+		}
+		return result, result.Failed(), nil
+	}
+
+	// A call that fails at the cap fails at any lower limit too.
+	result, failed, err := try(hi)
+	if err != nil {
+		return 0, err
+	}
+	if failed {
+		if result != nil && !errors.Is(result.Err, vm.ErrOutOfGas) {
+			return 0, callErr(result)
+		}
+		return 0, fmt.Errorf("gas required exceeds allowance (%d)", hi)
+	}
+
+	// Anything below the gas actually used is certain to fail.
+	lo := result.UsedGas - 1
+	for lo+1 < hi {
+		mid := lo + (hi-lo)/2
+		_, failed, err := try(mid)
+		if err != nil {
+			return 0, err
+		}
+		if failed {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hi, nil
+}
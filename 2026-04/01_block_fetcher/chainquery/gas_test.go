@@ -0,0 +1,119 @@
+package chainquery
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	corethcore "github.com/ava-labs/avalanchego/graft/coreth/core"
+	"github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/core/vm"
+	"github.com/ava-labs/libevm/params"
+)
+
+// tipTestBlock returns a block at baseFee holding a dynamic fee tx for each
+// of tips.
+func tipTestBlock(baseFee int64, tips ...int64) *types.Block {
+	var txs []*types.Transaction
+	for _, tip := range tips {
+		txs = append(txs, types.NewTx(&types.DynamicFeeTx{
+			GasTipCap: big.NewInt(tip),
+			GasFeeCap: big.NewInt(baseFee + tip),
+		}))
+	}
+	header := &types.Header{BaseFee: big.NewInt(baseFee)}
+	return types.NewBlockWithHeader(header).WithBody(types.Body{Transactions: txs})
+}
+
+func TestSuggestTip(t *testing.T) {
+	gwei := int64(params.GWei)
+	blocks := map[uint64]*types.Block{
+		1: tipTestBlock(1, 500*gwei), // outside the sampled blocks
+	}
+	for num := uint64(2); num <= GPOBlocks+1; num++ {
+		blocks[num] = tipTestBlock(25*gwei, int64(num)*gwei)
+	}
+	readBlock := func(num uint64) (*types.Block, error) {
+		return blocks[num], nil
+	}
+
+	tip, err := SuggestTip(GPOBlocks+1, readBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tips 2..41 gwei; the 40th percentile of 40 samples is the 16th.
+	if want := big.NewInt(17 * gwei); tip.Cmp(want) != 0 {
+		t.Fatalf("tip %v, want %v", tip, want)
+	}
+
+	for num := uint64(2); num <= GPOBlocks+1; num++ {
+		blocks[num] = tipTestBlock(1, 1000*gwei)
+	}
+	if tip, _ := SuggestTip(GPOBlocks+1, readBlock); tip.Cmp(GPOMaxPrice) != 0 {
+		t.Fatalf("tip %v, want the cap %v", tip, GPOMaxPrice)
+	}
+
+	empty := func(uint64) (*types.Block, error) { return tipTestBlock(1), nil }
+	if tip, _ := SuggestTip(5, empty); tip.Cmp(GPOMinPrice) != 0 {
+		t.Fatalf("tip without transactions %v, want %v", tip, GPOMinPrice)
+	}
+}
+
+func TestGasCap(t *testing.T) {
+	balance := func(b int64) func() (*big.Int, error) {
+		return func() (*big.Int, error) { return big.NewInt(b), nil }
+	}
+	unread := func() (*big.Int, error) { return nil, errors.New("balance read") }
+	for _, tc := range []struct {
+		name          string
+		gas, gasLimit uint64
+		feeCap, value *big.Int
+		balance       func() (*big.Int, error)
+		want          uint64
+		wantErr       bool
+	}{
+		{"block gas limit", 0, 8_000_000, nil, nil, unread, 8_000_000, false},
+		{"gas arg", 100_000, 8_000_000, big.NewInt(0), nil, unread, 100_000, false},
+		{"balance covers the limit", 0, 8_000_000, big.NewInt(1), nil, balance(10_000_000), 8_000_000, false},
+		{"balance lowers the limit", 0, 8_000_000, big.NewInt(10), big.NewInt(1_000_000), balance(3_000_000), 200_000, false},
+		{"value above the balance", 0, 8_000_000, big.NewInt(10), big.NewInt(5), balance(5), 0, true},
+	} {
+		got, err := GasCap(tc.gas, tc.gasLimit, tc.feeCap, tc.value, tc.balance)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("%s: GasCap = %d, %v; want %d (error %v)", tc.name, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+// estimateTestCall returns a call that needs need gas and uses used of it, failing with
+// ErrIntrinsicGas below intrinsic.
+func estimateTestCall(intrinsic, need, used uint64, revert error) func(uint64) (*corethcore.ExecutionResult, error) {
+	return func(gas uint64) (*corethcore.ExecutionResult, error) {
+		switch {
+		case gas < intrinsic:
+			return nil, corethcore.ErrIntrinsicGas
+		case revert != nil:
+			return &corethcore.ExecutionResult{UsedGas: intrinsic, Err: revert}, nil
+		case gas < need:
+			return &corethcore.ExecutionResult{UsedGas: gas, Err: vm.ErrOutOfGas}, nil
+		}
+		return &corethcore.ExecutionResult{UsedGas: used}, nil
+	}
+}
+
+func TestEstimateGas(t *testing.T) {
+	errReverted := errors.New("reverted with reason")
+	callErr := func(*corethcore.ExecutionResult) error { return errReverted }
+
+	// Refunds make the gas needed exceed the gas used.
+	gas, err := EstimateGas(1_000_000, estimateTestCall(21_000, 65_432, 50_000, nil), callErr)
+	if err != nil || gas != 65_432 {
+		t.Fatalf("EstimateGas = %d, %v; want 65432", gas, err)
+	}
+	if _, err := EstimateGas(60_000, estimateTestCall(21_000, 65_432, 50_000, nil), callErr); err == nil {
+		t.Fatal("call needing more than the cap was estimated")
+	}
+	if _, err := EstimateGas(1_000_000, estimateTestCall(21_000, 0, 0, vm.ErrExecutionReverted), callErr); !errors.Is(err, errReverted) {
+		t.Fatalf("reverting call: %v, want the call error", err)
+	}
+}
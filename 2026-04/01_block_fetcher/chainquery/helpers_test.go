@@ -0,0 +1,43 @@
+package chainquery
+
+import (
+	"testing"
+
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+// writeTestBlocks commits receipts for blocks 1..n, each with one tx whose
+// hash starts with the block number and one log from address 0x..num with
+// topic 0x..num, and builds the log and tx hash indexes up to indexed.
+func writeTestBlocks(t *testing.T, db *store.DB, n, indexed uint64) {
+	t.Helper()
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		if err := store.InitIndexWatermarks(tx, db); err != nil {
+			t.Fatal(err)
+		}
+		for num := uint64(1); num <= n; num++ {
+			receipts := []store.TxReceipt{{
+				TxHash: [32]byte{0: byte(num), 1: byte(num >> 8)},
+				Status: 1,
+				Logs:   []store.LogEntry{{Address: [20]byte{19: byte(num)}, Topics: [][32]byte{{31: byte(num)}}}},
+			}}
+			if err := store.WriteBlockReceipts(tx, db, num, receipts); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SetHeadBlock(tx, db, num); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if indexed == 0 {
+			return
+		}
+		for _, kind := range []store.IndexKind{store.IndexLogs, store.IndexTxHashes} {
+			if err := store.BuildIndex(tx, db, kind, 1, indexed); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,129 @@
+// Package chainquery holds the read-side queries the RPC server and the
+// light node both answer from the local database: log filtering, gas
+// estimation, the gas price oracle and transaction lookups.
+package chainquery
+
+import (
+	"fmt"
+
+	"github.com/RoaringBitmap/roaring/v2/roaring64"
+	ethereum "github.com/ava-labs/libevm"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+)
+
+// MaxUnfilteredLogRange caps the block range of a log query with no address
+// or topic criteria, which has no index to narrow it and reads the receipts
+// of every block in range.
+const MaxUnfilteredLogRange = 10000
+
+// LogBlocks returns the blocks in [from, to] whose receipts can hold a log
+// matching q: the candidates of the log indexes plus the blocks they do not
+// cover yet, or the whole range when q has no address or topic criteria.
+// The whole range is refused above MaxUnfilteredLogRange blocks, and the
+// uncovered blocks above MaxUnindexedScan while the indexer catches up.
+func LogBlocks(tx *mdbx.Txn, db *store.DB, q ethereum.FilterQuery, from, to uint64) (*roaring64.Bitmap, error) {
+	candidates, err := logCandidates(tx, db, q, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if candidates == nil {
+		if to-from >= MaxUnfilteredLogRange {
+			return nil, fmt.Errorf("block range too large: %d blocks (max %d without an address or topic filter)", to-from+1, MaxUnfilteredLogRange)
+		}
+		candidates = roaring64.NewBitmap()
+		candidates.AddRange(from, to+1)
+	} else if unindexed, head := store.UnindexedRange(tx, db, store.IndexLogs); max(unindexed, from) <= min(to, head) {
+		// The log indexes do not cover the newest blocks yet; their receipts
+		// are checked directly. Blocks past the head have none.
+		tailFrom, tailTo := max(unindexed, from), min(to, head)
+		if tailTo-tailFrom >= MaxUnindexedScan {
+			return nil, fmt.Errorf("logs are still being indexed (indexed to block %d, head %d)", unindexed-1, head)
+		}
+		candidates.AddRange(tailFrom, tailTo+1)
+	}
+	return candidates, nil
+}
+
+// logCandidates narrows [from, to] to the blocks that can hold a matching log,
+// using the address and topic bitmap indexes: OR within the address list and
+// within each topic position, AND across them. Returns nil when q has no
+// address or topic criteria, i.e. every block in range is a candidate.
+func logCandidates(tx *mdbx.Txn, db *store.DB, q ethereum.FilterQuery, from, to uint64) (*roaring64.Bitmap, error) {
+	var result *roaring64.Bitmap
+	intersect := func(bm *roaring64.Bitmap) {
+		if result == nil {
+			result = bm
+		} else {
+			result.And(bm)
+		}
+	}
+
+	if len(q.Addresses) > 0 {
+		union := roaring64.NewBitmap()
+		for _, addr := range q.Addresses {
+			bm, err := store.ReadAddressLogIndex(tx, db, addr, from, to)
+			if err != nil {
+				return nil, fmt.Errorf("read address log index: %w", err)
+			}
+			union.Or(bm)
+		}
+		intersect(union)
+	}
+
+	for _, topicValues := range q.Topics {
+		if len(topicValues) == 0 {
+			continue
+		}
+		if result != nil && result.IsEmpty() {
+			break
+		}
+		union := roaring64.NewBitmap()
+		for _, topic := range topicValues {
+			bm, err := store.ReadTopicLogIndex(tx, db, topic, from, to)
+			if err != nil {
+				return nil, fmt.Errorf("read topic log index: %w", err)
+			}
+			union.Or(bm)
+		}
+		intersect(union)
+	}
+	return result, nil
+}
+
+// MatchesLog checks l against the address and topic criteria of q. An empty
+// topic position is a wildcard.
+func MatchesLog(q ethereum.FilterQuery, l store.LogEntry) bool {
+	if len(q.Addresses) > 0 {
+		found := false
+		for _, a := range q.Addresses {
+			if a == l.Address {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for i, topicValues := range q.Topics {
+		if len(topicValues) == 0 {
+			continue
+		}
+		if i >= len(l.Topics) {
+			return false
+		}
+		found := false
+		for _, t := range topicValues {
+			if t == l.Topics[i] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
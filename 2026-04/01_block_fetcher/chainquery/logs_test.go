@@ -0,0 +1,115 @@
+package chainquery
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	ethereum "github.com/ava-labs/libevm"
+	"github.com/ava-labs/libevm/common"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+func TestMatchesLog(t *testing.T) {
+	l := store.LogEntry{Address: [20]byte{19: 1}, Topics: [][32]byte{{31: 1}, {31: 2}}}
+	for _, tc := range []struct {
+		name string
+		q    ethereum.FilterQuery
+		want bool
+	}{
+		{"no criteria", ethereum.FilterQuery{}, true},
+		{"address", ethereum.FilterQuery{Addresses: []common.Address{{19: 2}, {19: 1}}}, true},
+		{"other address", ethereum.FilterQuery{Addresses: []common.Address{{19: 2}}}, false},
+		{"second topic", ethereum.FilterQuery{Topics: [][]common.Hash{nil, {{31: 2}}}}, true},
+		{"topic in the wrong position", ethereum.FilterQuery{Topics: [][]common.Hash{{{31: 2}}}}, false},
+		{"more topics than the log", ethereum.FilterQuery{Topics: [][]common.Hash{nil, nil, {{31: 3}}}}, false},
+		{"address and topic", ethereum.FilterQuery{Addresses: []common.Address{{19: 1}}, Topics: [][]common.Hash{{{31: 9}, {31: 1}}}}, true},
+	} {
+		if got := MatchesLog(tc.q, l); got != tc.want {
+			t.Errorf("%s: MatchesLog = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLogBlocks(t *testing.T) {
+	db := storetest.Open(t, store.Open)
+	writeTestBlocks(t, db, 6, 4)
+
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		for _, tc := range []struct {
+			name     string
+			q        ethereum.FilterQuery
+			from, to uint64
+			want     []uint64
+		}{
+			// The indexes cover blocks 1-4; 5 and 6 are always candidates.
+			{"address", ethereum.FilterQuery{Addresses: []common.Address{{19: 2}}}, 1, 6, []uint64{2, 5, 6}},
+			{"topic", ethereum.FilterQuery{Topics: [][]common.Hash{{{31: 3}, {31: 4}}}}, 1, 6, []uint64{3, 4, 5, 6}},
+			{"address and topic", ethereum.FilterQuery{Addresses: []common.Address{{19: 2}}, Topics: [][]common.Hash{{{31: 3}}}}, 1, 6, []uint64{5, 6}},
+			{"indexed range", ethereum.FilterQuery{Addresses: []common.Address{{19: 2}}}, 1, 3, []uint64{2}},
+			{"unfiltered", ethereum.FilterQuery{}, 2, 4, []uint64{2, 3, 4}},
+		} {
+			bm, err := LogBlocks(tx, db, tc.q, tc.from, tc.to)
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			if got := bm.ToArray(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("%s: blocks %v, want %v", tc.name, got, tc.want)
+			}
+		}
+
+		if _, err := LogBlocks(tx, db, ethereum.FilterQuery{}, 1, MaxUnfilteredLogRange+1); err == nil {
+			t.Fatal("unfiltered range above the cap was accepted")
+		}
+		if _, err := LogBlocks(tx, db, ethereum.FilterQuery{}, 1, MaxUnfilteredLogRange); err != nil {
+			t.Fatalf("unfiltered range at the cap: %v", err)
+		}
+		if _, err := LogBlocks(tx, db, ethereum.FilterQuery{Addresses: []common.Address{{19: 1}}}, 1, 2*MaxUnfilteredLogRange); err != nil {
+			t.Fatalf("filtered range above the cap: %v", err)
+		}
+	})
+}
+
+func TestLogBlocksUnindexedTail(t *testing.T) {
+	db := storetest.Open(t, store.Open)
+	writeTestBlocks(t, db, 6, 4)
+	head := uint64(4 + MaxUnindexedScan + 1)
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		if err := store.SetHeadBlock(tx, db, head); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	q := ethereum.FilterQuery{Addresses: []common.Address{{19: 2}}}
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+		if _, err := LogBlocks(tx, db, q, 1, head); err == nil || !strings.Contains(err.Error(), "still being indexed") {
+			t.Fatalf("filtered query over %d unindexed blocks: %v", head-4, err)
+		}
+		// The cap counts only the part of the tail in range.
+		bm, err := LogBlocks(tx, db, q, 1, head-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := bm.GetCardinality(); got != MaxUnindexedScan+1 {
+			t.Fatalf("%d candidates, want block 2 and the %d unindexed blocks", got, MaxUnindexedScan)
+		}
+		if _, err := LogBlocks(tx, db, q, head-10, head); err != nil {
+			t.Fatalf("recent blocks: %v", err)
+		}
+		// Blocks past the head are not candidates.
+		if _, err := LogBlocks(tx, db, q, head-10, head+MaxUnindexedScan); err != nil {
+			t.Fatalf("range past the head: %v", err)
+		}
+		// A range wholly above the head has no tail to scan.
+		bm, err = LogBlocks(tx, db, q, head+1, head+2*MaxUnindexedScan)
+		if err != nil {
+			t.Fatalf("range above the head: %v", err)
+		}
+		if !bm.IsEmpty() {
+			t.Fatalf("range above the head: candidates %v", bm.ToArray())
+		}
+	})
+}
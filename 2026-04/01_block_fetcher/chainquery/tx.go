@@ -0,0 +1,47 @@
+package chainquery
+
+import (
+	"fmt"
+
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+)
+
+// MaxUnindexedScan is how many blocks above an index's watermark a lookup
+// walks through directly. The indexer normally trails the head by a batch
+// at most; a wider gap means it is still catching up.
+const MaxUnindexedScan = 1024
+
+// TxLocation looks txHash up in TxHashIndex and, failing that, in the
+// receipts of the newest blocks the indexer has not reached yet. Returns
+// mdbx.ErrNotFound if it is in neither, or an error if the index trails the
+// head by more blocks than are searched, as the tx may be in the others.
+func TxLocation(tx *mdbx.Txn, db *store.DB, txHash [32]byte) (uint64, uint16, error) {
+	blockNum, txIndex, err := store.GetTxLocation(tx, db, txHash)
+	if !mdbx.IsNotFound(err) {
+		return blockNum, txIndex, err
+	}
+	indexedFrom, to := store.UnindexedRange(tx, db, store.IndexTxHashes)
+	from := indexedFrom
+	if to >= from+MaxUnindexedScan {
+		from = to - MaxUnindexedScan + 1
+	}
+	// Block 0 has no transactions, and stopping above it keeps n from
+	// wrapping.
+	for n := to; n >= from && n > 0; n-- {
+		receipts, rerr := store.ReadBlockReceipts(tx, db, n)
+		if rerr != nil {
+			return 0, 0, rerr
+		}
+		for i, r := range receipts {
+			if r.TxHash == txHash {
+				return n, uint16(i), nil
+			}
+		}
+	}
+	if from > indexedFrom {
+		return 0, 0, fmt.Errorf("transactions are still being indexed (indexed to block %d, head %d)", indexedFrom-1, to)
+	}
+	return 0, 0, err
+}
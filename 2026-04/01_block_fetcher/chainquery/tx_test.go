@@ -0,0 +1,54 @@
+package chainquery
+
+import (
+	"testing"
+
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+func TestTxLocation(t *testing.T) {
+	db := storetest.Open(t, store.Open)
+	writeTestBlocks(t, db, 6, 4)
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		// Block 2 is indexed, block 6 is found by scanning its receipts.
+		for _, num := range []uint64{2, 6} {
+			blockNum, txIndex, err := TxLocation(tx, db, [32]byte{0: byte(num)})
+			if err != nil || blockNum != num || txIndex != 0 {
+				t.Fatalf("tx of block %d at %d/%d: %v", num, blockNum, txIndex, err)
+			}
+		}
+		if _, _, err := TxLocation(tx, db, [32]byte{0: 9}); !mdbx.IsNotFound(err) {
+			t.Fatalf("unknown tx: %v", err)
+		}
+	})
+}
+
+func TestTxLocationStillIndexing(t *testing.T) {
+	db := storetest.Open(t, store.Open)
+	writeTestBlocks(t, db, MaxUnindexedScan+3, 0)
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		// Block 1 is below the scanned window.
+		if _, _, err := TxLocation(tx, db, [32]byte{0: 1}); err == nil || mdbx.IsNotFound(err) {
+			t.Fatalf("tx below the scanned window: %v, want still indexing", err)
+		}
+		num := uint64(MaxUnindexedScan + 3)
+		if blockNum, _, err := TxLocation(tx, db, [32]byte{0: byte(num), 1: byte(num >> 8)}); err != nil || blockNum != num {
+			t.Fatalf("tx of block %d at %d: %v", num, blockNum, err)
+		}
+	})
+
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		if err := store.BuildIndex(tx, db, store.IndexTxHashes, 1, 3); err != nil {
+			t.Fatal(err)
+		}
+	})
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		// With the gap inside the window an unknown hash is not found.
+		if _, _, err := TxLocation(tx, db, [32]byte{0: 0xff, 1: 0xff}); !mdbx.IsNotFound(err) {
+			t.Fatalf("unknown tx: %v", err)
+		}
+	})
+}
@@ -71,16 +71,15 @@ func main() {
 			break
 		}
 
-		compressed, err := srcTx.Get(src.Containers, cid)
+		// Packed containers are only pointers into the source's segment
+		// files, so copy the decoded container.
+		raw, err := store.GetContainer(srcTx, src, [32]byte(cid))
 		if err != nil {
 			log.Fatalf("get container %d: %v", blockNum, err)
 		}
-		if err := dstTx.Put(dst.Containers, cid, compressed, 0); err != nil {
+		if err := store.PutContainer(dstTx, dst, [32]byte(cid), blockNum, raw); err != nil {
 			log.Fatalf("put destination container %d: %v", blockNum, err)
 		}
-		if err := dstTx.Put(dst.ContainerIndex, k, cid, 0); err != nil {
-			log.Fatalf("put destination index %d: %v", blockNum, err)
-		}
 
 		copied++
 		if copied%100000 == 0 {
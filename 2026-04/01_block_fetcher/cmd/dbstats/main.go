@@ -8,6 +8,8 @@ import (
 	"sort"
 
 	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
 )
 
 var tableNames = []string{
@@ -19,6 +21,8 @@ var tableNames = []string{
 	"StorageState",
 	"AddressIndex",
 	"SlotIndex",
+	"AddressByID",
+	"SlotByID",
 	"Changesets",
 	"HistoryIndex",
 	"AccountTrie",
@@ -31,6 +35,8 @@ var tableNames = []string{
 	"TxHashIndex",
 	"AddressLogIndex",
 	"TopicLogIndex",
+	"AtomicTxs",
+	"AtomicAddressIndex",
 }
 
 type tableStat struct {
@@ -47,22 +53,13 @@ func main() {
 	dbDir := flag.String("db-dir", "data/mainnet-mdbx", "MDBX database directory")
 	flag.Parse()
 
-	env, err := mdbx.NewEnv(mdbx.Label("dbstats"))
+	// Read-only, so it can run next to a syncing block_fetcher.
+	db, err := store.OpenReadOnly(*dbDir)
 	if err != nil {
-		log.Fatalf("new env: %v", err)
-	}
-	defer env.Close()
-
-	if err := env.SetOption(mdbx.OptMaxDB, 24); err != nil {
-		log.Fatalf("set maxdb: %v", err)
-	}
-	flags := uint(mdbx.Readonly | mdbx.NoReadahead | mdbx.NoStickyThreads)
-	if err := env.Open(*dbDir, flags, 0644); err != nil {
-		log.Fatalf("open env: %v", err)
-	}
-	if stale, err := env.ReaderCheck(); err == nil && stale > 0 {
-		log.Printf("released %d stale readers", stale)
+		log.Fatalf("open db: %v", err)
 	}
+	defer db.Close()
+	env := db.Env()
 
 	envStat, err := env.Stat()
 	if err != nil {
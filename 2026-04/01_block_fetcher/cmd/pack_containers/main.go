@@ -0,0 +1,73 @@
+// Command pack_containers moves the stored containers out of the MDBX
+// Containers table into ZSTD block-pack segment files next to the env, then
+// compacts the env so the freed space is returned to the filesystem. It can
+// be re-run at any time to pack blocks stored since; the node must be
+// stopped.
+package main
+
+import (
+	"flag"
+	"log"
+	"runtime"
+	"time"
+
+	"block_fetcher/store"
+)
+
+func main() {
+	dbDir := flag.String("db-dir", "data/mainnet-mdbx", "MDBX database directory")
+	packsPerTx := flag.Int("packs-per-tx", 256, "block packs moved per write transaction")
+	compact := flag.Bool("compact", true, "compact the MDBX env after packing")
+	flag.Parse()
+
+	db, err := store.Open(*dbDir)
+	if err != nil {
+		log.Fatalf("open DB: %v", err)
+	}
+
+	runtime.LockOSThread()
+	start := time.Now()
+	var first, packedTo uint64
+	for n := 0; ; n++ {
+		tx, err := db.BeginRW()
+		if err != nil {
+			log.Fatalf("begin RW tx: %v", err)
+		}
+		prev := store.GetPackedTo(tx, db)
+		if n == 0 {
+			first = prev
+		}
+		packedTo, err = store.PackContainers(tx, db, *packsPerTx)
+		if err != nil {
+			tx.Abort()
+			log.Fatalf("pack containers after block %d: %v", prev, err)
+		}
+		if packedTo == prev {
+			tx.Abort()
+			break
+		}
+		if _, err := tx.Commit(); err != nil {
+			log.Fatalf("commit packs through block %d: %v", packedTo, err)
+		}
+		if n%10 == 0 {
+			elapsed := time.Since(start)
+			log.Printf("packed through block %d (%.0f blk/s)", packedTo, float64(packedTo-first)/elapsed.Seconds())
+		}
+	}
+	runtime.UnlockOSThread()
+	db.Close()
+	if packedTo > first {
+		log.Printf("packed blocks %d-%d in %s", first+1, packedTo, time.Since(start).Round(time.Second))
+	}
+	log.Printf("blocks after %d stay in MDBX until a full pack of %d is stored", packedTo, store.PackBlocks)
+
+	if !*compact {
+		return
+	}
+	log.Printf("compacting %s", *dbDir)
+	before, after, err := store.Compact(*dbDir)
+	if err != nil {
+		log.Fatalf("compact: %v", err)
+	}
+	log.Printf("compacted mdbx.dat from %.1f GB to %.1f GB", float64(before)/(1<<30), float64(after)/(1<<30))
+}
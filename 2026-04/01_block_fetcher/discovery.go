@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"sort"
+
+	"github.com/ava-labs/avalanchego/api/info"
+	"github.com/ava-labs/avalanchego/genesis"
+	"github.com/ava-labs/avalanchego/ids"
+	avaconstants "github.com/ava-labs/avalanchego/utils/constants"
+	"github.com/ava-labs/avalanchego/utils/set"
+	"github.com/ava-labs/avalanchego/vms/platformvm"
+
+	"block_fetcher/profile"
+)
+
+// Peer discovery modes.
+const (
+	// discoveryAPI asks an AvalancheGo node's info and platform APIs for the
+	// validator set and peer IPs.
+	discoveryAPI = "api"
+	// discoveryP2P needs no node of its own: it dials the network's built-in
+	// bootstrappers and learns validator IPs from PeerList gossip.
+	discoveryP2P = "p2p"
+)
+
+// discovery is what the fetcher needs, besides the chain's profile, before
+// it can open the network.
+type discovery struct {
+	// allowed are the nodes the fetcher sends requests to.
+	allowed set.Set[ids.NodeID]
+	// seeds are dialed right away.
+	seeds []peerSeed
+	// validators are registered with the network's validator set for the
+	// chain's subnet, so the IPs that peers gossip for them get verified and
+	// dialed. Only set in p2p mode.
+	validators    []ids.NodeID
+	validatorOnly bool
+}
+
+type peerSeed struct {
+	id   ids.NodeID
+	addr netip.AddrPort
+}
+
+// discoverFromAPI loads the validators and peers of prof's chain from the
+// AvalancheGo node at nodeURI, after checking that the node is on prof's
+// network.
+func discoverFromAPI(ctx context.Context, nodeURI string, prof *profile.Profile) (*discovery, error) {
+	infoClient := info.NewClient(nodeURI)
+	pClient := platformvm.NewClient(nodeURI)
+
+	networkID, err := infoClient.GetNetworkID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("info.getNetworkID: %w", err)
+	}
+	if networkID != prof.NetworkID {
+		return nil, fmt.Errorf("node is on network %d, want %d (%s)", networkID, prof.NetworkID, prof.Name)
+	}
+
+	validatorIDs, err := loadValidatorIDs(ctx, pClient, prof.SubnetID)
+	if err != nil {
+		return nil, fmt.Errorf("platform.getCurrentValidators: %w", err)
+	}
+	log.Printf("validator set loaded: count=%d", len(validatorIDs))
+
+	peerInfos, validatorOnly, err := discoverPeers(ctx, infoClient, validatorIDs)
+	if err != nil {
+		return nil, fmt.Errorf("discover peers: %w", err)
+	}
+	if len(peerInfos) == 0 {
+		return nil, fmt.Errorf("no peers available from info.peers")
+	}
+
+	d := &discovery{
+		allowed:       set.NewSet[ids.NodeID](len(peerInfos)),
+		seeds:         make([]peerSeed, 0, len(peerInfos)),
+		validatorOnly: validatorOnly,
+	}
+	for _, peerInfo := range peerInfos {
+		d.allowed.Add(peerInfo.ID)
+		d.seeds = append(d.seeds, peerSeed{id: peerInfo.ID, addr: peerAddr(peerInfo)})
+	}
+	return d, nil
+}
+
+// discoverFromP2P builds the discovery for prof's chain without a node of its
+// own. The validator set comes from validatorsFile when given, otherwise from
+// platform.getCurrentValidators at validatorsURI, the network's public API by
+// default; only with a pinned validatorsFile does it need no HTTP endpoint.
+// The snapshot AvalancheGo ships with is not used: it goes stale as
+// validators leave, and nothing in it tells how old it is. The network's
+// bootstrappers are the seeds; the network pulls PeerList gossip from them
+// and dials the validators it learns about.
+func discoverFromP2P(ctx context.Context, prof *profile.Profile, validatorsFile, validatorsURI string) (*discovery, error) {
+	networkID := prof.NetworkID
+	var (
+		validatorIDs []ids.NodeID
+		source       string
+		err          error
+	)
+	if validatorsFile != "" {
+		validatorIDs, err = loadValidatorsFile(validatorsFile)
+		source = validatorsFile
+	} else {
+		validatorIDs, err = fetchValidators(ctx, validatorsURI, prof)
+		source = validatorsURI
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(validatorIDs) == 0 {
+		return nil, fmt.Errorf("no validators known for %s", prof.Name)
+	}
+	log.Printf("validator set loaded: count=%d source=%s", len(validatorIDs), source)
+
+	bootstrappers := genesis.GetBootstrappers(networkID)
+	if len(bootstrappers) == 0 {
+		return nil, fmt.Errorf("no bootstrappers known for network %d", networkID)
+	}
+
+	d := &discovery{
+		allowed:       set.Of(validatorIDs...),
+		seeds:         make([]peerSeed, 0, len(bootstrappers)),
+		validators:    validatorIDs,
+		validatorOnly: true,
+	}
+	for _, b := range bootstrappers {
+		// Bootstrappers validate the primary network, not an L1's chain.
+		if prof.SubnetID == avaconstants.PrimaryNetworkID {
+			d.allowed.Add(b.ID)
+		}
+		d.seeds = append(d.seeds, peerSeed{id: b.ID, addr: b.IP})
+	}
+	return d, nil
+}
+
+// loadValidatorsFile reads a pinned validator snapshot: a JSON array of node
+// IDs such as `jq '[.result.validators[].nodeID]'` makes from a
+// platform.getCurrentValidators response.
+func loadValidatorsFile(path string) ([]ids.NodeID, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read validators file: %w", err)
+	}
+	var nodeIDs []ids.NodeID
+	if err := json.Unmarshal(raw, &nodeIDs); err != nil {
+		return nil, fmt.Errorf("decode validators file %s: %w", path, err)
+	}
+	unique := set.Of(nodeIDs...).List()
+	sortNodeIDs(unique)
+	return unique, nil
+}
+
+// fetchValidators loads the current validators of prof's subnet from the
+// platform API at uri, after checking that the node is on prof's network.
+func fetchValidators(ctx context.Context, uri string, prof *profile.Profile) ([]ids.NodeID, error) {
+	networkID, err := info.NewClient(uri).GetNetworkID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch the validator set: info.getNetworkID at %s: %w (pin a set with -validators-file)", uri, err)
+	}
+	if networkID != prof.NetworkID {
+		return nil, fmt.Errorf("fetch the validator set: %s is on network %d, want %d (%s); point -node-uri at %s or pin a set with -validators-file",
+			uri, networkID, prof.NetworkID, prof.Name, prof.Name)
+	}
+	validatorIDs, err := loadValidatorIDs(ctx, platformvm.NewClient(uri), prof.SubnetID)
+	if err != nil {
+		return nil, fmt.Errorf("fetch the validator set: platform.getCurrentValidators at %s: %w (pin a set with -validators-file)", uri, err)
+	}
+	return validatorIDs, nil
+}
+
+func sortNodeIDs(nodeIDs []ids.NodeID) {
+	sort.Slice(nodeIDs, func(i, j int) bool {
+		return nodeIDs[i].String() < nodeIDs[j].String()
+	})
+}
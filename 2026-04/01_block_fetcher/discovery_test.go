@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ava-labs/avalanchego/genesis"
+	"github.com/ava-labs/avalanchego/ids"
+	avaconstants "github.com/ava-labs/avalanchego/utils/constants"
+
+	"block_fetcher/profile"
+)
+
+// discoveryTestNode stands in for an AvalancheGo node's info and platform
+// APIs: it reports networkID and has validators as the primary network's
+// current validators, and counts the calls per method.
+func discoveryTestNode(t *testing.T, networkID uint32, validators []ids.NodeID) (*httptest.Server, map[string]int) {
+	t.Helper()
+	calls := make(map[string]int)
+	mux := http.NewServeMux()
+	handle := func(path string, results map[string]any) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				Method string          `json:"method"`
+				ID     json.RawMessage `json:"id"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			calls[req.Method]++
+			resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+			if result, ok := results[req.Method]; ok {
+				resp["result"] = result
+			} else {
+				resp["error"] = map[string]any{"code": -32601, "message": "method not found"}
+			}
+			if err := json.NewEncoder(w).Encode(resp); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	current := make([]map[string]string, len(validators))
+	for i, nodeID := range validators {
+		current[i] = map[string]string{"nodeID": nodeID.String(), "weight": "1", "startTime": "0", "endTime": "0"}
+	}
+	handle("/ext/info", map[string]any{"info.getNetworkID": map[string]string{"networkID": fmt.Sprint(networkID)}})
+	handle("/ext/P", map[string]any{"platform.getCurrentValidators": map[string]any{"validators": current}})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, calls
+}
+
+// discoveryTestNodeIDs returns n node IDs, sorted as discovery sorts them.
+func discoveryTestNodeIDs(n int) []ids.NodeID {
+	nodeIDs := make([]ids.NodeID, n)
+	for i := range nodeIDs {
+		nodeIDs[i] = ids.BuildTestNodeID([]byte{byte(i + 1)})
+	}
+	sortNodeIDs(nodeIDs)
+	return nodeIDs
+}
+
+func TestLoadValidatorsFile(t *testing.T) {
+	nodeIDs := discoveryTestNodeIDs(3)
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	raw, err := json.Marshal([]ids.NodeID{nodeIDs[2], nodeIDs[0], nodeIDs[1], nodeIDs[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadValidatorsFile(write("validators.json", string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(nodeIDs) {
+		t.Fatalf("validators %v, want %v sorted and without the duplicate", got, nodeIDs)
+	}
+
+	for _, tc := range []struct {
+		name, path, wantErr string
+	}{
+		{"missing", filepath.Join(dir, "missing.json"), "read validators file"},
+		{"not node IDs", write("bad.json", `["validator-1"]`), "decode validators file"},
+		{"not an array", write("object.json", `{"validators":[]}`), "decode validators file"},
+	} {
+		if _, err := loadValidatorsFile(tc.path); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestFetchValidators(t *testing.T) {
+	prof, err := profile.Fuji()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeIDs := discoveryTestNodeIDs(3)
+
+	srv, calls := discoveryTestNode(t, prof.NetworkID, []ids.NodeID{nodeIDs[1], nodeIDs[0], nodeIDs[2], nodeIDs[1]})
+	got, err := fetchValidators(context.Background(), srv.URL, prof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(nodeIDs) {
+		t.Fatalf("validators %v, want %v", got, nodeIDs)
+	}
+	if calls["info.getNetworkID"] != 1 || calls["platform.getCurrentValidators"] != 1 {
+		t.Fatalf("calls %v", calls)
+	}
+
+	// A node on another network is refused before its validators are read.
+	srv, calls = discoveryTestNode(t, avaconstants.MainnetID, nodeIDs)
+	_, err = fetchValidators(context.Background(), srv.URL, prof)
+	if err == nil || !strings.Contains(err.Error(), "is on network 1, want 5") || !strings.Contains(err.Error(), "-validators-file") {
+		t.Fatalf("err = %v, want a network mismatch", err)
+	}
+	if calls["platform.getCurrentValidators"] != 0 {
+		t.Fatal("validators read from a node on the wrong network")
+	}
+
+	srv.Close()
+	if _, err := fetchValidators(context.Background(), srv.URL, prof); err == nil || !strings.Contains(err.Error(), "info.getNetworkID") {
+		t.Fatalf("err = %v with the node down", err)
+	}
+}
+
+// TestDiscoverFromP2P checks the seeds and allowed nodes p2p discovery
+// starts with: every bootstrapper is a seed, but only on the primary network
+// may requests go to them, as they don't validate an L1's chain.
+func TestDiscoverFromP2P(t *testing.T) {
+	fuji, err := profile.Fuji()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l1 := *fuji
+	l1.SubnetID = ids.GenerateTestID()
+	bootstrappers := genesis.GetBootstrappers(fuji.NetworkID)
+	if len(bootstrappers) == 0 {
+		t.Fatal("no Fuji bootstrappers")
+	}
+	nodeIDs := discoveryTestNodeIDs(2)
+	srv, _ := discoveryTestNode(t, fuji.NetworkID, nodeIDs)
+
+	for _, tc := range []struct {
+		name               string
+		prof               *profile.Profile
+		allowBootstrappers bool
+	}{
+		{"primary network", fuji, true},
+		{"L1", &l1, false},
+	} {
+		d, err := discoverFromP2P(context.Background(), tc.prof, "", srv.URL)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !d.validatorOnly || fmt.Sprint(d.validators) != fmt.Sprint(nodeIDs) {
+			t.Fatalf("%s: validators %v (validator-only %t), want %v", tc.name, d.validators, d.validatorOnly, nodeIDs)
+		}
+		if len(d.seeds) != len(bootstrappers) {
+			t.Fatalf("%s: %d seeds, want the %d bootstrappers", tc.name, len(d.seeds), len(bootstrappers))
+		}
+		for i, b := range bootstrappers {
+			if d.seeds[i].id != b.ID || d.seeds[i].addr != b.IP {
+				t.Fatalf("%s: seed %d is %s at %s, want %s at %s", tc.name, i, d.seeds[i].id, d.seeds[i].addr, b.ID, b.IP)
+			}
+			if d.allowed.Contains(b.ID) != tc.allowBootstrappers {
+				t.Fatalf("%s: bootstrapper %s allowed: %t", tc.name, b.ID, !tc.allowBootstrappers)
+			}
+		}
+		for _, nodeID := range nodeIDs {
+			if !d.allowed.Contains(nodeID) {
+				t.Fatalf("%s: validator %s not allowed", tc.name, nodeID)
+			}
+		}
+	}
+
+	empty, _ := discoveryTestNode(t, fuji.NetworkID, nil)
+	if _, err := discoverFromP2P(context.Background(), fuji, "", empty.URL); err == nil || !strings.Contains(err.Error(), "no validators known") {
+		t.Fatalf("err = %v with no validators", err)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/message"
+	"github.com/ava-labs/avalanchego/network"
+	avap2p "github.com/ava-labs/avalanchego/network/p2p"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+)
+
+const (
+	defaultFollowInterval = 2 * time.Second
+	defaultLiveBatchSize  = 16
+
+	// maxFollowFailures is how many polls in a row may fail before the
+	// follower gives up; a single slow or lying peer shouldn't stop it.
+	maxFollowFailures = 30
+
+	// followRequestIDBase keeps the follower's request IDs clear of the
+	// backfill workers', whose late responses may still arrive.
+	followRequestIDBase = uint32(1) << 31
+	// checkpointRequestIDBase does the same for the frontier checkpoint
+	// taken before the backfill.
+	checkpointRequestIDBase = followRequestIDBase - 1<<16
+)
+
+// errWriterStopped marks a follower exit caused by the writer, whose error
+// the follower has then already received.
+var errWriterStopped = errors.New("writer stopped")
+
+// runTipFollower keeps the store at the network's accepted tip once the
+// checkpoint backfill is done. Every interval it asks a peer (picked by the
+// tracker, so polls rotate across peers) for its accepted frontier. When the
+// frontier is new, it walks GetAncestors back from it to the last block it
+// knows is stored contiguously and streams the containers to the writer.
+// The executor picks them up in live mode. It returns nil when ctx ends.
+func runTipFollower(
+	ctx context.Context,
+	db *store.DB,
+	net network.Network,
+	msgCreator message.Creator,
+	chainID ids.ID,
+	peerTracker *avap2p.PeerTracker,
+	handler *inboundHandler,
+	writerCh chan<- []byte,
+	writerErrCh <-chan error,
+	dispatchErrCh <-chan error,
+	requestTimeout time.Duration,
+	interval time.Duration,
+) error {
+	// synced only moves once a fill has reached it, so a fill that fails
+	// halfway is redone from the same point and leaves no gap behind.
+	synced, syncedID, err := latestStored(db)
+	if err != nil {
+		return err
+	}
+	log.Printf("follower: following the accepted tip from block %d", synced)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ancestors := &peerAncestors{
+		net:            net,
+		msgCreator:     msgCreator,
+		chainID:        chainID,
+		peerTracker:    peerTracker,
+		ancestorsCh:    handler.ancestorsCh,
+		dispatchErrCh:  dispatchErrCh,
+		requestTimeout: requestTimeout,
+	}
+	requestID := followRequestIDBase
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-writerErrCh:
+			if err == nil {
+				err = errors.New("stopped unexpectedly")
+			}
+			return fmt.Errorf("%w: %w", errWriterStopped, err)
+		case <-ticker.C:
+		}
+
+		requestID++
+		frontier, peerID, err := fetchAcceptedFrontier(
+			ctx, dispatchErrCh, net, msgCreator, chainID, peerTracker,
+			requestID, requestTimeout, handler.frontierCh,
+		)
+		if err == nil && frontier != syncedID {
+			var tip uint64
+			tip, requestID, err = fillToFrontier(ctx, ancestors, writerCh, requestID, frontier, synced, syncedID)
+			if err == nil && tip > synced {
+				log.Printf("follower: frontier=%s peer=%s block=%d new_blocks=%d", frontier, peerID, tip, tip-synced)
+				synced, syncedID = tip, frontier
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			failures++
+			log.Printf("follower: poll failed (%d/%d): %v", failures, maxFollowFailures, err)
+			if failures >= maxFollowFailures {
+				return fmt.Errorf("%d consecutive follow polls failed: %w", failures, err)
+			}
+			continue
+		}
+		failures = 0
+	}
+}
+
+// ancestorsSource answers GetAncestors requests for the follower.
+type ancestorsSource interface {
+	// getAncestors returns the response to requestID for blockID and the
+	// peer that sent it.
+	getAncestors(ctx context.Context, requestID uint32, blockID ids.ID) (ancestorsResponse, ids.NodeID, error)
+	// registerFailure marks a peer whose response was unusable.
+	registerFailure(peerID ids.NodeID)
+}
+
+// peerAncestors asks the peers the tracker picks.
+type peerAncestors struct {
+	net            network.Network
+	msgCreator     message.Creator
+	chainID        ids.ID
+	peerTracker    *avap2p.PeerTracker
+	ancestorsCh    <-chan ancestorsResponse
+	dispatchErrCh  <-chan error
+	requestTimeout time.Duration
+}
+
+func (p *peerAncestors) getAncestors(ctx context.Context, requestID uint32, blockID ids.ID) (ancestorsResponse, ids.NodeID, error) {
+	return fetchAncestors(
+		ctx, p.dispatchErrCh, p.net, p.msgCreator, p.chainID, p.peerTracker,
+		requestID, blockID, p.requestTimeout, p.ancestorsCh,
+	)
+}
+
+func (p *peerAncestors) registerFailure(peerID ids.NodeID) {
+	p.peerTracker.RegisterFailure(peerID)
+}
+
+// fillToFrontier fetches the containers above synced up to frontier, newest
+// first, and hands them to the writer. The walk must join the chain at
+// synced: the block right above it has to name syncedID as parent. Returns
+// frontier's height (0 if it is not above synced, i.e. the peer is behind)
+// and the last request ID used.
+func fillToFrontier(
+	ctx context.Context,
+	ancestors ancestorsSource,
+	writerCh chan<- []byte,
+	requestID uint32,
+	frontier ids.ID,
+	synced uint64,
+	syncedID ids.ID,
+) (uint64, uint32, error) {
+	var tip uint64
+	next := frontier
+	for {
+		requestID++
+		resp, peerID, err := ancestors.getAncestors(ctx, requestID, next)
+		if err != nil {
+			return 0, requestID, err
+		}
+		if len(resp.blocks) == 0 {
+			return 0, requestID, fmt.Errorf("empty ancestors from %s for %s", peerID, next)
+		}
+		for _, blk := range resp.blocks {
+			raw := append([]byte(nil), blk...)
+			rec, err := parseContainerRecord(raw)
+			if err != nil {
+				ancestors.registerFailure(peerID)
+				return 0, requestID, fmt.Errorf("parse ancestor from %s: %w", peerID, err)
+			}
+			if rec.outerID != next {
+				ancestors.registerFailure(peerID)
+				return 0, requestID, fmt.Errorf("peer %s sent %s, want %s", peerID, rec.outerID, next)
+			}
+			if rec.innerNumber <= synced {
+				// Only the frontier itself can be here: its peer is behind us.
+				return 0, requestID, nil
+			}
+			if tip == 0 {
+				tip = rec.innerNumber
+			}
+			select {
+			case writerCh <- raw:
+			case <-ctx.Done():
+				return 0, requestID, ctx.Err()
+			}
+			if rec.innerNumber == synced+1 {
+				if rec.parentID != syncedID {
+					return 0, requestID, fmt.Errorf("block %d parent %s does not match stored block %d %s",
+						rec.innerNumber, rec.parentID, synced, syncedID)
+				}
+				return tip, requestID, nil
+			}
+			next = rec.parentID
+		}
+	}
+}
+
+// latestStored returns the latest stored block and its container ID.
+func latestStored(db *store.DB) (uint64, ids.ID, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := db.BeginRO()
+	if err != nil {
+		return 0, ids.Empty, err
+	}
+	defer tx.Abort()
+
+	latest, ok := store.GetLatestStoredBlock(tx, db)
+	if !ok {
+		return 0, ids.Empty, fmt.Errorf("no stored blocks to follow from")
+	}
+	id, err := store.GetContainerIDByNumber(tx, db, latest)
+	if err != nil {
+		return 0, ids.Empty, fmt.Errorf("container ID of latest stored block %d: %w", latest, err)
+	}
+	return latest, ids.ID(id), nil
+}
+
+// storedRunEnd returns the highest block in [from, to] such that every block
+// from `from` up to it is stored, or from-1 if `from` itself is missing.
+func storedRunEnd(db *store.DB, from, to uint64) (uint64, error) {
+	tx, err := db.BeginRO()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Abort()
+	end := from - 1
+	for n := from; n <= to; n++ {
+		if _, err := store.GetContainerIDByNumber(tx, db, n); err != nil {
+			if mdbx.IsNotFound(err) {
+				break
+			}
+			return 0, err
+		}
+		end = n
+	}
+	return end, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ava-labs/avalanchego/ids"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/rlp"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+// followTestChain returns the pre-fork containers of blocks 0..n, each the
+// child of the one before, and their IDs.
+func followTestChain(t *testing.T, n int) ([][]byte, []ids.ID) {
+	t.Helper()
+	var (
+		raws   [][]byte
+		blkIDs []ids.ID
+		parent ethtypes.Header
+	)
+	for i := 0; i <= n; i++ {
+		header := &ethtypes.Header{Number: big.NewInt(int64(i)), Time: uint64(i), Difficulty: big.NewInt(1)}
+		if i > 0 {
+			header.ParentHash = parent.Hash()
+		}
+		raw, err := rlp.EncodeToBytes(ethtypes.NewBlockWithHeader(header))
+		if err != nil {
+			t.Fatal(err)
+		}
+		raws = append(raws, raw)
+		blkIDs = append(blkIDs, ids.ID(header.Hash()))
+		parent = *header
+	}
+	return raws, blkIDs
+}
+
+// stubAncestors answers GetAncestors from a fixed set of responses, keyed by
+// the block asked for, and records the requests and failures.
+type stubAncestors struct {
+	responses map[ids.ID][][]byte
+	requests  []ids.ID
+	failures  int
+}
+
+func (s *stubAncestors) getAncestors(_ context.Context, requestID uint32, blockID ids.ID) (ancestorsResponse, ids.NodeID, error) {
+	s.requests = append(s.requests, blockID)
+	blocks, ok := s.responses[blockID]
+	if !ok {
+		return ancestorsResponse{}, ids.EmptyNodeID, fmt.Errorf("no ancestors for %s", blockID)
+	}
+	return ancestorsResponse{requestID: requestID, blocks: blocks}, ids.EmptyNodeID, nil
+}
+
+func (s *stubAncestors) registerFailure(ids.NodeID) {
+	s.failures++
+}
+
+func TestFillToFrontier(t *testing.T) {
+	raws, blkIDs := followTestChain(t, 6)
+	// Answers of two blocks each, as a peer capping its response would.
+	walk := map[ids.ID][][]byte{
+		blkIDs[6]: {raws[6], raws[5]},
+		blkIDs[4]: {raws[4], raws[3]},
+		blkIDs[2]: {raws[2], raws[1]},
+	}
+	for _, tc := range []struct {
+		name      string
+		responses map[ids.ID][][]byte
+		frontier  ids.ID
+		syncedID  ids.ID
+		wantTip   uint64
+		wantErr   string
+		written   []int
+		failures  int
+	}{
+		{
+			// The walk stops at the block above synced without writing
+			// the rest of the last answer.
+			name: "joins at synced", responses: walk, frontier: blkIDs[6], syncedID: blkIDs[3],
+			wantTip: 6, written: []int{6, 5, 4},
+		},
+		{
+			// Our block 3 isn't the frontier's ancestor.
+			name: "parent mismatch", responses: walk, frontier: blkIDs[6], syncedID: ids.ID{3},
+			wantErr: "does not match stored block 3", written: []int{6, 5, 4},
+		},
+		{
+			name: "peer behind", responses: walk, frontier: blkIDs[2], syncedID: blkIDs[3],
+		},
+		{
+			name:      "peer at synced",
+			responses: map[ids.ID][][]byte{blkIDs[3]: {raws[3]}}, frontier: blkIDs[3], syncedID: blkIDs[3],
+		},
+		{
+			name:      "wrong block",
+			responses: map[ids.ID][][]byte{blkIDs[6]: {raws[5]}}, frontier: blkIDs[6], syncedID: blkIDs[3],
+			wantErr: "sent " + blkIDs[5].String(), failures: 1,
+		},
+		{
+			name:      "unparsable block",
+			responses: map[ids.ID][][]byte{blkIDs[6]: {{0x01}}}, frontier: blkIDs[6], syncedID: blkIDs[3],
+			wantErr: "parse ancestor", failures: 1,
+		},
+		{
+			name:      "empty answer",
+			responses: map[ids.ID][][]byte{blkIDs[6]: nil}, frontier: blkIDs[6], syncedID: blkIDs[3],
+			wantErr: "empty ancestors",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stub := &stubAncestors{responses: tc.responses}
+			writerCh := make(chan []byte, len(raws))
+			tip, requestID, err := fillToFrontier(context.Background(), stub, writerCh, 100, tc.frontier, 3, tc.syncedID)
+			if tc.wantErr == "" && err != nil || tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Fatalf("err = %v, want %q", err, tc.wantErr)
+			}
+			if err == nil && tip != tc.wantTip {
+				t.Fatalf("tip = %d, want %d", tip, tc.wantTip)
+			}
+			if want := 100 + uint32(len(stub.requests)); requestID != want {
+				t.Fatalf("request ID %d after %d requests, want %d", requestID, len(stub.requests), want)
+			}
+			close(writerCh)
+			var written []int
+			for raw := range writerCh {
+				rec, err := parseContainerRecord(raw)
+				if err != nil {
+					t.Fatal(err)
+				}
+				written = append(written, int(rec.innerNumber))
+			}
+			if fmt.Sprint(written) != fmt.Sprint(tc.written) {
+				t.Fatalf("wrote blocks %v, want %v", written, tc.written)
+			}
+			if stub.failures != tc.failures {
+				t.Fatalf("%d peer failures, want %d", stub.failures, tc.failures)
+			}
+		})
+	}
+}
+
+func TestStoredRunEnd(t *testing.T) {
+	raws, blkIDs := followTestChain(t, 5)
+	db := storetest.Open(t, store.Open)
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		for _, n := range []int{1, 2, 3, 5} {
+			if err := store.PutContainer(tx, db, [32]byte(blkIDs[n]), uint64(n), raws[n]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	for _, tc := range []struct {
+		from, to, want uint64
+	}{
+		{1, 5, 3},
+		{1, 2, 2},
+		{4, 5, 3}, // from itself is missing
+		{5, 5, 5},
+		{5, 9, 5},
+	} {
+		got, err := storedRunEnd(db, tc.from, tc.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("storedRunEnd(%d, %d) = %d, want %d", tc.from, tc.to, got, tc.want)
+		}
+	}
+}
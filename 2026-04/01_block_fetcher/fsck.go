@@ -0,0 +1,647 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+
+	ccustomtypes "github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/customtypes"
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/crypto"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/statetrie"
+	"block_fetcher/store"
+)
+
+// fsckExamples is how many problems of each check fsck logs in full.
+const fsckExamples = 10
+
+// fsckCheck counts the problems one check found.
+type fsckCheck struct {
+	name string
+	// repairable is whether -repair fixes what the check finds.
+	repairable bool
+	problems   int
+}
+
+func (c *fsckCheck) report(format string, args ...any) {
+	c.problems++
+	if c.problems <= fsckExamples {
+		log.Printf("fsck: %s: "+format, append([]any{c.name}, args...)...)
+	}
+}
+
+// fsckFixes is what -repair writes. Hashed accounts are written with their
+// storage root recomputed after the storage fixes.
+type fsckFixes struct {
+	// accounts maps hashed address to the account to write; nil deletes.
+	accounts map[[32]byte][]byte
+	// storage maps hashed address ++ hashed slot to the value; nil deletes.
+	storage    map[[64]byte][]byte
+	changesets []uint64
+	// unindexed are blocks whose ContainerIndex entry names a container
+	// that is not stored. Dropping the entry makes the fetcher refetch them.
+	unindexed []uint64
+}
+
+func newFsckFixes() *fsckFixes {
+	return &fsckFixes{
+		accounts: make(map[[32]byte][]byte),
+		storage:  make(map[[64]byte][]byte),
+	}
+}
+
+// runFsck implements the fsck subcommand:
+//
+//	block_fetcher fsck -db-dir DIR [-sample F] [-recent N] [-repair] [-reset-tries]
+//
+// It cross-checks the tables the executor keeps in step and reports every
+// inconsistency. The node must be stopped.
+func runFsck(args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	dbDir := fs.String("db-dir", defaultDBDir, "MDBX database directory")
+	sample := fs.Float64("sample", 1, "fraction of accounts whose storage root is recomputed; 1 also recomputes the state root")
+	recent := fs.Uint64("recent", 10_000, "number of blocks up to the head whose changesets are checked")
+	repair := fs.Bool("repair", false, "fix the inconsistencies that can be fixed")
+	resetTries := fs.Bool("reset-tries", false, "with -repair, drop the stored trie nodes even if the state checks pass")
+	fs.Parse(args)
+	if *sample <= 0 || *sample > 1 {
+		return errors.New("-sample must be in (0, 1]")
+	}
+
+	db, err := store.Open(*dbDir)
+	if err != nil {
+		return fmt.Errorf("open MDBX: %w", err)
+	}
+	defer db.Close()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := db.BeginRO()
+	if err != nil {
+		return err
+	}
+	defer tx.Abort()
+
+	head, hasHead := store.GetHeadBlock(tx, db)
+	var headRoot common.Hash
+	if hasHead {
+		raw, err := store.GetBlockByNumber(tx, db, head)
+		if err != nil {
+			return fmt.Errorf("read head block %d: %w", head, err)
+		}
+		block, err := executorParseEthBlock(raw)
+		if err != nil {
+			return fmt.Errorf("parse head block %d: %w", head, err)
+		}
+		headRoot = block.Root()
+		log.Printf("fsck: head block %d root=%s", head, headRoot)
+	} else {
+		log.Printf("fsck: nothing has been executed")
+	}
+
+	fixes := newFsckFixes()
+	accounts := &fsckCheck{name: "accounts", repairable: true}
+	storage := &fsckCheck{name: "storage", repairable: true}
+	roots := &fsckCheck{name: "storage roots", repairable: true}
+	code := &fsckCheck{name: "code"}
+	stateRoot := &fsckCheck{name: "state root"}
+	changesets := &fsckCheck{name: "changesets"}
+	containers := &fsckCheck{name: "containers"}
+
+	start := time.Now()
+	if err := checkHashedAccounts(tx, db, accounts, fixes); err != nil {
+		return fmt.Errorf("check accounts: %w", err)
+	}
+	log.Printf("fsck: checked accounts problems=%d elapsed=%s", accounts.problems, time.Since(start).Round(time.Second))
+
+	start = time.Now()
+	if err := checkHashedStorage(tx, db, storage, fixes); err != nil {
+		return fmt.Errorf("check storage: %w", err)
+	}
+	log.Printf("fsck: checked storage problems=%d elapsed=%s", storage.problems, time.Since(start).Round(time.Second))
+
+	start = time.Now()
+	if err := checkStorageRoots(tx, db, *sample, roots, fixes); err != nil {
+		return fmt.Errorf("check storage roots: %w", err)
+	}
+	log.Printf("fsck: checked storage roots sample=%g problems=%d elapsed=%s", *sample, roots.problems, time.Since(start).Round(time.Second))
+
+	start = time.Now()
+	if err := checkCode(tx, db, code); err != nil {
+		return fmt.Errorf("check code: %w", err)
+	}
+	log.Printf("fsck: checked code problems=%d elapsed=%s", code.problems, time.Since(start).Round(time.Second))
+
+	// The full root only says something about the flat state once the
+	// hashed state is known to match it.
+	stateOK := accounts.problems+storage.problems+roots.problems == 0
+	if hasHead && *sample == 1 && stateOK {
+		start = time.Now()
+		root, err := statetrie.ComputeFullStateRoot(tx, db)
+		if err != nil {
+			return fmt.Errorf("compute state root: %w", err)
+		}
+		if common.Hash(root) != headRoot {
+			stateRoot.report("state root %x does not match block %d root %s: the flat state is wrong, unwind or import a snapshot", root, head, headRoot)
+		}
+		log.Printf("fsck: checked state root elapsed=%s", time.Since(start).Round(time.Second))
+	}
+
+	if hasHead {
+		if err := checkChangesets(tx, db, head, *recent, changesets, fixes); err != nil {
+			return fmt.Errorf("check changesets: %w", err)
+		}
+		log.Printf("fsck: checked changesets problems=%d", changesets.problems)
+	}
+
+	start = time.Now()
+	if err := checkContainerIndex(tx, db, containers, fixes); err != nil {
+		return fmt.Errorf("check container index: %w", err)
+	}
+	log.Printf("fsck: checked container index problems=%d elapsed=%s", containers.problems, time.Since(start).Round(time.Second))
+	tx.Abort()
+
+	checks := []*fsckCheck{accounts, storage, roots, code, stateRoot, changesets, containers}
+	total, unrepairable := 0, 0
+	for _, c := range checks {
+		total += c.problems
+		if !c.repairable {
+			unrepairable += c.problems
+		}
+	}
+	// Containers and changesets above the head are repairable even though
+	// the rest of their checks are not.
+	unrepairable -= len(fixes.changesets) + len(fixes.unindexed)
+	if total == 0 {
+		log.Printf("fsck: no problems found")
+		if !*repair || !*resetTries {
+			return nil
+		}
+	}
+	if !*repair {
+		return fmt.Errorf("%d problems found, %d repairable with -repair", total, total-unrepairable)
+	}
+
+	if err := applyFsckFixes(db, fixes, !stateOK || *resetTries, hasHead, headRoot); err != nil {
+		return fmt.Errorf("repair: %w", err)
+	}
+	if unrepairable > 0 {
+		return fmt.Errorf("%d problems cannot be repaired by fsck", unrepairable)
+	}
+	return nil
+}
+
+// checkHashedAccounts checks that HashedAccountState holds exactly the
+// accounts of AccountState. The storage root is not compared: the executor
+// only keeps it up to date in HashedAccountState.
+func checkHashedAccounts(tx *mdbx.Txn, db *store.DB, c *fsckCheck, fixes *fsckFixes) error {
+	cursor, err := tx.OpenCursor(db.AccountState)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	var matched uint64
+	k, v, err := cursor.Get(nil, nil, mdbx.First)
+	for ; err == nil; k, v, err = cursor.Get(nil, nil, mdbx.Next) {
+		ha := [32]byte(crypto.Keccak256(k))
+		hashed, getErr := tx.Get(db.HashedAccountState, ha[:])
+		switch {
+		case mdbx.IsNotFound(getErr):
+			c.report("account %x has no hashed entry", k)
+		case getErr != nil:
+			return getErr
+		default:
+			matched++
+			if sameAccount(v, hashed) {
+				continue
+			}
+			c.report("account %x differs from its hashed entry", k)
+		}
+		fixes.accounts[ha] = store.EncodeAccountBytes(store.DecodeAccount(v))
+	}
+	if !mdbx.IsNotFound(err) {
+		return err
+	}
+
+	stats, err := tx.StatDBI(db.HashedAccountState)
+	if err != nil {
+		return err
+	}
+	if stats.Entries == matched {
+		return nil
+	}
+	// Some hashed accounts have no flat account. Finding them takes the set
+	// of all hashed addresses, so it is only built now.
+	flat := make(map[[32]byte]struct{}, matched)
+	k, _, err = cursor.Get(nil, nil, mdbx.First)
+	for ; err == nil; k, _, err = cursor.Get(nil, nil, mdbx.Next) {
+		flat[[32]byte(crypto.Keccak256(k))] = struct{}{}
+	}
+	if !mdbx.IsNotFound(err) {
+		return err
+	}
+	hashedCursor, err := tx.OpenCursor(db.HashedAccountState)
+	if err != nil {
+		return err
+	}
+	defer hashedCursor.Close()
+	k, _, err = hashedCursor.Get(nil, nil, mdbx.First)
+	for ; err == nil; k, _, err = hashedCursor.Get(nil, nil, mdbx.Next) {
+		if _, ok := flat[[32]byte(k)]; !ok {
+			c.report("hashed account %x has no flat account", k)
+			fixes.accounts[[32]byte(k)] = nil
+		}
+	}
+	if !mdbx.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// sameAccount compares a flat and a hashed account in all but their storage
+// roots.
+func sameAccount(flat, hashed []byte) bool {
+	a, b := store.DecodeAccount(flat), store.DecodeAccount(hashed)
+	return a.Nonce == b.Nonce && a.Balance == b.Balance && a.CodeHash == b.CodeHash && a.IsMultiCoin == b.IsMultiCoin
+}
+
+// storageOwner is an account with storage as checkHashedStorage sees it.
+type storageOwner struct {
+	addr [20]byte
+	// matched counts the flat slots found in HashedStorageState.
+	matched uint64
+}
+
+// checkHashedStorage checks that HashedStorageState holds exactly the slots
+// of StorageState, with the same values.
+func checkHashedStorage(tx *mdbx.Txn, db *store.DB, c *fsckCheck, fixes *fsckFixes) error {
+	cursor, err := tx.OpenCursor(db.StorageState)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	owners := make(map[[32]byte]*storageOwner)
+	var owner *storageOwner
+	var ha [32]byte
+	k, v, err := cursor.Get(nil, nil, mdbx.First)
+	for ; err == nil; k, v, err = cursor.Get(nil, nil, mdbx.Next) {
+		if len(k) != 52 {
+			continue
+		}
+		if owner == nil || !bytes.Equal(owner.addr[:], k[:20]) {
+			ha = [32]byte(crypto.Keccak256(k[:20]))
+			owner = &storageOwner{addr: [20]byte(k[:20])}
+			owners[ha] = owner
+		}
+		want := bytes.TrimLeft(v, "\x00")
+		if len(want) == 0 {
+			continue // zero slots are not stored hashed
+		}
+		var hk [64]byte
+		copy(hk[:32], ha[:])
+		copy(hk[32:], crypto.Keccak256(k[20:52]))
+		hashed, getErr := tx.Get(db.HashedStorageState, hk[:])
+		switch {
+		case mdbx.IsNotFound(getErr):
+			c.report("slot %x of %x has no hashed entry", k[20:52], k[:20])
+		case getErr != nil:
+			return getErr
+		default:
+			owner.matched++
+			if bytes.Equal(bytes.TrimLeft(hashed, "\x00"), want) {
+				continue
+			}
+			c.report("slot %x of %x differs from its hashed entry", k[20:52], k[:20])
+		}
+		fixes.storage[hk] = bytes.Clone(want)
+	}
+	if !mdbx.IsNotFound(err) {
+		return err
+	}
+
+	// Count the hashed slots of every account. An account with more than
+	// were matched has hashed slots without a flat slot.
+	hashedCursor, err := tx.OpenCursor(db.HashedStorageState)
+	if err != nil {
+		return err
+	}
+	defer hashedCursor.Close()
+	var extra [][32]byte
+	var cur [32]byte
+	var count uint64
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		if o := owners[cur]; o == nil || count > o.matched {
+			extra = append(extra, cur)
+		}
+	}
+	k, _, err = hashedCursor.Get(nil, nil, mdbx.First)
+	for ; err == nil; k, _, err = hashedCursor.Get(nil, nil, mdbx.Next) {
+		if len(k) != 64 {
+			continue
+		}
+		if !bytes.Equal(cur[:], k[:32]) {
+			flush()
+			cur, count = [32]byte(k[:32]), 0
+		}
+		count++
+	}
+	if !mdbx.IsNotFound(err) {
+		return err
+	}
+	flush()
+
+	for _, ha := range extra {
+		slots := make(map[[32]byte]struct{})
+		if o := owners[ha]; o != nil {
+			k, _, err := cursor.Get(o.addr[:], nil, mdbx.SetRange)
+			for ; err == nil && bytes.HasPrefix(k, o.addr[:]); k, _, err = cursor.Get(nil, nil, mdbx.Next) {
+				slots[[32]byte(crypto.Keccak256(k[20:52]))] = struct{}{}
+			}
+			if err != nil && !mdbx.IsNotFound(err) {
+				return err
+			}
+		}
+		k, _, err := hashedCursor.Get(ha[:], nil, mdbx.SetRange)
+		for ; err == nil && bytes.HasPrefix(k, ha[:]); k, _, err = hashedCursor.Get(nil, nil, mdbx.Next) {
+			if len(k) != 64 {
+				continue
+			}
+			if _, ok := slots[[32]byte(k[32:64])]; !ok {
+				c.report("hashed slot %x of hashed account %x has no flat slot", k[32:64], ha)
+				fixes.storage[[64]byte(k)] = nil
+			}
+		}
+		if err != nil && !mdbx.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkStorageRoots recomputes the storage roots of a sample of the hashed
+// accounts from HashedStorageState and compares them with the stored ones.
+// Accounts are picked by their hashed address, so a sample of sample covers
+// that fraction of them.
+func checkStorageRoots(tx *mdbx.Txn, db *store.DB, sample float64, c *fsckCheck, fixes *fsckFixes) error {
+	limit := uint64(sample * (1 << 32))
+	cursor, err := tx.OpenCursor(db.HashedAccountState)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	k, v, err := cursor.Get(nil, nil, mdbx.First)
+	for ; err == nil; k, v, err = cursor.Get(nil, nil, mdbx.Next) {
+		if len(k) != 32 || uint64(binary.BigEndian.Uint32(k)) >= limit {
+			continue
+		}
+		ha := [32]byte(k)
+		stored := store.DecodeAccount(v).StorageRoot
+		computed := statetrie.ComputeStorageRoot(tx, db, ha)
+		if stored == computed {
+			continue
+		}
+		c.report("hashed account %x has storage root %x, its storage hashes to %x", ha, stored, computed)
+		if _, ok := fixes.accounts[ha]; !ok {
+			fixes.accounts[ha] = bytes.Clone(v)
+		}
+	}
+	if !mdbx.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// checkCode checks that every Code entry is keyed by the hash of its
+// bytecode and that every account with code has its Code entry.
+func checkCode(tx *mdbx.Txn, db *store.DB, c *fsckCheck) error {
+	cursor, err := tx.OpenCursor(db.Code)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	k, v, err := cursor.Get(nil, nil, mdbx.First)
+	for ; err == nil; k, v, err = cursor.Get(nil, nil, mdbx.Next) {
+		if h := crypto.Keccak256(v); !bytes.Equal(h, k) {
+			c.report("code %x hashes to %x", k, h)
+		}
+	}
+	if !mdbx.IsNotFound(err) {
+		return err
+	}
+
+	accountCursor, err := tx.OpenCursor(db.AccountState)
+	if err != nil {
+		return err
+	}
+	defer accountCursor.Close()
+	k, v, err = accountCursor.Get(nil, nil, mdbx.First)
+	for ; err == nil; k, v, err = accountCursor.Get(nil, nil, mdbx.Next) {
+		codeHash := store.DecodeAccount(v).CodeHash
+		if codeHash == store.EmptyCodeHash || codeHash == [32]byte{} {
+			continue
+		}
+		if _, getErr := tx.Get(db.Code, codeHash[:]); mdbx.IsNotFound(getErr) {
+			c.report("account %x has code hash %x with no code", k, codeHash)
+		} else if getErr != nil {
+			return getErr
+		}
+	}
+	if !mdbx.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// checkChangesets checks that the last recent blocks up to head that change
+// state have a decodable changeset, and that no block above head has one.
+// Only blocks with transactions or atomic transactions are sure to change
+// state; the changesets of the others are not required.
+func checkChangesets(tx *mdbx.Txn, db *store.DB, head, recent uint64, c *fsckCheck, fixes *fsckFixes) error {
+	from := head + 1 - min(head+1, recent)
+	prunedBelow, err := store.GetPrunedBelow(tx, db, store.PruneHistory)
+	if err != nil {
+		return err
+	}
+	// Nothing changes state at genesis, and the block history starts at
+	// has no changeset either.
+	from = max(from, prunedBelow+1, 1)
+	for n := from; n <= head; n++ {
+		_, err := store.ReadChangeset(tx, db, n)
+		if err == nil {
+			continue
+		}
+		if !mdbx.IsNotFound(err) {
+			c.report("changeset of block %d: %v", n, err)
+			continue
+		}
+		raw, err := store.GetBlockByNumber(tx, db, n)
+		if err != nil {
+			return fmt.Errorf("read block %d: %w", n, err)
+		}
+		block, err := executorParseEthBlock(raw)
+		if err != nil {
+			return fmt.Errorf("parse block %d: %w", n, err)
+		}
+		if len(block.Transactions()) > 0 || len(ccustomtypes.BlockExtData(block)) > 0 {
+			c.report("block %d changes state but has no changeset", n)
+		}
+	}
+
+	cursor, err := tx.OpenCursor(db.Changesets)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	above := store.BlockKey(head + 1)
+	k, _, err := cursor.Get(above[:], nil, mdbx.SetRange)
+	for ; err == nil; k, _, err = cursor.Get(nil, nil, mdbx.Next) {
+		n := binary.BigEndian.Uint64(k)
+		c.report("block %d above the head has a changeset", n)
+		fixes.changesets = append(fixes.changesets, n)
+	}
+	if !mdbx.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// checkContainerIndex checks that ContainerIndex has no gaps between its
+// first and last block and that every container it names is stored. Gaps
+// are filled by running the fetcher again.
+func checkContainerIndex(tx *mdbx.Txn, db *store.DB, c *fsckCheck, fixes *fsckFixes) error {
+	cursor, err := tx.OpenCursor(db.ContainerIndex)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	var next uint64
+	first := true
+	k, v, err := cursor.Get(nil, nil, mdbx.First)
+	for ; err == nil; k, v, err = cursor.Get(nil, nil, mdbx.Next) {
+		if len(k) != 8 {
+			continue
+		}
+		n := binary.BigEndian.Uint64(k)
+		if first {
+			log.Printf("fsck: container index starts at block %d", n)
+			first = false
+		} else if n != next {
+			c.report("blocks %d-%d are missing", next, n-1)
+		}
+		next = n + 1
+		if _, getErr := tx.Get(db.Containers, v); getErr != nil {
+			if !mdbx.IsNotFound(getErr) {
+				return getErr
+			}
+			c.report("block %d names container %x, which is not stored", n, v)
+			fixes.unindexed = append(fixes.unindexed, n)
+		}
+	}
+	if !mdbx.IsNotFound(err) {
+		return err
+	}
+	if latest, ok := store.GetLatestStoredBlock(tx, db); ok && !first && next <= latest {
+		c.report("blocks %d-%d are missing", next, latest)
+	}
+	return nil
+}
+
+// applyFsckFixes writes fixes in one transaction. With resetTries the stored
+// trie nodes are dropped so the executor rebuilds them from the hashed
+// state: their cached hashes cover the values the fixes replace. When there
+// is a head, the repaired state must hash to its root or nothing is written.
+func applyFsckFixes(db *store.DB, fixes *fsckFixes, resetTries, hasHead bool, headRoot common.Hash) error {
+	tx, err := db.BeginRW()
+	if err != nil {
+		return err
+	}
+	defer tx.Abort()
+
+	for hk, val := range fixes.storage {
+		// The storage root of an account whose slots change is recomputed
+		// with the account.
+		ha := [32]byte(hk[:32])
+		if _, ok := fixes.accounts[ha]; !ok {
+			acct, err := tx.Get(db.HashedAccountState, ha[:])
+			if err == nil {
+				fixes.accounts[ha] = bytes.Clone(acct)
+			} else if !mdbx.IsNotFound(err) {
+				return err
+			}
+		}
+		if val == nil {
+			if err := tx.Del(db.HashedStorageState, hk[:], nil); err != nil && !mdbx.IsNotFound(err) {
+				return err
+			}
+			continue
+		}
+		if err := tx.Put(db.HashedStorageState, hk[:], val, 0); err != nil {
+			return err
+		}
+	}
+	for ha, val := range fixes.accounts {
+		if val == nil {
+			if err := store.DeleteHashedAccount(tx, db, ha); err != nil {
+				return err
+			}
+			continue
+		}
+		acct := store.DecodeAccount(val)
+		acct.StorageRoot = statetrie.ComputeStorageRoot(tx, db, ha)
+		if err := store.PutHashedAccount(tx, db, ha, store.EncodeAccountBytes(acct)); err != nil {
+			return err
+		}
+	}
+	for _, n := range fixes.changesets {
+		key := store.BlockKey(n)
+		if err := tx.Del(db.Changesets, key[:], nil); err != nil {
+			return err
+		}
+	}
+	for _, n := range fixes.unindexed {
+		key := store.BlockKey(n)
+		if err := tx.Del(db.ContainerIndex, key[:], nil); err != nil {
+			return err
+		}
+	}
+	if resetTries {
+		if err := tx.Drop(db.AccountTrie, false); err != nil {
+			return fmt.Errorf("drop AccountTrie: %w", err)
+		}
+		if err := tx.Drop(db.StorageTrie, false); err != nil {
+			return fmt.Errorf("drop StorageTrie: %w", err)
+		}
+		log.Printf("fsck: dropped the stored trie nodes; the executor rebuilds them on its next batch")
+	}
+
+	if hasHead && (len(fixes.accounts) > 0 || len(fixes.storage) > 0) {
+		root, err := statetrie.ComputeFullStateRoot(tx, db)
+		if err != nil {
+			return fmt.Errorf("compute state root: %w", err)
+		}
+		if common.Hash(root) != headRoot {
+			return fmt.Errorf("repaired state root %x does not match the head root %s: the flat state is wrong, unwind or import a snapshot", root, headRoot)
+		}
+		log.Printf("fsck: repaired state matches the head root")
+	}
+	if _, err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("fsck: repaired accounts=%d slots=%d changesets=%d index entries=%d",
+		len(fixes.accounts), len(fixes.storage), len(fixes.changesets), len(fixes.unindexed))
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/crypto"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/statetrie"
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+// fsckTestChecks are the names of the checks runFsck reports under.
+var fsckTestChecks = []string{"accounts", "storage", "storage roots", "code", "state root", "changesets", "containers"}
+
+// runFsckTest runs fsck on the database in dir and returns its log and
+// error.
+func runFsckTest(t *testing.T, dir string, args ...string) (string, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	err := runFsck(append([]string{"-db-dir", dir}, args...))
+	return buf.String(), err
+}
+
+// viewFsckTestDB runs fn in a read transaction on the database in dir.
+func viewFsckTestDB(t *testing.T, dir string, fn func(tx *mdbx.Txn, db *store.DB)) {
+	t.Helper()
+	db, err := store.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) { fn(tx, db) })
+}
+
+func TestFsckRepairs(t *testing.T) {
+	counterHash := [32]byte(crypto.Keccak256(testCounter.Bytes()))
+	for _, tc := range []struct {
+		name, check string
+		tamper      func(tx *mdbx.Txn, db *store.DB)
+		repaired    func(tx *mdbx.Txn, db *store.DB) bool
+	}{
+		{
+			name:  "hashed account",
+			check: "accounts",
+			tamper: func(tx *mdbx.Txn, db *store.DB) {
+				ha := [32]byte(crypto.Keccak256(common.Address{19: 1}.Bytes()))
+				v, err := tx.Get(db.HashedAccountState, ha[:])
+				if err != nil {
+					t.Fatal(err)
+				}
+				acct := store.DecodeAccount(v)
+				acct.Nonce = 99
+				if err := store.PutHashedAccount(tx, db, ha, store.EncodeAccountBytes(acct)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			repaired: func(tx *mdbx.Txn, db *store.DB) bool {
+				ha := crypto.Keccak256(common.Address{19: 1}.Bytes())
+				v, err := tx.Get(db.HashedAccountState, ha)
+				return err == nil && store.DecodeAccount(v).Nonce == 1
+			},
+		},
+		{
+			name:  "storage root",
+			check: "storage roots",
+			tamper: func(tx *mdbx.Txn, db *store.DB) {
+				v, err := tx.Get(db.HashedAccountState, counterHash[:])
+				if err != nil {
+					t.Fatal(err)
+				}
+				acct := store.DecodeAccount(v)
+				acct.StorageRoot = common.Hash{1}
+				if err := store.PutHashedAccount(tx, db, counterHash, store.EncodeAccountBytes(acct)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			repaired: func(tx *mdbx.Txn, db *store.DB) bool {
+				v, err := tx.Get(db.HashedAccountState, counterHash[:])
+				return err == nil && store.DecodeAccount(v).StorageRoot == statetrie.ComputeStorageRoot(tx, db, counterHash)
+			},
+		},
+		{
+			// Left by a batch whose head never committed.
+			name:  "changeset above the head",
+			check: "changesets",
+			tamper: func(tx *mdbx.Txn, db *store.DB) {
+				if err := store.WriteChangeset(tx, db, 2, []store.Change{{KeyID: 1, OldValue: []byte{1}}}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			repaired: func(tx *mdbx.Txn, db *store.DB) bool {
+				_, err := store.ReadChangeset(tx, db, 2)
+				return mdbx.IsNotFound(err)
+			},
+		},
+		{
+			name:  "container index",
+			check: "containers",
+			tamper: func(tx *mdbx.Txn, db *store.DB) {
+				key := store.BlockKey(2)
+				if err := tx.Put(db.ContainerIndex, key[:], bytes.Repeat([]byte{0xee}, 32), 0); err != nil {
+					t.Fatal(err)
+				}
+			},
+			repaired: func(tx *mdbx.Txn, db *store.DB) bool {
+				_, err := store.GetContainerIDByNumber(tx, db, 2)
+				return mdbx.IsNotFound(err)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeSnapshotTestDB(t, dir, tc.tamper)
+
+			out, err := runFsckTest(t, dir)
+			if err == nil {
+				t.Fatal("fsck found no problems")
+			}
+			for _, name := range fsckTestChecks {
+				reported := strings.Contains(out, "fsck: "+name+": ")
+				if reported != (name == tc.check) {
+					t.Errorf("%s check reported = %v, want %v:\n%s", name, reported, name == tc.check, out)
+				}
+			}
+
+			if out, err := runFsckTest(t, dir, "-repair"); err != nil {
+				t.Fatalf("repair: %v\n%s", err, out)
+			}
+			viewFsckTestDB(t, dir, func(tx *mdbx.Txn, db *store.DB) {
+				if !tc.repaired(tx, db) {
+					t.Fatal("not repaired")
+				}
+			})
+			if out, err := runFsckTest(t, dir); err != nil {
+				t.Fatalf("after repair: %v\n%s", err, out)
+			}
+		})
+	}
+}
+
+func TestFsckReportsUnrepairableChangeset(t *testing.T) {
+	dir := t.TempDir()
+	writeSnapshotTestDB(t, dir, func(tx *mdbx.Txn, db *store.DB) {
+		key := store.BlockKey(1)
+		if err := tx.Put(db.Changesets, key[:], []byte{0, 1}, 0); err != nil {
+			t.Fatal(err)
+		}
+	})
+	out, err := runFsckTest(t, dir, "-repair")
+	if err == nil || !strings.Contains(err.Error(), "cannot be repaired") {
+		t.Fatalf("repair: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "fsck: changesets: changeset of block 1") {
+		t.Fatalf("undecodable changeset not reported:\n%s", out)
+	}
+	viewFsckTestDB(t, dir, func(tx *mdbx.Txn, db *store.DB) {
+		key := store.BlockKey(1)
+		if _, err := tx.Get(db.Changesets, key[:]); err != nil {
+			t.Fatalf("changeset of block 1: %v", err)
+		}
+	})
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+
+	"block_fetcher/atomictx"
+	"block_fetcher/store"
+)
+
+// indexChunk is how many blocks one indexer transaction covers. It bounds
+// how long the executor's critical flush can wait for the write lock.
+const indexChunk = 2000
+
+// keyDictChunk is how many dictionary entries one reverse dictionary
+// backfill transaction copies, bounded for the same reason.
+const keyDictChunk = 100_000
+
+// prepareIndexes sets the watermark of every deferred index that has none
+// to the executed head. It must run before the executor commits a batch:
+// databases written before the indexes were deferred are complete up to
+// their head, and a new database starts at 0.
+func prepareIndexes(db *store.DB) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := db.BeginRW()
+	if err != nil {
+		return err
+	}
+	defer tx.Abort()
+	if err := store.InitIndexWatermarks(tx, db); err != nil {
+		return err
+	}
+	_, err = tx.Commit()
+	return err
+}
+
+// runIndexer builds the history, log and tx hash indexes behind the
+// executor, in write transactions of its own, so the executor's critical
+// flush only carries state, changesets and receipts. It catches up on start,
+// which also finishes whatever a crash left behind, and again after every
+// committed head. Once finish is closed it returns as soon as it has caught
+// up; it returns right away when ctx ends. A failed pass is logged and
+// retried on the next head.
+func runIndexer(ctx context.Context, db *store.DB, finish <-chan struct{}) {
+	heads, cancel := db.SubscribeHead()
+	defer cancel()
+	for {
+		err := indexToHead(ctx, db)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("indexer: %v", err)
+		}
+		select {
+		case <-finish:
+			if err == nil {
+				return
+			}
+		default:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-heads:
+		case <-finish:
+		}
+	}
+}
+
+// runAtomicIndexer builds the atomic tx index behind the executor the way
+// runIndexer builds the others. It runs on its own: the index starts at
+// block 0 on a database that predates it, and that backfill must hold up
+// neither the other indexes nor shutdown. It returns when ctx ends; an
+// interrupted backfill resumes at its watermark on the next start.
+func runAtomicIndexer(ctx context.Context, db *store.DB, dec *atomictx.Decoder) {
+	heads, cancel := db.SubscribeHead()
+	defer cancel()
+	for {
+		err := indexKind(ctx, db, store.IndexAtomic, func(from, to uint64) error {
+			return indexAtomicChunk(db, dec, from, to)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("indexer: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-heads:
+		}
+	}
+}
+
+// indexToHead brings the history, log and tx hash indexes up to the
+// executed head.
+func indexToHead(ctx context.Context, db *store.DB) error {
+	for _, kind := range store.IndexKinds {
+		if kind == store.IndexAtomic {
+			continue
+		}
+		err := indexKind(ctx, db, kind, func(from, to uint64) error {
+			return indexChunkOf(db, kind, from, to)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexKind brings kind up to the executed head, calling build for one
+// chunk of blocks at a time.
+func indexKind(ctx context.Context, db *store.DB, kind store.IndexKind, build func(from, to uint64) error) error {
+	from, to, err := unindexed(db, kind)
+	if err != nil {
+		return err
+	}
+	if from > to {
+		return nil
+	}
+	start := time.Now()
+	for n := from; n <= to; n += indexChunk {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := min(n+indexChunk-1, to)
+		if err := build(n, end); err != nil {
+			return fmt.Errorf("%s blocks %d-%d: %w", kind, n, end, err)
+		}
+	}
+	log.Printf("indexer: %s indexed blocks %d-%d elapsed=%s", kind, from, to, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func unindexed(db *store.DB, kind store.IndexKind) (uint64, uint64, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := db.BeginRO()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Abort()
+	from, to := store.UnindexedRange(tx, db, kind)
+	return from, to, nil
+}
+
+// indexChunkOf adds blocks [from, to] to kind in one write transaction.
+func indexChunkOf(db *store.DB, kind store.IndexKind, from, to uint64) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := db.BeginRW()
+	if err != nil {
+		return err
+	}
+	defer tx.Abort()
+	if err := store.BuildIndex(tx, db, kind, from, to); err != nil {
+		return err
+	}
+	_, err = tx.Commit()
+	return err
+}
+
+// indexAtomicChunk adds blocks [from, to] to the atomic tx index. The
+// containers are decoded in a read transaction so the write transaction only
+// holds the lock for the writes.
+func indexAtomicChunk(db *store.DB, dec *atomictx.Decoder, from, to uint64) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	blocks, err := readAtomicTxs(db, dec, from, to)
+	if err != nil {
+		return err
+	}
+	tx, err := db.BeginRW()
+	if err != nil {
+		return err
+	}
+	defer tx.Abort()
+	if err := store.WriteAtomicIndex(tx, db, from, to, blocks); err != nil {
+		return err
+	}
+	_, err = tx.Commit()
+	return err
+}
+
+func readAtomicTxs(db *store.DB, dec *atomictx.Decoder, from, to uint64) (map[uint64][]store.AtomicTx, error) {
+	tx, err := db.BeginRO()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Abort()
+	blocks := make(map[uint64][]store.AtomicTx)
+	for n := from; n <= to; n++ {
+		raw, err := store.GetBlockByNumber(tx, db, n)
+		if err != nil {
+			return nil, fmt.Errorf("read block %d: %w", n, err)
+		}
+		block, err := executorParseEthBlock(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parse block %d: %w", n, err)
+		}
+		txs, err := dec.BlockAtomicTxs(block)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", n, err)
+		}
+		if len(txs) > 0 {
+			blocks[n] = txs
+		}
+	}
+	return blocks, nil
+}
+
+// reverseKeyDict backfills the reverse keyID dictionary of a database
+// written before it existed, in write transactions of its own alongside the
+// executor. It returns once the dictionary is complete or ctx ends; an
+// interrupted backfill resumes on the next start.
+func reverseKeyDict(ctx context.Context, db *store.DB) error {
+	start := time.Now()
+	for chunks := 1; ; chunks++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		done, err := reverseKeyDictChunk(db)
+		if err != nil {
+			return err
+		}
+		if done {
+			if chunks > 1 {
+				log.Printf("indexer: reverse keyID dictionary complete elapsed=%s", time.Since(start).Round(time.Second))
+			}
+			return nil
+		}
+		if chunks%100 == 0 {
+			log.Printf("indexer: reverse keyID dictionary copied=%d elapsed=%s", chunks*keyDictChunk, time.Since(start).Round(time.Second))
+		}
+	}
+}
+
+func reverseKeyDictChunk(db *store.DB) (bool, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := db.BeginRW()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Abort()
+	done, err := store.ReverseKeyDict(tx, db, keyDictChunk)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Commit()
+	return done, err
+}
@@ -0,0 +1,478 @@
+package lightnode
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"runtime"
+
+	corethcore "github.com/ava-labs/avalanchego/graft/coreth/core"
+	cparams "github.com/ava-labs/avalanchego/graft/coreth/params"
+	"github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/customheader"
+	ccustomtypes "github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/customtypes"
+	ethereum "github.com/ava-labs/libevm"
+	"github.com/ava-labs/libevm/accounts/abi"
+	"github.com/ava-labs/libevm/accounts/abi/bind"
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/event"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/chainquery"
+	"block_fetcher/store"
+)
+
+// Node serves abigen bindings and other ethclient users directly.
+var (
+	_ bind.ContractBackend       = (*Node)(nil)
+	_ bind.DeployBackend         = (*Node)(nil)
+	_ ethereum.LogFilterer       = (*Node)(nil)
+	_ ethereum.TransactionReader = (*Node)(nil)
+)
+
+// ErrReadOnly is returned by SendTransaction: a Node only reads the local
+// database and has no way to broadcast.
+var ErrReadOnly = errors.New("lightnode is read-only")
+
+// TransactionByHash returns the transaction with the given hash. A Node has
+// no pool, so isPending is always false; unknown hashes give
+// ethereum.NotFound.
+func (n *Node) TransactionByHash(ctx context.Context, txHash common.Hash) (*types.Transaction, bool, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	tx, err := n.db.BeginRO()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Abort()
+
+	blockNum, txIndex, err := n.txLocation(tx, txHash)
+	if err != nil {
+		return nil, false, err
+	}
+	block, err := n.readBlock(tx, blockNum)
+	if err != nil {
+		return nil, false, err
+	}
+	txs := block.Transactions()
+	if int(txIndex) >= len(txs) {
+		return nil, false, ethereum.NotFound
+	}
+	return txs[txIndex], false, nil
+}
+
+// TransactionReceipt returns the receipt of a mined transaction, rebuilt from
+// the stored receipt and its block.
+func (n *Node) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	tx, err := n.db.BeginRO()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Abort()
+
+	blockNum, txIndex, err := n.txLocation(tx, txHash)
+	if err != nil {
+		return nil, err
+	}
+	keptFrom, err := store.GetPrunedBelow(tx, n.db, store.PruneReceipts)
+	if err != nil {
+		return nil, err
+	}
+	if blockNum < keptFrom {
+		return nil, fmt.Errorf("receipts of block %d are pruned (kept from block %d)", blockNum, keptFrom)
+	}
+	block, err := n.readBlock(tx, blockNum)
+	if err != nil {
+		return nil, err
+	}
+	receipts, err := store.ReadBlockReceipts(tx, n.db, blockNum)
+	if err != nil {
+		return nil, err
+	}
+	txs := block.Transactions()
+	if int(txIndex) >= len(receipts) || int(txIndex) >= len(txs) {
+		return nil, fmt.Errorf("receipt not available for block %d tx %d", blockNum, txIndex)
+	}
+
+	// Log indexes count from the start of the block.
+	var logIndex uint
+	for _, r := range receipts[:txIndex] {
+		logIndex += uint(len(r.Logs))
+	}
+	r := receipts[txIndex]
+	receipt := &types.Receipt{
+		Type:              r.TxType,
+		Status:            uint64(r.Status),
+		CumulativeGasUsed: r.CumulativeGas,
+		TxHash:            txHash,
+		ContractAddress:   common.Address(r.ContractAddress),
+		GasUsed:           r.GasUsed,
+		EffectiveGasPrice: effectiveGasPrice(txs[txIndex], block.BaseFee()),
+		BlockHash:         block.Hash(),
+		BlockNumber:       new(big.Int).SetUint64(blockNum),
+		TransactionIndex:  uint(txIndex),
+	}
+	receipt.Logs = make([]*types.Log, len(r.Logs))
+	for i, l := range r.Logs {
+		log := toLog(l, blockNum, block.Hash(), r.TxHash, txIndex, logIndex)
+		receipt.Logs[i] = &log
+		logIndex++
+	}
+	receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+	return receipt, nil
+}
+
+// txLocation is chainquery.TxLocation with ethereum.NotFound for unknown
+// hashes.
+func (n *Node) txLocation(tx *mdbx.Txn, txHash common.Hash) (uint64, uint16, error) {
+	blockNum, txIndex, err := chainquery.TxLocation(tx, n.db, txHash)
+	if mdbx.IsNotFound(err) {
+		return 0, 0, ethereum.NotFound
+	}
+	return blockNum, txIndex, err
+}
+
+// FilterLogs returns the logs matching q. A nil FromBlock starts at the
+// oldest block whose receipts are kept, a nil ToBlock ends at the head. Like
+// eth_getLogs, a q without addresses or topics may span at most
+// chainquery.MaxUnfilteredLogRange blocks.
+func (n *Node) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	from, to, err := n.resolveFilterRange(q)
+	if err != nil {
+		return nil, err
+	}
+	if from > to {
+		return []types.Log{}, nil
+	}
+	return n.logsInRange(ctx, q, from, to)
+}
+
+// SubscribeFilterLogs delivers the logs matching q from every block executed
+// after the call. FromBlock, ToBlock and BlockHash are ignored. Blocks whose
+// logs can't be read yet, e.g. while the log indexes catch up, are read again
+// on the next head; only unsubscribing ends the subscription.
+func (n *Node) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	heads, cancel := n.db.SubscribeHead()
+	last, err := n.BlockNumber(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer cancel()
+		for {
+			var head uint64
+			select {
+			case <-quit:
+				return nil
+			case head = <-heads:
+			}
+			if head <= last {
+				// After an unwind the blocks above head are executed again.
+				last = head
+				continue
+			}
+			// A head that jumped far is read in windows an unfiltered
+			// query is allowed to span.
+			for last < head {
+				to := min(head, last+chainquery.MaxUnfilteredLogRange)
+				logs, err := n.logsInRange(context.Background(), q, last+1, to)
+				if err != nil {
+					log.Printf("lightnode: logs of blocks %d-%d: %v; retrying on the next head", last+1, to, err)
+					break
+				}
+				last = to
+				for _, l := range logs {
+					select {
+					case ch <- l:
+					case <-quit:
+						return nil
+					}
+				}
+			}
+		}
+	}), nil
+}
+
+// resolveFilterRange turns the block selection of q into an inclusive range.
+func (n *Node) resolveFilterRange(q ethereum.FilterQuery) (uint64, uint64, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	tx, err := n.db.BeginRO()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Abort()
+
+	if q.BlockHash != nil {
+		if q.FromBlock != nil || q.ToBlock != nil {
+			return 0, 0, errors.New("filter with a block hash cannot have a block range")
+		}
+		val, err := tx.Get(n.db.BlockHashIndex, q.BlockHash[:])
+		if mdbx.IsNotFound(err) || (err == nil && len(val) < 8) {
+			return 0, 0, ethereum.NotFound
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		num := binary.BigEndian.Uint64(val)
+		return num, num, nil
+	}
+
+	head, ok := store.GetHeadBlock(tx, n.db)
+	if !ok {
+		return 0, 0, fmt.Errorf("no head block in database")
+	}
+	keptFrom, err := store.GetPrunedBelow(tx, n.db, store.PruneReceipts)
+	if err != nil {
+		return 0, 0, err
+	}
+	// Negative numbers are the latest/pending/finalized tags.
+	from, to := keptFrom, head
+	if q.FromBlock != nil && q.FromBlock.Sign() >= 0 {
+		from = q.FromBlock.Uint64()
+		if from < keptFrom {
+			return 0, 0, fmt.Errorf("receipts of block %d are pruned (kept from block %d)", from, keptFrom)
+		}
+	} else if q.FromBlock != nil {
+		from = head
+	}
+	if q.ToBlock != nil && q.ToBlock.Sign() >= 0 {
+		to = min(q.ToBlock.Uint64(), head)
+	}
+	return from, to, nil
+}
+
+// logsInRange collects the logs in [from, to] matching q.
+func (n *Node) logsInRange(ctx context.Context, q ethereum.FilterQuery, from, to uint64) ([]types.Log, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	tx, err := n.db.BeginRO()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Abort()
+
+	candidates, err := chainquery.LogBlocks(tx, n.db, q, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := []types.Log{}
+	it := candidates.Iterator()
+	for it.HasNext() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blockNum := it.Next()
+		receipts, err := store.ReadBlockReceipts(tx, n.db, blockNum)
+		if err != nil {
+			return nil, fmt.Errorf("read receipts %d: %w", blockNum, err)
+		}
+		var blockHash common.Hash
+		haveHash := false
+		var logIndex uint
+		for txIdx, r := range receipts {
+			for _, l := range r.Logs {
+				if chainquery.MatchesLog(q, l) {
+					// Topic bitmaps are position-agnostic, so some candidates
+					// miss; only decode the block once a log matches.
+					if !haveHash {
+						block, err := n.readBlock(tx, blockNum)
+						if err != nil {
+							return nil, err
+						}
+						blockHash = block.Hash()
+						haveHash = true
+					}
+					logs = append(logs, toLog(l, blockNum, blockHash, r.TxHash, uint16(txIdx), logIndex))
+				}
+				logIndex++
+			}
+		}
+	}
+	return logs, nil
+}
+
+func toLog(l store.LogEntry, blockNum uint64, blockHash common.Hash, txHash [32]byte, txIndex uint16, logIndex uint) types.Log {
+	topics := make([]common.Hash, len(l.Topics))
+	for i, t := range l.Topics {
+		topics[i] = t
+	}
+	return types.Log{
+		Address:     l.Address,
+		Topics:      topics,
+		Data:        l.Data,
+		BlockNumber: blockNum,
+		TxHash:      txHash,
+		TxIndex:     uint(txIndex),
+		BlockHash:   blockHash,
+		Index:       logIndex,
+	}
+}
+
+// EstimateGas binary-searches the lowest gas limit at which call succeeds
+// against the head state, like the RPC server's eth_estimateGas.
+func (n *Node) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	num, err := n.resolveBlockNumber(nil)
+	if err != nil {
+		return 0, err
+	}
+	hi, err := n.gasCap(num, call)
+	if err != nil {
+		return 0, err
+	}
+
+	return chainquery.EstimateGas(hi, func(gas uint64) (*corethcore.ExecutionResult, error) {
+		msg := call
+		msg.Gas = gas
+		return n.execute(ctx, msg, num, nil, nil)
+	}, func(result *corethcore.ExecutionResult) error {
+		if reason, err := abi.UnpackRevert(result.Revert()); err == nil {
+			return fmt.Errorf("%w: %s", result.Err, reason)
+		}
+		return result.Err
+	})
+}
+
+// gasCap is the highest gas limit EstimateGas will try: call.Gas if set,
+// else the block gas limit, lowered to what the sender's balance covers at
+// a non-zero gas price.
+func (n *Node) gasCap(num uint64, call ethereum.CallMsg) (uint64, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	tx, err := n.db.BeginRO()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Abort()
+
+	var gasLimit uint64
+	if call.Gas == 0 {
+		block, err := n.readBlock(tx, num)
+		if err != nil {
+			return 0, err
+		}
+		gasLimit = block.GasLimit()
+	}
+	feeCap := call.GasPrice
+	if feeCap == nil {
+		feeCap = call.GasFeeCap
+	}
+	return chainquery.GasCap(call.Gas, gasLimit, feeCap, call.Value, func() (*big.Int, error) {
+		acct, err := store.LookupHistoricalAccount(tx, n.db, call.From, num)
+		if err != nil || acct == nil {
+			return new(big.Int), err
+		}
+		return new(big.Int).SetBytes(acct.Balance[:]), nil
+	})
+}
+
+// SuggestGasTipCap returns the tip chainquery.SuggestTip suggests at the
+// head, as eth_maxPriorityFeePerGas does.
+func (n *Node) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	tx, err := n.db.BeginRO()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Abort()
+
+	return n.suggestTip(tx)
+}
+
+// SuggestGasPrice returns the suggested tip plus the estimated base fee of
+// the block after the head.
+func (n *Node) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	tx, err := n.db.BeginRO()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Abort()
+
+	tip, err := n.suggestTip(tx)
+	if err != nil {
+		return nil, err
+	}
+	head, _ := store.GetHeadBlock(tx, n.db)
+	block, err := n.readBlock(tx, head)
+	if err != nil {
+		return nil, err
+	}
+	parent := block.Header()
+	if parent.BaseFee == nil {
+		return tip, nil
+	}
+	baseFee, err := customheader.EstimateNextBaseFee(cparams.GetExtra(n.chainCfg), parent, ccustomtypes.HeaderTimeMilliseconds(parent))
+	if err != nil {
+		return nil, fmt.Errorf("estimate next base fee: %w", err)
+	}
+	return tip.Add(tip, baseFee), nil
+}
+
+func (n *Node) suggestTip(tx *mdbx.Txn) (*big.Int, error) {
+	head, ok := store.GetHeadBlock(tx, n.db)
+	if !ok || head == 0 {
+		return nil, fmt.Errorf("no executed blocks")
+	}
+	return chainquery.SuggestTip(head, func(num uint64) (*types.Block, error) {
+		return n.readBlock(tx, num)
+	})
+}
+
+// PendingCodeAt returns the code of account at the head; a Node has no
+// pending state.
+func (n *Node) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	return n.CodeAt(ctx, account, nil)
+}
+
+// PendingNonceAt returns the nonce of account at the head; a Node has no
+// pending state.
+func (n *Node) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return n.NonceAt(ctx, account, nil)
+}
+
+// SendTransaction always fails with ErrReadOnly.
+func (n *Node) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	return ErrReadOnly
+}
+
+// readBlock reads and decodes block num within tx.
+func (n *Node) readBlock(tx *mdbx.Txn, num uint64) (*types.Block, error) {
+	raw, err := store.GetBlockByNumber(tx, n.db, num)
+	if err != nil {
+		return nil, fmt.Errorf("get block %d: %w", num, err)
+	}
+	block, err := parseEthBlock(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse block %d: %w", num, err)
+	}
+	return block, nil
+}
+
+// effectiveGasPrice is what t paid per gas in a block with baseFee.
+func effectiveGasPrice(t *types.Transaction, baseFee *big.Int) *big.Int {
+	if baseFee == nil {
+		return t.GasPrice()
+	}
+	tip, err := t.EffectiveGasTip(baseFee)
+	if err != nil {
+		return t.GasPrice()
+	}
+	return tip.Add(tip, baseFee)
+}
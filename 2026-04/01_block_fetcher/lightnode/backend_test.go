@@ -0,0 +1,211 @@
+package lightnode
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	cparams "github.com/ava-labs/avalanchego/graft/coreth/params"
+	ethereum "github.com/ava-labs/libevm"
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/rlp"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/chainquery"
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+var (
+	logTestA = common.Address{19: 0xa}
+	logTestB = common.Address{19: 0xb}
+	logTestX = common.Hash{31: 0x1}
+	logTestY = common.Hash{31: 0x2}
+)
+
+// newLogTestNode returns a Node on a fresh database, opened read-write so the
+// test can add blocks to it.
+func newLogTestNode(t *testing.T) (*Node, *store.DB) {
+	t.Helper()
+	registerExtras()
+	db := storetest.Open(t, store.Open)
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		if err := store.InitIndexWatermarks(tx, db); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return &Node{db: db, chainCfg: cparams.TestChainConfig}, db
+}
+
+// writeLogTestBlock stores block num, indexed by its hash, with one tx,
+// whose hash is num, that logged logs, and returns the block's hash.
+func writeLogTestBlock(t *testing.T, tx *mdbx.Txn, db *store.DB, num uint64, logs ...store.LogEntry) common.Hash {
+	t.Helper()
+	block := types.NewBlockWithHeader(&types.Header{Number: new(big.Int).SetUint64(num), Difficulty: big.NewInt(1)})
+	raw, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutContainer(tx, db, block.Hash(), num, raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.FlushBlockHashBatch(tx, db, [][2]uint64{{num}}, [][32]byte{block.Hash()}); err != nil {
+		t.Fatal(err)
+	}
+	receipts := []store.TxReceipt{{TxHash: [32]byte{31: byte(num)}, Status: 1, Logs: logs}}
+	if err := store.WriteBlockReceipts(tx, db, num, receipts); err != nil {
+		t.Fatal(err)
+	}
+	return block.Hash()
+}
+
+func logTestEntry(addr common.Address, topic common.Hash) store.LogEntry {
+	return store.LogEntry{Address: addr, Topics: [][32]byte{topic}, Data: []byte{0x2a}}
+}
+
+// logTestKey identifies a delivered log by block, index in the block and
+// block hash.
+type logTestKey struct {
+	block, index uint64
+	hash         common.Hash
+}
+
+func logTestKeys(logs []types.Log) []logTestKey {
+	keys := make([]logTestKey, len(logs))
+	for i, l := range logs {
+		keys[i] = logTestKey{l.BlockNumber, uint64(l.Index), l.BlockHash}
+	}
+	return keys
+}
+
+func TestFilterLogs(t *testing.T) {
+	n, db := newLogTestNode(t)
+	hashes := make(map[uint64]common.Hash)
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		hashes[1] = writeLogTestBlock(t, tx, db, 1)
+		hashes[2] = writeLogTestBlock(t, tx, db, 2, logTestEntry(logTestA, logTestX))
+		hashes[3] = writeLogTestBlock(t, tx, db, 3)
+		hashes[4] = writeLogTestBlock(t, tx, db, 4, logTestEntry(logTestB, logTestX))
+		hashes[5] = writeLogTestBlock(t, tx, db, 5, logTestEntry(logTestB, logTestY), logTestEntry(logTestA, logTestY))
+		if err := store.SetHeadBlock(tx, db, 5); err != nil {
+			t.Fatal(err)
+		}
+		// Blocks 4 and 5 are read past the indexes.
+		if err := store.BuildIndex(tx, db, store.IndexLogs, 1, 3); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	hash4 := hashes[4]
+	for _, tc := range []struct {
+		name string
+		q    ethereum.FilterQuery
+		want []logTestKey
+	}{
+		{"address", ethereum.FilterQuery{Addresses: []common.Address{logTestA}}, []logTestKey{{2, 0, hashes[2]}, {5, 1, hashes[5]}}},
+		{"topic", ethereum.FilterQuery{Topics: [][]common.Hash{{logTestX}}}, []logTestKey{{2, 0, hashes[2]}, {4, 0, hashes[4]}}},
+		{"unfiltered from 4", ethereum.FilterQuery{FromBlock: big.NewInt(4)}, []logTestKey{{4, 0, hashes[4]}, {5, 0, hashes[5]}, {5, 1, hashes[5]}}},
+		{"to 3", ethereum.FilterQuery{ToBlock: big.NewInt(3)}, []logTestKey{{2, 0, hashes[2]}}},
+		{"block hash", ethereum.FilterQuery{BlockHash: &hash4}, []logTestKey{{4, 0, hashes[4]}}},
+		{"no match", ethereum.FilterQuery{Addresses: []common.Address{logTestB}, Topics: [][]common.Hash{{logTestX}, {logTestY}}}, []logTestKey{}},
+	} {
+		logs, err := n.FilterLogs(context.Background(), tc.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := logTestKeys(logs); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: logs %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	logs, err := n.FilterLogs(context.Background(), ethereum.FilterQuery{BlockHash: &hash4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := logs[0]; l.Address != logTestB || l.TxHash != (common.Hash{31: 4}) || len(l.Data) != 1 || l.Data[0] != 0x2a {
+		t.Errorf("log %+v", l)
+	}
+	if _, err := n.FilterLogs(context.Background(), ethereum.FilterQuery{FromBlock: big.NewInt(1), BlockHash: &hash4}); err == nil {
+		t.Error("block hash with a range was accepted")
+	}
+}
+
+// TestSubscribeFilterLogs has the head move past the log indexes by more
+// than a query may scan: the subscription stays open and delivers those logs
+// once the indexes catch up.
+func TestSubscribeFilterLogs(t *testing.T) {
+	n, db := newLogTestNode(t)
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		writeLogTestBlock(t, tx, db, 1, logTestEntry(logTestA, logTestX))
+		if err := store.SetHeadBlock(tx, db, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.BuildIndex(tx, db, store.IndexLogs, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	ch := make(chan types.Log, 4)
+	sub, err := n.SubscribeFilterLogs(context.Background(), ethereum.FilterQuery{Addresses: []common.Address{logTestA}}, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	expect := func(what string, want []logTestKey) {
+		t.Helper()
+		var got []types.Log
+		for range want {
+			select {
+			case l := <-ch:
+				got = append(got, l)
+			case err := <-sub.Err():
+				t.Fatalf("%s: subscription ended: %v", what, err)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: got %v, want %v", what, logTestKeys(got), want)
+			}
+		}
+		select {
+		case l := <-ch:
+			t.Fatalf("%s: extra log %+v", what, l)
+		case err := <-sub.Err():
+			t.Fatalf("%s: subscription ended: %v", what, err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if keys := logTestKeys(got); fmt.Sprint(keys) != fmt.Sprint(want) {
+			t.Fatalf("%s: logs %v, want %v", what, keys, want)
+		}
+	}
+	commitHead := func(head uint64, fn func(tx *mdbx.Txn)) {
+		storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+			fn(tx)
+			if err := store.SetHeadBlock(tx, db, head); err != nil {
+				t.Fatal(err)
+			}
+		})
+		db.PublishHead(head)
+	}
+
+	var hash2, hash3 common.Hash
+	commitHead(2, func(tx *mdbx.Txn) {
+		hash2 = writeLogTestBlock(t, tx, db, 2, logTestEntry(logTestB, logTestX), logTestEntry(logTestA, logTestY))
+	})
+	expect("block 2", []logTestKey{{2, 1, hash2}})
+
+	// Blocks 3 to far are more unindexed blocks than a query scans: they
+	// are refused until the indexes reach them.
+	far := uint64(3 + chainquery.MaxUnindexedScan)
+	commitHead(far, func(tx *mdbx.Txn) {
+		hash3 = writeLogTestBlock(t, tx, db, 3, logTestEntry(logTestA, logTestX))
+	})
+	expect("head far past the indexes", nil)
+
+	commitHead(far+1, func(tx *mdbx.Txn) {
+		if err := store.BuildIndex(tx, db, store.IndexLogs, 2, far); err != nil {
+			t.Fatal(err)
+		}
+	})
+	expect("indexes caught up", []logTestKey{{3, 0, hash3}})
+}
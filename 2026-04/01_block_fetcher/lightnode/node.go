@@ -1,28 +1,30 @@
 package lightnode
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"math/big"
 	"runtime"
 	"sync"
 
-	"github.com/ava-labs/avalanchego/genesis"
 	corethcore "github.com/ava-labs/avalanchego/graft/coreth/core"
 	"github.com/ava-labs/avalanchego/graft/coreth/core/extstate"
 	cparams "github.com/ava-labs/avalanchego/graft/coreth/params"
 	ccustomtypes "github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/customtypes"
-	avaconstants "github.com/ava-labs/avalanchego/utils/constants"
+	"github.com/ava-labs/avalanchego/ids"
 	proposerblock "github.com/ava-labs/avalanchego/vms/proposervm/block"
+	ethereum "github.com/ava-labs/libevm"
 	"github.com/ava-labs/libevm/common"
 	"github.com/ava-labs/libevm/core/state"
 	"github.com/ava-labs/libevm/core/types"
 	"github.com/ava-labs/libevm/core/vm"
 	"github.com/ava-labs/libevm/params"
 	"github.com/ava-labs/libevm/rlp"
+	"github.com/erigontech/mdbx-go/mdbx"
 	"github.com/holiman/uint256"
 
+	"block_fetcher/profile"
 	"block_fetcher/statetrie"
 	"block_fetcher/store"
 )
@@ -47,6 +49,7 @@ func registerExtras() {
 type Config struct {
 	DataDir string // MDBX data directory
 	NodeURI string // Avalanche node URI for peer discovery (not needed for queries, only sync)
+	Network string // network of the C-Chain in DataDir: mainnet (the default) or fuji
 }
 
 // Node provides ethclient.Client-compatible read methods backed by MDBX historical state.
@@ -55,34 +58,64 @@ type Node struct {
 	chainCfg *params.ChainConfig
 }
 
-// New opens an MDBX database and loads chain config from the C-Chain genesis.
-// It does NOT start syncing.
+// New opens an MDBX database read-only and takes the chain config from the
+// profile of cfg.Network, the one the executor ran the blocks with. It does
+// NOT start syncing; the database can be shared with a running block_fetcher
+// that does. As it creates nothing, DataDir must hold a database
+// block_fetcher has already opened.
 func New(cfg Config) (*Node, error) {
 	registerExtras()
 
-	db, err := store.Open(cfg.DataDir)
+	network := cfg.Network
+	if network == "" {
+		network = "mainnet"
+	}
+	prof, err := profile.ByName(network)
 	if err != nil {
-		return nil, fmt.Errorf("open mdbx: %w", err)
+		return nil, err
 	}
 
-	// Load chain config from genesis.
-	config := genesis.GetConfig(avaconstants.MainnetID)
-	var cChainGenesis corethcore.Genesis
-	if err := json.Unmarshal([]byte(config.CChainGenesis), &cChainGenesis); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("parse C-Chain genesis: %w", err)
+	db, err := store.OpenReadOnly(cfg.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("open mdbx: %w", err)
 	}
-	if err := cparams.SetEthUpgrades(cChainGenesis.Config); err != nil {
+	if err := checkChain(db, prof); err != nil {
 		db.Close()
-		return nil, fmt.Errorf("set eth upgrades: %w", err)
+		return nil, err
 	}
 
 	return &Node{
 		db:       db,
-		chainCfg: cChainGenesis.Config,
+		chainCfg: prof.ChainConfig(),
 	}, nil
 }
 
+// checkChain refuses a database whose chain marker names another chain than
+// prof's. Databases without one hold mainnet.
+func checkChain(db *store.DB, prof *profile.Profile) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := db.BeginRO()
+	if err != nil {
+		return err
+	}
+	defer tx.Abort()
+
+	stored, err := tx.Get(db.Metadata, []byte("blockchain_id"))
+	switch {
+	case err == nil:
+		if !bytes.Equal(stored, prof.BlockchainID[:]) {
+			storedID, _ := ids.ToID(stored)
+			return fmt.Errorf("database holds chain %s, not %s (%s)", storedID, prof.BlockchainID, prof.Name)
+		}
+	case !mdbx.IsNotFound(err):
+		return fmt.Errorf("read chain marker: %w", err)
+	case !prof.EmbeddedCheckpoints:
+		return fmt.Errorf("database has no chain marker, so it holds mainnet C-Chain, not %s", prof.Name)
+	}
+	return nil
+}
+
 // Close closes the underlying MDBX database.
 func (n *Node) Close() error {
 	n.db.Close()
@@ -294,20 +327,44 @@ func (n *Node) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Head
 	return block.Header(), nil
 }
 
-// TransactionByHash returns a transaction by its hash (scans blocks).
-// For now this is O(blocks) — a tx hash index would make it O(1).
-func (n *Node) TransactionByHash(ctx context.Context, txHash common.Hash) (*types.Transaction, uint64, error) {
-	// TODO: add a tx hash index for O(1) lookup
-	return nil, 0, fmt.Errorf("TransactionByHash not yet implemented (needs tx index)")
-}
-
 // CallContract executes a contract call against historical state.
 func (n *Node) CallContract(ctx context.Context, msg CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return n.CallContractWithOverrides(ctx, msg, blockNumber, nil, nil)
+}
+
+// CallContractWithOverrides is CallContract with go-ethereum style state and
+// block overrides applied on top of the historical state. Either may be nil.
+func (n *Node) CallContractWithOverrides(
+	ctx context.Context,
+	msg CallMsg,
+	blockNumber *big.Int,
+	stateOverrides *StateOverride,
+	blockOverrides *BlockOverrides,
+) ([]byte, error) {
 	num, err := n.resolveBlockNumber(blockNumber)
 	if err != nil {
 		return nil, err
 	}
+	result, err := n.execute(ctx, msg, num, stateOverrides, blockOverrides)
+	if err != nil {
+		return nil, err
+	}
+	if result.Err != nil {
+		return result.ReturnData, result.Err
+	}
+	return result.ReturnData, nil
+}
 
+// execute runs msg on top of the state after block num and returns the raw
+// execution result. Errors that keep the message from executing at all, such
+// as intrinsic gas, are returned wrapped.
+func (n *Node) execute(
+	ctx context.Context,
+	msg CallMsg,
+	num uint64,
+	stateOverrides *StateOverride,
+	blockOverrides *BlockOverrides,
+) (*corethcore.ExecutionResult, error) {
 	// Get block header for EVM context.
 	runtime.LockOSThread()
 	rtx, err := n.db.BeginRO()
@@ -350,6 +407,7 @@ func (n *Node) CallContract(ctx context.Context, msg CallMsg, blockNumber *big.I
 		return b.Hash()
 	}
 	blockCtx := buildBlockContext(header, n.chainCfg, getHashFn)
+	blockOverrides.Apply(&blockCtx)
 
 	// Build historical statedb using real *state.StateDB backed by statetrie.
 	// This is critical: corethcore.RegisterExtras() installs a hook that wraps
@@ -360,6 +418,9 @@ func (n *Node) CallContract(ctx context.Context, msg CallMsg, blockNumber *big.I
 	if err != nil {
 		return nil, fmt.Errorf("create historical statedb at block %d: %w", num, err)
 	}
+	if err := stateOverrides.Apply(statedb); err != nil {
+		return nil, err
+	}
 
 	// Build tx context from the call message.
 	gas := msg.Gas
@@ -401,30 +462,26 @@ func (n *Node) CallContract(ctx context.Context, msg CallMsg, blockNumber *big.I
 		GasFeeCap:         msg.GasFeeCap,
 		GasTipCap:         msg.GasTipCap,
 		Data:              msg.Data,
-		AccessList:        nil,
+		AccessList:        msg.AccessList,
 		SkipAccountChecks: true,
 	}, new(corethcore.GasPool).AddGas(gas))
 	if err != nil {
 		return nil, fmt.Errorf("evm execution: %w", err)
 	}
-	if result.Err != nil {
-		return result.ReturnData, result.Err
-	}
-	return result.ReturnData, nil
+	return result, nil
 }
 
-// CallMsg mirrors ethereum.CallMsg to avoid importing the interfaces package
-// which may conflict. We accept the same fields.
-type CallMsg struct {
-	From      common.Address
-	To        *common.Address
-	Gas       uint64
-	GasPrice  *big.Int
-	GasFeeCap *big.Int
-	GasTipCap *big.Int
-	Value     *big.Int
-	Data      []byte
-}
+// StateOverride, OverrideAccount and BlockOverrides are the call override
+// types shared with the RPC server.
+type (
+	StateOverride   = statetrie.StateOverride
+	OverrideAccount = statetrie.OverrideAccount
+	BlockOverrides  = statetrie.BlockOverrides
+)
+
+// CallMsg is ethereum.CallMsg, kept as a name so callers need not import
+// the libevm root package. The blob fields are ignored.
+type CallMsg = ethereum.CallMsg
 
 // parseEthBlock decodes a raw block from MDBX. It first tries to unwrap a
 // ProposerVM envelope; if that fails it falls back to a pre-fork RLP decode.
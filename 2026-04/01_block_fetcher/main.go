@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
 	"encoding/json"
@@ -26,7 +27,6 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/ava-labs/avalanchego/api/info"
-	"github.com/ava-labs/avalanchego/genesis"
 	corethconsensus "github.com/ava-labs/avalanchego/graft/coreth/consensus"
 	"github.com/ava-labs/avalanchego/graft/coreth/consensus/dummy"
 	corethcore "github.com/ava-labs/avalanchego/graft/coreth/core"
@@ -45,7 +45,6 @@ import (
 	"github.com/ava-labs/avalanchego/snow/validators"
 	"github.com/ava-labs/avalanchego/staking"
 	"github.com/ava-labs/avalanchego/subnets"
-	"github.com/ava-labs/avalanchego/upgrade"
 	"github.com/ava-labs/avalanchego/utils/compression"
 	avaconstants "github.com/ava-labs/avalanchego/utils/constants"
 	"github.com/ava-labs/avalanchego/utils/logging"
@@ -63,20 +62,20 @@ import (
 	"github.com/erigontech/mdbx-go/mdbx"
 	"github.com/holiman/uint256"
 
+	"block_fetcher/atomictx"
+	"block_fetcher/profile"
 	rpcpkg "block_fetcher/rpc"
 	"block_fetcher/statetrie"
 	"block_fetcher/store"
 )
 
 const (
-	defaultNodeURI       = "https://api.avax.network"
 	defaultDBDir         = "data/mainnet-mdbx"
 	defaultConnectWait   = 30 * time.Second
 	defaultPeerWarmup    = 5 * time.Second
 	defaultRequestWait   = 20 * time.Second
 	defaultWriterBuffer  = 4096
 	defaultBatchSize     = 256
-	defaultExpectedNetID = uint32(1)
 	defaultPrimarySubnet = "11111111111111111111111111111111LpoYY"
 	defaultFixedTipBlock = uint64(1_000_000)
 )
@@ -228,22 +227,68 @@ func main() {
 	extstate.RegisterExtras()
 	cparams.RegisterExtras()
 
+	if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+		if err := runSnapshot(os.Args[2:]); err != nil {
+			log.Fatalf("snapshot: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "fsck" {
+		if err := runFsck(os.Args[2:]); err != nil {
+			log.Fatalf("fsck: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "unwind" {
+		if err := runUnwind(os.Args[2:]); err != nil {
+			log.Fatalf("unwind: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		if err := runVerify(os.Args[2:]); err != nil {
+			log.Fatalf("verify: %v", err)
+		}
+		return
+	}
+
 	var (
-		nodeURI       = flag.String("node-uri", defaultNodeURI, "base AvalancheGo URI for local RPC discovery")
+		nodeURI       = flag.String("node-uri", "", "base AvalancheGo URI for API discovery, and for the validator set of p2p discovery (default: the network's public API)")
+		discoveryMode = flag.String("discovery", discoveryAPI, "peer discovery: api (info/platform APIs at -node-uri) or p2p (bootstrappers and PeerList gossip; the validator set comes from -validators-file, or else from the platform API at -node-uri)")
+		vdrFile       = flag.String("validators-file", "", "p2p discovery: JSON array of validator node IDs (default: fetched from the platform API at -node-uri)")
 		dbDir         = flag.String("db-dir", defaultDBDir, "MDBX database directory")
 		connectWait   = flag.Duration("connect-timeout", defaultConnectWait, "time to wait for a validator peer connection")
 		peerWarmup    = flag.Duration("peer-warmup", defaultPeerWarmup, "extra time to gather more connected peers before fetching")
 		requestWait   = flag.Duration("request-timeout", defaultRequestWait, "time to wait for each P2P response")
 		writerBuffer  = flag.Int("writer-buffer", defaultWriterBuffer, "number of fetched containers to buffer before blocking")
 		batchSize     = flag.Int("batch-size", defaultBatchSize, "number of containers per MDBX batch")
-		expectedNet   = flag.Uint("expected-network-id", uint(defaultExpectedNetID), "expected Avalanche network ID")
-		subnetIDStr   = flag.String("subnet-id", defaultPrimarySubnet, "subnet ID for platform.getCurrentValidators")
+		netName       = flag.String("network", "mainnet", "Avalanche network: mainnet or fuji")
+		expectedNet   = flag.Uint("expected-network-id", 0, "if set, fail unless -network has this network ID")
+		subnetIDStr   = flag.String("subnet-id", defaultPrimarySubnet, "subnet validating the chain (an L1's subnet with -l1-genesis)")
+		l1Genesis     = flag.String("l1-genesis", "", "Subnet-EVM genesis JSON: sync this L1 on -network instead of the C-Chain")
+		l1ChainCfg    = flag.String("l1-chain-config", "", "L1 EVM chain config JSON, replacing the genesis config")
+		l1ChainID     = flag.String("l1-blockchain-id", "", "L1 blockchain ID")
 		cleanState    = flag.Bool("clean-state", false, "clear all state tables (keep blocks) and re-execute from genesis")
 		execBatchSize = flag.Uint64("exec-batch-size", 50000, "number of blocks per executor batch (verified every batch)")
 		fetchWorkers  = flag.Int("fetch-workers", 32, "number of parallel fetch workers")
 		execOnly      = flag.Bool("exec-only", false, "run executor only, no fetcher/writer/network")
 		execStop      = flag.Uint64("exec-stop", 0, "stop executor after reaching this block number (0 = no limit)")
+		execWorkers   = flag.Int("exec-workers", 1, "goroutines executing a block's transactions speculatively in parallel (1 = serial)")
 		rpcAddr       = flag.String("rpc-addr", ":9670", "JSON-RPC server listen address")
+		readOnly      = flag.Bool("read-only", false, "open the database read-only next to a running block_fetcher and only serve JSON-RPC")
+		follow        = flag.Bool("follow", false, "after the checkpoint backfill, keep following the accepted tip instead of exiting")
+		followEvery   = flag.Duration("follow-interval", defaultFollowInterval, "how often to poll peers for the accepted frontier when following")
+		liveBatchSize = flag.Uint64("live-batch-size", defaultLiveBatchSize, "max blocks per executor batch once following the tip")
+		rpcMaxBatch   = flag.Int("rpc-max-batch", rpcpkg.DefaultServerConfig().MaxBatchSize, "maximum entries in one JSON-RPC batch")
+		rpcBatchProcs = flag.Int("rpc-batch-workers", rpcpkg.DefaultServerConfig().BatchWorkers, "batch entries served in parallel")
+		rpcMaxConc    = flag.Int("rpc-max-concurrent", rpcpkg.DefaultServerConfig().MaxConcurrent, "JSON-RPC requests executing at once")
+		rpcTimeout    = flag.Duration("rpc-timeout", rpcpkg.DefaultServerConfig().Timeout, "default JSON-RPC request timeout")
+		rpcEVMTimeout = flag.Duration("rpc-evm-timeout", 5*time.Second, "timeout for eth_call, eth_estimateGas and eth_createAccessList")
+		pruneHistory  = flag.Uint64("prune.history", 0, "keep historical state (changesets, history index) for this many blocks below the head (0 = keep all)")
+		pruneReceipts = flag.Uint64("prune.receipts", 0, "keep receipts and logs for this many blocks below the head (0 = keep all)")
+		pruneTxIndex  = flag.Uint64("prune.txindex", 0, "keep the tx hash index for this many blocks below the head (0 = keep all)")
+		pruneEvery    = flag.Duration("prune.interval", defaultPruneInterval, "how often the pruner runs")
+		packEvery     = flag.Duration("pack.interval", 0, "how often stored containers are moved into ZSTD block-pack segment files (0 = never)")
 	)
 	flag.Parse()
 
@@ -256,18 +301,48 @@ func main() {
 	if *batchSize <= 0 {
 		log.Fatalf("batch-size must be > 0")
 	}
+	if *pruneEvery <= 0 {
+		log.Fatalf("prune.interval must be > 0")
+	}
 
-	db, err := store.Open(*dbDir)
+	prof, err := loadProfile(*netName, *subnetIDStr, *l1Genesis, *l1ChainCfg, *l1ChainID)
+	if err != nil {
+		log.Fatalf("chain profile: %v", err)
+	}
+	if *expectedNet > 0 && prof.NetworkID != uint32(*expectedNet) {
+		log.Fatalf("unexpected network ID: got=%d want=%d", prof.NetworkID, *expectedNet)
+	}
+	log.Printf("chain profile: %s network_id=%d subnet_id=%s blockchain_id=%s evm_chain_id=%s vm=%s",
+		prof.Name, prof.NetworkID, prof.SubnetID, prof.BlockchainID, prof.EVMChainID(), prof.VM)
+	if *nodeURI == "" {
+		*nodeURI = prof.PublicAPI
+	}
+
+	if *readOnly && *cleanState {
+		log.Fatalf("-clean-state cannot be used with -read-only")
+	}
+	var db *store.DB
+	if *readOnly {
+		db, err = store.OpenReadOnly(*dbDir)
+	} else {
+		db, err = store.Open(*dbDir)
+	}
 	if err != nil {
 		log.Fatalf("open MDBX: %v", err)
 	}
 	defer db.Close()
+	if err := checkChainMarker(db, prof); err != nil {
+		log.Fatalf("%v", err)
+	}
 
-	// Only expose pprof after the DB lock is held.
-	go func() {
-		log.Println("pprof listening on :6060")
-		log.Println(http.ListenAndServe(":6060", nil))
-	}()
+	// Only expose pprof after the DB lock is held. A read-only process runs
+	// next to the writer, which already has the port.
+	if !*readOnly {
+		go func() {
+			log.Println("pprof listening on :6060")
+			log.Println(http.ListenAndServe(":6060", nil))
+		}()
+	}
 
 	if *cleanState {
 		log.Printf("clearing state tables (keeping blocks)...")
@@ -278,25 +353,51 @@ func main() {
 	}
 
 	// Start JSON-RPC server.
-	rpcBackend := rpcpkg.NewBackend(db)
-	rpcServer := rpcpkg.NewServer(rpcBackend)
+	rpcBackend := rpcpkg.NewBackend(db, prof)
+	rpcCfg := rpcpkg.DefaultServerConfig()
+	rpcCfg.MaxBatchSize = *rpcMaxBatch
+	rpcCfg.BatchWorkers = *rpcBatchProcs
+	rpcCfg.MaxConcurrent = *rpcMaxConc
+	rpcCfg.Timeout = *rpcTimeout
+	for method := range rpcCfg.MethodTimeouts {
+		rpcCfg.MethodTimeouts[method] = *rpcEVMTimeout
+	}
+	rpcServer := rpcpkg.NewServer(rpcBackend, rpcCfg)
 	go func() {
 		if err := rpcServer.ListenAndServe(*rpcAddr); err != nil {
 			log.Printf("RPC server error: %v", err)
 		}
 	}()
 
+	if *readOnly {
+		log.Printf("read-only mode: serving JSON-RPC on %s, no executor/fetcher", *rpcAddr)
+		<-ctx.Done()
+		return
+	}
+
 	executorStopAt := make(chan uint64, 1)
 	if *execStop > 0 {
 		executorStopAt <- *execStop
 		log.Printf("executor: will stop at block %d", *execStop)
 	}
 	executorErrCh := make(chan error, 1)
+	executorLive := make(chan struct{})
 	go func() {
-		executorErrCh <- runExecutor(ctx, db, executorStopAt, *execBatchSize)
+		executorErrCh <- runExecutor(ctx, db, prof, executorStopAt, *execBatchSize, executorLive, *liveBatchSize, *execWorkers)
 	}()
 
+	pruneCfg := pruneConfig{history: *pruneHistory, receipts: *pruneReceipts, txIndex: *pruneTxIndex}
+	if pruneCfg.enabled() {
+		go runPruner(ctx, db, pruneCfg, *pruneEvery)
+	}
+	if *packEvery > 0 {
+		go runPacker(ctx, db, *packEvery)
+	}
+
 	if *execOnly {
+		if !prof.Executable() {
+			log.Fatalf("exec-only: %s blocks cannot be executed", prof.VM)
+		}
 		log.Printf("exec-only mode: no fetcher/writer")
 		if err := <-executorErrCh; err != nil {
 			log.Fatalf("executor: %v", err)
@@ -310,46 +411,23 @@ func main() {
 		writerErrCh <- runWriter(ctx, db, writerCh, *batchSize)
 	}()
 
-	subnetID, err := ids.FromString(*subnetIDStr)
-	if err != nil {
-		log.Fatalf("invalid subnet-id: %v", err)
-	}
-
-	infoClient := info.NewClient(*nodeURI)
-	pClient := platformvm.NewClient(*nodeURI)
-
-	networkID, err := infoClient.GetNetworkID(ctx)
-	if err != nil {
-		log.Fatalf("info.getNetworkID: %v", err)
-	}
-	if *expectedNet > 0 && networkID != uint32(*expectedNet) {
-		log.Fatalf("unexpected network ID: got=%d want=%d", networkID, *expectedNet)
-	}
-	chainID, err := infoClient.GetBlockchainID(ctx, "C")
-	if err != nil {
-		log.Fatalf("info.getBlockchainID(C): %v", err)
+	var disc *discovery
+	switch *discoveryMode {
+	case discoveryAPI:
+		disc, err = discoverFromAPI(ctx, *nodeURI, prof)
+	case discoveryP2P:
+		disc, err = discoverFromP2P(ctx, prof, *vdrFile, *nodeURI)
+	default:
+		err = fmt.Errorf("unknown discovery mode %q (want %s or %s)", *discoveryMode, discoveryAPI, discoveryP2P)
 	}
-	log.Printf("network info: network_id=%d chain_id=%s", networkID, chainID)
-
-	validatorIDs, err := loadValidatorIDs(ctx, pClient, subnetID)
 	if err != nil {
-		log.Fatalf("platform.getCurrentValidators: %v", err)
+		log.Fatalf("discovery: %v", err)
 	}
-	log.Printf("validator set loaded: count=%d", len(validatorIDs))
+	networkID, chainID := prof.NetworkID, prof.BlockchainID
+	log.Printf("network info: network_id=%d chain_id=%s discovery=%s", networkID, chainID, *discoveryMode)
+	log.Printf("peer candidates loaded: allowed=%d seeds=%d validator_only=%t", disc.allowed.Len(), len(disc.seeds), disc.validatorOnly)
 
-	peerInfos, validatorOnly, err := discoverPeers(ctx, infoClient, validatorIDs)
-	if err != nil {
-		log.Fatalf("discover peers: %v", err)
-	}
-	if len(peerInfos) == 0 {
-		log.Fatalf("no peers available from info.peers")
-	}
-	log.Printf("peer candidates loaded: count=%d validator_only=%t", len(peerInfos), validatorOnly)
-
-	peerIDs := set.NewSet[ids.NodeID](len(peerInfos))
-	for _, peerInfo := range peerInfos {
-		peerIDs.Add(peerInfo.ID)
-	}
+	peerIDs := disc.allowed
 	peerTracker, err := avap2p.NewPeerTracker(
 		logging.NoLog{},
 		"block_fetcher",
@@ -362,7 +440,7 @@ func main() {
 	}
 
 	handler := &inboundHandler{
-		connectedCh: make(chan ids.NodeID, len(peerInfos)+4),
+		connectedCh: make(chan ids.NodeID, peerIDs.Len()+4),
 		frontierCh:  make(chan frontierResponse, 4),
 		ancestorsCh: make(chan ancestorsResponse, 8),
 		peers:       peerIDs,
@@ -373,11 +451,22 @@ func main() {
 	vdrs := &permissiveValidatorManager{
 		Manager: validators.NewManager(),
 	}
+	for _, nodeID := range disc.validators {
+		if err := vdrs.AddStaker(prof.SubnetID, nodeID, nil, ids.Empty, 1); err != nil {
+			log.Fatalf("add validator %s: %v", nodeID, err)
+		}
+	}
+	// An L1's subnet has to be tracked for its validators' IPs to be
+	// gossiped to us; the primary network is always tracked.
+	trackedSubnets := set.Set[ids.ID]{}
+	if prof.SubnetID != avaconstants.PrimaryNetworkID {
+		trackedSubnets.Add(prof.SubnetID)
+	}
 	cfg, err := network.NewTestNetworkConfig(
 		prometheus.NewRegistry(),
 		networkID,
 		vdrs,
-		set.Set[ids.ID]{},
+		trackedSubnets,
 	)
 	if err != nil {
 		log.Fatalf("NewTestNetworkConfig: %v", err)
@@ -404,8 +493,8 @@ func main() {
 		dispatchErrCh <- net.Dispatch()
 	}()
 
-	for _, peerInfo := range peerInfos {
-		net.ManuallyTrack(peerInfo.ID, peerAddr(peerInfo))
+	for _, seed := range disc.seeds {
+		net.ManuallyTrack(seed.id, seed.addr)
 	}
 
 	connected, err := waitForConnectedPeer(ctx, dispatchErrCh, handler.connectedCh, peerIDs, *connectWait)
@@ -427,7 +516,18 @@ func main() {
 		log.Fatalf("NewCreator: %v", err)
 	}
 
-	// Build fetch jobs from embedded checkpoints and run the parallel fetcher.
+	// Build fetch jobs from checkpoints and run the parallel fetcher. Only
+	// mainnet has embedded checkpoints; other chains start from the
+	// accepted frontier.
+	var checkpoints []checkpoint
+	if prof.EmbeddedCheckpoints {
+		checkpoints, err = parseCheckpoints()
+	} else {
+		checkpoints, err = frontierCheckpoint(ctx, net, msgCreator, chainID, peerTracker, handler, dispatchErrCh, *requestWait)
+	}
+	if err != nil {
+		log.Fatalf("checkpoints: %v", err)
+	}
 	maxBlock, fetchErr := runParallelFetcher(
 		ctx,
 		db,
@@ -441,7 +541,48 @@ func main() {
 		dispatchErrCh,
 		*requestWait,
 		*fetchWorkers,
+		checkpoints,
 	)
+	if fetchErr == nil && *follow {
+		// Stay at the tip until shutdown. The executor switches to small
+		// batches of whatever is stored; if it fails, stop following.
+		close(executorLive)
+		followCtx, cancelFollow := context.WithCancel(ctx)
+		executorDone := make(chan error, 1)
+		go func() {
+			executorDone <- <-executorErrCh
+			cancelFollow()
+		}()
+		followErr := runTipFollower(
+			followCtx,
+			db,
+			net,
+			msgCreator,
+			chainID,
+			peerTracker,
+			handler,
+			writerCh,
+			writerErrCh,
+			dispatchErrCh,
+			*requestWait,
+			*followEvery,
+		)
+		cancelFollow()
+		close(writerCh)
+		if !errors.Is(followErr, errWriterStopped) {
+			if writerErr := <-writerErrCh; writerErr != nil {
+				log.Fatalf("writer failed: %v", writerErr)
+			}
+		}
+		if followErr != nil {
+			log.Fatalf("tip follower: %v", followErr)
+		}
+		if err := <-executorDone; err != nil {
+			log.Fatalf("executor failed: %v", err)
+		}
+		return
+	}
+
 	close(writerCh)
 	if writerErr := <-writerErrCh; writerErr != nil {
 		log.Fatalf("writer failed: %v", writerErr)
@@ -451,7 +592,7 @@ func main() {
 	}
 
 	// Tell executor the max block number and wait.
-	if maxBlock > 0 {
+	if maxBlock > 0 && prof.Executable() {
 		executorStopAt <- maxBlock
 		log.Printf("waiting for executor to finish processing up to block %d...", maxBlock)
 		if err := <-executorErrCh; err != nil {
@@ -460,6 +601,74 @@ func main() {
 	}
 }
 
+// loadProfile builds the chain profile from the command line: the C-Chain of
+// netName, or the Subnet-EVM L1 on netName described by the l1 flags.
+func loadProfile(netName, subnetIDStr, l1Genesis, l1ChainCfg, l1ChainID string) (*profile.Profile, error) {
+	if l1Genesis == "" {
+		if l1ChainCfg != "" || l1ChainID != "" {
+			return nil, fmt.Errorf("-l1-chain-config and -l1-blockchain-id need -l1-genesis")
+		}
+		return profile.ByName(netName)
+	}
+	subnetID, err := ids.FromString(subnetIDStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subnet-id: %w", err)
+	}
+	blockchainID, err := ids.FromString(l1ChainID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid l1-blockchain-id: %w", err)
+	}
+	return profile.L1(profile.L1Config{
+		Network:         netName,
+		SubnetID:        subnetID,
+		BlockchainID:    blockchainID,
+		GenesisFile:     l1Genesis,
+		ChainConfigFile: l1ChainCfg,
+	})
+}
+
+// checkChainMarker records prof's blockchain ID in a new database and refuses
+// a database that holds another chain. Databases from before the marker
+// existed were always mainnet C-Chain. A read-only database without a
+// marker is left for its writer to mark.
+func checkChainMarker(db *store.DB, prof *profile.Profile) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	begin := db.BeginRW
+	if db.ReadOnly() {
+		begin = db.BeginRO
+	}
+	tx, err := begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Abort()
+
+	stored, err := tx.Get(db.Metadata, []byte("blockchain_id"))
+	switch {
+	case err == nil:
+		if !bytes.Equal(stored, prof.BlockchainID[:]) {
+			storedID, _ := ids.ToID(stored)
+			return fmt.Errorf("database holds chain %s, not %s (%s)", storedID, prof.BlockchainID, prof.Name)
+		}
+		return nil
+	case !mdbx.IsNotFound(err):
+		return fmt.Errorf("read chain marker: %w", err)
+	}
+
+	if _, ok := store.GetLatestStoredBlock(tx, db); ok && !prof.EmbeddedCheckpoints {
+		return fmt.Errorf("database has blocks but no chain marker, so it holds mainnet C-Chain, not %s", prof.Name)
+	}
+	if db.ReadOnly() {
+		return nil
+	}
+	if err := tx.Put(db.Metadata, []byte("blockchain_id"), prof.BlockchainID[:], 0); err != nil {
+		return fmt.Errorf("write chain marker: %w", err)
+	}
+	_, err = tx.Commit()
+	return err
+}
+
 func loadEmbeddedContainerID(blockNum uint64) (ids.ID, error) {
 	var containerIDs map[string]string
 	if err := json.Unmarshal(embeddedContainerIDs, &containerIDs); err != nil {
@@ -715,57 +924,117 @@ func parseBlockMeta(raw []byte) (blockMeta, error) {
 		}, nil
 	}
 
-	ethBlock, _, err := parsePreForkEthBlock(raw)
+	info, _, err := parsePreForkBlock(raw)
 	if err != nil {
-		return blockMeta{}, fmt.Errorf("block is neither proposer nor pre-fork coreth: %w", err)
+		return blockMeta{}, fmt.Errorf("block is neither proposer nor pre-fork EVM block: %w", err)
 	}
 	return blockMeta{
-		parentID: ids.ID(ethBlock.ParentHash()),
+		parentID: ids.ID(info.parentHash),
 	}, nil
 }
 
 func parseContainerRecord(raw []byte) (*containerRecord, error) {
 	proposerBlk, err := proposerblock.ParseWithoutVerification(raw)
 	if err != nil {
-		ethBlock, rawBlock, rawErr := parsePreForkEthBlock(raw)
+		info, rawBlock, rawErr := parsePreForkBlock(raw)
 		if rawErr != nil {
 			return nil, fmt.Errorf("parse proposer block: %w", err)
 		}
 		return &containerRecord{
-			outerID:     ids.ID(ethBlock.Hash()),
-			parentID:    ids.ID(ethBlock.ParentHash()),
-			innerHash:   ids.ID(ethBlock.Hash()),
-			innerNumber: ethBlock.NumberU64(),
-			txCount:     len(ethBlock.Transactions()),
+			outerID:     ids.ID(info.hash),
+			parentID:    ids.ID(info.parentHash),
+			innerHash:   ids.ID(info.hash),
+			innerNumber: info.number,
+			txCount:     info.txCount,
 			raw:         rawBlock,
 		}, nil
 	}
-	ethBlock := new(ethtypes.Block)
-	if err := rlp.DecodeBytes(proposerBlk.Block(), ethBlock); err != nil {
+	info, err := parseEthBlockInfo(proposerBlk.Block())
+	if err != nil {
 		return nil, fmt.Errorf("decode inner eth block: %w", err)
 	}
 	return &containerRecord{
 		outerID:     proposerBlk.ID(),
 		parentID:    proposerBlk.ParentID(),
-		innerHash:   ids.ID(ethBlock.Hash()),
-		innerNumber: ethBlock.NumberU64(),
-		txCount:     len(ethBlock.Transactions()),
+		innerHash:   ids.ID(info.hash),
+		innerNumber: info.number,
+		txCount:     info.txCount,
 		raw:         raw,
 	}, nil
 }
 
-func parsePreForkEthBlock(raw []byte) (*ethtypes.Block, []byte, error) {
+func parsePreForkBlock(raw []byte) (ethBlockInfo, []byte, error) {
 	_, _, rest, err := rlp.Split(raw)
 	if err != nil {
-		return nil, nil, err
+		return ethBlockInfo{}, nil, err
 	}
 	rawBlock := raw[:len(raw)-len(rest)]
 
-	ethBlock := new(ethtypes.Block)
-	if err := rlp.DecodeBytes(rawBlock, ethBlock); err != nil {
-		return nil, nil, err
+	info, err := parseEthBlockInfo(rawBlock)
+	if err != nil {
+		return ethBlockInfo{}, nil, err
+	}
+	return info, rawBlock, nil
+}
+
+// ethBlockInfo is what the fetcher needs from an EVM block.
+type ethBlockInfo struct {
+	hash       common.Hash
+	parentHash common.Hash
+	number     uint64
+	txCount    int
+}
+
+// parseEthBlockInfo reads ethBlockInfo straight from a block's RLP. coreth
+// and Subnet-EVM headers share the Ethereum fields and differ only after
+// them, and the hash is the Keccak of the header encoding either way, so the
+// fetcher doesn't need to know which VM produced the block.
+func parseEthBlockInfo(rawBlock []byte) (ethBlockInfo, error) {
+	body, _, err := rlp.SplitList(rawBlock)
+	if err != nil {
+		return ethBlockInfo{}, fmt.Errorf("block: %w", err)
+	}
+	headerFields, afterHeader, err := rlp.SplitList(body)
+	if err != nil {
+		return ethBlockInfo{}, fmt.Errorf("header: %w", err)
 	}
-	return ethBlock, rawBlock, nil
+	txList, _, err := rlp.SplitList(afterHeader)
+	if err != nil {
+		return ethBlockInfo{}, fmt.Errorf("transactions: %w", err)
+	}
+	txCount, err := rlp.CountValues(txList)
+	if err != nil {
+		return ethBlockInfo{}, fmt.Errorf("transactions: %w", err)
+	}
+
+	info := ethBlockInfo{
+		hash:    crypto.Keccak256Hash(body[:len(body)-len(afterHeader)]),
+		txCount: txCount,
+	}
+	// ParentHash is header field 0 and Number field 8; the ones in between
+	// are all byte strings.
+	rest := headerFields
+	for i := 0; i <= 8; i++ {
+		var field []byte
+		field, rest, err = rlp.SplitString(rest)
+		if err != nil {
+			return ethBlockInfo{}, fmt.Errorf("header field %d: %w", i, err)
+		}
+		switch i {
+		case 0:
+			if len(field) != common.HashLength {
+				return ethBlockInfo{}, fmt.Errorf("parent hash has %d bytes", len(field))
+			}
+			info.parentHash = common.BytesToHash(field)
+		case 8:
+			number := new(big.Int).SetBytes(field)
+			if !number.IsUint64() {
+				return ethBlockInfo{}, fmt.Errorf("block number %s overflows uint64", number)
+			}
+			info.number = number.Uint64()
+		}
+	}
+	return info, nil
 }
 
 func runWriter(
@@ -840,38 +1109,36 @@ func runWriter(
 	}
 }
 
-const MainnetAVAXAssetID = "FvwEAhmxKfeiG8SnEvq42hc6whRyY3EFYAvebMqDNDGCgxN5Z"
-
-func runExecutor(ctx context.Context, db *store.DB, stopAt <-chan uint64, batchSize uint64) error {
+// runExecutor executes stored blocks in batches of batchSize, each batch
+// waiting until its last block is stored. Once live is closed (the fetcher is
+// following the tip) it stops waiting for full batches and runs whatever
+// contiguous run of up to liveBatchSize blocks is already stored.
+//
+// Chains whose VM the executor can't run (Subnet-EVM) only get their genesis
+// state loaded; their blocks are stored and the executor then just waits for
+// ctx to end.
+func runExecutor(ctx context.Context, db *store.DB, prof *profile.Profile, stopAt <-chan uint64, batchSize uint64, live <-chan struct{}, liveBatchSize uint64, workers int) error {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
-	// Parse genesis.
-	config := genesis.GetConfig(avaconstants.MainnetID)
-	var cChainGenesis corethcore.Genesis
-	if err := json.Unmarshal([]byte(config.CChainGenesis), &cChainGenesis); err != nil {
-		return fmt.Errorf("parse C-Chain genesis config: %w", err)
+	// Load genesis into flat MDBX tables (idempotent).
+	if err := loadGenesisFlat(db, prof.Genesis); err != nil {
+		return fmt.Errorf("load genesis: %w", err)
 	}
-	// Set Avalanche network upgrade timestamps on the chain config.
-	// The genesis JSON only has standard Ethereum forks — Avalanche-specific
-	// upgrades (ApricotPhase1-5, Banff, Cortina, etc.) must be set from the
-	// known mainnet upgrade schedule.
-	mainnetUpgrades := upgrade.GetConfig(avaconstants.MainnetID)
-	setAvalancheUpgrades(cChainGenesis.Config, mainnetUpgrades)
-	if err := cparams.SetEthUpgrades(cChainGenesis.Config); err != nil {
-		return fmt.Errorf("set eth upgrades: %w", err)
+	if !prof.Executable() {
+		log.Printf("executor: disabled, %s blocks are stored but not executed", prof.VM)
+		<-ctx.Done()
+		return nil
 	}
-	chainCfg := cChainGenesis.Config
+
+	// The profile's chain config already has the network's Avalanche
+	// upgrade timestamps set.
+	chainCfg := prof.ChainConfig()
 
 	// Set up statetrie-backed state database.
 	stateTrieDB := statetrie.NewDatabase(db)
 
-	// Load genesis into flat MDBX tables (idempotent).
-	if err := loadGenesisFlat(db, &cChainGenesis); err != nil {
-		return fmt.Errorf("load genesis: %w", err)
-	}
-
-	genesisRoot := cChainGenesis.ToBlock().Root()
+	genesisRoot := prof.GenesisRoot
 	log.Printf("executor: genesis root=%x", genesisRoot)
 
 	// One-time migration: wipe StorageTrie + AccountTrie branch nodes built by
@@ -913,12 +1180,41 @@ func runExecutor(ctx context.Context, db *store.DB, stopAt <-chan uint64, batchS
 		}
 	}
 
-	// Set up snow.Context for atomic transactions.
-	avaxAssetID, err := ids.FromString(MainnetAVAXAssetID)
-	if err != nil {
-		return fmt.Errorf("invalid AVAX asset ID: %w", err)
+	// The history, log, tx hash and atomic tx indexes are built behind the
+	// executor by the indexer. It stops with the executor.
+	if err := prepareIndexes(db); err != nil {
+		return fmt.Errorf("prepare indexes: %w", err)
 	}
-	snowCtx := &snow.Context{AVAXAssetID: avaxAssetID}
+	indexerCtx, stopIndexer := context.WithCancel(ctx)
+	indexerFinish := make(chan struct{})
+	indexerDone := make(chan struct{})
+	go func() {
+		runIndexer(indexerCtx, db, indexerFinish)
+		close(indexerDone)
+	}()
+	atomicDone := make(chan struct{})
+	go func() {
+		runAtomicIndexer(indexerCtx, db, atomictx.NewDecoder(prof.ChainConfig(), prof.AVAXAssetID))
+		close(atomicDone)
+	}()
+	// Databases written before the reverse keyID dictionary existed get it
+	// backfilled alongside.
+	keyDictDone := make(chan struct{})
+	go func() {
+		if err := reverseKeyDict(indexerCtx, db); err != nil && indexerCtx.Err() == nil {
+			log.Printf("indexer: reverse keyID dictionary: %v", err)
+		}
+		close(keyDictDone)
+	}()
+	defer func() {
+		stopIndexer()
+		<-indexerDone
+		<-atomicDone
+		<-keyDictDone
+	}()
+
+	// Set up snow.Context for atomic transactions.
+	snowCtx := &snow.Context{AVAXAssetID: prof.AVAXAssetID}
 
 	// Resume from last executed block.
 	roTx, err := db.BeginRO()
@@ -937,6 +1233,9 @@ func runExecutor(ctx context.Context, db *store.DB, stopAt <-chan uint64, batchS
 	if batchSize == 0 {
 		batchSize = 1 // default: verify every block
 	}
+	if workers > 1 {
+		log.Printf("executor: executing transactions with %d workers", workers)
+	}
 
 	var maxBlock uint64
 
@@ -951,7 +1250,9 @@ func runExecutor(ctx context.Context, db *store.DB, stopAt <-chan uint64, batchS
 		default:
 		}
 		if maxBlock > 0 && nextBlock > maxBlock {
-			log.Printf("executor: finished all blocks up to %d", maxBlock)
+			log.Printf("executor: finished all blocks up to %d, waiting for the indexer", maxBlock)
+			close(indexerFinish)
+			<-indexerDone
 			return nil
 		}
 
@@ -972,14 +1273,30 @@ func runExecutor(ctx context.Context, db *store.DB, stopAt <-chan uint64, batchS
 			if err == nil {
 				break
 			}
+			if isClosed(live) {
+				end, err := storedRunEnd(db, nextBlock, min(batchEnd, nextBlock+liveBatchSize-1))
+				if err != nil {
+					return err
+				}
+				if end >= nextBlock {
+					batchEnd = end
+					break
+				}
+			}
 			time.Sleep(100 * time.Millisecond)
 			if ctx.Err() != nil {
 				return nil
 			}
 		}
 
-		// Execute the batch.
-		if err := executeBatch(db, stateTrieDB, chainCfg, snowCtx, nextBlock, batchEnd); err != nil {
+		// Execute the batch. A parallel batch that misses the root is
+		// executed again serially before anything is declared wrong.
+		err := executeBatch(db, stateTrieDB, chainCfg, snowCtx, genesisRoot, nextBlock, batchEnd, workers)
+		if errors.Is(err, errParallelMismatch) {
+			log.Printf("executor: %v, re-executing batch %d-%d serially", err, nextBlock, batchEnd)
+			err = executeBatch(db, stateTrieDB, chainCfg, snowCtx, genesisRoot, nextBlock, batchEnd, 1)
+		}
+		if err != nil {
 			return err
 		}
 
@@ -987,10 +1304,20 @@ func runExecutor(ctx context.Context, db *store.DB, stopAt <-chan uint64, batchS
 	}
 }
 
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
 type executorBlockStats struct {
 	txCount        int
 	gasUsed        uint64
 	atomicExtBytes int
+	reexecuted     int // transactions re-executed after a parallel conflict
 }
 
 func getenvPositiveInt(name string) (int, bool) {
@@ -1040,12 +1367,15 @@ func executeBatch(
 	stateDB *statetrie.Database,
 	chainCfg *params.ChainConfig,
 	snowCtx *snow.Context,
+	genesisRoot common.Hash,
 	from, to uint64,
+	workers int,
 ) error {
 	overlay := statetrie.NewBatchOverlay()
 	stateDB.Overlay = overlay
 	chainCtx := newExecutorChainContext(db)
 	batchTxCount := 0
+	batchReexecuted := 0
 
 	// Open a shared RO transaction for all reads during the batch.
 	// The overlay handles in-batch writes; MDBX stays read-only.
@@ -1100,12 +1430,13 @@ func executeBatch(
 	for blockNum := from; blockNum <= to; blockNum++ {
 		stateDB.CurrentBlock = blockNum
 		blockStart := time.Now()
-		stats, err := executeBlock(db, stateDB, chainCfg, snowCtx, chainCtx, blockNum)
+		stats, err := executeBlock(db, stateDB, chainCfg, snowCtx, genesisRoot, chainCtx, blockNum, workers)
 		if err != nil {
 			stateDB.Overlay = nil
 			return fmt.Errorf("block %d: %w", blockNum, err)
 		}
 		batchTxCount += stats.txCount
+		batchReexecuted += stats.reexecuted
 		blockElapsed := time.Since(blockStart)
 		kickWatchdog()
 		if slowThreshold > 0 && blockElapsed >= slowThreshold {
@@ -1186,6 +1517,12 @@ func executeBatch(
 	hashElapsed := flushElapsed + trieElapsed
 	kickWatchdog()
 
+	if common.Hash(computedRoot) != expectedRoot && workers > 1 {
+		rwTx.Abort()
+		runtime.UnlockOSThread()
+		stateDB.Overlay = nil
+		return fmt.Errorf("%w: state root at block %d computed=%x expected=%x", errParallelMismatch, to, computedRoot, expectedRoot)
+	}
 	if common.Hash(computedRoot) != expectedRoot {
 		allowCommitOnMismatch := os.Getenv("ALLOW_COMMIT_ON_MISMATCH") != ""
 		if os.Getenv("TRACE_FULL_STATE_ROOT") != "" {
@@ -1247,12 +1584,16 @@ func executeBatch(
 	commitElapsed := time.Since(commitStart)
 	runtime.UnlockOSThread()
 	kickWatchdog()
+	db.PublishHead(to)
 
 	totalElapsed := execElapsed + hashElapsed + commitElapsed
 	blocksPerSec := float64(to-from+1) / totalElapsed.Seconds()
 	txsPerSec := float64(batchTxCount) / totalElapsed.Seconds()
 	log.Printf("executor: verified batch %d-%d root=%x (exec=%s flush=%s trie=%s commit=%s txs=%d rate=%.1f blk/s %.1f tx/s)", from, to, common.Hash(computedRoot), execElapsed.Truncate(time.Millisecond), flushElapsed.Truncate(time.Millisecond), trieElapsed.Truncate(time.Millisecond), commitElapsed.Truncate(time.Millisecond), batchTxCount, blocksPerSec, txsPerSec)
 	log.Printf("executor: batch-rate %d-%d blocks_per_sec=%.1f tx_count=%d txs_per_sec=%.1f", from, to, blocksPerSec, batchTxCount, txsPerSec)
+	if workers > 1 {
+		log.Printf("executor: parallel batch %d-%d workers=%d txs=%d reexecuted=%d", from, to, workers, batchTxCount, batchReexecuted)
+	}
 	if os.Getenv("TRACE_EXEC_HEADER_CACHE") != "" {
 		log.Printf("executor: header-cache batch %d-%d calls=%d cacheHits=%d dbHits=%d misses=%d",
 			from, to,
@@ -1358,7 +1699,10 @@ func logChangedStorageDebug(changedStorage map[[20]byte]map[[32]byte][]byte) {
 	}
 }
 
+// executorChainContext is safe for concurrent use: parallel transaction
+// execution looks up headers from several goroutines.
 type executorChainContext struct {
+	mu                 sync.Mutex
 	db                 *store.DB
 	engine             corethconsensus.Engine
 	headers            map[uint64]*ethtypes.Header
@@ -1384,10 +1728,14 @@ func (c *executorChainContext) rememberHeader(header *ethtypes.Header) {
 	if header == nil || header.Number == nil {
 		return
 	}
+	c.mu.Lock()
 	c.headers[header.Number.Uint64()] = header
+	c.mu.Unlock()
 }
 
 func (c *executorChainContext) GetHeader(hash common.Hash, number uint64) *ethtypes.Header {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.getHeaderCalls++
 	if header, ok := c.headers[number]; ok {
 		if header.Hash() == hash {
@@ -1418,7 +1766,7 @@ func (c *executorChainContext) GetHeader(hash common.Hash, number uint64) *ethty
 		return nil
 	}
 	header := block.Header()
-	c.rememberHeader(header)
+	c.headers[number] = header
 	c.getHeaderDBHits++
 	return header
 }
@@ -1430,8 +1778,10 @@ func executeBlock(
 	stateDB *statetrie.Database,
 	chainCfg *params.ChainConfig,
 	snowCtx *snow.Context,
+	genesisRoot common.Hash,
 	chainCtx *executorChainContext,
 	blockNum uint64,
+	workers int,
 ) (executorBlockStats, error) {
 	stats := executorBlockStats{}
 
@@ -1463,7 +1813,7 @@ func executeBlock(
 		parentTime uint64
 	)
 	if blockNum == 1 {
-		parentRoot = common.Hash(common.HexToHash("d65eb1b8604a7aa497d41cd6372663785a5f809a17bd192edb86658ef24e29cc"))
+		parentRoot = genesisRoot
 	} else {
 		// Read parent block's root.
 		proTx, err := db.BeginRO()
@@ -1495,27 +1845,42 @@ func executeBlock(
 	}
 
 	blockCtx := corethcore.NewEVMBlockContext(header, chainCtx, &header.Coinbase)
-	gp := new(corethcore.GasPool).AddGas(header.GasLimit)
 	var blockReceipts []store.TxReceipt
-	usedGas := uint64(0)
 
 	if beaconRoot := ethBlock.BeaconRoot(); beaconRoot != nil {
 		vmenv := vm.NewEVM(blockCtx, vm.TxContext{}, sdb, chainCfg, vm.Config{})
 		corethcore.ProcessBeaconBlockRoot(*beaconRoot, vmenv, sdb)
 	}
 
-	for txIndex, tx := range ethBlock.Transactions() {
-		sdb.SetTxContext(tx.Hash(), txIndex)
-		receipt, err := corethcore.ApplyTransaction(chainCfg, chainCtx, blockCtx, gp, sdb, header, tx, &usedGas, vm.Config{})
+	var receipts []*ethtypes.Receipt
+	if txs := ethBlock.Transactions(); workers > 1 && len(txs) > 1 {
+		receipts, stats.reexecuted, err = executeTxsParallel(&parallelBlock{
+			db:         db,
+			stateDB:    stateDB,
+			chainCfg:   chainCfg,
+			chainCtx:   chainCtx,
+			header:     header,
+			parentRoot: parentRoot,
+			parentTime: parentTime,
+			beaconRoot: ethBlock.BeaconRoot(),
+			txs:        txs,
+		}, sdb, workers)
+		if err != nil {
+			return stats, err
+		}
+	} else {
+		receipts, err = applyTxsSerially(chainCfg, chainCtx, blockCtx, header, txs, sdb)
 		if err != nil {
-			return stats, fmt.Errorf("tx %d apply: %w", txIndex, err)
+			return stats, err
 		}
+	}
 
+	for _, receipt := range receipts {
 		storeReceipt := store.TxReceipt{
 			TxHash:          [32]byte(receipt.TxHash),
 			CumulativeGas:   receipt.CumulativeGasUsed,
 			GasUsed:         receipt.GasUsed,
-			TxType:          tx.Type(),
+			TxType:          receipt.Type,
 			ContractAddress: [20]byte(receipt.ContractAddress),
 		}
 		if receipt.Status == ethtypes.ReceiptStatusSuccessful {
@@ -1533,11 +1898,6 @@ func executeBlock(
 		}
 
 		blockReceipts = append(blockReceipts, storeReceipt)
-
-		// Record tx hash → (blockNum, txIndex).
-		if stateDB != nil && stateDB.Overlay != nil {
-			stateDB.Overlay.AddTxHash([32]byte(tx.Hash()), blockNum, uint16(txIndex))
-		}
 	}
 
 	// Atomic transactions.
@@ -1681,6 +2041,29 @@ func loadGenesisFlat(db *store.DB, gen *corethcore.Genesis) error {
 	return err
 }
 
+// applyTxsSerially executes txs on sdb in block order.
+func applyTxsSerially(
+	chainCfg *params.ChainConfig,
+	chainCtx *executorChainContext,
+	blockCtx vm.BlockContext,
+	header *ethtypes.Header,
+	txs ethtypes.Transactions,
+	sdb *state.StateDB,
+) ([]*ethtypes.Receipt, error) {
+	gp := new(corethcore.GasPool).AddGas(header.GasLimit)
+	usedGas := uint64(0)
+	receipts := make([]*ethtypes.Receipt, 0, len(txs))
+	for txIndex, tx := range txs {
+		sdb.SetTxContext(tx.Hash(), txIndex)
+		receipt, err := corethcore.ApplyTransaction(chainCfg, chainCtx, blockCtx, gp, sdb, header, tx, &usedGas, vm.Config{})
+		if err != nil {
+			return nil, fmt.Errorf("tx %d apply: %w", txIndex, err)
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, nil
+}
+
 // executorParseEthBlock decodes a raw block from MDBX. It first tries to unwrap a
 // ProposerVM envelope; if that fails it falls back to a pre-fork RLP decode.
 func executorParseEthBlock(raw []byte) (*ethtypes.Block, error) {
@@ -1705,31 +2088,6 @@ func executorParseEthBlock(raw []byte) (*ethtypes.Block, error) {
 	return ethBlock, nil
 }
 
-// setAvalancheUpgrades sets the Avalanche network upgrade timestamps on the
-// chain config extras. The genesis JSON only has standard Ethereum forks;
-// Avalanche-specific upgrades must be set from the known upgrade schedule.
-func setAvalancheUpgrades(c *params.ChainConfig, cfg upgrade.Config) {
-	extra := cparams.GetExtra(c)
-	ts := func(t time.Time) *uint64 { v := uint64(t.Unix()); return &v }
-	extra.NetworkUpgrades.ApricotPhase1BlockTimestamp = ts(cfg.ApricotPhase1Time)
-	extra.NetworkUpgrades.ApricotPhase2BlockTimestamp = ts(cfg.ApricotPhase2Time)
-	extra.NetworkUpgrades.ApricotPhase3BlockTimestamp = ts(cfg.ApricotPhase3Time)
-	extra.NetworkUpgrades.ApricotPhase4BlockTimestamp = ts(cfg.ApricotPhase4Time)
-	extra.NetworkUpgrades.ApricotPhase5BlockTimestamp = ts(cfg.ApricotPhase5Time)
-	extra.NetworkUpgrades.ApricotPhasePre6BlockTimestamp = ts(cfg.ApricotPhasePre6Time)
-	extra.NetworkUpgrades.ApricotPhase6BlockTimestamp = ts(cfg.ApricotPhase6Time)
-	extra.NetworkUpgrades.ApricotPhasePost6BlockTimestamp = ts(cfg.ApricotPhasePost6Time)
-	extra.NetworkUpgrades.BanffBlockTimestamp = ts(cfg.BanffTime)
-	extra.NetworkUpgrades.CortinaBlockTimestamp = ts(cfg.CortinaTime)
-	extra.NetworkUpgrades.DurangoBlockTimestamp = ts(cfg.DurangoTime)
-	extra.NetworkUpgrades.EtnaTimestamp = ts(cfg.EtnaTime)
-	cparams.WithExtra(c, extra)
-	log.Printf("executor: Avalanche upgrades set (AP1=%d AP2=%d AP3=%d AP5=%d Banff=%d Cortina=%d Durango=%d Etna=%d)",
-		cfg.ApricotPhase1Time.Unix(), cfg.ApricotPhase2Time.Unix(), cfg.ApricotPhase3Time.Unix(),
-		cfg.ApricotPhase5Time.Unix(), cfg.BanffTime.Unix(), cfg.CortinaTime.Unix(),
-		cfg.DurangoTime.Unix(), cfg.EtnaTime.Unix())
-}
-
 func verifyLatestBlocks(ctx context.Context, db *store.DB, nodeURI string, samples int) error {
 	rpcURL := cChainRPCURL(nodeURI)
 	client, err := corethethclient.DialContext(ctx, rpcURL)
@@ -1834,6 +2192,61 @@ func parseCheckpoints() ([]checkpoint, error) {
 	return cps, nil
 }
 
+// frontierCheckpoint asks peers for the accepted frontier and returns it as
+// the only checkpoint, for chains without embedded container IDs. A few peers
+// are tried in turn.
+func frontierCheckpoint(
+	ctx context.Context,
+	net network.Network,
+	msgCreator message.Creator,
+	chainID ids.ID,
+	peerTracker *avap2p.PeerTracker,
+	handler *inboundHandler,
+	dispatchErrCh <-chan error,
+	requestTimeout time.Duration,
+) ([]checkpoint, error) {
+	const attempts = 5
+	var lastErr error
+	requestID := checkpointRequestIDBase
+	for i := 0; i < attempts; i++ {
+		requestID++
+		frontier, peerID, err := fetchAcceptedFrontier(
+			ctx, dispatchErrCh, net, msgCreator, chainID, peerTracker,
+			requestID, requestTimeout, handler.frontierCh,
+		)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		// The first container of an Ancestors response is the requested one.
+		requestID++
+		resp, _, err := fetchAncestors(
+			ctx, dispatchErrCh, net, msgCreator, chainID, peerTracker,
+			requestID, frontier, requestTimeout, handler.ancestorsCh,
+		)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(resp.blocks) == 0 {
+			lastErr = fmt.Errorf("empty ancestors for frontier %s", frontier)
+			continue
+		}
+		rec, err := parseContainerRecord(append([]byte(nil), resp.blocks[0]...))
+		if err != nil {
+			lastErr = fmt.Errorf("parse frontier %s: %w", frontier, err)
+			continue
+		}
+		if rec.outerID != frontier {
+			lastErr = fmt.Errorf("ancestors of %s started at %s", frontier, rec.outerID)
+			continue
+		}
+		log.Printf("checkpoint from accepted frontier: block=%d id=%s peer=%s", rec.innerNumber, frontier, peerID)
+		return []checkpoint{{blockNum: rec.innerNumber, containerID: frontier}}, nil
+	}
+	return nil, fmt.Errorf("accepted frontier: %d attempts failed: %w", attempts, lastErr)
+}
+
 // buildFetchJobs creates fetch jobs from checkpoints. Each job covers the range
 // (checkpoint[i-1].blockNum, checkpoint[i].blockNum] walking backwards from
 // checkpoint[i]. Jobs are sorted by toBlock ascending so lowest ranges are fetched first.
@@ -1863,8 +2276,9 @@ func buildFetchJobs(checkpoints []checkpoint) []fetchJob {
 	return jobs
 }
 
-// runParallelFetcher manages concurrent fetch workers pulling blocks from peers.
-// Returns the highest block number across all jobs when complete.
+// runParallelFetcher manages concurrent fetch workers pulling blocks from peers,
+// walking back from each checkpoint. Returns the highest block number across
+// all jobs when complete.
 func runParallelFetcher(
 	ctx context.Context,
 	db *store.DB,
@@ -1878,11 +2292,8 @@ func runParallelFetcher(
 	dispatchErrCh <-chan error,
 	requestTimeout time.Duration,
 	numWorkers int,
+	checkpoints []checkpoint,
 ) (uint64, error) {
-	checkpoints, err := parseCheckpoints()
-	if err != nil {
-		return 0, fmt.Errorf("parse checkpoints: %w", err)
-	}
 	if len(checkpoints) == 0 {
 		return 0, fmt.Errorf("no checkpoints found")
 	}
@@ -2156,7 +2567,6 @@ func runParallelFetcher(
 				workerID, job.fromBlock, job.toBlock, jobBlocks,
 				jobElapsed.Truncate(time.Millisecond), rate)
 		}
-		return nil
 	}
 
 	// Launch workers.
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ava-labs/avalanchego/ids"
+	avaconstants "github.com/ava-labs/avalanchego/utils/constants"
+
+	corethcore "github.com/ava-labs/avalanchego/graft/coreth/core"
+	"github.com/ava-labs/avalanchego/graft/coreth/core/extstate"
+	cparams "github.com/ava-labs/avalanchego/graft/coreth/params"
+	ccustomtypes "github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/customtypes"
+	"github.com/ava-labs/libevm/common"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/profile"
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+func TestMain(m *testing.M) {
+	corethcore.RegisterExtras()
+	ccustomtypes.Register()
+	extstate.RegisterExtras()
+	cparams.RegisterExtras()
+	os.Exit(m.Run())
+}
+
+// dumpTestTables returns the entries of tables(db) of the database in dir,
+// keyed by table name. extra, if set, adds entries derived in the same read
+// transaction.
+func dumpTestTables(t *testing.T, dir string, tables func(db *store.DB) []snapshotTable, extra func(t *testing.T, tx *mdbx.Txn, db *store.DB, out map[string]map[string]string)) map[string]map[string]string {
+	t.Helper()
+	db, err := store.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	out := make(map[string]map[string]string)
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+		for _, st := range tables(db) {
+			entries := make(map[string]string)
+			cursor, err := tx.OpenCursor(st.dbi)
+			if err != nil {
+				t.Fatal(err)
+			}
+			k, v, err := cursor.Get(nil, nil, mdbx.First)
+			for ; err == nil; k, v, err = cursor.Get(nil, nil, mdbx.Next) {
+				entries[string(k)] = string(v)
+			}
+			cursor.Close()
+			if !mdbx.IsNotFound(err) {
+				t.Fatal(err)
+			}
+			out[st.name] = entries
+		}
+		if extra != nil {
+			extra(t, tx, db, out)
+		}
+	})
+	return out
+}
+
+func TestLoadProfileL1(t *testing.T) {
+	dir := t.TempDir()
+	genesisFile, chainCfgFile := filepath.Join(dir, "genesis.json"), filepath.Join(dir, "config.json")
+	funded := common.Address{19: 0x42}
+	if err := os.WriteFile(genesisFile, []byte(`{
+		"config": {"chainId": 99999, "homesteadBlock": 0, "eip150Block": 0, "eip155Block": 0, "eip158Block": 0, "byzantiumBlock": 0, "constantinopleBlock": 0, "petersburgBlock": 0, "istanbulBlock": 0, "muirGlacierBlock": 0},
+		"alloc": {"`+funded.Hex()+`": {"balance": "0x2a"}},
+		"gasLimit": "0x7a1200",
+		"difficulty": "0x0"
+	}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	subnetID, blockchainID := ids.GenerateTestID(), ids.GenerateTestID()
+
+	prof, err := loadProfile("fuji", subnetID.String(), genesisFile, "", blockchainID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prof.VM != profile.SubnetEVM || prof.Executable() {
+		t.Fatalf("L1 profile VM %s, executable %v", prof.VM, prof.Executable())
+	}
+	if prof.NetworkID != avaconstants.FujiID || prof.SubnetID != subnetID || prof.BlockchainID != blockchainID {
+		t.Fatalf("L1 profile network %d subnet %s blockchain %s", prof.NetworkID, prof.SubnetID, prof.BlockchainID)
+	}
+	if got := prof.EVMChainID(); got.Uint64() != 99999 {
+		t.Fatalf("L1 chain ID %d, want 99999", got)
+	}
+
+	// The genesis loader writes the L1's alloc like the C-Chain's.
+	db := storetest.Open(t, store.Open)
+	if err := loadGenesisFlat(db, prof.Genesis); err != nil {
+		t.Fatal(err)
+	}
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+		acct, err := store.GetAccount(tx, db, funded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acct == nil || acct.Balance[31] != 0x2a {
+			t.Fatalf("funded account %+v", acct)
+		}
+	})
+
+	// -l1-chain-config replaces the genesis config.
+	if err := os.WriteFile(chainCfgFile, []byte(`{"chainId": 88888}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	prof, err = loadProfile("fuji", subnetID.String(), genesisFile, chainCfgFile, blockchainID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := prof.EVMChainID(); got.Uint64() != 88888 {
+		t.Fatalf("L1 chain ID with -l1-chain-config %d, want 88888", got)
+	}
+
+	for _, args := range [][5]string{
+		{"fuji", subnetID.String(), "", "", blockchainID.String()},
+		{"fuji", avaconstants.PrimaryNetworkID.String(), genesisFile, "", blockchainID.String()},
+		{"fuji", subnetID.String(), genesisFile, "", ""},
+	} {
+		if _, err := loadProfile(args[0], args[1], args[2], args[3], args[4]); err == nil {
+			t.Errorf("loadProfile%q succeeded", args)
+		}
+	}
+}
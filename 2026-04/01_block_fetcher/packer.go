@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"log"
+	"runtime"
+	"time"
+
+	"block_fetcher/store"
+)
+
+// packChunk is how many block packs one packer transaction moves, so the
+// executor never waits long for the write lock.
+const packChunk = 16
+
+// runPacker moves stored containers into ZSTD block-pack segment files every
+// interval until ctx ends. MDBX reuses the pages it frees; run
+// cmd/pack_containers while the node is stopped to shrink the file. A failed
+// pass is logged and retried on the next tick.
+func runPacker(ctx context.Context, db *store.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := packStored(ctx, db); err != nil && ctx.Err() == nil {
+			log.Printf("packer: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// packStored packs every complete pack of stored blocks, one chunk per write
+// transaction.
+func packStored(ctx context.Context, db *store.DB) error {
+	start := time.Now()
+	var from, to uint64
+	for first := true; ; first = false {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		prev, packedTo, err := packChunkOf(db)
+		if err != nil {
+			return err
+		}
+		if first {
+			from = prev
+		}
+		if packedTo == prev {
+			break
+		}
+		to = packedTo
+	}
+	if to > from {
+		log.Printf("packer: packed blocks %d-%d elapsed=%s", from+1, to, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// packChunkOf moves up to packChunk packs in one write transaction and
+// returns packed_to before and after.
+func packChunkOf(db *store.DB) (uint64, uint64, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := db.BeginRW()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Abort()
+	prev := store.GetPackedTo(tx, db)
+	packedTo, err := store.PackContainers(tx, db, packChunk)
+	if err != nil || packedTo == prev {
+		return prev, prev, err
+	}
+	if _, err := tx.Commit(); err != nil {
+		return prev, prev, err
+	}
+	return prev, packedTo, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+func TestPackStored(t *testing.T) {
+	db, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	// More packs than one chunk moves, and a partial pack.
+	packs := uint64(2*packChunk + 3)
+	last := packs*store.PackBlocks + 5
+	container := func(num uint64) []byte {
+		return bytes.Repeat(binary.BigEndian.AppendUint64(nil, num), 1+int(num%3))
+	}
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		for num := uint64(1); num <= last; num++ {
+			if err := store.PutContainer(tx, db, [32]byte(binary.BigEndian.AppendUint64(make([]byte, 24), num)), num, container(num)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := packStored(ctx, db); err == nil {
+		t.Fatal("cancelled pass succeeded")
+	}
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+		if got := store.GetPackedTo(tx, db); got != 0 {
+			t.Fatalf("cancelled pass packed to %d", got)
+		}
+	})
+
+	if err := packStored(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+		if got := store.GetPackedTo(tx, db); got != packs*store.PackBlocks {
+			t.Fatalf("packed to %d, want %d", got, packs*store.PackBlocks)
+		}
+		for num := uint64(1); num <= last; num++ {
+			raw, err := store.GetBlockByNumber(tx, db, num)
+			if err != nil || !bytes.Equal(raw, container(num)) {
+				t.Fatalf("block %d reads %x, %v", num, raw, err)
+			}
+		}
+	})
+}
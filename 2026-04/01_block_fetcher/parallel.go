@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	syncatomic "sync/atomic"
+
+	corethcore "github.com/ava-labs/avalanchego/graft/coreth/core"
+	ccustomtypes "github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/customtypes"
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/core/state"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/core/vm"
+	"github.com/ava-labs/libevm/libevm/stateconf"
+	"github.com/ava-labs/libevm/params"
+	"github.com/ava-labs/libevm/trie"
+	"github.com/erigontech/mdbx-go/mdbx"
+	"github.com/holiman/uint256"
+
+	"block_fetcher/statetrie"
+	"block_fetcher/store"
+)
+
+// errParallelMismatch is returned by executeBatch when a batch executed with
+// parallel workers does not reach the expected state root or a block's
+// receipts miss its receipt root. Nothing of the batch is committed; the
+// caller re-executes it serially.
+var errParallelMismatch = errors.New("mismatch after parallel execution")
+
+// parallelBlock is the input of executeTxsParallel: a block whose pre-steps
+// (upgrades, beacon root) have been applied to the canonical StateDB.
+type parallelBlock struct {
+	db         *store.DB
+	stateDB    *statetrie.Database
+	chainCfg   *params.ChainConfig
+	chainCtx   *executorChainContext
+	header     *ethtypes.Header
+	parentRoot common.Hash
+	parentTime uint64
+	beaconRoot *common.Hash
+	txs        ethtypes.Transactions
+}
+
+// txRun is one execution of a transaction against speculative state.
+type txRun struct {
+	receipt *ethtypes.Receipt
+	reads   statetrie.ReadSet
+	writes  *statetrie.WriteSet
+	err     error
+}
+
+// executeTxsParallel executes the transactions of b on sdb with optimistic
+// concurrency. Every transaction first runs speculatively on workers
+// goroutines against the state before the block's first transaction. The
+// runs are then validated in block order: a run that read an account or slot
+// written by an earlier transaction of the block is discarded and the
+// transaction re-executed against the block's state so far. Validated writes
+// are applied to sdb, so it ends in the same state serial execution reaches.
+//
+// Every transaction pays its fee to the coinbase, so its balance would make
+// all of them conflict. A run that only added to the coinbase balance is
+// applied as that delta instead. EVM code reading the coinbase balance sees
+// the balance before the block; the batch root check catches such a block.
+//
+// The receipts are checked against the header's receipt root: a run whose
+// receipt differs from serial execution, say a status or gas figure that
+// depended on the coinbase balance, does not always show in the state root.
+//
+// It returns the receipts with block-level cumulative gas and how many
+// transactions were re-executed.
+func executeTxsParallel(b *parallelBlock, sdb *state.StateDB, workers int) ([]*ethtypes.Receipt, int, error) {
+	batchTx, done, err := b.stateDB.GetROTx()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer done()
+
+	base, err := b.preState(batchTx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	runs := make([]txRun, len(b.txs))
+	var (
+		next syncatomic.Int64
+		wg   sync.WaitGroup
+	)
+	for w := 0; w < min(workers, len(b.txs)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			tx, err := b.db.BeginRO()
+			if err == nil {
+				defer tx.Abort()
+			}
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(b.txs) {
+					return
+				}
+				if err != nil {
+					runs[i] = txRun{err: err}
+					continue
+				}
+				runs[i] = b.run(tx, base, i)
+			}
+		}()
+	}
+	wg.Wait()
+
+	coinbase := b.header.Coinbase
+	committed := statetrie.NewWriteSet()
+	committed.Merge(base)
+	written := newWrittenState()
+	receipts := make([]*ethtypes.Receipt, len(b.txs))
+	reexecuted := 0
+	usedGas := uint64(0)
+	for i := range b.txs {
+		r := &runs[i]
+		if r.err != nil || written.conflicts(r, coinbase) {
+			reexecuted++
+			*r = b.run(batchTx, committed, i)
+			if r.err != nil {
+				return nil, reexecuted, fmt.Errorf("tx %d apply: %w", i, r.err)
+			}
+		}
+		applyRun(sdb, r, coinbase, committed, written)
+		sdb.Finalise(true)
+
+		usedGas += r.receipt.GasUsed
+		if usedGas > b.header.GasLimit {
+			return nil, reexecuted, fmt.Errorf("tx %d apply: %w", i, corethcore.ErrGasLimitReached)
+		}
+		r.receipt.CumulativeGasUsed = usedGas
+		receipts[i] = r.receipt
+	}
+	if root := ethtypes.DeriveSha(ethtypes.Receipts(receipts), trie.NewStackTrie(nil)); root != b.header.ReceiptHash {
+		return nil, reexecuted, fmt.Errorf("%w: receipt root computed=%x expected=%x", errParallelMismatch, root, b.header.ReceiptHash)
+	}
+	return receipts, reexecuted, nil
+}
+
+// preState applies the block's pre-steps to speculative state and returns
+// their writes, the state every speculative run starts from.
+func (b *parallelBlock) preState(tx *mdbx.Txn) (*statetrie.WriteSet, error) {
+	specDB := statetrie.NewSpeculativeDatabase(b.db, b.stateDB.Overlay, tx, nil)
+	pre, err := state.New(b.parentRoot, specDB, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open speculative state at root %x: %w", b.parentRoot, err)
+	}
+	parentTime := b.parentTime
+	if err := corethcore.ApplyUpgrades(b.chainCfg, &parentTime, corethcore.NewBlockContext(b.header.Number, b.header.Time), pre); err != nil {
+		return nil, fmt.Errorf("apply upgrades: %w", err)
+	}
+	if b.beaconRoot != nil {
+		blockCtx := corethcore.NewEVMBlockContext(b.header, b.chainCtx, &b.header.Coinbase)
+		vmenv := vm.NewEVM(blockCtx, vm.TxContext{}, pre, b.chainCfg, vm.Config{})
+		corethcore.ProcessBeaconBlockRoot(*b.beaconRoot, vmenv, pre)
+	}
+	pre.IntermediateRoot(true)
+	ws := statetrie.NewWriteSet()
+	ws.Merge(specDB.Writes)
+	return ws, nil
+}
+
+// run executes transaction i against speculative state reading tx with base
+// on top. The receipt's CumulativeGasUsed only counts the transaction itself.
+func (b *parallelBlock) run(tx *mdbx.Txn, base *statetrie.WriteSet, i int) txRun {
+	specDB := statetrie.NewSpeculativeDatabase(b.db, b.stateDB.Overlay, tx, base)
+	st, err := state.New(b.parentRoot, specDB, nil)
+	if err != nil {
+		return txRun{err: err}
+	}
+	t := b.txs[i]
+	st.SetTxContext(t.Hash(), i)
+	// The block context caches block hashes and must not be shared between
+	// goroutines.
+	blockCtx := corethcore.NewEVMBlockContext(b.header, b.chainCtx, &b.header.Coinbase)
+	gp := new(corethcore.GasPool).AddGas(b.header.GasLimit)
+	var usedGas uint64
+	receipt, err := corethcore.ApplyTransaction(b.chainCfg, b.chainCtx, blockCtx, gp, st, b.header, t, &usedGas, vm.Config{})
+	if err != nil {
+		return txRun{err: err}
+	}
+	st.IntermediateRoot(true)
+	return txRun{receipt: receipt, reads: specDB.Reads, writes: specDB.Writes}
+}
+
+// writtenState is what the validated transactions of a block have changed.
+type writtenState struct {
+	accounts map[common.Address]bool
+	slots    map[common.Address]map[common.Hash]bool
+}
+
+func newWrittenState() *writtenState {
+	return &writtenState{
+		accounts: make(map[common.Address]bool),
+		slots:    make(map[common.Address]map[common.Hash]bool),
+	}
+}
+
+// conflicts reports whether r read state an earlier transaction changed. A
+// coinbase read is fine when r only adds to the coinbase balance.
+func (w *writtenState) conflicts(r *txRun, coinbase common.Address) bool {
+	_, isDelta := coinbaseDelta(r, coinbase)
+	for addr := range r.reads.Accounts {
+		if addr == coinbase && isDelta {
+			continue
+		}
+		if w.accounts[addr] {
+			return true
+		}
+	}
+	// Reading a slot loads its account first, so a slot of an account an
+	// earlier transaction destructed is caught above.
+	for addr, slots := range r.reads.Slots {
+		written := w.slots[addr]
+		for slot := range slots {
+			if written[slot] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// coinbaseDelta returns how much r added to the coinbase balance, if that is
+// all it did to the coinbase.
+func coinbaseDelta(r *txRun, coinbase common.Address) (*uint256.Int, bool) {
+	read := r.reads.Accounts[coinbase]
+	acct, ok := r.writes.Accounts[coinbase]
+	if !ok || acct == nil || read == nil || len(r.writes.Slots[coinbase]) > 0 {
+		return nil, false
+	}
+	if acct.Nonce != read.Nonce || !bytes.Equal(acct.CodeHash, read.CodeHash) || acct.Balance.Lt(read.Balance) {
+		return nil, false
+	}
+	return new(uint256.Int).Sub(acct.Balance, read.Balance), true
+}
+
+// accountChanged reports whether acct differs from read in anything but its
+// storage root, which the canonical StateDB recomputes itself.
+func accountChanged(read, acct *ethtypes.StateAccount) bool {
+	if read == nil {
+		return true
+	}
+	return acct.Nonce != read.Nonce ||
+		!acct.Balance.Eq(read.Balance) ||
+		!bytes.Equal(acct.CodeHash, read.CodeHash) ||
+		ccustomtypes.IsAccountMultiCoin(acct) != ccustomtypes.IsAccountMultiCoin(read)
+}
+
+// applyRun applies the writes of a validated run to sdb and records them in
+// committed and written.
+func applyRun(sdb *state.StateDB, r *txRun, coinbase common.Address, committed *statetrie.WriteSet, written *writtenState) {
+	delta, isDelta := coinbaseDelta(r, coinbase)
+	for addr, acct := range r.writes.Accounts {
+		read := r.reads.Accounts[addr]
+		switch {
+		case acct == nil:
+			sdb.SelfDestruct(addr)
+			written.accounts[addr] = true
+		case addr == coinbase && isDelta:
+			if !delta.IsZero() {
+				sdb.AddBalance(addr, delta)
+				written.accounts[addr] = true
+			}
+		case accountChanged(read, acct):
+			sdb.SetNonce(addr, acct.Nonce)
+			sdb.SetBalance(addr, acct.Balance)
+			if read == nil || !bytes.Equal(read.CodeHash, acct.CodeHash) {
+				sdb.SetCode(addr, r.writes.Code[common.BytesToHash(acct.CodeHash)])
+			}
+			if ccustomtypes.IsAccountMultiCoin(acct) {
+				ccustomtypes.SetMultiCoin(sdb, addr, true)
+			}
+			written.accounts[addr] = true
+		}
+	}
+	for addr, slots := range r.writes.Slots {
+		dst := written.slots[addr]
+		if dst == nil {
+			dst = make(map[common.Hash]bool, len(slots))
+			written.slots[addr] = dst
+		}
+		for slot, value := range slots {
+			sdb.SetState(addr, slot, value, stateconf.SkipStateKeyTransformation())
+			dst[slot] = true
+		}
+	}
+
+	committed.Merge(r.writes)
+	if isDelta {
+		acct := *committed.Accounts[coinbase]
+		acct.Balance = new(uint256.Int).Set(sdb.GetBalance(coinbase))
+		committed.Accounts[coinbase] = &acct
+	}
+}
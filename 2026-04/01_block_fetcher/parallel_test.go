@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"testing"
+
+	corethcore "github.com/ava-labs/avalanchego/graft/coreth/core"
+	cparams "github.com/ava-labs/avalanchego/graft/coreth/params"
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/core/state"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/crypto"
+	"github.com/ava-labs/libevm/trie"
+	"github.com/erigontech/mdbx-go/mdbx"
+	"github.com/holiman/uint256"
+
+	"block_fetcher/statetrie"
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+// counterCode increments slot 0 and logs the new value.
+var counterCode = common.FromHex("0x6000546001018060005560005260206000a000")
+
+var (
+	testCounter  = common.Address{19: 0xcc}
+	testCoinbase = common.Address{19: 0xcb}
+)
+
+// parallelTestChain is a pre-state of funded keys and a counter contract
+// and a block of transactions against it. The block has same-sender nonce
+// sequences, transfers to accounts that send later in the block and calls
+// that write the same slot, so validation has to re-execute some of them.
+type parallelTestChain struct {
+	keys   []*ecdsa.PrivateKey
+	header *ethtypes.Header
+	txs    ethtypes.Transactions
+}
+
+func newParallelTestChain(t *testing.T) *parallelTestChain {
+	t.Helper()
+	c := &parallelTestChain{
+		header: &ethtypes.Header{
+			Number:     big.NewInt(1),
+			Time:       1,
+			GasLimit:   15_000_000,
+			BaseFee:    big.NewInt(25_000_000_000),
+			Difficulty: big.NewInt(1),
+			Coinbase:   testCoinbase,
+		},
+	}
+	for range 5 {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.keys = append(c.keys, key)
+	}
+	signer := ethtypes.LatestSignerForChainID(cparams.TestChainConfig.ChainID)
+	nonces := make(map[int]uint64)
+	send := func(from int, to common.Address, value int64, gas uint64) {
+		tx, err := ethtypes.SignNewTx(c.keys[from], signer, &ethtypes.LegacyTx{
+			Nonce:    nonces[from],
+			To:       &to,
+			Value:    big.NewInt(value),
+			Gas:      gas,
+			GasPrice: big.NewInt(100_000_000_000),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonces[from]++
+		c.txs = append(c.txs, tx)
+	}
+	send(0, common.Address{19: 0x10}, 1e18, 21_000)
+	send(0, common.Address{19: 0x11}, 1e18, 21_000)
+	send(1, testCounter, 0, 100_000)
+	send(2, testCounter, 0, 100_000)
+	send(3, c.addr(4), 5e18, 21_000)
+	send(4, common.Address{19: 0x12}, 55e17, 21_000)
+	send(1, common.Address{19: 0x13}, 1, 21_000)
+	send(2, common.Address{19: 0x14}, 1, 21_000)
+	return c
+}
+
+func (c *parallelTestChain) addr(i int) common.Address {
+	return crypto.PubkeyToAddress(c.keys[i].PublicKey)
+}
+
+// openParallelTestDB writes the pre-state of c to a new database and returns
+// it with its state root.
+func openParallelTestDB(t *testing.T, c *parallelTestChain) (*store.DB, *statetrie.Database, common.Hash) {
+	t.Helper()
+	db, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	stateDB := statetrie.NewDatabase(db)
+	sdb, err := state.New(ethtypes.EmptyRootHash, stateDB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Key 4 starts with 1 ETH: its transfer of 5.5 ETH only succeeds with the
+	// 5 ETH key 3 sends it earlier in the block.
+	for i := range c.keys {
+		balance := uint256.NewInt(1e18)
+		if i < 4 {
+			balance.Mul(balance, uint256.NewInt(100))
+		}
+		sdb.SetBalance(c.addr(i), balance)
+	}
+	sdb.SetCode(testCounter, counterCode)
+	root := commitParallelTestState(t, db, stateDB, sdb, 0)
+	return db, stateDB, root
+}
+
+// commitParallelTestState commits sdb as block blockNum the way executeBatch
+// does and returns the state root.
+func commitParallelTestState(t *testing.T, db *store.DB, stateDB *statetrie.Database, sdb *state.StateDB, blockNum uint64) common.Hash {
+	t.Helper()
+	overlay := statetrie.NewBatchOverlay()
+	stateDB.Overlay = overlay
+	defer func() { stateDB.Overlay = nil }()
+	stateDB.CurrentBlock = blockNum
+	sdb.Finalise(true)
+	if _, err := sdb.Commit(blockNum, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var root [32]byte
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		oldStorageRoots := statetrie.ReadOldStorageRoots(tx, db, overlay.ChangedAccountHashes())
+		if err := overlay.FlushStateToTx(tx, db); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		if root, _, err = statetrie.ComputeIncrementalStateRoot(tx, db, overlay, oldStorageRoots); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return common.Hash(root)
+}
+
+// executeParallelTestBlock executes c's block on a fresh copy of its
+// pre-state, with parallel workers if workers > 1, and returns the receipts
+// and the resulting state root.
+func executeParallelTestBlock(t *testing.T, c *parallelTestChain, workers int) ([]*ethtypes.Receipt, int, common.Hash, error) {
+	t.Helper()
+	db, stateDB, parentRoot := openParallelTestDB(t, c)
+	stateDB.Overlay = statetrie.NewBatchOverlay()
+	stateDB.CurrentBlock = 1
+	sdb, err := state.New(parentRoot, stateDB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainCfg := cparams.TestChainConfig
+	chainCtx := newExecutorChainContext(db)
+
+	var (
+		receipts   []*ethtypes.Receipt
+		reexecuted int
+	)
+	if workers > 1 {
+		receipts, reexecuted, err = executeTxsParallel(&parallelBlock{
+			db:         db,
+			stateDB:    stateDB,
+			chainCfg:   chainCfg,
+			chainCtx:   chainCtx,
+			header:     c.header,
+			parentRoot: parentRoot,
+			txs:        c.txs,
+		}, sdb, workers)
+		if err != nil {
+			return nil, reexecuted, common.Hash{}, err
+		}
+	} else {
+		blockCtx := corethcore.NewEVMBlockContext(c.header, chainCtx, &c.header.Coinbase)
+		receipts, err = applyTxsSerially(chainCfg, chainCtx, blockCtx, c.header, c.txs, sdb)
+		if err != nil {
+			return nil, 0, common.Hash{}, err
+		}
+	}
+	return receipts, reexecuted, commitParallelTestState(t, db, stateDB, sdb, 1), nil
+}
+
+func TestParallelMatchesSerial(t *testing.T) {
+	c := newParallelTestChain(t)
+	serial, _, serialRoot, err := executeParallelTestBlock(t, c, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range serial {
+		if r.Status != ethtypes.ReceiptStatusSuccessful {
+			t.Fatalf("serial tx %d failed", i)
+		}
+	}
+	c.header.ReceiptHash = ethtypes.DeriveSha(ethtypes.Receipts(serial), trie.NewStackTrie(nil))
+
+	parallel, reexecuted, parallelRoot, err := executeParallelTestBlock(t, c, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reexecuted == 0 {
+		t.Fatal("no transaction was re-executed")
+	}
+	if parallelRoot != serialRoot {
+		t.Fatalf("parallel state root %x, serial %x", parallelRoot, serialRoot)
+	}
+	if len(parallel) != len(serial) {
+		t.Fatalf("%d parallel receipts, %d serial", len(parallel), len(serial))
+	}
+	for i := range serial {
+		p, s := parallel[i], serial[i]
+		if p.Status != s.Status || p.GasUsed != s.GasUsed || p.CumulativeGasUsed != s.CumulativeGasUsed || len(p.Logs) != len(s.Logs) {
+			t.Fatalf("tx %d: parallel receipt %+v, serial %+v", i, p, s)
+		}
+	}
+}
+
+func TestParallelReceiptRootMismatch(t *testing.T) {
+	c := newParallelTestChain(t)
+	c.header.ReceiptHash = common.Hash{1}
+	_, _, _, err := executeParallelTestBlock(t, c, 4)
+	if !errors.Is(err, errParallelMismatch) {
+		t.Fatalf("err = %v, want %v", err, errParallelMismatch)
+	}
+}
@@ -0,0 +1,245 @@
+// Package profile describes the chain block_fetcher syncs: the Avalanche
+// network it lives on, its subnet and blockchain IDs, its VM and its genesis.
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/ava-labs/avalanchego/genesis"
+	corethcore "github.com/ava-labs/avalanchego/graft/coreth/core"
+	cparams "github.com/ava-labs/avalanchego/graft/coreth/params"
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/upgrade"
+	avaconstants "github.com/ava-labs/avalanchego/utils/constants"
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/params"
+)
+
+// VM is the virtual machine a chain runs.
+type VM string
+
+const (
+	// Coreth is the C-Chain VM. Its blocks can be executed.
+	Coreth VM = "coreth"
+	// SubnetEVM is the VM of EVM L1s. Its blocks are fetched and stored but
+	// not executed: the subnet-evm rules (fee config, precompiles) are not
+	// part of this module. The RPC serves its blocks and txs but refuses
+	// state and log queries, as the database holds no state past genesis.
+	SubnetEVM VM = "subnet-evm"
+)
+
+// mainnetGenesisRoot is the state root of mainnet C-Chain block 0.
+var mainnetGenesisRoot = common.HexToHash("d65eb1b8604a7aa497d41cd6372663785a5f809a17bd192edb86658ef24e29cc")
+
+// Profile is everything that differs between the chains block_fetcher can
+// sync.
+type Profile struct {
+	Name      string
+	NetworkID uint32
+	// SubnetID is the subnet validating the chain: the primary network for
+	// the C-Chain, the L1's subnet otherwise.
+	SubnetID     ids.ID
+	BlockchainID ids.ID
+	VM           VM
+	// Genesis carries the chain config, with the network's Avalanche upgrade
+	// times already applied.
+	Genesis     *corethcore.Genesis
+	GenesisRoot common.Hash
+	AVAXAssetID ids.ID
+	// PublicAPI is a public AvalancheGo API on the chain's network. Its
+	// platform API serves the validator set when no node is configured.
+	PublicAPI string
+	// EmbeddedCheckpoints is set when the container IDs embedded in the
+	// binary belong to this chain. Without them the fetcher starts from the
+	// accepted frontier.
+	EmbeddedCheckpoints bool
+}
+
+// ChainConfig returns the EVM chain config.
+func (p *Profile) ChainConfig() *params.ChainConfig {
+	return p.Genesis.Config
+}
+
+// EVMChainID returns the EIP-155 chain ID.
+func (p *Profile) EVMChainID() *big.Int {
+	return p.Genesis.Config.ChainID
+}
+
+// Executable reports whether the executor can run this chain's blocks, and
+// so whether the database holds their state, receipts and logs.
+func (p *Profile) Executable() bool {
+	return p.VM == Coreth
+}
+
+// Mainnet returns the mainnet C-Chain profile.
+func Mainnet() (*Profile, error) {
+	p, err := cChain("mainnet", avaconstants.MainnetID)
+	if err != nil {
+		return nil, err
+	}
+	p.GenesisRoot = mainnetGenesisRoot
+	p.PublicAPI = "https://api.avax.network"
+	p.EmbeddedCheckpoints = true
+	return p, nil
+}
+
+// Fuji returns the Fuji C-Chain profile.
+func Fuji() (*Profile, error) {
+	p, err := cChain("fuji", avaconstants.FujiID)
+	if err != nil {
+		return nil, err
+	}
+	p.PublicAPI = "https://api.avax-test.network"
+	return p, nil
+}
+
+// ByName returns the C-Chain profile of a named network.
+func ByName(name string) (*Profile, error) {
+	switch name {
+	case "mainnet":
+		return Mainnet()
+	case "fuji":
+		return Fuji()
+	default:
+		return nil, fmt.Errorf("unknown network %q (want mainnet or fuji)", name)
+	}
+}
+
+// cChain builds the C-Chain profile of networkID. The blockchain ID and AVAX
+// asset ID are derived from the network's genesis, as the node does.
+func cChain(name string, networkID uint32) (*Profile, error) {
+	config := genesis.GetConfig(networkID)
+	gen := new(corethcore.Genesis)
+	if err := json.Unmarshal([]byte(config.CChainGenesis), gen); err != nil {
+		return nil, fmt.Errorf("parse %s C-Chain genesis: %w", name, err)
+	}
+	if err := setUpgrades(gen.Config, upgrade.GetConfig(networkID)); err != nil {
+		return nil, err
+	}
+
+	genesisBytes, avaxAssetID, err := genesis.FromConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("build %s genesis: %w", name, err)
+	}
+	chainTx, err := genesis.VMGenesis(genesisBytes, avaconstants.EVMID)
+	if err != nil {
+		return nil, fmt.Errorf("find C-Chain in %s genesis: %w", name, err)
+	}
+	return &Profile{
+		Name:         name,
+		NetworkID:    networkID,
+		SubnetID:     avaconstants.PrimaryNetworkID,
+		BlockchainID: chainTx.ID(),
+		VM:           Coreth,
+		Genesis:      gen,
+		GenesisRoot:  gen.ToBlock().Root(),
+		AVAXAssetID:  avaxAssetID,
+	}, nil
+}
+
+// L1Config names the files and IDs that describe a Subnet-EVM L1.
+type L1Config struct {
+	// Network is the primary network the L1 is registered on.
+	Network      string
+	SubnetID     ids.ID
+	BlockchainID ids.ID
+	// GenesisFile is the L1's genesis JSON, as passed to CreateChainTx.
+	GenesisFile string
+	// ChainConfigFile optionally replaces the genesis "config" object, for
+	// L1s whose EVM chain config has changed since genesis.
+	ChainConfigFile string
+}
+
+// L1 builds the profile of a Subnet-EVM L1.
+func L1(cfg L1Config) (*Profile, error) {
+	primary, err := ByName(cfg.Network)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SubnetID == ids.Empty || cfg.SubnetID == avaconstants.PrimaryNetworkID {
+		return nil, fmt.Errorf("L1 needs its subnet ID")
+	}
+	if cfg.BlockchainID == ids.Empty {
+		return nil, fmt.Errorf("L1 needs its blockchain ID")
+	}
+
+	raw, err := os.ReadFile(cfg.GenesisFile)
+	if err != nil {
+		return nil, fmt.Errorf("read L1 genesis: %w", err)
+	}
+	gen := new(corethcore.Genesis)
+	if err := json.Unmarshal(raw, gen); err != nil {
+		return nil, fmt.Errorf("parse L1 genesis %s: %w", cfg.GenesisFile, err)
+	}
+	if cfg.ChainConfigFile != "" {
+		raw, err := os.ReadFile(cfg.ChainConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("read L1 chain config: %w", err)
+		}
+		chainCfg := new(params.ChainConfig)
+		if err := json.Unmarshal(raw, chainCfg); err != nil {
+			return nil, fmt.Errorf("parse L1 chain config %s: %w", cfg.ChainConfigFile, err)
+		}
+		gen.Config = chainCfg
+	}
+	if gen.Config == nil || gen.Config.ChainID == nil {
+		return nil, fmt.Errorf("L1 genesis %s has no chain ID", cfg.GenesisFile)
+	}
+	// As in subnet-evm, the network's upgrade schedule wins over the
+	// timestamps in the genesis. The upgrades before Durango predate L1s and
+	// are active from their genesis.
+	upgrades := upgrade.GetConfig(primary.NetworkID)
+	for _, t := range []*time.Time{
+		&upgrades.ApricotPhase1Time, &upgrades.ApricotPhase2Time, &upgrades.ApricotPhase3Time,
+		&upgrades.ApricotPhase4Time, &upgrades.ApricotPhase5Time, &upgrades.ApricotPhasePre6Time,
+		&upgrades.ApricotPhase6Time, &upgrades.ApricotPhasePost6Time, &upgrades.BanffTime,
+		&upgrades.CortinaTime,
+	} {
+		*t = upgrade.InitiallyActiveTime
+	}
+	if err := setUpgrades(gen.Config, upgrades); err != nil {
+		return nil, err
+	}
+
+	return &Profile{
+		Name:         "l1-" + cfg.BlockchainID.String(),
+		NetworkID:    primary.NetworkID,
+		SubnetID:     cfg.SubnetID,
+		BlockchainID: cfg.BlockchainID,
+		VM:           SubnetEVM,
+		Genesis:      gen,
+		GenesisRoot:  gen.ToBlock().Root(),
+		AVAXAssetID:  primary.AVAXAssetID,
+		// L1 validators are registered on the P-Chain of the primary network.
+		PublicAPI: primary.PublicAPI,
+	}, nil
+}
+
+// setUpgrades sets the Avalanche network upgrade timestamps of cfg on the
+// chain config extras. A genesis JSON only has the Ethereum forks; the
+// Avalanche ones come from the node's upgrade schedule.
+func setUpgrades(c *params.ChainConfig, cfg upgrade.Config) error {
+	extra := cparams.GetExtra(c)
+	ts := func(t time.Time) *uint64 { v := uint64(t.Unix()); return &v }
+	extra.NetworkUpgrades.ApricotPhase1BlockTimestamp = ts(cfg.ApricotPhase1Time)
+	extra.NetworkUpgrades.ApricotPhase2BlockTimestamp = ts(cfg.ApricotPhase2Time)
+	extra.NetworkUpgrades.ApricotPhase3BlockTimestamp = ts(cfg.ApricotPhase3Time)
+	extra.NetworkUpgrades.ApricotPhase4BlockTimestamp = ts(cfg.ApricotPhase4Time)
+	extra.NetworkUpgrades.ApricotPhase5BlockTimestamp = ts(cfg.ApricotPhase5Time)
+	extra.NetworkUpgrades.ApricotPhasePre6BlockTimestamp = ts(cfg.ApricotPhasePre6Time)
+	extra.NetworkUpgrades.ApricotPhase6BlockTimestamp = ts(cfg.ApricotPhase6Time)
+	extra.NetworkUpgrades.ApricotPhasePost6BlockTimestamp = ts(cfg.ApricotPhasePost6Time)
+	extra.NetworkUpgrades.BanffBlockTimestamp = ts(cfg.BanffTime)
+	extra.NetworkUpgrades.CortinaBlockTimestamp = ts(cfg.CortinaTime)
+	extra.NetworkUpgrades.DurangoBlockTimestamp = ts(cfg.DurangoTime)
+	extra.NetworkUpgrades.EtnaTimestamp = ts(cfg.EtnaTime)
+	cparams.WithExtra(c, extra)
+	if err := cparams.SetEthUpgrades(c); err != nil {
+		return fmt.Errorf("set eth upgrades: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+)
+
+const (
+	defaultPruneInterval = time.Minute
+
+	// pruneChunk is how many blocks one pruning transaction covers, so the
+	// executor never waits long for the write lock.
+	pruneChunk = 1000
+)
+
+// pruneConfig is how many blocks below the executed head each kind of
+// history is kept for. Zero keeps it all.
+type pruneConfig struct {
+	history  uint64
+	receipts uint64
+	txIndex  uint64
+}
+
+func (c pruneConfig) enabled() bool {
+	return c.history > 0 || c.receipts > 0 || c.txIndex > 0
+}
+
+// runPruner deletes history that has fallen out of its retention window
+// every interval until ctx ends. A failed pass is logged and retried on the
+// next tick.
+func runPruner(ctx context.Context, db *store.DB, cfg pruneConfig, interval time.Duration) {
+	log.Printf("pruner: keeping history=%d receipts=%d txindex=%d blocks (0 = all)", cfg.history, cfg.receipts, cfg.txIndex)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, k := range []struct {
+			kind   store.PruneKind
+			retain uint64
+		}{
+			{store.PruneHistory, cfg.history},
+			{store.PruneReceipts, cfg.receipts},
+			{store.PruneTxIndex, cfg.txIndex},
+		} {
+			if k.retain == 0 {
+				continue
+			}
+			if err := pruneKind(ctx, db, k.kind, k.retain); err != nil && ctx.Err() == nil {
+				log.Printf("pruner: %s: %v", k.kind, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pruneKind prunes kind up to retain blocks below the executed head, one
+// chunk per write transaction.
+func pruneKind(ctx context.Context, db *store.DB, kind store.PruneKind, retain uint64) error {
+	head, from, err := pruneState(db, kind)
+	if err != nil {
+		return err
+	}
+	if head <= retain {
+		return nil
+	}
+	target := head - retain
+	if from >= target {
+		return nil
+	}
+	start := time.Now()
+	pruned := from
+	for pruned < target {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		to := min(pruned+pruneChunk, target)
+		if err := pruneChunkOf(db, kind, pruned, to); err != nil {
+			return fmt.Errorf("blocks %d-%d: %w", pruned, to-1, err)
+		}
+		pruned = to
+	}
+	log.Printf("pruner: %s pruned blocks %d-%d elapsed=%s", kind, from, target-1, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// pruneFeeds lists the deferred indexes built from each kind of history.
+// Pruning must not get ahead of them.
+var pruneFeeds = map[store.PruneKind][]store.IndexKind{
+	store.PruneHistory:  {store.IndexHistory},
+	store.PruneReceipts: {store.IndexLogs, store.IndexTxHashes},
+	store.PruneTxIndex:  {store.IndexTxHashes},
+}
+
+// pruneState returns the head kind is pruned against, the executed head or
+// the lowest watermark of the indexes kind feeds if one is behind, and the
+// lowest block kind is kept from. A database nothing has been executed into
+// has head 0.
+func pruneState(db *store.DB, kind store.PruneKind) (uint64, uint64, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := db.BeginRO()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Abort()
+	head, _ := store.GetHeadBlock(tx, db)
+	for _, idx := range pruneFeeds[kind] {
+		if indexed, ok := store.GetIndexedTo(tx, db, idx); ok && indexed < head {
+			head = indexed
+		}
+	}
+	from, err := store.GetPrunedBelow(tx, db, kind)
+	if err != nil {
+		return 0, 0, err
+	}
+	return head, from, nil
+}
+
+// pruneChunkOf prunes kind for blocks [from, to) in one write transaction.
+func pruneChunkOf(db *store.DB, kind store.PruneKind, from, to uint64) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := db.BeginRW()
+	if err != nil {
+		return err
+	}
+	defer tx.Abort()
+
+	switch kind {
+	case store.PruneHistory:
+		err = store.PruneStateHistory(tx, db, from, to)
+	case store.PruneReceipts:
+		err = store.PruneBlockReceipts(tx, db, from, to)
+	case store.PruneTxIndex:
+		var hashes [][32]byte
+		hashes, err = blockTxHashes(tx, db, from, to)
+		if err == nil {
+			err = store.PruneTxHashes(tx, db, hashes, to)
+		}
+	default:
+		err = fmt.Errorf("unknown prune kind %q", kind)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Commit()
+	return err
+}
+
+// blockTxHashes returns the hashes of the transactions in blocks [from, to),
+// read from the stored containers since the receipts may be gone already.
+func blockTxHashes(tx *mdbx.Txn, db *store.DB, from, to uint64) ([][32]byte, error) {
+	var hashes [][32]byte
+	for n := from; n < to; n++ {
+		id, err := store.GetContainerIDByNumber(tx, db, n)
+		if err != nil {
+			if mdbx.IsNotFound(err) {
+				continue // genesis has no container
+			}
+			return nil, fmt.Errorf("index of block %d: %w", n, err)
+		}
+		raw, err := store.GetContainer(tx, db, id)
+		if err != nil {
+			return nil, fmt.Errorf("read block %d: %w", n, err)
+		}
+		ethBlock, err := executorParseEthBlock(append([]byte(nil), raw...))
+		if err != nil {
+			return nil, fmt.Errorf("parse block %d: %w", n, err)
+		}
+		for _, t := range ethBlock.Transactions() {
+			hashes = append(hashes, t.Hash())
+		}
+	}
+	return hashes, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+// The pruner stops at the lowest watermark of the indexes a kind of history
+// feeds, so nothing the indexer still has to read is deleted.
+func TestPruneStopsAtIndexWatermarks(t *testing.T) {
+	db := storetest.Open(t, store.Open)
+	addr := [20]byte{19: 1}
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		keyID, err := store.GetOrAssignKeyID(tx, db, addr, store.AccountSentinelSlot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for num := uint64(1); num <= 6; num++ {
+			if err := store.WriteChangeset(tx, db, num, []store.Change{{KeyID: keyID}}); err != nil {
+				t.Fatal(err)
+			}
+			receipts := []store.TxReceipt{{TxHash: [32]byte{0: byte(num)}, Status: 1, Logs: []store.LogEntry{{Address: addr}}}}
+			if err := store.WriteBlockReceipts(tx, db, num, receipts); err != nil {
+				t.Fatal(err)
+			}
+			if num == 3 {
+				// Indexed to 3; blocks 4-6 are committed behind the indexer.
+				if err := store.SetHeadBlock(tx, db, 3); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.InitIndexWatermarks(tx, db); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := store.SetHeadBlock(tx, db, 6); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	check := func(stage string, wantBelow uint64) {
+		t.Helper()
+		storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+			for _, kind := range []store.PruneKind{store.PruneHistory, store.PruneReceipts} {
+				if below, err := store.GetPrunedBelow(tx, db, kind); err != nil || below != wantBelow {
+					t.Fatalf("%s: %s pruned below %d (%v), want %d", stage, kind, below, err, wantBelow)
+				}
+			}
+			for num := wantBelow; num <= 6; num++ {
+				if _, err := store.ReadChangeset(tx, db, num); err != nil {
+					t.Fatalf("%s: changeset %d: %v", stage, num, err)
+				}
+				if receipts, err := store.ReadBlockReceipts(tx, db, num); err != nil || receipts == nil {
+					t.Fatalf("%s: receipts of block %d: %v", stage, num, err)
+				}
+			}
+		})
+	}
+
+	prune := func() {
+		t.Helper()
+		for _, kind := range []store.PruneKind{store.PruneHistory, store.PruneReceipts} {
+			if err := pruneKind(context.Background(), db, kind, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	prune()
+	check("indexed to 3", 2)
+
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		for _, kind := range []store.IndexKind{store.IndexHistory, store.IndexLogs, store.IndexTxHashes} {
+			if err := store.BuildIndex(tx, db, kind, 4, 6); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	prune()
+	check("indexed to 6", 5)
+}
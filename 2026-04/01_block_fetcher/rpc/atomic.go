@@ -0,0 +1,257 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+
+	"github.com/ava-labs/avalanchego/graft/coreth/core/extstate"
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/common/hexutil"
+	"github.com/ava-labs/libevm/core/state"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/chainquery"
+	"block_fetcher/statetrie"
+	"block_fetcher/store"
+)
+
+// maxAtomicResults caps how many atomic txs one listing returns.
+const maxAtomicResults = 10000
+
+// GetAssetBalance returns the balance of a multi-coin asset (an ANT
+// imported from the X-chain) held by an address at a block, as coreth's
+// eth_getAssetBalance does. Params: address, block tag, asset ID in CB58.
+func (b *Backend) GetAssetBalance(params []json.RawMessage) (any, error) {
+	if len(params) < 3 {
+		return nil, fmt.Errorf("missing parameters")
+	}
+	var addrHex, blockTag string
+	var assetID ids.ID
+	if err := json.Unmarshal(params[0], &addrHex); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(params[1], &blockTag); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(params[2], &assetID); err != nil {
+		return nil, fmt.Errorf("invalid asset ID: %w", err)
+	}
+	if blockTag == "" {
+		blockTag = "latest"
+	}
+	blockNum, err := b.resolveStateBlock(blockTag)
+	if err != nil {
+		return nil, err
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := b.db.BeginRO()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := store.GetBlockByNumber(tx, b.db, blockNum)
+	if err != nil {
+		tx.Abort()
+		return nil, fmt.Errorf("read block %d: %w", blockNum, err)
+	}
+	raw = append([]byte(nil), raw...)
+	tx.Abort()
+	ethBlock, err := parseEthBlock(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	sdb, err := state.New(ethBlock.Root(), statetrie.NewHistoricalDatabase(b.db, blockNum), nil)
+	if err != nil {
+		return nil, fmt.Errorf("open state at block %d root %x: %w", blockNum, ethBlock.Root(), err)
+	}
+	bal := extstate.New(sdb).GetBalanceMultiCoin(common.HexToAddress(addrHex), common.Hash(assetID))
+	return (*hexutil.Big)(bal), nil
+}
+
+// GetAtomicTxsByBlock returns the imports and exports of a block for
+// avax_getAtomicTxsByBlock. Params: block tag.
+func (b *Backend) GetAtomicTxsByBlock(params []json.RawMessage) (any, error) {
+	blockTag := "latest"
+	if len(params) > 0 {
+		json.Unmarshal(params[0], &blockTag)
+	}
+	blockNum, err := b.resolveBlockTag(blockTag)
+	if err != nil {
+		return nil, err
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := b.db.BeginRO()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Abort()
+
+	if head, _ := store.GetHeadBlock(tx, b.db); blockNum > head {
+		return nil, nil
+	}
+	indexed, err := b.atomicIndexedTo(tx, blockNum)
+	if err != nil {
+		return nil, err
+	}
+	txs, err := b.blockAtomicTxs(tx, blockNum, indexed)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]map[string]any, 0, len(txs))
+	for _, t := range txs {
+		results = append(results, formatAtomicTx(t, blockNum))
+	}
+	return results, nil
+}
+
+// GetAtomicTxsByAddress returns the imports crediting and exports debiting
+// an address in a block range for avax_getAtomicTxsByAddress. Params:
+// address, fromBlock, toBlock; the range defaults to the whole chain.
+func (b *Backend) GetAtomicTxsByAddress(ctx context.Context, params []json.RawMessage) (any, error) {
+	if len(params) < 1 {
+		return nil, fmt.Errorf("missing address parameter")
+	}
+	var addrHex, fromTag, toTag string
+	if err := json.Unmarshal(params[0], &addrHex); err != nil {
+		return nil, err
+	}
+	if len(params) > 1 {
+		json.Unmarshal(params[1], &fromTag)
+	}
+	if len(params) > 2 {
+		json.Unmarshal(params[2], &toTag)
+	}
+	if fromTag == "" {
+		fromTag = "earliest"
+	}
+	addr := [20]byte(common.HexToAddress(addrHex))
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := b.db.BeginRO()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Abort()
+
+	head, _ := store.GetHeadBlock(tx, b.db)
+	fromBlock, err := resolveFilterBlock(fromTag, head)
+	if err != nil {
+		return nil, err
+	}
+	toBlock, err := resolveFilterBlock(toTag, head)
+	if err != nil {
+		return nil, err
+	}
+	toBlock = min(toBlock, head)
+	if fromBlock > toBlock {
+		return []map[string]any{}, nil
+	}
+	indexed, err := b.atomicIndexedTo(tx, toBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := store.ReadAtomicAddressIndex(tx, b.db, addr, fromBlock, toBlock)
+	if err != nil {
+		return nil, err
+	}
+	if indexed < toBlock {
+		candidates.AddRange(max(indexed+1, fromBlock), toBlock+1)
+	}
+
+	results := []map[string]any{}
+	it := candidates.Iterator()
+	for it.HasNext() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blockNum := it.Next()
+		txs, err := b.blockAtomicTxs(tx, blockNum, indexed)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range txs {
+			for _, tr := range t.Transfers {
+				if tr.Address == addr {
+					results = append(results, formatAtomicTx(t, blockNum))
+					break
+				}
+			}
+		}
+		if len(results) > maxAtomicResults {
+			return nil, fmt.Errorf("too many results: >%d atomic transactions", maxAtomicResults)
+		}
+	}
+	return results, nil
+}
+
+// atomicIndexedTo returns the atomic tx index watermark. It fails if the
+// index has not reached blockNum and the gap is too wide to decode the
+// containers directly, as while the index is first built for the whole
+// chain.
+func (b *Backend) atomicIndexedTo(tx *mdbx.Txn, blockNum uint64) (uint64, error) {
+	indexed, ok := store.GetIndexedTo(tx, b.db, store.IndexAtomic)
+	if !ok {
+		return 0, fmt.Errorf("atomic transactions have not been indexed")
+	}
+	if blockNum > indexed && blockNum-indexed > chainquery.MaxUnindexedScan {
+		head, _ := store.GetHeadBlock(tx, b.db)
+		return 0, fmt.Errorf("atomic transactions are still being indexed (indexed to block %d, head %d)", indexed, head)
+	}
+	return indexed, nil
+}
+
+// blockAtomicTxs reads the atomic txs of blockNum from the index, or decodes
+// them from the container if the index, built to indexed, has not reached it.
+func (b *Backend) blockAtomicTxs(tx *mdbx.Txn, blockNum, indexed uint64) ([]store.AtomicTx, error) {
+	if blockNum <= indexed {
+		return store.ReadBlockAtomicTxs(tx, b.db, blockNum)
+	}
+	raw, err := store.GetBlockByNumber(tx, b.db, blockNum)
+	if err != nil {
+		return nil, fmt.Errorf("block %d: %w", blockNum, err)
+	}
+	ethBlock, err := parseEthBlock(append([]byte(nil), raw...))
+	if err != nil {
+		return nil, fmt.Errorf("block %d: %w", blockNum, err)
+	}
+	return b.atomic.BlockAtomicTxs(ethBlock)
+}
+
+func formatAtomicTx(t store.AtomicTx, blockNum uint64) map[string]any {
+	transfers := make([]map[string]any, 0, len(t.Transfers))
+	for _, tr := range t.Transfers {
+		m := map[string]any{
+			"address": addrHex(common.Address(tr.Address)),
+			"assetID": ids.ID(tr.AssetID).String(),
+			"amount":  hexutil.Uint64(tr.Amount),
+		}
+		if t.Export {
+			m["nonce"] = hexutil.Uint64(tr.Nonce)
+		}
+		transfers = append(transfers, m)
+	}
+	out := map[string]any{
+		"txID":        ids.ID(t.TxID).String(),
+		"blockNumber": hexutil.Uint64(blockNum),
+		"burned":      hexutil.Uint64(t.Burned),
+	}
+	if t.Export {
+		out["type"] = "export"
+		out["destinationChain"] = ids.ID(t.Chain).String()
+		out["inputs"] = transfers
+	} else {
+		out["type"] = "import"
+		out["sourceChain"] = ids.ID(t.Chain).String()
+		out["outputs"] = transfers
+	}
+	return out
+}
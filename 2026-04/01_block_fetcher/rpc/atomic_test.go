@@ -0,0 +1,188 @@
+package rpc
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+
+	cparams "github.com/ava-labs/avalanchego/graft/coreth/params"
+	"github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/atomic"
+	ccustomtypes "github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/customtypes"
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/vms/components/avax"
+	"github.com/ava-labs/avalanchego/vms/secp256k1fx"
+	"github.com/ava-labs/libevm/common"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/rlp"
+	"github.com/ava-labs/libevm/trie"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/atomictx"
+	"block_fetcher/chainquery"
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+var (
+	testAVAX       = ids.ID{0xaa}
+	testXChain     = ids.ID{0x58}
+	testAtomicAddr = common.Address{19: 1}
+)
+
+// atomicTestBlocks are the atomic txs of blocks 1-5: an import crediting
+// testAtomicAddr in block 2 and an export debiting it in block 4.
+func atomicTestBlocks() map[uint64]*atomic.Tx {
+	return map[uint64]*atomic.Tx{
+		2: {UnsignedAtomicTx: &atomic.UnsignedImportTx{
+			SourceChain: testXChain,
+			ImportedInputs: []*avax.TransferableInput{{
+				UTXOID: avax.UTXOID{TxID: ids.ID{1}},
+				Asset:  avax.Asset{ID: testAVAX},
+				In:     &secp256k1fx.TransferInput{Amt: 1000, Input: secp256k1fx.Input{SigIndices: []uint32{0}}},
+			}},
+			Outs: []atomic.EVMOutput{{Address: testAtomicAddr, Amount: 990, AssetID: testAVAX}},
+		}},
+		4: {UnsignedAtomicTx: &atomic.UnsignedExportTx{
+			DestinationChain: testXChain,
+			Ins:              []atomic.EVMInput{{Address: testAtomicAddr, Amount: 500, AssetID: testAVAX, Nonce: 7}},
+			ExportedOutputs: []*avax.TransferableOutput{{
+				Asset: avax.Asset{ID: testAVAX},
+				Out: &secp256k1fx.TransferOutput{
+					Amt:          480,
+					OutputOwners: secp256k1fx.OutputOwners{Threshold: 1, Addrs: []ids.ShortID{{1}}},
+				},
+			}},
+		}},
+	}
+}
+
+// newAtomicTestBackend stores blocks 1-5 with the atomic txs of
+// atomicTestBlocks and indexes them up to indexedTo. It returns the backend
+// and the tx IDs by block.
+func newAtomicTestBackend(t *testing.T, indexedTo uint64) (*Backend, map[uint64]ids.ID) {
+	t.Helper()
+	db := storetest.Open(t, store.Open)
+	b := &Backend{db: db, atomic: atomictx.NewDecoder(cparams.TestChainConfig, testAVAX)}
+	txIDs := make(map[uint64]ids.ID)
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		indexed := make(map[uint64][]store.AtomicTx)
+		for n := uint64(1); n <= 5; n++ {
+			var extData []byte
+			if atx, ok := atomicTestBlocks()[n]; ok {
+				var err error
+				if extData, err = atomic.Codec.Marshal(atomic.CodecVersion, []*atomic.Tx{atx}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			header := &ethtypes.Header{Number: new(big.Int).SetUint64(n), Time: n, Difficulty: big.NewInt(1)}
+			block := ccustomtypes.NewBlockWithExtData(header, nil, nil, nil, trie.NewStackTrie(nil), extData, true)
+			raw, err := rlp.EncodeToBytes(block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.PutContainer(tx, db, block.Hash(), n, raw); err != nil {
+				t.Fatal(err)
+			}
+			txs, err := b.atomic.BlockAtomicTxs(block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(txs) > 0 {
+				txIDs[n] = txs[0].TxID
+				indexed[n] = txs
+			}
+		}
+		if err := store.SetHeadBlock(tx, db, 5); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.InitIndexWatermarks(tx, db); err != nil {
+			t.Fatal(err)
+		}
+		if indexedTo > 0 {
+			if err := store.WriteAtomicIndex(tx, db, 1, indexedTo, indexed); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	return b, txIDs
+}
+
+func TestGetAtomicTxsByBlock(t *testing.T) {
+	// Indexed to 3: block 2 is read from the index, block 4 decoded from
+	// its container.
+	b, txIDs := newAtomicTestBackend(t, 3)
+	for _, tc := range []struct {
+		block string
+		want  string
+	}{{"0x2", "import"}, {"0x4", "export"}, {"0x3", ""}, {"0x9", ""}} {
+		res, err := callTestMethod(t, b.GetAtomicTxsByBlock, tc.block)
+		if err != nil {
+			t.Fatalf("block %s: %v", tc.block, err)
+		}
+		if tc.want == "" {
+			if txs, _ := res.([]map[string]any); len(txs) != 0 {
+				t.Fatalf("block %s: %v, want none", tc.block, res)
+			}
+			continue
+		}
+		txs := res.([]map[string]any)
+		if len(txs) != 1 || txs[0]["type"] != tc.want {
+			t.Fatalf("block %s: %v, want one %s", tc.block, res, tc.want)
+		}
+		n := uint64(2)
+		if tc.want == "export" {
+			n = 4
+		}
+		if got := txs[0]["txID"]; got != txIDs[n].String() {
+			t.Fatalf("block %s: txID %v, want %s", tc.block, got, txIDs[n])
+		}
+	}
+}
+
+func TestGetAtomicTxsByAddress(t *testing.T) {
+	for _, indexedTo := range []uint64{0, 3, 5} {
+		b, txIDs := newAtomicTestBackend(t, indexedTo)
+		for _, tc := range []struct {
+			addr     common.Address
+			from, to string
+			want     []uint64
+		}{
+			{testAtomicAddr, "", "", []uint64{2, 4}},
+			{testAtomicAddr, "0x3", "latest", []uint64{4}},
+			{testAtomicAddr, "0x1", "0x2", []uint64{2}},
+			{common.Address{19: 2}, "", "", nil},
+		} {
+			res, err := callTestMethod(t, withContext(context.Background(), b.GetAtomicTxsByAddress), tc.addr.Hex(), tc.from, tc.to)
+			if err != nil {
+				t.Fatalf("indexed to %d: %v", indexedTo, err)
+			}
+			txs := res.([]map[string]any)
+			if len(txs) != len(tc.want) {
+				t.Fatalf("indexed to %d, %s [%s, %s]: %v, want blocks %v", indexedTo, tc.addr, tc.from, tc.to, txs, tc.want)
+			}
+			for i, n := range tc.want {
+				if txs[i]["txID"] != txIDs[n].String() {
+					t.Fatalf("indexed to %d: result %d is %v, want the tx of block %d", indexedTo, i, txs[i], n)
+				}
+			}
+		}
+	}
+}
+
+func TestAtomicTxsStillIndexing(t *testing.T) {
+	b, _ := newAtomicTestBackend(t, 0)
+	storetest.WithRW(t, b.db, func(tx *mdbx.Txn) {
+		if err := store.SetHeadBlock(tx, b.db, 5+chainquery.MaxUnindexedScan); err != nil {
+			t.Fatal(err)
+		}
+	})
+	_, err := callTestMethod(t, b.GetAtomicTxsByBlock, "latest")
+	if err == nil || !strings.Contains(err.Error(), "still being indexed") {
+		t.Fatalf("err = %v", err)
+	}
+	_, err = callTestMethod(t, withContext(context.Background(), b.GetAtomicTxsByAddress), testAtomicAddr.Hex())
+	if err == nil || !strings.Contains(err.Error(), "still being indexed") {
+		t.Fatalf("err = %v", err)
+	}
+}
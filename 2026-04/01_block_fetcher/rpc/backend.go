@@ -1,35 +1,71 @@
 package rpc
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"math/big"
 	"runtime"
+	"sync"
 
+	corethcore "github.com/ava-labs/avalanchego/graft/coreth/core"
 	"github.com/ava-labs/libevm/common"
 	"github.com/ava-labs/libevm/common/hexutil"
 	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/crypto"
+	"github.com/ava-labs/libevm/eth/tracers/logger"
 	"github.com/ava-labs/libevm/rlp"
 	"github.com/erigontech/mdbx-go/mdbx"
 	proposerblock "github.com/ava-labs/avalanchego/vms/proposervm/block"
 
+	"block_fetcher/atomictx"
+	"block_fetcher/chainquery"
+	"block_fetcher/profile"
+	"block_fetcher/statetrie"
 	"block_fetcher/store"
 )
 
 // Backend provides data access for RPC methods.
 type Backend struct {
-	db  *store.DB
-	evm *EVMContext
+	db      *store.DB
+	evm     *EVMContext
+	atomic  *atomictx.Decoder
+	chainID *big.Int
+	// unexecutedVM is set when the chain's blocks are stored but never
+	// executed: the database then holds only the genesis state, no later
+	// state, receipts or logs.
+	unexecutedVM profile.VM
+
+	// Gas price oracle cache, keyed by the head it was computed at.
+	gpoMu   sync.Mutex
+	gpoHead uint64
+	gpoTip  *big.Int
 }
 
-// NewBackend creates a new RPC backend.
-func NewBackend(db *store.DB) *Backend {
-	evmCtx, err := NewEVMContext()
-	if err != nil {
-		panic(fmt.Sprintf("failed to initialize EVM context: %v", err))
+// NewBackend creates a new RPC backend for the chain described by prof.
+func NewBackend(db *store.DB, prof *profile.Profile) *Backend {
+	b := &Backend{
+		db:      db,
+		evm:     NewEVMContext(prof.ChainConfig()),
+		atomic:  atomictx.NewDecoder(prof.ChainConfig(), prof.AVAXAssetID),
+		chainID: prof.EVMChainID(),
+	}
+	if !prof.Executable() {
+		b.unexecutedVM = prof.VM
 	}
-	return &Backend{db: db, evm: evmCtx}
+	return b
+}
+
+// ChainID returns the EIP-155 chain ID for eth_chainId.
+func (b *Backend) ChainID() string {
+	return encodeBigInt(b.chainID)
+}
+
+// NetVersion returns the chain ID in decimal, which is what Avalanche EVM
+// nodes answer to net_version.
+func (b *Backend) NetVersion() string {
+	return b.chainID.String()
 }
 
 // BlockNumber returns the latest block number.
@@ -131,7 +167,7 @@ func (b *Backend) GetTransactionByHash(params []json.RawMessage) (any, error) {
 	}
 	defer tx.Abort()
 
-	blockNum, txIndex, err := store.GetTxLocation(tx, b.db, [32]byte(txHash))
+	blockNum, txIndex, err := chainquery.TxLocation(tx, b.db, [32]byte(txHash))
 	if err != nil {
 		if mdbx.IsNotFound(err) {
 			return nil, nil
@@ -177,13 +213,16 @@ func (b *Backend) GetTransactionReceipt(params []json.RawMessage) (any, error) {
 	}
 	defer tx.Abort()
 
-	blockNum, txIndex, err := store.GetTxLocation(tx, b.db, [32]byte(txHash))
+	blockNum, txIndex, err := chainquery.TxLocation(tx, b.db, [32]byte(txHash))
 	if err != nil {
 		if mdbx.IsNotFound(err) {
 			return nil, nil
 		}
 		return nil, err
 	}
+	if err := b.checkPruned(tx, store.PruneReceipts, blockNum); err != nil {
+		return nil, err
+	}
 
 	// Read block for hash and transaction data.
 	raw, err := store.GetBlockByNumber(tx, b.db, blockNum)
@@ -224,7 +263,7 @@ func (b *Backend) GetBalance(params []json.RawMessage) (any, error) {
 	}
 
 	addr := common.HexToAddress(addrHex)
-	blockNum, err := b.resolveBlockTag(blockTag)
+	blockNum, err := b.resolveStateBlock(blockTag)
 	if err != nil {
 		return nil, err
 	}
@@ -269,7 +308,7 @@ func (b *Backend) GetStorageAt(params []json.RawMessage) (any, error) {
 
 	addr := common.HexToAddress(addrHex)
 	slot := common.HexToHash(slotHex)
-	blockNum, err := b.resolveBlockTag(blockTag)
+	blockNum, err := b.resolveStateBlock(blockTag)
 	if err != nil {
 		return nil, err
 	}
@@ -317,7 +356,7 @@ func (b *Backend) GetCode(params []json.RawMessage) (any, error) {
 	}
 
 	addr := common.HexToAddress(addrHex)
-	blockNum, err := b.resolveBlockTag(blockTag)
+	blockNum, err := b.resolveStateBlock(blockTag)
 	if err != nil {
 		return nil, err
 	}
@@ -367,7 +406,7 @@ func (b *Backend) GetTransactionCount(params []json.RawMessage) (any, error) {
 	}
 
 	addr := common.HexToAddress(addrHex)
-	blockNum, err := b.resolveBlockTag(blockTag)
+	blockNum, err := b.resolveStateBlock(blockTag)
 	if err != nil {
 		return nil, err
 	}
@@ -391,7 +430,7 @@ func (b *Backend) GetTransactionCount(params []json.RawMessage) (any, error) {
 }
 
 // GetLogs returns logs matching a filter.
-func (b *Backend) GetLogs(params []json.RawMessage) (any, error) {
+func (b *Backend) GetLogs(ctx context.Context, params []json.RawMessage) (any, error) {
 	if len(params) < 1 {
 		return nil, fmt.Errorf("missing filter parameter")
 	}
@@ -399,6 +438,9 @@ func (b *Backend) GetLogs(params []json.RawMessage) (any, error) {
 	if err := json.Unmarshal(params[0], &filter); err != nil {
 		return nil, fmt.Errorf("invalid filter: %w", err)
 	}
+	if err := b.requireExecuted(); err != nil {
+		return nil, err
+	}
 
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
@@ -418,33 +460,54 @@ func (b *Backend) GetLogs(params []json.RawMessage) (any, error) {
 	if err != nil {
 		return nil, err
 	}
-	if toBlock-fromBlock > 10000 {
-		return nil, fmt.Errorf("block range too large: %d blocks (max 10000)", toBlock-fromBlock)
+	if fromBlock > toBlock {
+		return []map[string]any{}, nil
+	}
+	if err := b.checkPruned(tx, store.PruneReceipts, fromBlock); err != nil {
+		return nil, err
 	}
 
-	// TODO: use bitmap indexes for filtered queries.
-	// For now, brute force scan the block range.
-	var results []map[string]any
-	for blockNum := fromBlock; blockNum <= toBlock; blockNum++ {
-		receipts, err := store.ReadBlockReceipts(tx, b.db, blockNum)
-		if err != nil || receipts == nil {
-			continue
+	filter.parse()
+	candidates, err := chainquery.LogBlocks(tx, b.db, filter.query, fromBlock, toBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []map[string]any{}
+	it := candidates.Iterator()
+	for it.HasNext() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
-		// Get block hash for response.
-		raw, err := store.GetBlockByNumber(tx, b.db, blockNum)
+		blockNum := it.Next()
+		receipts, err := store.ReadBlockReceipts(tx, b.db, blockNum)
 		if err != nil {
-			continue
+			return nil, fmt.Errorf("read receipts %d: %w", blockNum, err)
 		}
-		ethBlock, err := parseEthBlock(append([]byte(nil), raw...))
-		if err != nil {
+		if receipts == nil {
 			continue
 		}
-		blockHash := ethBlock.Hash()
+		var blockHash common.Hash
+		haveHash := false
 
 		logIndex := 0
 		for txIdx, r := range receipts {
 			for _, l := range r.Logs {
 				if filter.matchesLog(l) {
+					// Only decode the container once a log actually matches;
+					// topic bitmaps are position-agnostic, so some candidates miss.
+					if !haveHash {
+						raw, err := store.GetBlockByNumber(tx, b.db, blockNum)
+						if err != nil {
+							return nil, fmt.Errorf("block %d: %w", blockNum, err)
+						}
+						ethBlock, err := parseEthBlock(append([]byte(nil), raw...))
+						if err != nil {
+							return nil, fmt.Errorf("block %d: %w", blockNum, err)
+						}
+						blockHash = ethBlock.Hash()
+						haveHash = true
+					}
 					results = append(results, formatLogFromReceipt(l, uint16(txIdx), uint16(logIndex), blockNum, blockHash, r.TxHash))
 				}
 				logIndex++
@@ -459,161 +522,256 @@ func (b *Backend) GetLogs(params []json.RawMessage) (any, error) {
 
 // CallArgs matches the standard eth_call params.
 type CallArgs struct {
-	From     *string `json:"from"`
-	To       *string `json:"to"`
-	Gas      *string `json:"gas"`
-	GasPrice *string `json:"gasPrice"`
-	Value    *string `json:"value"`
-	Data     *string `json:"data"`
-	Input    *string `json:"input"` // alias for data
+	From       *string              `json:"from"`
+	To         *string              `json:"to"`
+	Gas        *string              `json:"gas"`
+	GasPrice   *string              `json:"gasPrice"`
+	Value      *string              `json:"value"`
+	Data       *string              `json:"data"`
+	Input      *string              `json:"input"` // alias for data
+	Nonce      *string              `json:"nonce"` // eth_simulateV1 only
+	AccessList *ethtypes.AccessList `json:"accessList"`
 }
 
-// Call executes a read-only call against historical state.
-func (b *Backend) Call(params []json.RawMessage) (any, error) {
-	if len(params) < 1 {
-		return nil, fmt.Errorf("missing call params")
+// request decodes the args into a CallRequest without overrides.
+func (a *CallArgs) request() (*CallRequest, error) {
+	req := &CallRequest{}
+	if a.From != nil {
+		req.From = common.HexToAddress(*a.From)
 	}
-	var args CallArgs
-	if err := json.Unmarshal(params[0], &args); err != nil {
-		return nil, fmt.Errorf("invalid call args: %w", err)
+	if a.To != nil {
+		addr := common.HexToAddress(*a.To)
+		req.To = &addr
 	}
-	blockTag := "latest"
-	if len(params) > 1 {
-		json.Unmarshal(params[1], &blockTag)
-	}
-
-	blockNum, err := b.resolveBlockTag(blockTag)
-	if err != nil {
-		return nil, err
-	}
-
-	from := common.Address{}
-	if args.From != nil {
-		from = common.HexToAddress(*args.From)
-	}
-	var to *common.Address
-	if args.To != nil {
-		addr := common.HexToAddress(*args.To)
-		to = &addr
-	}
-	var gas uint64
-	if args.Gas != nil {
-		gas, _ = hexutil.DecodeUint64(*args.Gas)
+	var err error
+	if a.Gas != nil {
+		if req.Gas, err = hexutil.DecodeUint64(*a.Gas); err != nil {
+			return nil, fmt.Errorf("invalid gas: %w", err)
+		}
 	}
-	var gasPrice *big.Int
-	if args.GasPrice != nil {
-		gasPrice, _ = hexutil.DecodeBig(*args.GasPrice)
+	if a.GasPrice != nil {
+		if req.GasPrice, err = hexutil.DecodeBig(*a.GasPrice); err != nil {
+			return nil, fmt.Errorf("invalid gasPrice: %w", err)
+		}
 	}
-	var value *big.Int
-	if args.Value != nil {
-		value, _ = hexutil.DecodeBig(*args.Value)
+	if a.Value != nil {
+		if req.Value, err = hexutil.DecodeBig(*a.Value); err != nil {
+			return nil, fmt.Errorf("invalid value: %w", err)
+		}
 	}
-	dataHex := args.Data
+	dataHex := a.Data
 	if dataHex == nil {
-		dataHex = args.Input
+		dataHex = a.Input
 	}
-	var data []byte
 	if dataHex != nil {
-		data, _ = hexutil.Decode(*dataHex)
-	}
-
-	result, _, err := b.evm.ExecuteCall(b.db, blockNum, from, to, gas, gasPrice, value, data)
-	if err != nil {
-		// Return revert data in the error response for compatibility.
-		if len(result) > 0 {
-			return nil, fmt.Errorf("execution reverted: %s", hexutil.Encode(result))
+		if req.Data, err = hexutil.Decode(*dataHex); err != nil {
+			return nil, fmt.Errorf("invalid data: %w", err)
 		}
-		return nil, err
 	}
-	return hexutil.Encode(result), nil
+	if a.AccessList != nil {
+		req.AccessList = *a.AccessList
+	}
+	return req, nil
 }
 
-// EstimateGas estimates gas for a call using binary search.
-func (b *Backend) EstimateGas(params []json.RawMessage) (any, error) {
+// parseCallRequest decodes [callArgs, block, stateOverrides, blockOverrides],
+// the shared params layout of eth_call, eth_estimateGas and
+// eth_createAccessList.
+func (b *Backend) parseCallRequest(params []json.RawMessage) (*CallRequest, uint64, error) {
 	if len(params) < 1 {
-		return nil, fmt.Errorf("missing call params")
+		return nil, 0, fmt.Errorf("missing call params")
 	}
 	var args CallArgs
 	if err := json.Unmarshal(params[0], &args); err != nil {
-		return nil, fmt.Errorf("invalid call args: %w", err)
+		return nil, 0, fmt.Errorf("invalid call args: %w", err)
 	}
 	blockTag := "latest"
 	if len(params) > 1 {
 		json.Unmarshal(params[1], &blockTag)
 	}
+	blockNum, err := b.resolveStateBlock(blockTag)
+	if err != nil {
+		return nil, 0, err
+	}
+	req, err := args.request()
+	if err != nil {
+		return nil, 0, err
+	}
+	req.StateOverrides, req.BlockOverrides, err = parseCallOverrides(params)
+	if err != nil {
+		return nil, 0, err
+	}
+	return req, blockNum, nil
+}
 
-	blockNum, err := b.resolveBlockTag(blockTag)
+// Call executes a read-only call against historical state.
+func (b *Backend) Call(ctx context.Context, params []json.RawMessage) (any, error) {
+	req, blockNum, err := b.parseCallRequest(params)
 	if err != nil {
 		return nil, err
 	}
-
-	from := common.Address{}
-	if args.From != nil {
-		from = common.HexToAddress(*args.From)
+	result, err := b.evm.ExecuteCall(ctx, b.db, blockNum, req, nil)
+	if err != nil {
+		return nil, err
 	}
-	var to *common.Address
-	if args.To != nil {
-		addr := common.HexToAddress(*args.To)
-		to = &addr
+	if err := callError(result); err != nil {
+		return nil, err
 	}
-	var gasPrice *big.Int
-	if args.GasPrice != nil {
-		gasPrice, _ = hexutil.DecodeBig(*args.GasPrice)
+	return hexutil.Encode(result.ReturnData), nil
+}
+
+// EstimateGas binary-searches the lowest gas limit at which the call
+// succeeds, following go-ethereum's estimator: the upper bound is the gas
+// arg, the block gas limit or what the sender can pay for, whichever is
+// lowest, and the lower bound starts just under the gas used at that bound.
+func (b *Backend) EstimateGas(ctx context.Context, params []json.RawMessage) (any, error) {
+	req, blockNum, err := b.parseCallRequest(params)
+	if err != nil {
+		return nil, err
 	}
-	var value *big.Int
-	if args.Value != nil {
-		value, _ = hexutil.DecodeBig(*args.Value)
+	hi, err := b.gasCap(blockNum, req)
+	if err != nil {
+		return nil, err
 	}
-	dataHex := args.Data
-	if dataHex == nil {
-		dataHex = args.Input
+	gas, err := chainquery.EstimateGas(hi, func(gas uint64) (*corethcore.ExecutionResult, error) {
+		r := *req
+		r.Gas = gas
+		return b.evm.ExecuteCall(ctx, b.db, blockNum, &r, nil)
+	}, callError)
+	if err != nil {
+		return nil, err
 	}
-	var data []byte
-	if dataHex != nil {
-		data, _ = hexutil.Decode(*dataHex)
+	return hexutil.EncodeUint64(gas), nil
+}
+
+// gasCap is the highest gas limit EstimateGas will try: the gas arg if set,
+// else the block gas limit or its override, lowered to what the sender's
+// balance, or its override, covers at a non-zero gas price.
+func (b *Backend) gasCap(blockNum uint64, req *CallRequest) (uint64, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := b.db.BeginRO()
+	if err != nil {
+		return 0, err
 	}
+	defer tx.Abort()
 
-	// Binary search for minimum gas.
-	lo := uint64(21000)
-	hi := uint64(8_000_000)
-	if args.Gas != nil {
-		if g, err := hexutil.DecodeUint64(*args.Gas); err == nil && g > 0 {
-			hi = g
+	var gasLimit uint64
+	if req.Gas == 0 {
+		raw, err := store.GetBlockByNumber(tx, b.db, blockNum)
+		if err != nil {
+			return 0, fmt.Errorf("read block %d: %w", blockNum, err)
+		}
+		ethBlock, err := parseEthBlock(append([]byte(nil), raw...))
+		if err != nil {
+			return 0, err
+		}
+		gasLimit = ethBlock.GasLimit()
+		if req.BlockOverrides != nil && req.BlockOverrides.GasLimit != nil {
+			gasLimit = uint64(*req.BlockOverrides.GasLimit)
 		}
 	}
+	return chainquery.GasCap(req.Gas, gasLimit, req.GasPrice, req.Value, func() (*big.Int, error) {
+		if o := req.senderOverride(); o.Balance != nil {
+			return o.Balance.ToInt(), nil
+		}
+		acct, err := b.getAccountAt(tx, req.From, blockNum)
+		if err != nil || acct == nil {
+			return new(big.Int), err
+		}
+		return new(big.Int).SetBytes(acct.Balance[:]), nil
+	})
+}
 
-	// First check if it works at hi.
-	_, _, err = b.evm.ExecuteCall(b.db, blockNum, from, to, hi, gasPrice, value, data)
+// CreateAccessList implements eth_createAccessList. As in go-ethereum, the
+// call is re-run with the access list found so far until tracing it adds
+// nothing new; the gas used is that of the final run.
+func (b *Backend) CreateAccessList(ctx context.Context, params []json.RawMessage) (any, error) {
+	req, blockNum, err := b.parseCallRequest(params)
 	if err != nil {
 		return nil, err
 	}
-
-	// Binary search.
-	for lo+1 < hi {
-		mid := (lo + hi) / 2
-		_, _, err = b.evm.ExecuteCall(b.db, blockNum, from, to, mid, gasPrice, value, data)
+	precompiles, err := b.evm.activePrecompiles(b.db, blockNum, req.BlockOverrides)
+	if err != nil {
+		return nil, err
+	}
+	// The sender and the call target are warm anyway; a contract creation
+	// targets the address derived from the sender's nonce.
+	to := req.To
+	if to == nil {
+		nonce, err := b.nonceAt(blockNum, req)
 		if err != nil {
-			lo = mid
-		} else {
-			hi = mid
+			return nil, err
 		}
+		addr := crypto.CreateAddress(req.From, nonce)
+		to = &addr
 	}
 
-	return fmt.Sprintf("0x%x", hi), nil
-}
-
-// GasPrice returns a gas price suggestion.
-func (b *Backend) GasPrice() (string, error) {
-	return "0x5d21dba00", nil // 25 nAVAX default
+	prev := logger.NewAccessListTracer(req.AccessList, req.From, *to, precompiles)
+	for {
+		accessList := prev.AccessList()
+		tracer := logger.NewAccessListTracer(accessList, req.From, *to, precompiles)
+		r := *req
+		r.AccessList = accessList
+		result, err := b.evm.ExecuteCall(ctx, b.db, blockNum, &r, tracer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply transaction: %w", err)
+		}
+		if tracer.Equal(prev) {
+			out := map[string]any{
+				"accessList": accessList,
+				"gasUsed":    hexutil.EncodeUint64(result.UsedGas),
+			}
+			if result.Err != nil {
+				out["error"] = result.Err.Error()
+			}
+			return out, nil
+		}
+		prev = tracer
+	}
 }
 
-// FeeHistory returns fee history data.
-func (b *Backend) FeeHistory(params []json.RawMessage) (any, error) {
-	return nil, fmt.Errorf("eth_feeHistory not yet implemented")
+// nonceAt is the sender's nonce at blockNum, after state overrides.
+func (b *Backend) nonceAt(blockNum uint64, req *CallRequest) (uint64, error) {
+	if o := req.senderOverride(); o.Nonce != nil {
+		return uint64(*o.Nonce), nil
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := b.db.BeginRO()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Abort()
+	acct, err := b.getAccountAt(tx, req.From, blockNum)
+	if err != nil || acct == nil {
+		return 0, err
+	}
+	return acct.Nonce, nil
 }
 
 // --- Helper methods ---
 
+// parseCallOverrides decodes the optional third (state overrides) and fourth
+// (block overrides) params of eth_call and eth_estimateGas.
+func parseCallOverrides(params []json.RawMessage) (*statetrie.StateOverride, *statetrie.BlockOverrides, error) {
+	var (
+		stateOverrides *statetrie.StateOverride
+		blockOverrides *statetrie.BlockOverrides
+	)
+	if len(params) > 2 && string(params[2]) != "null" {
+		if err := json.Unmarshal(params[2], &stateOverrides); err != nil {
+			return nil, nil, fmt.Errorf("invalid state overrides: %w", err)
+		}
+	}
+	if len(params) > 3 && string(params[3]) != "null" {
+		if err := json.Unmarshal(params[3], &blockOverrides); err != nil {
+			return nil, nil, fmt.Errorf("invalid block overrides: %w", err)
+		}
+	}
+	return stateOverrides, blockOverrides, nil
+}
+
 func (b *Backend) resolveBlockTag(tag string) (uint64, error) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
@@ -639,6 +797,66 @@ func (b *Backend) resolveBlockTag(tag string) (uint64, error) {
 	}
 }
 
+// resolveStateBlock resolves tag like resolveBlockTag, for methods that read
+// the state at that block. It fails if the state has been pruned.
+func (b *Backend) resolveStateBlock(tag string) (uint64, error) {
+	blockNum, err := b.resolveBlockTag(tag)
+	if err != nil {
+		return 0, err
+	}
+	if err := b.requireState(blockNum); err != nil {
+		return 0, err
+	}
+	return blockNum, nil
+}
+
+// requireState fails if the chain's blocks are not executed, and with a
+// history-pruned error if the state at blockNum has been pruned.
+func (b *Backend) requireState(blockNum uint64) error {
+	if err := b.requireExecuted(); err != nil {
+		return err
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := b.db.BeginRO()
+	if err != nil {
+		return err
+	}
+	defer tx.Abort()
+	if err := b.checkPruned(tx, store.PruneHistory, blockNum); err != nil {
+		return err
+	}
+	// Lookups below the head scan the changesets HistoryIndex has not
+	// reached yet; refuse while that would be slow.
+	from, to := store.UnindexedRange(tx, b.db, store.IndexHistory)
+	if blockNum < to && to-max(from-1, blockNum) > chainquery.MaxUnindexedScan {
+		return fmt.Errorf("state history is still being indexed (indexed to block %d, head %d)", from-1, to)
+	}
+	return nil
+}
+
+// requireExecuted fails for chains whose blocks are not executed, so that
+// state and log queries are refused rather than answered from genesis.
+func (b *Backend) requireExecuted() error {
+	if b.unexecutedVM != "" {
+		return newNotExecutedError(b.unexecutedVM)
+	}
+	return nil
+}
+
+// checkPruned fails with a history-pruned error if blockNum's history of
+// kind has been pruned.
+func (b *Backend) checkPruned(tx *mdbx.Txn, kind store.PruneKind, blockNum uint64) error {
+	keptFrom, err := store.GetPrunedBelow(tx, b.db, kind)
+	if err != nil {
+		return err
+	}
+	if blockNum < keptFrom {
+		return newPrunedError(kind, blockNum, keptFrom)
+	}
+	return nil
+}
+
 func (b *Backend) getAccountAt(tx *mdbx.Txn, addr common.Address, blockNum uint64) (*store.Account, error) {
 	head, _ := store.GetHeadBlock(tx, b.db)
 	var a20 [20]byte
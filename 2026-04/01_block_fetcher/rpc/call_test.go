@@ -0,0 +1,233 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	cparams "github.com/ava-labs/avalanchego/graft/coreth/params"
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/common/hexutil"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+
+	"block_fetcher/statetrie"
+)
+
+var (
+	// callTestSlots returns the storage slot named by its first calldata
+	// word.
+	callTestSlots = common.FromHex("0x6000355460005260206000f3")
+	// callTestTload reads transient slot 0, an opcode from Cancun.
+	callTestTload = common.FromHex("0x60005c60005260206000f3")
+	// callTestKZG returns whether a STATICCALL to 0x0a without input
+	// succeeds, then NUMBER and TIMESTAMP. Before Cancun 0x0a is an empty
+	// account and the call succeeds; from Cancun it is the KZG point
+	// evaluation precompile, which fails on empty input.
+	callTestKZG = common.FromHex("0x6000600060006000600a5afa600052436020524260405260606000f3")
+	// callTestSstoreGas sets slot 1 to 0x11 and returns the gas that took,
+	// with the GAS and two PUSH1 around the SSTORE.
+	callTestSstoreGas = common.FromHex("0x5a60116001555a900360005260206000f3")
+	// callTestRevertingSelf calls itself with one byte of calldata, which
+	// makes it set slot 1 to 0xdead and revert, then returns slot 1.
+	callTestRevertingSelf = common.FromHex("0x36601d5760006000600160006000305af15060015460005260206000f35b61dead600155600080fd")
+)
+
+// newCallTestChain stores a genesis with the call test contracts and one
+// empty block, and returns a backend serving it.
+func newCallTestChain(t *testing.T) (*Backend, map[string]common.Address) {
+	t.Helper()
+	contracts := map[string]common.Address{
+		"slots": {19: 0xc1}, "tload": {19: 0xc2}, "kzg": {19: 0xc3}, "self": {19: 0xc4}, "sstore": {19: 0xc5},
+	}
+	alloc := ethtypes.GenesisAlloc{testSender: {Balance: big.NewInt(1e18)}}
+	for name, code := range map[string][]byte{
+		"slots": callTestSlots, "tload": callTestTload, "kzg": callTestKZG, "self": callTestRevertingSelf,
+		"sstore": callTestSstoreGas,
+	} {
+		alloc[contracts[name]] = ethtypes.Account{Code: code, Balance: new(big.Int)}
+	}
+	slots := alloc[contracts["slots"]]
+	slots.Storage = map[common.Hash]common.Hash{{31: 1}: {31: 0x11}, {31: 2}: {31: 0x22}}
+	alloc[contracts["slots"]] = slots
+	self := alloc[contracts["self"]]
+	self.Storage = map[common.Hash]common.Hash{{31: 1}: {31: 0x11}}
+	alloc[contracts["self"]] = self
+	sstore := alloc[contracts["sstore"]]
+	sstore.Storage = map[common.Hash]common.Hash{{31: 1}: {31: 0x11}}
+	alloc[contracts["sstore"]] = sstore
+
+	c := newTestChain(t, alloc)
+	c.addBlock(t)
+	return c.backend(), contracts
+}
+
+// callTestArgs are eth_call args from testSender to to with data.
+func callTestArgs(to common.Address, data []byte) map[string]any {
+	return map[string]any{"from": testSender.Hex(), "to": to.Hex(), "data": hexutil.Encode(data)}
+}
+
+func TestCallStateOverrides(t *testing.T) {
+	b, contracts := newCallTestChain(t)
+	slots := contracts["slots"]
+	slot := func(n byte) []byte { return common.Hash{31: n}.Bytes() }
+	word := func(n byte) string { return common.Hash{31: n}.Hex() }
+	for _, tc := range []struct {
+		name     string
+		override map[string]any
+		slot     byte
+		want     string
+		wantErr  string
+	}{
+		{"none", nil, 2, word(0x22), ""},
+		// state replaces all storage: slot 2 is gone.
+		{"state", map[string]any{"state": map[string]string{word(1): word(0x99)}}, 2, word(0), ""},
+		{"state sets", map[string]any{"state": map[string]string{word(1): word(0x99)}}, 1, word(0x99), ""},
+		// stateDiff patches slot 1 only: slot 2 is kept.
+		{"stateDiff", map[string]any{"stateDiff": map[string]string{word(1): word(0x99)}}, 2, word(0x22), ""},
+		{"stateDiff sets", map[string]any{"stateDiff": map[string]string{word(1): word(0x99)}}, 1, word(0x99), ""},
+		{"both", map[string]any{
+			"state":     map[string]string{word(1): word(0x99)},
+			"stateDiff": map[string]string{word(2): word(0x99)},
+		}, 1, "", "both 'state' and 'stateDiff'"},
+		// Refused when decoded; Apply checks the overrides it is handed too.
+		{"balance overflow", map[string]any{"balance": "0x1" + strings.Repeat("0", 64)}, 1, "", "256 bits"},
+	} {
+		overrides := map[string]any{}
+		if tc.override != nil {
+			overrides[slots.Hex()] = tc.override
+		}
+		res, err := callTestMethod(t, withContext(context.Background(), b.Call), callTestArgs(slots, slot(tc.slot)), "latest", overrides)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%s: err = %v, want %q", tc.name, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if res != tc.want {
+			t.Errorf("%s: slot %d = %v, want %s", tc.name, tc.slot, res, tc.want)
+		}
+	}
+
+	balance := (*hexutil.Big)(new(big.Int).Lsh(common.Big1, 256))
+	req := &CallRequest{From: testSender, To: &slots, StateOverrides: &statetrie.StateOverride{slots: {Balance: balance}}}
+	if _, err := b.evm.ExecuteCall(context.Background(), b.db, 1, req, nil); err == nil || !strings.Contains(err.Error(), "overflows 256 bits") {
+		t.Errorf("balance overflow: err = %v", err)
+	}
+}
+
+// TestCallOverridesSurviveRevert has the call set the overridden slot and
+// revert in a subcall: the slot reads back as overridden, not as stored.
+func TestCallOverridesSurviveRevert(t *testing.T) {
+	b, contracts := newCallTestChain(t)
+	self := contracts["self"]
+	overrides := map[string]any{self.Hex(): map[string]any{
+		"stateDiff": map[string]string{common.Hash{31: 1}.Hex(): common.Hash{31: 0x42}.Hex()},
+	}}
+	res, err := callTestMethod(t, withContext(context.Background(), b.Call), callTestArgs(self, nil), "latest", overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (common.Hash{31: 0x42}).Hex(); res != want {
+		t.Fatalf("slot 1 after the reverted subcall = %v, want %s", res, want)
+	}
+}
+
+// TestCallOverridesCommitted sets a slot back from its overridden value to
+// the stored one. The override is the slot's original value for the call,
+// so that is a cold reset (2100 + 2900 gas), not the restore of a slot
+// dirtied by the call itself (2100 + 100).
+func TestCallOverridesCommitted(t *testing.T) {
+	b, contracts := newCallTestChain(t)
+	sstore := contracts["sstore"]
+	overrides := map[string]any{sstore.Hex(): map[string]any{
+		"stateDiff": map[string]string{common.Hash{31: 1}.Hex(): common.Hash{31: 0x42}.Hex()},
+	}}
+	res, err := callTestMethod(t, withContext(context.Background(), b.Call), callTestArgs(sstore, nil), "latest", overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := common.BigToHash(big.NewInt(2 + 3 + 3 + 2100 + 2900)).Hex(); res != want {
+		t.Fatalf("SSTORE took %v gas, want %s", res, want)
+	}
+}
+
+// TestCallBlockOverridesForkRules runs on a chain config with Cancun at
+// time 100: moving the block time past it enables TLOAD and the KZG
+// precompile.
+func TestCallBlockOverridesForkRules(t *testing.T) {
+	b, contracts := newCallTestChain(t)
+	cfg := *cparams.TestChainConfig
+	cancun := uint64(100)
+	cfg.CancunTime = &cancun
+	b.evm = NewEVMContext(&cfg)
+
+	blockOverrides := map[string]any{"number": "0x10", "time": "0x64"}
+	if _, err := callTestMethod(t, withContext(context.Background(), b.Call), callTestArgs(contracts["tload"], nil)); err == nil || !strings.Contains(err.Error(), "invalid opcode") {
+		t.Fatalf("TLOAD before Cancun: err = %v", err)
+	}
+	if _, err := callTestMethod(t, withContext(context.Background(), b.Call), callTestArgs(contracts["tload"], nil), "latest", nil, blockOverrides); err != nil {
+		t.Fatalf("TLOAD at Cancun: %v", err)
+	}
+
+	for _, tc := range []struct {
+		overrides map[string]any
+		want      string
+	}{
+		// Block 1 is at time 2.
+		{nil, "0x" + common.Bytes2Hex(append(append(common.Hash{31: 1}.Bytes(), common.Hash{31: 1}.Bytes()...), common.Hash{31: 2}.Bytes()...))},
+		{blockOverrides, "0x" + common.Bytes2Hex(append(append(common.Hash{}.Bytes(), common.Hash{31: 0x10}.Bytes()...), common.Hash{31: 0x64}.Bytes()...))},
+	} {
+		res, err := callTestMethod(t, withContext(context.Background(), b.Call), callTestArgs(contracts["kzg"], nil), "latest", nil, tc.overrides)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != tc.want {
+			t.Fatalf("overrides %v: got %v, want %s", tc.overrides, res, tc.want)
+		}
+	}
+}
+
+// TestCreateAccessList reads slot 2 of the slots contract: the access list
+// holds the contract with that slot, and the gas is that of the call run
+// with it.
+func TestCreateAccessList(t *testing.T) {
+	b, contracts := newCallTestChain(t)
+	slots := contracts["slots"]
+	srv := httptest.NewServer(NewServer(b, DefaultServerConfig()).mux)
+	defer srv.Close()
+
+	args, err := json.Marshal(callTestArgs(slots, common.Hash{31: 2}.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *RPCError       `json:"error"`
+	}
+	postTestRPC(t, srv.URL, `{"jsonrpc":"2.0","id":1,"method":"eth_createAccessList","params":[`+string(args)+`,"latest"]}`, &resp)
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	// 21000, 31 zero and 1 nonzero calldata bytes, 2400 for the address
+	// and 1900 for the key in the list, then the code: a warm SLOAD, one
+	// word of memory and six cheap ops.
+	gas := 21_000 + 31*4 + 16 + 2400 + 1900 + 100 + 3 + 6*3
+	want := `{"accessList":[{"address":"` + strings.ToLower(slots.Hex()) + `","storageKeys":["` + common.Hash{31: 2}.Hex() + `"]}],"gasUsed":"` + hexutil.EncodeUint64(uint64(gas)) + `"}`
+	var got, wantV any
+	if err := json.Unmarshal(resp.Result, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantV); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, wantV) {
+		t.Fatalf("got %s\nwant %s", resp.Result, want)
+	}
+}
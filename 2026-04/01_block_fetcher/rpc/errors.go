@@ -0,0 +1,78 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+
+	corethcore "github.com/ava-labs/avalanchego/graft/coreth/core"
+	"github.com/ava-labs/libevm/accounts/abi"
+	"github.com/ava-labs/libevm/common/hexutil"
+	"github.com/ava-labs/libevm/core/vm"
+
+	"block_fetcher/profile"
+	"block_fetcher/store"
+)
+
+// Error codes go-ethereum uses for failed executions. Wallet libraries look
+// for code 3 to find revert data.
+const (
+	errCodeReverted = 3
+	errCodeVMError  = -32015
+)
+
+// errCodeHistoryPruned is the code go-ethereum answers with when a request
+// needs history the node has pruned.
+const errCodeHistoryPruned = 4444
+
+// errCodeNotExecuted is the code for state and log queries on a chain whose
+// blocks this node stores but does not execute.
+const errCodeNotExecuted = -32001
+
+// newNotExecutedError reports that vm's blocks are not executed, so there is
+// no state past genesis and no logs to answer from.
+func newNotExecutedError(vm profile.VM) *RPCError {
+	return &RPCError{
+		Code:    errCodeNotExecuted,
+		Message: fmt.Sprintf("state not available: %s blocks are stored but not executed", vm),
+	}
+}
+
+// prunedWhat says what a PruneKind removes, for error messages.
+var prunedWhat = map[store.PruneKind]string{
+	store.PruneHistory:  "state",
+	store.PruneReceipts: "receipts and logs",
+	store.PruneTxIndex:  "transaction index",
+}
+
+// newPrunedError reports that the history of kind at blockNum is gone and
+// which block it is kept from.
+func newPrunedError(kind store.PruneKind, blockNum, keptFrom uint64) *RPCError {
+	return &RPCError{
+		Code:    errCodeHistoryPruned,
+		Message: fmt.Sprintf("history pruned: %s of block %d not available (kept from block %d)", prunedWhat[kind], blockNum, keptFrom),
+	}
+}
+
+// newRevertError reports a revert the way go-ethereum does: code 3, the
+// decoded Error(string) reason in the message when there is one, and the
+// raw revert data in the data field.
+func newRevertError(ret []byte) *RPCError {
+	msg := "execution reverted"
+	if reason, err := abi.UnpackRevert(ret); err == nil {
+		msg += ": " + reason
+	}
+	return &RPCError{Code: errCodeReverted, Message: msg, Data: hexutil.Encode(ret)}
+}
+
+// callError turns a failed eth_call result into its RPC error: a revert
+// error if the call reverted, the plain EVM error (code -32000) otherwise.
+// It returns nil for successful calls.
+func callError(result *corethcore.ExecutionResult) error {
+	if result.Err == nil {
+		return nil
+	}
+	if errors.Is(result.Err, vm.ErrExecutionReverted) {
+		return newRevertError(result.Revert())
+	}
+	return result.Err
+}
@@ -0,0 +1,143 @@
+package rpc
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/common/hexutil"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/profile"
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+func TestPrunedHistoryError(t *testing.T) {
+	to := common.Address{19: 0x20}
+	c := newTestChain(t, ethtypes.GenesisAlloc{testSender: {Balance: big.NewInt(1e18)}})
+	var txHashes []common.Hash
+	for nonce := range uint64(3) {
+		tx := c.signTx(t, nonce, to, big.NewInt(1), 21_000, nil)
+		c.addBlock(t, tx)
+		txHashes = append(txHashes, tx.Hash())
+	}
+	storetest.WithRW(t, c.db, func(tx *mdbx.Txn) {
+		if err := store.PruneStateHistory(tx, c.db, 0, 2); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.PruneBlockReceipts(tx, c.db, 0, 2); err != nil {
+			t.Fatal(err)
+		}
+	})
+	srv := httptest.NewServer(NewServer(c.backend(), DefaultServerConfig()).mux)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name, req string
+		pruned    bool
+	}{
+		{"balance at a pruned block", `"eth_getBalance","params":["` + to.Hex() + `","0x1"]`, true},
+		{"balance at the horizon", `"eth_getBalance","params":["` + to.Hex() + `","0x2"]`, false},
+		{"receipt in a pruned block", `"eth_getTransactionReceipt","params":["` + txHashes[0].Hex() + `"]`, true},
+		{"receipt at the horizon", `"eth_getTransactionReceipt","params":["` + txHashes[1].Hex() + `"]`, false},
+	} {
+		var resp Response
+		postTestRPC(t, srv.URL, `{"jsonrpc":"2.0","id":1,"method":`+tc.req+`}`, &resp)
+		switch {
+		case tc.pruned && (resp.Error == nil || resp.Error.Code != errCodeHistoryPruned):
+			t.Errorf("%s: %+v, want code %d", tc.name, resp.Error, errCodeHistoryPruned)
+		case !tc.pruned && (resp.Error != nil || resp.Result == nil):
+			t.Errorf("%s: %+v", tc.name, resp.Error)
+		}
+	}
+}
+
+// TestRevertError checks the geth-compatible revert error of eth_call and
+// eth_estimateGas as a client receives it: code 3, the revert data in hex
+// and, for an Error(string) revert, the reason in the message.
+func TestRevertError(t *testing.T) {
+	token, self := common.Address{19: 0x70}, common.Address{19: 0xc4}
+	c := newTestChain(t, ethtypes.GenesisAlloc{
+		testSender: {Balance: big.NewInt(1e18)},
+		token:      {Code: testTokenCode, Balance: new(big.Int)},
+		self:       {Code: callTestRevertingSelf, Balance: new(big.Int)},
+	})
+	c.addBlock(t)
+	srv := httptest.NewServer(NewServer(c.backend(), DefaultServerConfig()).mux)
+	defer srv.Close()
+
+	// transferFrom without an allowance.
+	transferFrom := tokenCall("0x23b872dd", testSender.Bytes(), common.Address{19: 0x21}.Bytes(), big.NewInt(1).Bytes())
+	reason := tokenCall("0x08c379a0", []byte{0x20}, []byte{22}, nil)
+	copy(reason[4+64:], "insufficient allowance")
+	for _, tc := range []struct {
+		name     string
+		to       common.Address
+		data     []byte
+		message  string
+		wantData string
+	}{
+		{"reason", token, transferFrom, "execution reverted: insufficient allowance", hexutil.Encode(reason)},
+		{"no data", self, []byte{1}, "execution reverted", "0x"},
+	} {
+		args, err := json.Marshal(callTestArgs(tc.to, tc.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, method := range []string{"eth_call", "eth_estimateGas"} {
+			var resp struct {
+				Error *struct {
+					Code    int    `json:"code"`
+					Message string `json:"message"`
+					Data    string `json:"data"`
+				} `json:"error"`
+			}
+			postTestRPC(t, srv.URL, `{"jsonrpc":"2.0","id":1,"method":"`+method+`","params":[`+string(args)+`,"latest"]}`, &resp)
+			if resp.Error == nil || resp.Error.Code != 3 || resp.Error.Message != tc.message || resp.Error.Data != tc.wantData {
+				t.Errorf("%s %s: error %+v, want code 3, %q, data %s", tc.name, method, resp.Error, tc.message, tc.wantData)
+			}
+		}
+	}
+}
+
+// TestNotExecutedError serves a chain whose blocks are not executed: state
+// and log queries are refused, block queries still answer.
+func TestNotExecutedError(t *testing.T) {
+	c := newTestChain(t, ethtypes.GenesisAlloc{testSender: {Balance: big.NewInt(1e18)}})
+	c.addBlock(t)
+	b := c.backend()
+	b.unexecutedVM = profile.SubnetEVM
+	srv := httptest.NewServer(NewServer(b, DefaultServerConfig()).mux)
+	defer srv.Close()
+
+	args, err := json.Marshal(callTestArgs(testSender, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		req     string
+		refused bool
+	}{
+		{`"eth_getBalance","params":["` + testSender.Hex() + `","0x0"]`, true},
+		{`"eth_getTransactionCount","params":["` + testSender.Hex() + `","latest"]`, true},
+		{`"eth_call","params":[` + string(args) + `,"latest"]`, true},
+		{`"eth_getLogs","params":[{"fromBlock":"0x0"}]`, true},
+		{`"debug_traceBlockByNumber","params":["0x1"]`, true},
+		{`"eth_getBlockByNumber","params":["0x1",false]`, false},
+		{`"eth_blockNumber","params":[]`, false},
+	} {
+		var resp Response
+		postTestRPC(t, srv.URL, `{"jsonrpc":"2.0","id":1,"method":`+tc.req+`}`, &resp)
+		switch {
+		case tc.refused && (resp.Error == nil || resp.Error.Code != errCodeNotExecuted || !strings.Contains(resp.Error.Message, "subnet-evm blocks are stored but not executed")):
+			t.Errorf("%s: %+v, want code %d", tc.req, resp.Error, errCodeNotExecuted)
+		case !tc.refused && (resp.Error != nil || resp.Result == nil):
+			t.Errorf("%s: %+v", tc.req, resp.Error)
+		}
+	}
+}
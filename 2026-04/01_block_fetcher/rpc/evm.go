@@ -1,18 +1,14 @@
 package rpc
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"math/big"
 	"runtime"
-	"time"
 
-	"github.com/ava-labs/avalanchego/genesis"
-	"github.com/ava-labs/avalanchego/upgrade"
 	corethcore "github.com/ava-labs/avalanchego/graft/coreth/core"
 	cparams "github.com/ava-labs/avalanchego/graft/coreth/params"
 	ccustomtypes "github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/customtypes"
-	avaconstants "github.com/ava-labs/avalanchego/utils/constants"
 	"github.com/ava-labs/libevm/common"
 	ethtypes "github.com/ava-labs/libevm/core/types"
 	"github.com/ava-labs/libevm/core/state"
@@ -29,51 +25,56 @@ type EVMContext struct {
 	ChainConfig *params.ChainConfig
 }
 
-// NewEVMContext initializes the chain config (same setup as the executor).
-func NewEVMContext() (*EVMContext, error) {
-	config := genesis.GetConfig(avaconstants.MainnetID)
-	var cChainGenesis corethcore.Genesis
-	if err := json.Unmarshal([]byte(config.CChainGenesis), &cChainGenesis); err != nil {
-		return nil, fmt.Errorf("parse C-Chain genesis: %w", err)
-	}
-	mainnetUpgrades := upgrade.GetConfig(avaconstants.MainnetID)
-	setUpgrades(cChainGenesis.Config, mainnetUpgrades)
-	if err := cparams.SetEthUpgrades(cChainGenesis.Config); err != nil {
-		return nil, fmt.Errorf("set eth upgrades: %w", err)
-	}
-	return &EVMContext{ChainConfig: cChainGenesis.Config}, nil
+// NewEVMContext returns an EVMContext for the chain config, which must
+// already carry the network's Avalanche upgrade times.
+func NewEVMContext(chainCfg *params.ChainConfig) *EVMContext {
+	return &EVMContext{ChainConfig: chainCfg}
+}
+
+// CallRequest is a decoded eth_call style message. Nil GasPrice and Value
+// mean zero and Gas 0 means the block gas limit.
+type CallRequest struct {
+	From       common.Address
+	To         *common.Address
+	Gas        uint64
+	GasPrice   *big.Int
+	Value      *big.Int
+	Data       []byte
+	AccessList ethtypes.AccessList
+
+	StateOverrides *statetrie.StateOverride
+	BlockOverrides *statetrie.BlockOverrides
 }
 
-// ExecuteCall runs a read-only EVM call against the state at blockNum.
-func (ec *EVMContext) ExecuteCall(
-	db *store.DB,
-	blockNum uint64,
-	from common.Address,
-	to *common.Address,
-	gas uint64,
-	gasPrice *big.Int,
-	value *big.Int,
-	data []byte,
-) ([]byte, uint64, error) {
+// ExecuteCall runs a read-only EVM call against the state at blockNum, with
+// the request's state and block overrides applied first. Like go-ethereum's
+// eth_call it skips nonce and EOA checks. A failed execution (revert, out of
+// gas) is reported in the result's Err; the returned error is for calls that
+// could not run at all, including one aborted because ctx ended. tracer may
+// be nil.
+func (ec *EVMContext) ExecuteCall(ctx context.Context, db *store.DB, blockNum uint64, req *CallRequest, tracer vm.EVMLogger) (*corethcore.ExecutionResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
 	// Read the block header for block context.
 	roTx, err := db.BeginRO()
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	raw, err := store.GetBlockByNumber(roTx, db, blockNum)
 	if err != nil {
 		roTx.Abort()
-		return nil, 0, fmt.Errorf("read block %d: %w", blockNum, err)
+		return nil, fmt.Errorf("read block %d: %w", blockNum, err)
 	}
 	raw = append([]byte(nil), raw...)
 	roTx.Abort()
 
 	ethBlock, err := parseEthBlock(raw)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	header := ethBlock.Header()
 
@@ -82,54 +83,95 @@ func (ec *EVMContext) ExecuteCall(
 
 	sdb, err := state.New(header.Root, stateDB, nil)
 	if err != nil {
-		return nil, 0, fmt.Errorf("open state at block %d root %x: %w", blockNum, header.Root, err)
+		return nil, fmt.Errorf("open state at block %d root %x: %w", blockNum, header.Root, err)
+	}
+	if err := req.StateOverrides.Apply(sdb); err != nil {
+		return nil, err
 	}
 
 	// Build block context.
 	ccustomtypes.SetHeaderExtra(header, &ccustomtypes.HeaderExtra{})
 	blockCtx := buildCallBlockContext(header, ec.ChainConfig, db)
+	req.BlockOverrides.Apply(&blockCtx)
 
 	// Build message.
+	gas := req.Gas
 	if gas == 0 {
-		gas = header.GasLimit
+		gas = blockCtx.GasLimit
 	}
-	if gasPrice == nil {
-		gasPrice = new(big.Int)
-		if header.BaseFee != nil {
-			gasPrice.Set(header.BaseFee)
-		}
+	gasPrice := new(big.Int)
+	if req.GasPrice != nil {
+		gasPrice.Set(req.GasPrice)
 	}
-	if value == nil {
-		value = new(big.Int)
+	value := new(big.Int)
+	if req.Value != nil {
+		value.Set(req.Value)
 	}
 
 	msg := &corethcore.Message{
-		From:      from,
-		To:        to,
-		Nonce:     0,
-		Value:     value,
-		GasLimit:  gas,
-		GasPrice:  gasPrice,
-		GasFeeCap: gasPrice,
-		GasTipCap: new(big.Int),
-		Data:      data,
+		From:              req.From,
+		To:                req.To,
+		Nonce:             sdb.GetNonce(req.From),
+		Value:             value,
+		GasLimit:          gas,
+		GasPrice:          gasPrice,
+		GasFeeCap:         gasPrice,
+		GasTipCap:         new(big.Int),
+		Data:              req.Data,
+		AccessList:        req.AccessList,
+		SkipAccountChecks: true,
 	}
 
 	// Prepare and execute.
-	rules := ec.ChainConfig.Rules(header.Number, cparams.IsMergeTODO, header.Time)
-	sdb.Prepare(rules, from, header.Coinbase, to,
-		vm.ActivePrecompiles(rules), nil)
+	rules := ec.ChainConfig.Rules(blockCtx.BlockNumber, cparams.IsMergeTODO, blockCtx.Time)
+	sdb.Prepare(rules, req.From, blockCtx.Coinbase, req.To,
+		vm.ActivePrecompiles(rules), req.AccessList)
 
-	evm := vm.NewEVM(blockCtx, corethcore.NewEVMTxContext(msg), sdb, ec.ChainConfig, vm.Config{NoBaseFee: true})
+	evm := vm.NewEVM(blockCtx, corethcore.NewEVMTxContext(msg), sdb, ec.ChainConfig, vm.Config{NoBaseFee: true, Tracer: tracer})
+	stop := context.AfterFunc(ctx, evm.Cancel)
+	defer stop()
 	gp := new(corethcore.GasPool).AddGas(gas)
 	result, err := corethcore.ApplyMessage(evm, msg, gp)
+	if evm.Cancelled() {
+		return nil, fmt.Errorf("execution aborted: %w", ctx.Err())
+	}
 	if err != nil {
-		return nil, 0, fmt.Errorf("apply message: %w", err)
+		return nil, fmt.Errorf("apply message: %w", err)
 	}
-	if result.Err != nil {
-		return result.ReturnData, result.UsedGas, fmt.Errorf("execution reverted: %v", result.Err)
+	return result, nil
+}
+
+// senderOverride returns the state override for the request's sender, or
+// the zero value if there is none.
+func (req *CallRequest) senderOverride() statetrie.OverrideAccount {
+	if req.StateOverrides == nil {
+		return statetrie.OverrideAccount{}
 	}
-	return result.ReturnData, result.UsedGas, nil
+	return (*req.StateOverrides)[req.From]
+}
+
+// activePrecompiles lists the precompiles active at blockNum, or at the
+// number and time given by blockOverrides.
+func (ec *EVMContext) activePrecompiles(db *store.DB, blockNum uint64, blockOverrides *statetrie.BlockOverrides) ([]common.Address, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	roTx, err := db.BeginRO()
+	if err != nil {
+		return nil, err
+	}
+	defer roTx.Abort()
+	raw, err := store.GetBlockByNumber(roTx, db, blockNum)
+	if err != nil {
+		return nil, fmt.Errorf("read block %d: %w", blockNum, err)
+	}
+	ethBlock, err := parseEthBlock(append([]byte(nil), raw...))
+	if err != nil {
+		return nil, err
+	}
+	blockCtx := vm.BlockContext{BlockNumber: ethBlock.Number(), Time: ethBlock.Time()}
+	blockOverrides.Apply(&blockCtx)
+	rules := ec.ChainConfig.Rules(blockCtx.BlockNumber, cparams.IsMergeTODO, blockCtx.Time)
+	return vm.ActivePrecompiles(rules), nil
 }
 
 func buildCallBlockContext(header *ethtypes.Header, chainCfg *params.ChainConfig, db *store.DB) vm.BlockContext {
@@ -184,21 +226,3 @@ func buildCallBlockContext(header *ethtypes.Header, chainCfg *params.ChainConfig
 		Header:      header,
 	}
 }
-
-func setUpgrades(c *params.ChainConfig, cfg upgrade.Config) {
-	extra := cparams.GetExtra(c)
-	ts := func(t time.Time) *uint64 { v := uint64(t.Unix()); return &v }
-	extra.NetworkUpgrades.ApricotPhase1BlockTimestamp = ts(cfg.ApricotPhase1Time)
-	extra.NetworkUpgrades.ApricotPhase2BlockTimestamp = ts(cfg.ApricotPhase2Time)
-	extra.NetworkUpgrades.ApricotPhase3BlockTimestamp = ts(cfg.ApricotPhase3Time)
-	extra.NetworkUpgrades.ApricotPhase4BlockTimestamp = ts(cfg.ApricotPhase4Time)
-	extra.NetworkUpgrades.ApricotPhase5BlockTimestamp = ts(cfg.ApricotPhase5Time)
-	extra.NetworkUpgrades.ApricotPhasePre6BlockTimestamp = ts(cfg.ApricotPhasePre6Time)
-	extra.NetworkUpgrades.ApricotPhase6BlockTimestamp = ts(cfg.ApricotPhase6Time)
-	extra.NetworkUpgrades.ApricotPhasePost6BlockTimestamp = ts(cfg.ApricotPhasePost6Time)
-	extra.NetworkUpgrades.BanffBlockTimestamp = ts(cfg.BanffTime)
-	extra.NetworkUpgrades.CortinaBlockTimestamp = ts(cfg.CortinaTime)
-	extra.NetworkUpgrades.DurangoBlockTimestamp = ts(cfg.DurangoTime)
-	extra.NetworkUpgrades.EtnaTimestamp = ts(cfg.EtnaTime)
-	cparams.WithExtra(c, extra)
-}
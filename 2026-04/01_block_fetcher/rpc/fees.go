@@ -0,0 +1,336 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"runtime"
+	"sort"
+	"strings"
+
+	cparams "github.com/ava-labs/avalanchego/graft/coreth/params"
+	"github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/customheader"
+	ccustomtypes "github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/customtypes"
+	"github.com/ava-labs/libevm/common/hexutil"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/chainquery"
+	"block_fetcher/store"
+)
+
+const (
+	// maxFeeHistoryBlocks caps blockCount in a single eth_feeHistory call.
+	maxFeeHistoryBlocks = 1024
+	// maxRewardPercentiles caps the rewardPercentiles list length.
+	maxRewardPercentiles = 100
+)
+
+// feeBlock is the fee-relevant slice of one stored block.
+type feeBlock struct {
+	header  *ethtypes.Header
+	txs     ethtypes.Transactions
+	gasUsed []uint64 // per tx, from stored receipts; nil if receipts missing
+}
+
+func (b *Backend) readFeeBlock(tx *mdbx.Txn, num uint64, withReceipts bool) (*feeBlock, error) {
+	raw, err := store.GetBlockByNumber(tx, b.db, num)
+	if err != nil {
+		return nil, fmt.Errorf("read block %d: %w", num, err)
+	}
+	ethBlock, err := parseEthBlock(append([]byte(nil), raw...))
+	if err != nil {
+		return nil, fmt.Errorf("parse block %d: %w", num, err)
+	}
+	fb := &feeBlock{header: ethBlock.Header(), txs: ethBlock.Transactions()}
+	if withReceipts {
+		if err := b.checkPruned(tx, store.PruneReceipts, num); err != nil {
+			return nil, err
+		}
+		receipts, err := store.ReadBlockReceipts(tx, b.db, num)
+		if err != nil {
+			return nil, fmt.Errorf("read receipts %d: %w", num, err)
+		}
+		if len(receipts) == len(fb.txs) {
+			fb.gasUsed = make([]uint64, len(receipts))
+			for i, r := range receipts {
+				fb.gasUsed[i] = r.GasUsed
+			}
+		}
+	}
+	return fb, nil
+}
+
+// FeeHistory implements eth_feeHistory: [blockCount, newestBlock, rewardPercentiles].
+func (b *Backend) FeeHistory(ctx context.Context, params []json.RawMessage) (any, error) {
+	if len(params) < 2 {
+		return nil, fmt.Errorf("missing blockCount or newestBlock parameter")
+	}
+	count, err := parseQuantity(params[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid blockCount: %w", err)
+	}
+	var newestTag string
+	if err := json.Unmarshal(params[1], &newestTag); err != nil {
+		return nil, fmt.Errorf("invalid newestBlock: %w", err)
+	}
+	var percentiles []float64
+	if len(params) > 2 && string(params[2]) != "null" {
+		if err := json.Unmarshal(params[2], &percentiles); err != nil {
+			return nil, fmt.Errorf("invalid rewardPercentiles: %w", err)
+		}
+	}
+	if len(percentiles) > maxRewardPercentiles {
+		return nil, fmt.Errorf("too many reward percentiles: %d (max %d)", len(percentiles), maxRewardPercentiles)
+	}
+	for i, p := range percentiles {
+		if p < 0 || p > 100 || (i > 0 && p < percentiles[i-1]) {
+			return nil, fmt.Errorf("invalid reward percentile: %v", p)
+		}
+	}
+	if count > maxFeeHistoryBlocks {
+		count = maxFeeHistoryBlocks
+	}
+
+	newest, err := b.resolveBlockTag(newestTag)
+	if err != nil {
+		return nil, err
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := b.db.BeginRO()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Abort()
+
+	head, _ := store.GetHeadBlock(tx, b.db)
+	if newest > head {
+		return nil, fmt.Errorf("block %d is beyond head %d", newest, head)
+	}
+	// Block 0 has no stored container.
+	if count > newest {
+		count = newest
+	}
+	if count == 0 {
+		return map[string]any{
+			"oldestBlock":   "0x0",
+			"baseFeePerGas": []string{},
+			"gasUsedRatio":  []float64{},
+		}, nil
+	}
+	oldest := newest - count + 1
+
+	baseFees := make([]string, 0, count+1)
+	ratios := make([]float64, 0, count)
+	var rewards [][]string
+	if len(percentiles) > 0 {
+		rewards = make([][]string, 0, count)
+	}
+
+	var last *feeBlock
+	for num := oldest; num <= newest; num++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fb, err := b.readFeeBlock(tx, num, len(percentiles) > 0)
+		if err != nil {
+			return nil, err
+		}
+		h := fb.header
+		baseFees = append(baseFees, encodeBigInt(h.BaseFee))
+		ratio := float64(0)
+		if h.GasLimit > 0 {
+			ratio = float64(h.GasUsed) / float64(h.GasLimit)
+		}
+		ratios = append(ratios, ratio)
+		if len(percentiles) > 0 {
+			rewards = append(rewards, blockRewards(fb, percentiles))
+		}
+		last = fb
+	}
+
+	// The list carries one extra entry: the base fee of the block after newest.
+	// Use the real one when we have it, otherwise estimate it from newest.
+	next, err := b.nextBaseFee(tx, last.header, head)
+	if err != nil {
+		return nil, err
+	}
+	baseFees = append(baseFees, encodeBigInt(next))
+
+	result := map[string]any{
+		"oldestBlock":   fmt.Sprintf("0x%x", oldest),
+		"baseFeePerGas": baseFees,
+		"gasUsedRatio":  ratios,
+	}
+	if rewards != nil {
+		result["reward"] = rewards
+	}
+	return result, nil
+}
+
+// blockRewards returns the effective tip at each gas-weighted percentile of the
+// block, following the go-ethereum algorithm.
+func blockRewards(fb *feeBlock, percentiles []float64) []string {
+	out := make([]string, len(percentiles))
+	if len(fb.txs) == 0 || fb.gasUsed == nil {
+		for i := range out {
+			out[i] = "0x0"
+		}
+		return out
+	}
+
+	type txGasAndReward struct {
+		gasUsed uint64
+		reward  *big.Int
+	}
+	sorter := make([]txGasAndReward, len(fb.txs))
+	for i, t := range fb.txs {
+		sorter[i] = txGasAndReward{gasUsed: fb.gasUsed[i], reward: effectiveTip(t, fb.header.BaseFee)}
+	}
+	sort.SliceStable(sorter, func(i, j int) bool {
+		return sorter[i].reward.Cmp(sorter[j].reward) < 0
+	})
+
+	var txIndex int
+	sumGasUsed := sorter[0].gasUsed
+	for i, p := range percentiles {
+		threshold := uint64(float64(fb.header.GasUsed) * p / 100)
+		for sumGasUsed < threshold && txIndex < len(sorter)-1 {
+			txIndex++
+			sumGasUsed += sorter[txIndex].gasUsed
+		}
+		out[i] = encodeBigInt(sorter[txIndex].reward)
+	}
+	return out
+}
+
+// effectiveTip is the tip the block producer actually received, floored at 0.
+func effectiveTip(t *ethtypes.Transaction, baseFee *big.Int) *big.Int {
+	tip, err := t.EffectiveGasTip(baseFee)
+	if err != nil || tip.Sign() < 0 {
+		return new(big.Int)
+	}
+	return tip
+}
+
+// nextBaseFee returns the base fee of the block following parent: read from
+// the DB when that block is already executed, estimated otherwise. The
+// estimate assumes the child is built at the parent's timestamp, which keeps
+// it meaningful when our head lags the network.
+func (b *Backend) nextBaseFee(tx *mdbx.Txn, parent *ethtypes.Header, head uint64) (*big.Int, error) {
+	num := parent.Number.Uint64() + 1
+	if num <= head {
+		child, err := b.readFeeBlock(tx, num, false)
+		if err != nil {
+			return nil, err
+		}
+		return child.header.BaseFee, nil
+	}
+	if parent.BaseFee == nil {
+		return nil, nil
+	}
+	cfg := cparams.GetExtra(b.evm.ChainConfig)
+	fee, err := customheader.EstimateNextBaseFee(cfg, parent, ccustomtypes.HeaderTimeMilliseconds(parent))
+	if err != nil {
+		return nil, fmt.Errorf("estimate next base fee: %w", err)
+	}
+	return fee, nil
+}
+
+// suggestTip returns chainquery.SuggestTip at head, cached until head moves.
+func (b *Backend) suggestTip(tx *mdbx.Txn, head uint64) (*big.Int, error) {
+	b.gpoMu.Lock()
+	defer b.gpoMu.Unlock()
+	if b.gpoTip != nil && b.gpoHead == head {
+		return new(big.Int).Set(b.gpoTip), nil
+	}
+
+	price, err := chainquery.SuggestTip(head, func(num uint64) (*ethtypes.Block, error) {
+		raw, err := store.GetBlockByNumber(tx, b.db, num)
+		if err != nil {
+			return nil, fmt.Errorf("read block %d: %w", num, err)
+		}
+		return parseEthBlock(append([]byte(nil), raw...))
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.gpoHead, b.gpoTip = head, price
+	return new(big.Int).Set(price), nil
+}
+
+// GasPrice implements eth_gasPrice as suggested tip + estimated next base fee.
+func (b *Backend) GasPrice() (string, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := b.db.BeginRO()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Abort()
+
+	head, ok := store.GetHeadBlock(tx, b.db)
+	if !ok || head == 0 {
+		return "", fmt.Errorf("no executed blocks")
+	}
+	tip, err := b.suggestTip(tx, head)
+	if err != nil {
+		return "", err
+	}
+	fb, err := b.readFeeBlock(tx, head, false)
+	if err != nil {
+		return "", err
+	}
+	baseFee, err := b.nextBaseFee(tx, fb.header, head)
+	if err != nil {
+		return "", err
+	}
+	if baseFee != nil {
+		tip.Add(tip, baseFee)
+	}
+	return encodeBigInt(tip), nil
+}
+
+// MaxPriorityFeePerGas implements eth_maxPriorityFeePerGas.
+func (b *Backend) MaxPriorityFeePerGas() (string, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := b.db.BeginRO()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Abort()
+
+	head, ok := store.GetHeadBlock(tx, b.db)
+	if !ok || head == 0 {
+		return "", fmt.Errorf("no executed blocks")
+	}
+	tip, err := b.suggestTip(tx, head)
+	if err != nil {
+		return "", err
+	}
+	return encodeBigInt(tip), nil
+}
+
+// parseQuantity accepts a hex quantity string or a plain JSON number.
+func parseQuantity(raw json.RawMessage) (uint64, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if strings.HasPrefix(s, "0x") {
+			return hexutil.DecodeUint64(s)
+		}
+		var n uint64
+		if _, err := fmt.Sscanf(s, "%d", &n); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+	var n uint64
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
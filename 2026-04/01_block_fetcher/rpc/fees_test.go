@@ -0,0 +1,187 @@
+package rpc
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"testing"
+
+	cparams "github.com/ava-labs/avalanchego/graft/coreth/params"
+	"github.com/ava-labs/avalanchego/vms/components/gas"
+	"github.com/ava-labs/avalanchego/vms/evm/acp176"
+	"github.com/ava-labs/libevm/common"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/rlp"
+	"github.com/ava-labs/libevm/trie"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+const gwei = 1_000_000_000
+
+// feeTestBlock is a stored block for the fee tests: its header fields and
+// its txs with the gas each used. A nil baseFee makes it a pre-London block;
+// otherwise extra defaults to an ACP-176 fee state with no excess, as
+// blocks since Fortuna carry one.
+type feeTestBlock struct {
+	baseFee *big.Int
+	extra   []byte
+	txs     []*ethtypes.Transaction
+	gasUsed []uint64
+}
+
+// newFeeTestBackend stores blocks as blocks 1..n, with their receipts, and
+// sets the head to n. The txs are unsigned: only their fee fields are read.
+func newFeeTestBackend(t *testing.T, blocks ...feeTestBlock) *Backend {
+	t.Helper()
+	db := storetest.Open(t, store.Open)
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		for i, fb := range blocks {
+			num := uint64(i + 1)
+			extra := fb.extra
+			if extra == nil && fb.baseFee != nil {
+				extra = (&acp176.State{}).Bytes()
+			}
+			header := &ethtypes.Header{
+				Number:     new(big.Int).SetUint64(num),
+				Time:       num * 2,
+				GasLimit:   200_000,
+				BaseFee:    fb.baseFee,
+				Extra:      extra,
+				Difficulty: big.NewInt(1),
+			}
+			receipts := make([]store.TxReceipt, len(fb.txs))
+			for j, ethTx := range fb.txs {
+				header.GasUsed += fb.gasUsed[j]
+				receipts[j] = store.TxReceipt{
+					TxHash:        [32]byte(ethTx.Hash()),
+					Status:        1,
+					CumulativeGas: header.GasUsed,
+					GasUsed:       fb.gasUsed[j],
+					TxType:        ethTx.Type(),
+				}
+			}
+			block := ethtypes.NewBlock(header, fb.txs, nil, nil, trie.NewStackTrie(nil))
+			raw, err := rlp.EncodeToBytes(block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.PutContainer(tx, db, block.Hash(), num, raw); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.WriteBlockReceipts(tx, db, num, receipts); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := store.SetHeadBlock(tx, db, uint64(len(blocks))); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return &Backend{db: db, evm: NewEVMContext(cparams.TestChainConfig)}
+}
+
+// dynamicFeeTx is an unsigned EIP-1559 tx with the given tip and fee caps.
+func dynamicFeeTx(nonce uint64, tipCap, feeCap int64) *ethtypes.Transaction {
+	return ethtypes.NewTx(&ethtypes.DynamicFeeTx{
+		ChainID:   cparams.TestChainConfig.ChainID,
+		Nonce:     nonce,
+		GasTipCap: big.NewInt(tipCap),
+		GasFeeCap: big.NewInt(feeCap),
+		Gas:       100_000,
+		To:        &common.Address{19: 0x20},
+	})
+}
+
+func TestFeeHistoryRewards(t *testing.T) {
+	// Sorted by effective tip the txs are 1 gwei for 21000 gas, 5 gwei
+	// (capped by its fee cap of 30 gwei) for 29000 and 10 gwei for 50000.
+	// The percentiles weigh each tip by its gas: a median by tx count would
+	// be 5 gwei, by gas it falls on the last gas unit of the 5 gwei tx.
+	b := newFeeTestBackend(t, feeTestBlock{
+		baseFee: big.NewInt(25 * gwei),
+		txs: []*ethtypes.Transaction{
+			dynamicFeeTx(0, 10*gwei, 100*gwei),
+			dynamicFeeTx(1, 1*gwei, 100*gwei),
+			dynamicFeeTx(2, 20*gwei, 30*gwei),
+		},
+		gasUsed: []uint64{50_000, 21_000, 29_000},
+	})
+	res, err := callTestMethod(t, withContext(context.Background(), b.FeeHistory), "0x1", "latest", []float64{0, 20, 21, 50, 51, 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := res.(map[string]any)
+	want := [][]string{{"0x3b9aca00", "0x3b9aca00", "0x3b9aca00", "0x12a05f200", "0x2540be400", "0x2540be400"}}
+	if !reflect.DeepEqual(got["reward"], want) {
+		t.Fatalf("reward = %v, want %v", got["reward"], want)
+	}
+	if ratios := got["gasUsedRatio"].([]float64); len(ratios) != 1 || ratios[0] != 0.5 {
+		t.Fatalf("gasUsedRatio = %v, want [0.5]", ratios)
+	}
+}
+
+func TestFeeHistoryRange(t *testing.T) {
+	// Block 3 carries an ACP-176 fee state: the base fee after it is the
+	// price that state quotes, as it is estimated at block 3's own time.
+	state := acp176.State{Gas: gas.State{Capacity: 10_000_000, Excess: 2_000_000_000}}
+	b := newFeeTestBackend(t,
+		feeTestBlock{baseFee: big.NewInt(25 * gwei)},
+		feeTestBlock{baseFee: big.NewInt(26 * gwei)},
+		feeTestBlock{baseFee: big.NewInt(27 * gwei), extra: state.Bytes()},
+	)
+	nextFee := encodeBigInt(new(big.Int).SetUint64(uint64(state.GasPrice())))
+	if nextFee == "0x1" {
+		t.Fatal("fee state quotes the minimum price")
+	}
+	for _, tc := range []struct {
+		name          string
+		count, newest string
+		oldest        string
+		baseFees      []string
+	}{
+		// More blocks than exist before newest: from block 1, as block 0 has
+		// no container. The next base fee is block 3's own.
+		{"count past genesis", "0xa", "0x2", "0x1", []string{"0x5d21dba00", "0x60db88400", "0x649534e00"}},
+		// Past head the next base fee is estimated from block 3.
+		{"next past head", "0x2", "latest", "0x2", []string{"0x60db88400", "0x649534e00", nextFee}},
+	} {
+		res, err := callTestMethod(t, withContext(context.Background(), b.FeeHistory), tc.count, tc.newest)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got := res.(map[string]any)
+		if got["oldestBlock"] != tc.oldest || !reflect.DeepEqual(got["baseFeePerGas"], tc.baseFees) {
+			t.Fatalf("%s: oldest %v, base fees %v; want %s, %v", tc.name, got["oldestBlock"], got["baseFeePerGas"], tc.oldest, tc.baseFees)
+		}
+		if _, ok := got["reward"]; ok {
+			t.Fatalf("%s: reward without percentiles", tc.name)
+		}
+	}
+
+	if _, err := callTestMethod(t, withContext(context.Background(), b.FeeHistory), "0x1", "0x4"); err == nil {
+		t.Fatal("no error for newest past head")
+	}
+}
+
+func TestFeeHistoryPreLondon(t *testing.T) {
+	// Without a base fee the base fees read as zero, including the next
+	// one, and the reward is the whole gas price.
+	legacy := ethtypes.NewTx(&ethtypes.LegacyTx{GasPrice: big.NewInt(7 * gwei), Gas: 21_000, To: &common.Address{19: 0x20}})
+	b := newFeeTestBackend(t,
+		feeTestBlock{},
+		feeTestBlock{txs: []*ethtypes.Transaction{legacy}, gasUsed: []uint64{21_000}},
+	)
+	res, err := callTestMethod(t, withContext(context.Background(), b.FeeHistory), "0x2", "latest", []float64{50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := res.(map[string]any)
+	if want := []string{"0x0", "0x0", "0x0"}; !reflect.DeepEqual(got["baseFeePerGas"], want) {
+		t.Fatalf("baseFeePerGas = %v, want %v", got["baseFeePerGas"], want)
+	}
+	if want := [][]string{{"0x0"}, {"0x1a13b8600"}}; !reflect.DeepEqual(got["reward"], want) {
+		t.Fatalf("reward = %v, want %v", got["reward"], want)
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"math/big"
 	"strings"
 
+	ethereum "github.com/ava-labs/libevm"
 	"github.com/ava-labs/libevm/common"
 	"github.com/ava-labs/libevm/common/hexutil"
 	ethtypes "github.com/ava-labs/libevm/core/types"
 
+	"block_fetcher/chainquery"
 	"block_fetcher/store"
 )
 
@@ -180,90 +182,71 @@ func encodeBigInt(n *big.Int) string {
 
 // LogFilter represents the params for eth_getLogs.
 type LogFilter struct {
-	FromBlock string           `json:"fromBlock"`
-	ToBlock   string           `json:"toBlock"`
-	Address   json.RawMessage  `json:"address"`   // string or []string
-	Topics    []json.RawMessage `json:"topics"`    // each: null, string, or []string
+	FromBlock string            `json:"fromBlock"`
+	ToBlock   string            `json:"toBlock"`
+	Address   json.RawMessage   `json:"address"` // string or []string
+	Topics    []json.RawMessage `json:"topics"`  // each: null, string, or []string
+
+	// Decoded criteria, filled lazily by parse. A nil topic position is a
+	// wildcard.
+	parsed bool
+	query  ethereum.FilterQuery
 }
 
-// matchesLog checks if a log matches the filter criteria.
-func (f *LogFilter) matchesLog(l store.LogEntry) bool {
-	// Check address filter.
+// parse decodes Address and Topics once so per-log matching stays cheap.
+func (f *LogFilter) parse() {
+	if f.parsed {
+		return
+	}
+	f.parsed = true
 	if len(f.Address) > 0 {
-		addrs := f.parseAddresses()
-		if len(addrs) > 0 {
-			found := false
-			for _, a := range addrs {
-				if a == l.Address {
-					found = true
-					break
-				}
-			}
-			if !found {
-				return false
-			}
-		}
+		f.query.Addresses = f.parseAddresses()
 	}
-
-	// Check topic filters.
-	for i, topicFilter := range f.Topics {
-		if len(topicFilter) == 0 || string(topicFilter) == "null" {
-			continue
-		}
-		if i >= len(l.Topics) {
-			return false
-		}
-		topicValues := parseTopicFilter(topicFilter)
-		if len(topicValues) == 0 {
+	f.query.Topics = make([][]common.Hash, len(f.Topics))
+	for i, raw := range f.Topics {
+		if len(raw) == 0 || string(raw) == "null" {
 			continue
 		}
-		found := false
-		for _, tv := range topicValues {
-			if tv == l.Topics[i] {
-				found = true
-				break
-			}
-		}
-		if !found {
-			return false
-		}
+		f.query.Topics[i] = parseTopicFilter(raw)
 	}
+}
 
-	return true
+// matchesLog checks if a log matches the filter criteria.
+func (f *LogFilter) matchesLog(l store.LogEntry) bool {
+	f.parse()
+	return chainquery.MatchesLog(f.query, l)
 }
 
-func (f *LogFilter) parseAddresses() [][20]byte {
+func (f *LogFilter) parseAddresses() []common.Address {
 	// Try single address.
 	var single string
 	if err := json.Unmarshal(f.Address, &single); err == nil {
-		addr := common.HexToAddress(single)
-		return [][20]byte{[20]byte(addr)}
+		return []common.Address{common.HexToAddress(single)}
 	}
 	// Try array.
 	var arr []string
 	if err := json.Unmarshal(f.Address, &arr); err == nil {
-		result := make([][20]byte, len(arr))
+		result := make([]common.Address, len(arr))
 		for i, s := range arr {
-			result[i] = [20]byte(common.HexToAddress(s))
+			result[i] = common.HexToAddress(s)
 		}
 		return result
 	}
 	return nil
 }
 
-func parseTopicFilter(raw json.RawMessage) [][32]byte {
+func parseTopicFilter(raw json.RawMessage) []common.Hash {
 	// Try single topic.
 	var single string
 	if err := json.Unmarshal(raw, &single); err == nil {
-		h := common.HexToHash(single)
-		return [][32]byte{[32]byte(h)}
+		return []common.Hash{common.HexToHash(single)}
 	}
 	// Try array.
 	var arr []string
 	if err := json.Unmarshal(raw, &arr); err == nil {
-		result := make([][32]byte, len(arr))
+		result := make([]common.Hash, len(arr))
 		for i, s := range arr {
-			result[i] = [32]byte(common.HexToHash(s))
+			result[i] = common.HexToHash(s)
 		}
 		return result
 	}
@@ -0,0 +1,264 @@
+package rpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/ava-labs/avalanchego/graft/coreth/consensus/dummy"
+	corethcore "github.com/ava-labs/avalanchego/graft/coreth/core"
+	"github.com/ava-labs/avalanchego/graft/coreth/core/extstate"
+	cparams "github.com/ava-labs/avalanchego/graft/coreth/params"
+	ccustomtypes "github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/customtypes"
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/core/state"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/core/vm"
+	"github.com/ava-labs/libevm/crypto"
+	"github.com/ava-labs/libevm/params"
+	"github.com/ava-labs/libevm/rlp"
+	"github.com/ava-labs/libevm/trie"
+	"github.com/erigontech/mdbx-go/mdbx"
+	"github.com/holiman/uint256"
+
+	"block_fetcher/atomictx"
+	"block_fetcher/statetrie"
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+func TestMain(m *testing.M) {
+	corethcore.RegisterExtras()
+	ccustomtypes.Register()
+	extstate.RegisterExtras()
+	cparams.RegisterExtras()
+	os.Exit(m.Run())
+}
+
+// callTestMethod calls the RPC method fn with JSON-encoded params.
+func callTestMethod(t *testing.T, fn func([]json.RawMessage) (any, error), params ...any) (any, error) {
+	t.Helper()
+	raw := make([]json.RawMessage, len(params))
+	for i, p := range params {
+		b, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw[i] = b
+	}
+	return fn(raw)
+}
+
+// withContext binds the context-taking RPC method fn to ctx, for
+// callTestMethod.
+func withContext(ctx context.Context, fn func(context.Context, []json.RawMessage) (any, error)) func([]json.RawMessage) (any, error) {
+	return func(params []json.RawMessage) (any, error) {
+		return fn(ctx, params)
+	}
+}
+
+var (
+	testChainCoinbase = common.Address{19: 0xcb}
+	testChainBaseFee  = big.NewInt(25_000_000_000)
+
+	// testCounterCode increments slot 0 and logs its new value, without
+	// topics.
+	testCounterCode = common.FromHex("0x6000546001018060005560005260206000a000")
+
+	testKey, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	testSender = crypto.PubkeyToAddress(testKey.PublicKey)
+)
+
+// testChain is a database holding a genesis state and blocks executed on
+// it, committed the way the executor commits them: flat and hashed state,
+// changesets, receipts, trie nodes and, block by block, the indexes.
+type testChain struct {
+	db       *store.DB
+	chainCfg *params.ChainConfig
+	root     common.Hash
+	blocks   []*ethtypes.Block
+	receipts []ethtypes.Receipts // by block, from 1
+}
+
+// newTestChain writes alloc as the genesis state of a new database.
+func newTestChain(t *testing.T, alloc ethtypes.GenesisAlloc) *testChain {
+	t.Helper()
+	c := &testChain{db: storetest.Open(t, store.Open), chainCfg: cparams.TestChainConfig}
+	// Genesis gets a database of its own: its changes are not a changeset.
+	stateDB := statetrie.NewDatabase(c.db)
+	sdb, err := state.New(ethtypes.EmptyRootHash, stateDB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, acct := range alloc {
+		if acct.Balance != nil {
+			sdb.SetBalance(addr, uint256.MustFromBig(acct.Balance))
+		}
+		sdb.SetNonce(addr, acct.Nonce)
+		sdb.SetCode(addr, acct.Code)
+		for k, v := range acct.Storage {
+			sdb.SetState(addr, k, v)
+		}
+	}
+	c.root = c.commit(t, stateDB, sdb, 0, nil)
+	storetest.WithRW(t, c.db, func(tx *mdbx.Txn) {
+		if err := store.InitIndexWatermarks(tx, c.db); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return c
+}
+
+// backend returns a Backend serving c.
+func (c *testChain) backend() *Backend {
+	return &Backend{
+		db:      c.db,
+		evm:     NewEVMContext(c.chainCfg),
+		atomic:  atomictx.NewDecoder(c.chainCfg, testAVAX),
+		chainID: c.chainCfg.ChainID,
+	}
+}
+
+// addBlock executes txs as the next block, stores it and indexes it. Every
+// tx must succeed or revert; a tx that can't be included fails the test.
+func (c *testChain) addBlock(t *testing.T, txs ...*ethtypes.Transaction) *ethtypes.Block {
+	t.Helper()
+	num := uint64(len(c.blocks) + 1)
+	header := &ethtypes.Header{
+		Number:     new(big.Int).SetUint64(num),
+		Time:       num * 2,
+		GasLimit:   15_000_000,
+		BaseFee:    testChainBaseFee,
+		Difficulty: big.NewInt(1),
+		Coinbase:   testChainCoinbase,
+	}
+	if num > 1 {
+		header.ParentHash = c.blocks[num-2].Hash()
+	}
+
+	stateDB := statetrie.NewDatabase(c.db)
+	sdb, err := state.New(c.root, stateDB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainCtx := &traceChainContext{db: c.db, engine: dummy.NewCoinbaseFaker()}
+	blockCtx := corethcore.NewEVMBlockContext(header, chainCtx, &header.Coinbase)
+	gp := new(corethcore.GasPool).AddGas(header.GasLimit)
+	var receipts ethtypes.Receipts
+	for i, tx := range txs {
+		sdb.SetTxContext(tx.Hash(), i)
+		r, err := corethcore.ApplyTransaction(c.chainCfg, chainCtx, blockCtx, gp, sdb, header, tx, &header.GasUsed, vm.Config{})
+		if err != nil {
+			t.Fatalf("block %d tx %d: %v", num, i, err)
+		}
+		receipts = append(receipts, r)
+	}
+
+	stored := make([]store.TxReceipt, len(receipts))
+	for i, r := range receipts {
+		stored[i] = store.TxReceipt{
+			TxHash:          [32]byte(r.TxHash),
+			Status:          byte(r.Status),
+			CumulativeGas:   r.CumulativeGasUsed,
+			GasUsed:         r.GasUsed,
+			TxType:          r.Type,
+			ContractAddress: [20]byte(r.ContractAddress),
+		}
+		for _, l := range r.Logs {
+			entry := store.LogEntry{Address: [20]byte(l.Address), Data: l.Data}
+			for _, topic := range l.Topics {
+				entry.Topics = append(entry.Topics, [32]byte(topic))
+			}
+			stored[i].Logs = append(stored[i].Logs, entry)
+		}
+	}
+	header.Root = c.commit(t, stateDB, sdb, num, func(overlay *statetrie.BatchOverlay) {
+		overlay.AddBlockReceipts(num, stored)
+	})
+	block := ethtypes.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil))
+
+	storetest.WithRW(t, c.db, func(tx *mdbx.Txn) {
+		raw, err := rlp.EncodeToBytes(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.PutContainer(tx, c.db, block.Hash(), num, raw); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Put(c.db.BlockHashIndex, block.Hash().Bytes(), binary.BigEndian.AppendUint64(nil, num), 0); err != nil {
+			t.Fatal(err)
+		}
+		for _, kind := range []store.IndexKind{store.IndexHistory, store.IndexLogs, store.IndexTxHashes} {
+			if err := store.BuildIndex(tx, c.db, kind, num, num); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	c.root = header.Root
+	c.blocks = append(c.blocks, block)
+	c.receipts = append(c.receipts, receipts)
+	return block
+}
+
+// signTx signs a legacy transaction from testSender at 100 gwei.
+func (c *testChain) signTx(t *testing.T, nonce uint64, to common.Address, value *big.Int, gas uint64, data []byte) *ethtypes.Transaction {
+	t.Helper()
+	return c.signTxWith(t, testKey, nonce, to, value, gas, data)
+}
+
+// signTxWith is signTx from the account of key.
+func (c *testChain) signTxWith(t *testing.T, key *ecdsa.PrivateKey, nonce uint64, to common.Address, value *big.Int, gas uint64, data []byte) *ethtypes.Transaction {
+	t.Helper()
+	tx, err := ethtypes.SignNewTx(key, ethtypes.LatestSignerForChainID(c.chainCfg.ChainID), &ethtypes.LegacyTx{
+		Nonce:    nonce,
+		To:       &to,
+		Value:    value,
+		Gas:      gas,
+		GasPrice: big.NewInt(100_000_000_000),
+		Data:     data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// commit flushes sdb as block num through an overlay, as the executor does,
+// and returns the state root. extra adds to the overlay before the flush.
+func (c *testChain) commit(t *testing.T, stateDB *statetrie.Database, sdb *state.StateDB, num uint64, extra func(*statetrie.BatchOverlay)) common.Hash {
+	t.Helper()
+	overlay := statetrie.NewBatchOverlay()
+	stateDB.Overlay = overlay
+	stateDB.CurrentBlock = num
+	sdb.Finalise(true)
+	if _, err := sdb.Commit(num, true); err != nil {
+		t.Fatal(err)
+	}
+	if num > 0 {
+		if err := stateDB.FlushChangeset(num); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if extra != nil {
+		extra(overlay)
+	}
+	var root [32]byte
+	storetest.WithRW(t, c.db, func(tx *mdbx.Txn) {
+		oldStorageRoots := statetrie.ReadOldStorageRoots(tx, c.db, overlay.ChangedAccountHashes())
+		if err := overlay.FlushStateToTx(tx, c.db); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		if root, _, err = statetrie.ComputeIncrementalStateRoot(tx, c.db, overlay, oldStorageRoots); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetHeadBlock(tx, c.db, num); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return common.Hash(root)
+}
@@ -0,0 +1,37 @@
+package rpc
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// serverMetrics instruments the JSON-RPC server. Methods the server doesn't
+// know are counted as "unknown" so clients can't grow the label set.
+type serverMetrics struct {
+	duration *prometheus.HistogramVec // by method and outcome (ok, error, timeout)
+	rejected *prometheus.CounterVec   // by reason (busy, batch_too_large)
+	inFlight prometheus.Gauge
+}
+
+func newServerMetrics(reg prometheus.Registerer) *serverMetrics {
+	m := &serverMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "block_fetcher",
+			Subsystem: "rpc",
+			Name:      "request_duration_seconds",
+			Help:      "Time to serve one JSON-RPC request, including the wait for a free slot.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"method", "outcome"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "block_fetcher",
+			Subsystem: "rpc",
+			Name:      "rejected_total",
+			Help:      "JSON-RPC requests refused before running.",
+		}, []string{"reason"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "block_fetcher",
+			Subsystem: "rpc",
+			Name:      "in_flight_requests",
+			Help:      "JSON-RPC requests currently executing.",
+		}),
+	}
+	reg.MustRegister(m.duration, m.rejected, m.inFlight)
+	return m
+}
@@ -0,0 +1,139 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"runtime"
+
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/common/hexutil"
+
+	"block_fetcher/statetrie"
+	"block_fetcher/store"
+)
+
+// maxProofStorageKeys caps the storageKeys list of a single eth_getProof call.
+const maxProofStorageKeys = 1024
+
+// GetProof implements eth_getProof: [address, storageKeys, block].
+func (b *Backend) GetProof(ctx context.Context, params []json.RawMessage) (any, error) {
+	if len(params) < 2 {
+		return nil, fmt.Errorf("missing address or storageKeys parameter")
+	}
+	var addrHex string
+	if err := json.Unmarshal(params[0], &addrHex); err != nil {
+		return nil, fmt.Errorf("invalid address: %w", err)
+	}
+	var keyHexes []string
+	if err := json.Unmarshal(params[1], &keyHexes); err != nil {
+		return nil, fmt.Errorf("invalid storageKeys: %w", err)
+	}
+	if len(keyHexes) > maxProofStorageKeys {
+		return nil, fmt.Errorf("too many storage keys: %d (max %d)", len(keyHexes), maxProofStorageKeys)
+	}
+	blockTag := "latest"
+	if len(params) > 2 {
+		json.Unmarshal(params[2], &blockTag)
+	}
+	keys := make([][32]byte, len(keyHexes))
+	for i, k := range keyHexes {
+		raw, err := hexutil.Decode(k)
+		if err != nil || len(raw) > 32 {
+			return nil, fmt.Errorf("invalid storage key %q", k)
+		}
+		keys[i] = common.BytesToHash(raw)
+	}
+
+	addr := common.HexToAddress(addrHex)
+	blockNum, err := b.resolveStateBlock(blockTag)
+	if err != nil {
+		return nil, err
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := b.db.BeginRO()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Abort()
+
+	head, ok := store.GetHeadBlock(tx, b.db)
+	if !ok || head == 0 {
+		return nil, fmt.Errorf("no executed blocks")
+	}
+	if blockNum > head {
+		return nil, fmt.Errorf("block %d is beyond head %d", blockNum, head)
+	}
+	// Genesis has no stored container, so there is no header root to check.
+	if blockNum == 0 {
+		return nil, fmt.Errorf("proofs are not available for genesis")
+	}
+	raw, err := store.GetBlockByNumber(tx, b.db, blockNum)
+	if err != nil {
+		return nil, fmt.Errorf("read block %d: %w", blockNum, err)
+	}
+	ethBlock, err := parseEthBlock(append([]byte(nil), raw...))
+	if err != nil {
+		return nil, err
+	}
+
+	// The rebuild itself can't be interrupted; don't start it or answer
+	// after the deadline.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	proof, err := statetrie.ProveAccount(tx, b.db, blockNum, [20]byte(addr), keys)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if proof.StateRoot != ethBlock.Root() {
+		return nil, fmt.Errorf("rebuilt state root %x does not match block %d stateRoot %x", proof.StateRoot, blockNum, ethBlock.Root())
+	}
+
+	// Missing accounts report zero hashes, matching go-ethereum.
+	var (
+		nonce       uint64
+		balance     = new(big.Int)
+		codeHash    common.Hash
+		storageHash common.Hash
+	)
+	if acct := proof.Account; acct != nil {
+		nonce = acct.Nonce
+		balance.SetBytes(acct.Balance[:])
+		codeHash = acct.CodeHash
+		storageHash = acct.StorageRoot
+	}
+
+	storageProofs := make([]map[string]any, len(keys))
+	for i := range keys {
+		storageProofs[i] = map[string]any{
+			"key":   keyHexes[i],
+			"value": encodeBigInt(new(big.Int).SetBytes(proof.StorageValues[i][:])),
+			"proof": encodeProof(proof.StorageProofs[i]),
+		}
+	}
+
+	return map[string]any{
+		"address":      addr,
+		"accountProof": encodeProof(proof.AccountProof),
+		"balance":      encodeBigInt(balance),
+		"codeHash":     codeHash,
+		"nonce":        hexutil.EncodeUint64(nonce),
+		"storageHash":  storageHash,
+		"storageProof": storageProofs,
+	}, nil
+}
+
+func encodeProof(nodes [][]byte) []string {
+	out := make([]string, len(nodes))
+	for i, n := range nodes {
+		out[i] = hexutil.Encode(n)
+	}
+	return out
+}
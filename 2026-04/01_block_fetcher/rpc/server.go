@@ -3,11 +3,19 @@
 package rpc
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Request is a JSON-RPC 2.0 request.
@@ -26,24 +34,86 @@ type Response struct {
 	ID      json.RawMessage `json:"id"`
 }
 
-// RPCError is a JSON-RPC 2.0 error.
+// RPCError is a JSON-RPC 2.0 error. Backend methods may return one to set
+// the code and data; any other error is reported with code -32000.
 type RPCError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// Error codes for requests the server refuses to run.
+const (
+	errCodeInvalidRequest = -32600
+	errCodeLimitExceeded  = -32005
+)
+
+// ServerConfig bounds the work the server takes on.
+type ServerConfig struct {
+	// MaxBatchSize is the most entries a batch request may have.
+	MaxBatchSize int
+	// BatchWorkers is how many entries of one batch run in parallel.
+	BatchWorkers int
+	// MaxConcurrent caps requests executing at once across all connections.
+	// Requests over the cap wait for a slot until their timeout runs out.
+	MaxConcurrent int
+	// Timeout applies to methods without an entry in MethodTimeouts.
+	Timeout time.Duration
+	// MethodTimeouts overrides Timeout per method.
+	MethodTimeouts map[string]time.Duration
+	// Registry receives the server's metrics, which are also served on
+	// /metrics. Nil means a private registry.
+	Registry *prometheus.Registry
+}
+
+// DefaultServerConfig returns the limits the server runs with unless told
+// otherwise. EVM methods get go-ethereum's 5s eth_call timeout.
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		MaxBatchSize:  100,
+		BatchWorkers:  4,
+		MaxConcurrent: 2 * runtime.NumCPU(),
+		Timeout:       30 * time.Second,
+		MethodTimeouts: map[string]time.Duration{
+			"eth_call":             5 * time.Second,
+			"eth_estimateGas":      5 * time.Second,
+			"eth_createAccessList": 5 * time.Second,
+		},
+	}
 }
 
 // Server is the JSON-RPC server.
 type Server struct {
 	backend *Backend
+	subs    *subscriptionHub
 	mux     *http.ServeMux
+
+	cfg     ServerConfig
+	slots   chan struct{} // one token per executing request
+	metrics *serverMetrics
 }
 
 // NewServer creates a new RPC server backed by the given backend.
-func NewServer(backend *Backend) *Server {
-	s := &Server{backend: backend}
+func NewServer(backend *Backend, cfg ServerConfig) *Server {
+	if cfg.Registry == nil {
+		cfg.Registry = prometheus.NewRegistry()
+	}
+	s := &Server{
+		backend: backend,
+		subs:    newSubscriptionHub(backend.db),
+		cfg:     cfg,
+		slots:   make(chan struct{}, max(cfg.MaxConcurrent, 1)),
+		metrics: newServerMetrics(cfg.Registry),
+	}
 	s.mux = http.NewServeMux()
 	// Match avalanchego's C-Chain RPC path.
 	s.mux.HandleFunc("/ext/bc/C/rpc", s.handleRPC)
+	s.mux.HandleFunc("/ext/bc/C/ws", s.handleWS)
+	s.mux.Handle("/metrics", promhttp.HandlerFor(cfg.Registry, promhttp.HandlerOpts{}))
 	// Also serve on root for convenience.
 	s.mux.HandleFunc("/", s.handleRPC)
 	return s
@@ -70,12 +140,8 @@ func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 	// Try batch request first.
 	var batch []Request
 	if err := json.Unmarshal(body, &batch); err == nil && len(batch) > 0 {
-		responses := make([]Response, len(batch))
-		for i, req := range batch {
-			responses[i] = s.dispatch(req)
-		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(responses)
+		json.NewEncoder(w).Encode(s.serveBatch(r.Context(), batch, s.serve))
 		return
 	}
 
@@ -91,12 +157,89 @@ func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := s.dispatch(req)
+	resp := s.serve(r.Context(), req)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-func (s *Server) dispatch(req Request) Response {
+// serveBatch runs the entries of a batch on up to BatchWorkers goroutines
+// and returns their responses in order, or a single error response if the
+// batch is over MaxBatchSize.
+func (s *Server) serveBatch(ctx context.Context, batch []Request, serve func(context.Context, Request) Response) any {
+	if len(batch) > s.cfg.MaxBatchSize {
+		s.metrics.rejected.WithLabelValues("batch_too_large").Inc()
+		return Response{
+			JSONRPC: "2.0",
+			Error: &RPCError{
+				Code:    errCodeInvalidRequest,
+				Message: fmt.Sprintf("batch too large: %d entries (max %d)", len(batch), s.cfg.MaxBatchSize),
+			},
+		}
+	}
+
+	responses := make([]Response, len(batch))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(s.cfg.BatchWorkers, 1), len(batch)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				responses[i] = serve(ctx, batch[i])
+			}
+		}()
+	}
+	for i := range batch {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return responses
+}
+
+// serve runs one request under its method's timeout once a concurrency slot
+// is free, and records how long it took.
+func (s *Server) serve(ctx context.Context, req Request) Response {
+	start := time.Now()
+	timeout := s.cfg.Timeout
+	if t, ok := s.cfg.MethodTimeouts[req.Method]; ok {
+		timeout = t
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		s.metrics.rejected.WithLabelValues("busy").Inc()
+		return Response{
+			JSONRPC: "2.0",
+			Error:   &RPCError{Code: errCodeLimitExceeded, Message: "server busy, try again later"},
+			ID:      req.ID,
+		}
+	}
+	s.metrics.inFlight.Inc()
+	resp := s.dispatch(ctx, req)
+	s.metrics.inFlight.Dec()
+	<-s.slots
+
+	method, outcome := req.Method, "ok"
+	switch {
+	case resp.Error != nil && resp.Error.Code == -32601:
+		method, outcome = "unknown", "error"
+	case resp.Error != nil && ctx.Err() == context.DeadlineExceeded:
+		outcome = "timeout"
+	case resp.Error != nil:
+		outcome = "error"
+	}
+	s.metrics.duration.WithLabelValues(method, outcome).Observe(time.Since(start).Seconds())
+	return resp
+}
+
+func (s *Server) dispatch(ctx context.Context, req Request) Response {
 	var result any
 	var err error
 
@@ -104,9 +247,9 @@ func (s *Server) dispatch(req Request) Response {
 	case "eth_blockNumber":
 		result, err = s.backend.BlockNumber()
 	case "eth_chainId":
-		result = "0xa86a" // 43114 = Avalanche C-Chain mainnet
+		result = s.backend.ChainID()
 	case "net_version":
-		result = "43114"
+		result = s.backend.NetVersion()
 	case "web3_clientVersion":
 		result = "block_fetcher/0.1.0"
 	case "eth_getBlockByNumber":
@@ -119,22 +262,42 @@ func (s *Server) dispatch(req Request) Response {
 		result, err = s.backend.GetTransactionReceipt(req.Params)
 	case "eth_getBalance":
 		result, err = s.backend.GetBalance(req.Params)
+	case "eth_getAssetBalance":
+		result, err = s.backend.GetAssetBalance(req.Params)
 	case "eth_getStorageAt":
 		result, err = s.backend.GetStorageAt(req.Params)
+	case "eth_getProof":
+		result, err = s.backend.GetProof(ctx, req.Params)
 	case "eth_getCode":
 		result, err = s.backend.GetCode(req.Params)
 	case "eth_getTransactionCount":
 		result, err = s.backend.GetTransactionCount(req.Params)
 	case "eth_getLogs":
-		result, err = s.backend.GetLogs(req.Params)
+		result, err = s.backend.GetLogs(ctx, req.Params)
 	case "eth_call":
-		result, err = s.backend.Call(req.Params)
+		result, err = s.backend.Call(ctx, req.Params)
 	case "eth_estimateGas":
-		result, err = s.backend.EstimateGas(req.Params)
+		result, err = s.backend.EstimateGas(ctx, req.Params)
+	case "eth_createAccessList":
+		result, err = s.backend.CreateAccessList(ctx, req.Params)
+	case "eth_simulateV1":
+		result, err = s.backend.Simulate(ctx, req.Params)
 	case "eth_gasPrice":
 		result, err = s.backend.GasPrice()
+	case "eth_maxPriorityFeePerGas":
+		result, err = s.backend.MaxPriorityFeePerGas()
 	case "eth_feeHistory":
-		result, err = s.backend.FeeHistory(req.Params)
+		result, err = s.backend.FeeHistory(ctx, req.Params)
+	case "debug_traceTransaction":
+		result, err = s.backend.TraceTransaction(ctx, req.Params)
+	case "debug_traceBlockByNumber":
+		result, err = s.backend.TraceBlockByNumber(ctx, req.Params)
+	case "debug_getStateDiff":
+		result, err = s.backend.GetStateDiff(ctx, req.Params)
+	case "avax_getAtomicTxsByBlock":
+		result, err = s.backend.GetAtomicTxsByBlock(req.Params)
+	case "avax_getAtomicTxsByAddress":
+		result, err = s.backend.GetAtomicTxsByAddress(ctx, req.Params)
 	default:
 		return Response{
 			JSONRPC: "2.0",
@@ -144,9 +307,11 @@ func (s *Server) dispatch(req Request) Response {
 	}
 
 	if err != nil {
+		rpcErr := &RPCError{Code: -32000, Message: err.Error()}
+		errors.As(err, &rpcErr)
 		return Response{
 			JSONRPC: "2.0",
-			Error:   &RPCError{Code: -32000, Message: err.Error()},
+			Error:   rpcErr,
 			ID:      req.ID,
 		}
 	}
@@ -0,0 +1,95 @@
+package rpc
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/libevm/common"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+)
+
+// postTestRPC posts body to the server at url and decodes the response.
+func postTestRPC(t *testing.T, url, body string, out any) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerBatchTooLarge(t *testing.T) {
+	cfg := DefaultServerConfig()
+	cfg.MaxBatchSize = 2
+	srv := httptest.NewServer(NewServer(newTestChain(t, nil).backend(), cfg).mux)
+	defer srv.Close()
+
+	entry := `{"jsonrpc":"2.0","method":"web3_clientVersion","id":1}`
+	var ok []Response
+	postTestRPC(t, srv.URL, "["+entry+","+entry+"]", &ok)
+	if len(ok) != 2 || ok[0].Error != nil || ok[1].Error != nil {
+		t.Fatalf("batch of 2: %+v", ok)
+	}
+	var rejected Response
+	postTestRPC(t, srv.URL, "["+entry+","+entry+","+entry+"]", &rejected)
+	if rejected.Error == nil || rejected.Error.Code != errCodeInvalidRequest {
+		t.Fatalf("batch of 3: %+v", rejected)
+	}
+}
+
+func TestServerBusy(t *testing.T) {
+	cfg := DefaultServerConfig()
+	cfg.MaxConcurrent = 1
+	cfg.Timeout = 20 * time.Millisecond
+	s := NewServer(newTestChain(t, nil).backend(), cfg)
+	srv := httptest.NewServer(s.mux)
+	defer srv.Close()
+
+	const req = `{"jsonrpc":"2.0","method":"web3_clientVersion","id":1}`
+	// Hold the only slot, as a long request would.
+	s.slots <- struct{}{}
+	var resp Response
+	postTestRPC(t, srv.URL, req, &resp)
+	if resp.Error == nil || resp.Error.Code != errCodeLimitExceeded {
+		t.Fatalf("request with no free slot: %+v", resp)
+	}
+	<-s.slots
+	resp = Response{}
+	postTestRPC(t, srv.URL, req, &resp)
+	if resp.Error != nil {
+		t.Fatalf("request with a free slot: %+v", resp.Error)
+	}
+}
+
+func TestServerCallTimeout(t *testing.T) {
+	// JUMPDEST; PUSH1 0; JUMP: loops until the gas runs out.
+	looper := common.Address{19: 0xff}
+	c := newTestChain(t, ethtypes.GenesisAlloc{
+		looper: {Code: common.FromHex("0x5b600056"), Balance: new(big.Int)},
+	})
+	c.addBlock(t)
+
+	cfg := DefaultServerConfig()
+	cfg.MethodTimeouts["eth_call"] = 50 * time.Millisecond
+	srv := httptest.NewServer(NewServer(c.backend(), cfg).mux)
+	defer srv.Close()
+
+	// A billion gas takes seconds to burn.
+	start := time.Now()
+	var resp Response
+	postTestRPC(t, srv.URL, `{"jsonrpc":"2.0","method":"eth_call","params":[{"to":"`+looper.Hex()+`","gas":"0x3b9aca00"},"latest"],"id":1}`, &resp)
+	if resp.Error == nil || !strings.Contains(resp.Error.Message, "execution aborted") {
+		t.Fatalf("looping call: %+v", resp)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("looping call returned after %s, timeout 50ms", elapsed)
+	}
+}
@@ -0,0 +1,300 @@
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"runtime"
+
+	corethcore "github.com/ava-labs/avalanchego/graft/coreth/core"
+	cparams "github.com/ava-labs/avalanchego/graft/coreth/params"
+	ccustomtypes "github.com/ava-labs/avalanchego/graft/coreth/plugin/evm/customtypes"
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/common/hexutil"
+	"github.com/ava-labs/libevm/core/state"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/core/vm"
+	"github.com/ava-labs/libevm/crypto"
+
+	"block_fetcher/statetrie"
+	"block_fetcher/store"
+)
+
+// Simulation limits, matching go-ethereum's eth_simulateV1 defaults where it
+// has one.
+const (
+	maxSimulateBlocks = 256
+	maxSimulateCalls  = 1000
+)
+
+// SimBlock is one entry of eth_simulateV1's blockStateCalls.
+type SimBlock struct {
+	BlockOverrides *statetrie.BlockOverrides `json:"blockOverrides"`
+	StateOverrides *statetrie.StateOverride  `json:"stateOverrides"`
+	Calls          []CallArgs                `json:"calls"`
+}
+
+// SimOpts is the first eth_simulateV1 param. With Validation set, calls pay
+// the base fee and must carry the sender's next nonce, as real transactions
+// would; without it both checks are skipped, like eth_call.
+type SimOpts struct {
+	BlockStateCalls []SimBlock `json:"blockStateCalls"`
+	Validation      bool       `json:"validation"`
+}
+
+// Simulate implements eth_simulateV1: [opts, block].
+func (b *Backend) Simulate(ctx context.Context, params []json.RawMessage) (any, error) {
+	if len(params) < 1 {
+		return nil, fmt.Errorf("missing simulation options")
+	}
+	var opts SimOpts
+	if err := json.Unmarshal(params[0], &opts); err != nil {
+		return nil, fmt.Errorf("invalid simulation options: %w", err)
+	}
+	if len(opts.BlockStateCalls) == 0 {
+		return nil, fmt.Errorf("empty blockStateCalls")
+	}
+	if len(opts.BlockStateCalls) > maxSimulateBlocks {
+		return nil, fmt.Errorf("too many blocks: %d (max %d)", len(opts.BlockStateCalls), maxSimulateBlocks)
+	}
+	calls := 0
+	for _, blk := range opts.BlockStateCalls {
+		calls += len(blk.Calls)
+	}
+	if calls > maxSimulateCalls {
+		return nil, fmt.Errorf("too many calls: %d (max %d)", calls, maxSimulateCalls)
+	}
+	blockTag := "latest"
+	if len(params) > 1 {
+		json.Unmarshal(params[1], &blockTag)
+	}
+	blockNum, err := b.resolveStateBlock(blockTag)
+	if err != nil {
+		return nil, err
+	}
+	return b.evm.Simulate(ctx, b.db, blockNum, &opts)
+}
+
+// Simulate runs opts' blocks of calls in sequence on top of the state after
+// blockNum. All blocks share one StateDB, so every call sees the writes of the
+// calls before it; a reverted call only rolls back its own changes. The run
+// is aborted when ctx ends.
+func (ec *EVMContext) Simulate(ctx context.Context, db *store.DB, blockNum uint64, opts *SimOpts) ([]map[string]any, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	roTx, err := db.BeginRO()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := store.GetBlockByNumber(roTx, db, blockNum)
+	if err != nil {
+		roTx.Abort()
+		return nil, fmt.Errorf("read block %d: %w", blockNum, err)
+	}
+	raw = append([]byte(nil), raw...)
+	roTx.Abort()
+
+	ethBlock, err := parseEthBlock(raw)
+	if err != nil {
+		return nil, err
+	}
+	parent := ethBlock.Header()
+
+	sdb, err := state.New(parent.Root, statetrie.NewHistoricalDatabase(db, blockNum), nil)
+	if err != nil {
+		return nil, fmt.Errorf("open state at block %d root %x: %w", blockNum, parent.Root, err)
+	}
+
+	// BLOCKHASH of a simulated block resolves to its simulated hash.
+	simHashes := make(map[uint64]common.Hash)
+	results := make([]map[string]any, 0, len(opts.BlockStateCalls))
+	for bi, blk := range opts.BlockStateCalls {
+		header := &ethtypes.Header{
+			ParentHash: parent.Hash(),
+			Coinbase:   parent.Coinbase,
+			Difficulty: new(big.Int),
+			Number:     new(big.Int).Add(parent.Number, common.Big1),
+			GasLimit:   parent.GasLimit,
+			Time:       parent.Time + 1,
+			BaseFee:    parent.BaseFee,
+		}
+		ccustomtypes.SetHeaderExtra(header, &ccustomtypes.HeaderExtra{})
+		blockCtx := buildCallBlockContext(header, ec.ChainConfig, db)
+		getHash := blockCtx.GetHash
+		blockCtx.GetHash = func(n uint64) common.Hash {
+			if h, ok := simHashes[n]; ok {
+				return h
+			}
+			return getHash(n)
+		}
+		blk.BlockOverrides.Apply(&blockCtx)
+		if blockCtx.BlockNumber.Cmp(parent.Number) <= 0 {
+			return nil, fmt.Errorf("block %d: number %s is not above parent %s", bi, blockCtx.BlockNumber, parent.Number)
+		}
+		if blockCtx.Time <= parent.Time {
+			return nil, fmt.Errorf("block %d: timestamp %d is not above parent %d", bi, blockCtx.Time, parent.Time)
+		}
+		header = blockCtx.Header
+		header.Difficulty = blockCtx.Difficulty
+
+		if err := blk.StateOverrides.Apply(sdb); err != nil {
+			return nil, fmt.Errorf("block %d: %w", bi, err)
+		}
+
+		rules := ec.ChainConfig.Rules(blockCtx.BlockNumber, cparams.IsMergeTODO, blockCtx.Time)
+		gp := new(corethcore.GasPool).AddGas(blockCtx.GasLimit)
+		var (
+			gasUsed  uint64
+			txHashes = make([]common.Hash, len(blk.Calls))
+			callRes  = make([]map[string]any, len(blk.Calls))
+		)
+		for i := range blk.Calls {
+			msg, err := blk.Calls[i].simMessage(sdb, blockCtx, gp.Gas(), opts.Validation)
+			if err != nil {
+				return nil, fmt.Errorf("block %d call %d: %w", bi, i, err)
+			}
+			txHashes[i] = simTxHash(header.Number.Uint64(), i)
+			sdb.SetTxContext(txHashes[i], i)
+			sdb.Prepare(rules, msg.From, blockCtx.Coinbase, msg.To, vm.ActivePrecompiles(rules), msg.AccessList)
+
+			evm := vm.NewEVM(blockCtx, corethcore.NewEVMTxContext(msg), sdb, ec.ChainConfig, vm.Config{NoBaseFee: !opts.Validation})
+			stop := context.AfterFunc(ctx, evm.Cancel)
+			result, err := corethcore.ApplyMessage(evm, msg, gp)
+			stop()
+			if evm.Cancelled() {
+				return nil, fmt.Errorf("execution aborted: %w", ctx.Err())
+			}
+			if err != nil {
+				return nil, fmt.Errorf("block %d call %d: %w", bi, i, err)
+			}
+			sdb.Finalise(true)
+			gasUsed += result.UsedGas
+
+			res := map[string]any{
+				"returnData": hexutil.Encode(result.ReturnData),
+				"gasUsed":    hexutil.EncodeUint64(result.UsedGas),
+				"status":     "0x1",
+			}
+			if result.Err != nil {
+				res["status"] = "0x0"
+				res["error"] = simCallError(result.Err, result.ReturnData)
+			}
+			callRes[i] = res
+		}
+
+		// The hash covers the final gasUsed, so logs are formatted afterwards.
+		header.GasUsed = gasUsed
+		hash := header.Hash()
+		simHashes[header.Number.Uint64()] = hash
+		logIndex := uint(0)
+		for i, res := range callRes {
+			logs := sdb.GetLogs(txHashes[i], header.Number.Uint64(), hash)
+			out := make([]map[string]any, len(logs))
+			for j, l := range logs {
+				out[j] = formatSimLog(l, logIndex)
+				logIndex++
+			}
+			res["logs"] = out
+		}
+
+		results = append(results, map[string]any{
+			"number":        encodeBigInt(header.Number),
+			"hash":          hash,
+			"parentHash":    header.ParentHash,
+			"timestamp":     hexutil.EncodeUint64(header.Time),
+			"gasLimit":      hexutil.EncodeUint64(header.GasLimit),
+			"gasUsed":       hexutil.EncodeUint64(header.GasUsed),
+			"miner":         addrHex(header.Coinbase),
+			"baseFeePerGas": encodeBigInt(header.BaseFee),
+			"calls":         callRes,
+		})
+		parent = header
+	}
+	return results, nil
+}
+
+// simMessage builds the message for one simulated call. Gas defaults to what
+// is left in the block, the gas price to zero (or the base fee when
+// validating), and the nonce to the sender's current one.
+func (a *CallArgs) simMessage(sdb *state.StateDB, blockCtx vm.BlockContext, gasLeft uint64, validation bool) (*corethcore.Message, error) {
+	req, err := a.request()
+	if err != nil {
+		return nil, err
+	}
+	gas := gasLeft
+	if req.Gas != 0 {
+		if req.Gas > gasLeft {
+			return nil, fmt.Errorf("gas %d exceeds remaining block gas %d", req.Gas, gasLeft)
+		}
+		gas = req.Gas
+	}
+	gasPrice := new(big.Int)
+	if req.GasPrice != nil {
+		gasPrice = req.GasPrice
+	} else if validation && blockCtx.BaseFee != nil {
+		gasPrice.Set(blockCtx.BaseFee)
+	}
+	value := new(big.Int)
+	if req.Value != nil {
+		value = req.Value
+	}
+	nonce := sdb.GetNonce(req.From)
+	if a.Nonce != nil {
+		if nonce, err = hexutil.DecodeUint64(*a.Nonce); err != nil {
+			return nil, fmt.Errorf("invalid nonce: %w", err)
+		}
+	}
+	return &corethcore.Message{
+		From:              req.From,
+		To:                req.To,
+		Nonce:             nonce,
+		Value:             value,
+		GasLimit:          gas,
+		GasPrice:          gasPrice,
+		GasFeeCap:         gasPrice,
+		GasTipCap:         new(big.Int),
+		Data:              req.Data,
+		AccessList:        req.AccessList,
+		SkipAccountChecks: !validation,
+	}, nil
+}
+
+// simTxHash names a simulated call so its logs can be collected. There is no
+// real transaction behind it.
+func simTxHash(blockNum uint64, index int) common.Hash {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], blockNum)
+	binary.BigEndian.PutUint64(buf[8:], uint64(index))
+	return crypto.Keccak256Hash(buf[:])
+}
+
+// simCallError is the per-call error object of a failed simulated call:
+// the revert error for reverts, code -32015 for any other EVM error.
+func simCallError(vmErr error, ret []byte) *RPCError {
+	if errors.Is(vmErr, vm.ErrExecutionReverted) {
+		return newRevertError(ret)
+	}
+	return &RPCError{Code: errCodeVMError, Message: vmErr.Error()}
+}
+
+func formatSimLog(l *ethtypes.Log, logIndex uint) map[string]any {
+	topics := make([]string, len(l.Topics))
+	for i, t := range l.Topics {
+		topics[i] = t.Hex()
+	}
+	return map[string]any{
+		"address":          addrHex(l.Address),
+		"topics":           topics,
+		"data":             hexutil.Encode(l.Data),
+		"blockNumber":      hexutil.EncodeUint64(l.BlockNumber),
+		"blockHash":        l.BlockHash.Hex(),
+		"transactionHash":  l.TxHash.Hex(),
+		"transactionIndex": hexutil.EncodeUint64(uint64(l.TxIndex)),
+		"logIndex":         hexutil.EncodeUint64(uint64(logIndex)),
+		"removed":          false,
+	}
+}
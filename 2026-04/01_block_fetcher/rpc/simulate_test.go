@@ -0,0 +1,137 @@
+package rpc
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/common/hexutil"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/crypto"
+)
+
+// testTokenCode is a minimal ERC-20 without checks on its inputs: balances
+// are kept at the owner's address as slot, allowances at
+// keccak256(owner, spender). It has approve, transferFrom and balanceOf,
+// emits Approval and Transfer, and reverts transferFrom with
+// Error("insufficient allowance") or Error("insufficient balance").
+var testTokenCode = common.FromHex("0x60003560e01c8063095ea7b31461002b57806323b872dd1461007957806370a08231146100f157600080fd5b33600052600435602052602435604060002055602435604052600435337f8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b92560206040a3600160005260206000f35b60043560005233602052604060002080546044358181116100fe5790039055600435805460443581811161015657900390556044356024358054820190556000526024356004357fddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef60206000a3600160005260206000f35b6004355460005260206000f35b7f08c379a000000000000000000000000000000000000000000000000000000000600052602060045260166024527f696e73756666696369656e7420616c6c6f77616e63650000000000000000000060445260646000fd5b7f08c379a000000000000000000000000000000000000000000000000000000000600052602060045260146024527f696e73756666696369656e742062616c616e636500000000000000000000000060445260646000fd")
+
+var (
+	transferTopic = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+	approvalTopic = common.HexToHash("0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925")
+)
+
+// tokenCall encodes a call of the function with selector sel on words.
+func tokenCall(sel string, words ...[]byte) []byte {
+	data := common.FromHex(sel)
+	for _, w := range words {
+		data = append(data, common.LeftPadBytes(w, 32)...)
+	}
+	return data
+}
+
+// TestSimulate approves a spender and has it call transferFrom twice in one
+// simulated block, the second time over the allowance that is left. The
+// same calls are then executed as a real block, whose receipts give the gas
+// and logs each simulated call must report.
+func TestSimulate(t *testing.T) {
+	token, recipient := common.Address{19: 0x70}, common.Address{19: 0x21}
+	spenderKey, _ := crypto.HexToECDSA("8a1f9a8f95be41cd7ccb6168179afb4504aefe388d1e14474d32c45c72ce7b7a")
+	spender := crypto.PubkeyToAddress(spenderKey.PublicKey)
+	c := newTestChain(t, ethtypes.GenesisAlloc{
+		testSender: {Balance: big.NewInt(1e18)},
+		spender:    {Balance: big.NewInt(1e18)},
+		token: {
+			Code:    testTokenCode,
+			Balance: new(big.Int),
+			Storage: map[common.Hash]common.Hash{common.BytesToHash(testSender.Bytes()): common.BigToHash(big.NewInt(1000))},
+		},
+	})
+	c.addBlock(t)
+
+	amount := func(n int64) []byte { return big.NewInt(n).Bytes() }
+	calls := []struct {
+		bySpender bool
+		data      []byte
+	}{
+		{false, tokenCall("0x095ea7b3", spender.Bytes(), amount(300))},
+		{true, tokenCall("0x23b872dd", testSender.Bytes(), recipient.Bytes(), amount(200))},
+		{true, tokenCall("0x23b872dd", testSender.Bytes(), recipient.Bytes(), amount(200))},
+		{false, tokenCall("0x70a08231", recipient.Bytes())},
+	}
+	simCalls := make([]map[string]any, len(calls))
+	txs := make([]*ethtypes.Transaction, len(calls))
+	nonces := make(map[bool]uint64)
+	for i, call := range calls {
+		from, key := testSender, testKey
+		if call.bySpender {
+			from, key = spender, spenderKey
+		}
+		simCalls[i] = map[string]any{"from": from.Hex(), "to": token.Hex(), "data": hexutil.Encode(call.data)}
+		txs[i] = c.signTxWith(t, key, nonces[call.bySpender], token, new(big.Int), 100_000, call.data)
+		nonces[call.bySpender]++
+	}
+
+	res, err := callTestMethod(t, withContext(context.Background(), c.backend().Simulate), map[string]any{
+		"blockStateCalls": []map[string]any{{"calls": simCalls}},
+	}, "0x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := res.([]map[string]any)
+	if len(blocks) != 1 || blocks[0]["number"] != "0x2" {
+		t.Fatalf("blocks %v, want one numbered 0x2", blocks)
+	}
+	results := blocks[0]["calls"].([]map[string]any)
+
+	// The spender can't move more than the 100 left of its allowance.
+	revertData := tokenCall("0x08c379a0", []byte{0x20}, []byte{22}, nil)
+	copy(revertData[4+64:], "insufficient allowance")
+	wantErr := &RPCError{Code: errCodeReverted, Message: "execution reverted: insufficient allowance", Data: hexutil.Encode(revertData)}
+	if got, _ := results[2]["error"].(*RPCError); results[2]["status"] != "0x0" || !reflect.DeepEqual(got, wantErr) {
+		t.Fatalf("call 2: status %v, error %+v; want 0x0, %+v", results[2]["status"], results[2]["error"], wantErr)
+	}
+	if got := results[3]["returnData"]; got != hexutil.Encode(common.LeftPadBytes(amount(200), 32)) {
+		t.Fatalf("balanceOf recipient = %v, want 200", got)
+	}
+	wantTopics := [][]common.Hash{
+		{approvalTopic, common.BytesToHash(testSender.Bytes()), common.BytesToHash(spender.Bytes())},
+		{transferTopic, common.BytesToHash(testSender.Bytes()), common.BytesToHash(recipient.Bytes())},
+		nil,
+		nil,
+	}
+
+	block := c.addBlock(t, txs...)
+	receipts := c.receipts[len(c.receipts)-1]
+	if got := blocks[0]["gasUsed"]; got != hexutil.EncodeUint64(block.GasUsed()) {
+		t.Fatalf("block gasUsed %v, want %d", got, block.GasUsed())
+	}
+	logIndex := 0
+	for i, r := range results {
+		receipt := receipts[i]
+		if r["status"] != hexutil.EncodeUint64(receipt.Status) || r["gasUsed"] != hexutil.EncodeUint64(receipt.GasUsed) {
+			t.Errorf("call %d: status %v, gasUsed %v; the tx has %d, %d", i, r["status"], r["gasUsed"], receipt.Status, receipt.GasUsed)
+		}
+		logs := r["logs"].([]map[string]any)
+		if len(logs) != len(receipt.Logs) || len(logs) != min(len(wantTopics[i]), 1) {
+			t.Fatalf("call %d: %d logs, the tx has %d", i, len(logs), len(receipt.Logs))
+		}
+		for j, l := range logs {
+			want := receipt.Logs[j]
+			topics := make([]string, len(wantTopics[i]))
+			for k, topic := range wantTopics[i] {
+				topics[k] = topic.Hex()
+			}
+			if l["address"] != addrHex(want.Address) || !reflect.DeepEqual(l["topics"], topics) || l["data"] != hexutil.Encode(want.Data) {
+				t.Errorf("call %d log %d: %v, the tx logged %+v", i, j, l, want)
+			}
+			if l["logIndex"] != hexutil.EncodeUint64(uint64(logIndex)) || l["blockHash"] != blocks[0]["hash"].(common.Hash).Hex() {
+				t.Errorf("call %d log %d: logIndex %v, blockHash %v", i, j, l["logIndex"], l["blockHash"])
+			}
+			logIndex++
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"runtime"
+
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/common/hexutil"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+)
+
+// StateDiffConfig is the options object of debug_getStateDiff. Format is
+// "diff", the default, or "parity" for the stateDiff object of
+// trace_replayBlockTransactions.
+type StateDiffConfig struct {
+	Format string `json:"format"`
+}
+
+// accountDiff is one address's side of a block's state diff. A nil account
+// is absent; storage maps a changed slot to its values before and after.
+type accountDiff struct {
+	before, after *store.Account
+	storage       map[common.Hash][2]common.Hash
+}
+
+// GetStateDiff implements debug_getStateDiff: the accounts and storage
+// slots a block changed, with their values before and after it, decoded
+// from the block's changeset. Params: block tag, optional StateDiffConfig.
+//
+// Changesets are written per block, so the diff is the net change of the
+// whole block; in the parity format it stands for all of its transactions
+// together. Storage slots are the state keys as stored, after coreth's key
+// normalization: keys with the low bit of the first byte set hold
+// multi-coin balances.
+func (b *Backend) GetStateDiff(ctx context.Context, params []json.RawMessage) (any, error) {
+	if len(params) < 1 {
+		return nil, fmt.Errorf("missing block parameter")
+	}
+	var blockTag string
+	if err := json.Unmarshal(params[0], &blockTag); err != nil {
+		return nil, err
+	}
+	var cfg StateDiffConfig
+	if len(params) > 1 && string(params[1]) != "null" {
+		if err := json.Unmarshal(params[1], &cfg); err != nil {
+			return nil, fmt.Errorf("invalid state diff config: %w", err)
+		}
+	}
+	if cfg.Format == "" {
+		cfg.Format = "diff"
+	}
+	if cfg.Format != "diff" && cfg.Format != "parity" {
+		return nil, fmt.Errorf("unsupported format %q (supported: diff, parity)", cfg.Format)
+	}
+
+	blockNum, err := b.resolveStateBlock(blockTag)
+	if err != nil {
+		return nil, err
+	}
+	if blockNum == 0 {
+		return nil, fmt.Errorf("the genesis block has no state diff")
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := b.db.BeginRO()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Abort()
+
+	if head, _ := store.GetHeadBlock(tx, b.db); blockNum > head {
+		return nil, nil
+	}
+	// The values before the block are the state at its parent.
+	if err := b.checkPruned(tx, store.PruneHistory, blockNum-1); err != nil {
+		return nil, err
+	}
+	if !store.KeyDictReversed(tx, b.db) {
+		return nil, fmt.Errorf("the reverse keyID dictionary is still being built")
+	}
+	diffs, err := b.blockStateDiff(tx, blockNum)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]any, len(diffs))
+	for addr, d := range diffs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var entry map[string]any
+		if cfg.Format == "parity" {
+			entry, err = b.formatParityDiff(tx, d)
+		} else {
+			entry, err = b.formatStateDiff(tx, d)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			out[addrHex(addr)] = entry
+		}
+	}
+	return out, nil
+}
+
+// blockStateDiff decodes the changeset of blockNum into per-address diffs.
+// The old values come from the changeset; the new ones are the state at
+// blockNum.
+func (b *Backend) blockStateDiff(tx *mdbx.Txn, blockNum uint64) (map[common.Address]*accountDiff, error) {
+	changes, err := store.ReadChangeset(tx, b.db, blockNum)
+	if err != nil {
+		if mdbx.IsNotFound(err) {
+			return nil, nil // block touched no state
+		}
+		return nil, fmt.Errorf("read changeset %d: %w", blockNum, err)
+	}
+	head, _ := store.GetHeadBlock(tx, b.db)
+
+	diffs := make(map[common.Address]*accountDiff)
+	touched := make(map[common.Address]bool) // account entry in the changeset
+	diffOf := func(addr common.Address) *accountDiff {
+		d := diffs[addr]
+		if d == nil {
+			d = &accountDiff{storage: make(map[common.Hash][2]common.Hash)}
+			diffs[addr] = d
+		}
+		return d
+	}
+	for _, c := range changes {
+		a20, slot, err := store.ResolveKeyID(tx, b.db, c.KeyID)
+		if err != nil {
+			return nil, fmt.Errorf("changeset %d: %w", blockNum, err)
+		}
+		addr := common.Address(a20)
+		d := diffOf(addr)
+		if slot == store.AccountSentinelSlot {
+			touched[addr] = true
+			if len(c.OldValue) > 0 {
+				d.before = store.DecodeAccount(c.OldValue)
+			}
+			continue
+		}
+		var before, after common.Hash
+		copy(before[32-len(c.OldValue):], c.OldValue)
+		if blockNum >= head {
+			after, err = store.GetStorage(tx, b.db, a20, slot)
+		} else {
+			after, err = store.LookupHistoricalStorage(tx, b.db, a20, slot, blockNum)
+		}
+		if err != nil {
+			return nil, err
+		}
+		d.storage[common.Hash(slot)] = [2]common.Hash{before, after}
+	}
+	for addr, d := range diffs {
+		if d.after, err = b.getAccountAt(tx, addr, blockNum); err != nil {
+			return nil, err
+		}
+		if !touched[addr] {
+			d.before = d.after
+		}
+	}
+	return diffs, nil
+}
+
+// formatStateDiff renders d with a {"from", "to"} pair for every field that
+// changed; an absent account's fields are null. Returns nil if nothing did.
+func (b *Backend) formatStateDiff(tx *mdbx.Txn, d *accountDiff) (map[string]any, error) {
+	entry := make(map[string]any)
+	fromBal, toBal := accountBalance(d.before), accountBalance(d.after)
+	if fromBal != toBal {
+		entry["balance"] = map[string]any{"from": fromBal, "to": toBal}
+	}
+	fromNonce, toNonce := accountNonce(d.before), accountNonce(d.after)
+	if fromNonce != toNonce {
+		entry["nonce"] = map[string]any{"from": fromNonce, "to": toNonce}
+	}
+	if codeChanged(d) {
+		fromCode, err := b.accountCode(tx, d.before)
+		if err != nil {
+			return nil, err
+		}
+		toCode, err := b.accountCode(tx, d.after)
+		if err != nil {
+			return nil, err
+		}
+		entry["code"] = map[string]any{"from": fromCode, "to": toCode}
+	}
+	storage := make(map[string]any)
+	for slot, v := range d.storage {
+		if v[0] != v[1] {
+			storage[slot.Hex()] = map[string]any{"from": v[0].Hex(), "to": v[1].Hex()}
+		}
+	}
+	if len(storage) > 0 {
+		entry["storage"] = storage
+	}
+	if len(entry) == 0 {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// formatParityDiff renders d the way trace_replayBlockTransactions reports
+// stateDiff: "=" for an unchanged field, {"+": new} for an account that came
+// into existence, {"-": old} for one that ceased to, and
+// {"*": {"from", "to"}} otherwise. Returns nil for an account absent on both
+// sides with no storage change.
+func (b *Backend) formatParityDiff(tx *mdbx.Txn, d *accountDiff) (map[string]any, error) {
+	field := func(from, to any) any {
+		switch {
+		case from == to:
+			return "="
+		case d.before == nil && d.after != nil:
+			return map[string]any{"+": to}
+		case d.before != nil && d.after == nil:
+			return map[string]any{"-": from}
+		default:
+			return map[string]any{"*": map[string]any{"from": from, "to": to}}
+		}
+	}
+
+	fromCode, toCode := any("0x"), any("0x")
+	if codeChanged(d) || d.before == nil || d.after == nil {
+		var err error
+		if fromCode, err = b.accountCode(tx, d.before); err != nil {
+			return nil, err
+		}
+		if toCode, err = b.accountCode(tx, d.after); err != nil {
+			return nil, err
+		}
+	}
+	storage := make(map[string]any)
+	for slot, v := range d.storage {
+		if v[0] == v[1] {
+			continue
+		}
+		storage[slot.Hex()] = field(v[0].Hex(), v[1].Hex())
+	}
+	if d.before == nil && d.after == nil && len(storage) == 0 {
+		return nil, nil
+	}
+	return map[string]any{
+		"balance": field(accountBalance(d.before), accountBalance(d.after)),
+		"nonce":   field(accountNonce(d.before), accountNonce(d.after)),
+		"code":    field(fromCode, toCode),
+		"storage": storage,
+	}, nil
+}
+
+// Field values below are hex strings, or nil for an absent account, so they
+// compare with ==.
+
+// accountCode returns acct's code as hex, null for an absent account.
+func (b *Backend) accountCode(tx *mdbx.Txn, acct *store.Account) (any, error) {
+	if acct == nil {
+		return nil, nil
+	}
+	if acct.CodeHash == store.EmptyCodeHash || acct.CodeHash == [32]byte{} {
+		return "0x", nil
+	}
+	code, err := store.GetCode(tx, b.db, acct.CodeHash)
+	if err != nil {
+		return nil, err
+	}
+	return hexutil.Encode(code), nil
+}
+
+func codeChanged(d *accountDiff) bool {
+	if d.before == nil || d.after == nil {
+		return d.before != d.after
+	}
+	return d.before.CodeHash != d.after.CodeHash
+}
+
+func accountBalance(acct *store.Account) any {
+	if acct == nil {
+		return nil
+	}
+	return encodeBigInt(new(big.Int).SetBytes(acct.Balance[:]))
+}
+
+func accountNonce(acct *store.Account) any {
+	if acct == nil {
+		return nil
+	}
+	return hexutil.Uint64(acct.Nonce).String()
+}
@@ -0,0 +1,170 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/crypto"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+var (
+	diffCreated  = common.Address{19: 0xa1}
+	diffDeleted  = common.Address{19: 0xa2}
+	diffStorage  = common.Address{19: 0xa3}
+	diffModified = common.Address{19: 0xa4}
+	diffCode     = []byte{0x60, 0x01}
+)
+
+// writeStateDiffTestDB writes the state at head 2 and the changeset of
+// block 2, which creates an account with code and a slot, deletes one,
+// changes a slot of a third without touching the account itself and bumps
+// the balance and nonce of a fourth.
+func writeStateDiffTestDB(t *testing.T) *Backend {
+	t.Helper()
+	db := storetest.Open(t, store.Open)
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		account := func(nonce uint64, balance byte) *store.Account {
+			return &store.Account{Nonce: nonce, Balance: [32]byte{31: balance}, CodeHash: store.EmptyCodeHash, StorageRoot: store.EmptyRootHash}
+		}
+		codeHash := [32]byte(crypto.Keccak256(diffCode))
+		if err := store.PutCode(tx, db, codeHash, diffCode); err != nil {
+			t.Fatal(err)
+		}
+		created := account(0, 5)
+		created.CodeHash = codeHash
+		for addr, acct := range map[common.Address]*store.Account{
+			diffCreated:  created,
+			diffStorage:  account(1, 1),
+			diffModified: account(1, 2),
+		} {
+			if err := store.PutAccount(tx, db, addr, acct); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, s := range []struct {
+			addr  common.Address
+			slot  common.Hash
+			value byte
+		}{
+			{diffCreated, common.Hash{31: 1}, 7},
+			{diffStorage, common.Hash{31: 2}, 9},
+			{diffStorage, common.Hash{31: 3}, 4},
+		} {
+			if err := store.PutStorage(tx, db, s.addr, s.slot, common.Hash{31: s.value}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var changes []store.Change
+		change := func(addr common.Address, slot [32]byte, old []byte) {
+			id, err := store.GetOrAssignKeyID(tx, db, addr, slot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			changes = append(changes, store.Change{KeyID: id, OldValue: old})
+		}
+		change(diffCreated, store.AccountSentinelSlot, nil)
+		change(diffCreated, common.Hash{31: 1}, nil)
+		change(diffDeleted, store.AccountSentinelSlot, store.EncodeAccountBytes(account(1, 3)))
+		change(diffStorage, common.Hash{31: 2}, []byte{5})
+		// Written back with the value it had.
+		change(diffStorage, common.Hash{31: 3}, []byte{4})
+		change(diffModified, store.AccountSentinelSlot, store.EncodeAccountBytes(account(0, 1)))
+		if err := store.WriteChangeset(tx, db, 2, changes); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.ReverseKeyDict(tx, db, -1); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetHeadBlock(tx, db, 2); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return &Backend{db: db}
+}
+
+func TestGetStateDiff(t *testing.T) {
+	b := writeStateDiffTestDB(t)
+	slot := func(n byte) string { return common.Hash{31: n}.Hex() }
+	created, deleted := addrHex(diffCreated), addrHex(diffDeleted)
+	storage, modified := addrHex(diffStorage), addrHex(diffModified)
+	for _, tc := range []struct {
+		format string
+		want   map[string]any
+	}{
+		{"diff", map[string]any{
+			created: map[string]any{
+				"balance": map[string]any{"from": nil, "to": "0x5"},
+				"nonce":   map[string]any{"from": nil, "to": "0x0"},
+				"code":    map[string]any{"from": nil, "to": "0x6001"},
+				"storage": map[string]any{slot(1): map[string]any{"from": slot(0), "to": slot(7)}},
+			},
+			deleted: map[string]any{
+				"balance": map[string]any{"from": "0x3", "to": nil},
+				"nonce":   map[string]any{"from": "0x1", "to": nil},
+				"code":    map[string]any{"from": "0x", "to": nil},
+			},
+			storage: map[string]any{
+				"storage": map[string]any{slot(2): map[string]any{"from": slot(5), "to": slot(9)}},
+			},
+			modified: map[string]any{
+				"balance": map[string]any{"from": "0x1", "to": "0x2"},
+				"nonce":   map[string]any{"from": "0x0", "to": "0x1"},
+			},
+		}},
+		{"parity", map[string]any{
+			created: map[string]any{
+				"balance": map[string]any{"+": "0x5"},
+				"nonce":   map[string]any{"+": "0x0"},
+				"code":    map[string]any{"+": "0x6001"},
+				"storage": map[string]any{slot(1): map[string]any{"+": slot(7)}},
+			},
+			deleted: map[string]any{
+				"balance": map[string]any{"-": "0x3"},
+				"nonce":   map[string]any{"-": "0x1"},
+				"code":    map[string]any{"-": "0x"},
+				"storage": map[string]any{},
+			},
+			storage: map[string]any{
+				"balance": "=",
+				"nonce":   "=",
+				"code":    "=",
+				"storage": map[string]any{slot(2): map[string]any{"*": map[string]any{"from": slot(5), "to": slot(9)}}},
+			},
+			modified: map[string]any{
+				"balance": map[string]any{"*": map[string]any{"from": "0x1", "to": "0x2"}},
+				"nonce":   map[string]any{"*": map[string]any{"from": "0x0", "to": "0x1"}},
+				"code":    "=",
+				"storage": map[string]any{},
+			},
+		}},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			result, err := callTestMethod(t, withContext(context.Background(), b.GetStateDiff), "0x2", StateDiffConfig{Format: tc.format})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Compare as the client sees it.
+			raw, err := json.Marshal(result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(raw, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				want, _ := json.MarshalIndent(tc.want, "", "  ")
+				pretty, _ := json.MarshalIndent(got, "", "  ")
+				t.Fatalf("got\n%s\nwant\n%s", pretty, want)
+			}
+		})
+	}
+}
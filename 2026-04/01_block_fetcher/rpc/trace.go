@@ -0,0 +1,294 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+
+	corethconsensus "github.com/ava-labs/avalanchego/graft/coreth/consensus"
+	"github.com/ava-labs/avalanchego/graft/coreth/consensus/dummy"
+	corethcore "github.com/ava-labs/avalanchego/graft/coreth/core"
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/core/state"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/core/vm"
+	"github.com/ava-labs/libevm/eth/tracers"
+	_ "github.com/ava-labs/libevm/eth/tracers/native" // registers callTracer, prestateTracer
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/chainquery"
+	"block_fetcher/statetrie"
+	"block_fetcher/store"
+)
+
+const defaultTraceTimeout = 5 * time.Second
+
+// TraceConfig matches the geth debug_trace* options object. Only the native
+// callTracer and prestateTracer are supported; the struct logger is not.
+type TraceConfig struct {
+	Tracer       *string         `json:"tracer"`
+	TracerConfig json.RawMessage `json:"tracerConfig"`
+	Timeout      *string         `json:"timeout"`
+}
+
+func parseTraceConfig(params []json.RawMessage, idx int) (*TraceConfig, error) {
+	cfg := &TraceConfig{}
+	if len(params) > idx && string(params[idx]) != "null" {
+		if err := json.Unmarshal(params[idx], cfg); err != nil {
+			return nil, fmt.Errorf("invalid trace config: %w", err)
+		}
+	}
+	name := "callTracer"
+	if cfg.Tracer != nil {
+		name = *cfg.Tracer
+	}
+	if name != "callTracer" && name != "prestateTracer" {
+		return nil, fmt.Errorf("unsupported tracer %q (supported: callTracer, prestateTracer)", name)
+	}
+	cfg.Tracer = &name
+	return cfg, nil
+}
+
+// TraceTransaction implements debug_traceTransaction.
+func (b *Backend) TraceTransaction(ctx context.Context, params []json.RawMessage) (any, error) {
+	if len(params) < 1 {
+		return nil, fmt.Errorf("missing tx hash parameter")
+	}
+	var hashHex string
+	if err := json.Unmarshal(params[0], &hashHex); err != nil {
+		return nil, fmt.Errorf("invalid tx hash: %w", err)
+	}
+	cfg, err := parseTraceConfig(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	txHash := common.HexToHash(hashHex)
+
+	runtime.LockOSThread()
+	tx, err := b.db.BeginRO()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	blockNum, txIndex, err := chainquery.TxLocation(tx, b.db, [32]byte(txHash))
+	tx.Abort()
+	runtime.UnlockOSThread()
+	if err != nil {
+		if mdbx.IsNotFound(err) {
+			return nil, fmt.Errorf("transaction %s not found", txHash.Hex())
+		}
+		return nil, err
+	}
+	if blockNum > 0 {
+		if err := b.requireState(blockNum - 1); err != nil {
+			return nil, err
+		}
+	}
+
+	results, err := b.evm.TraceBlock(ctx, b.db, blockNum, cfg, int(txIndex))
+	if err != nil {
+		return nil, err
+	}
+	if results[0].Error != "" {
+		return nil, errors.New(results[0].Error)
+	}
+	return results[0].Result, nil
+}
+
+// TraceBlockByNumber implements debug_traceBlockByNumber.
+func (b *Backend) TraceBlockByNumber(ctx context.Context, params []json.RawMessage) (any, error) {
+	if len(params) < 1 {
+		return nil, fmt.Errorf("missing block number parameter")
+	}
+	var tag string
+	if err := json.Unmarshal(params[0], &tag); err != nil {
+		return nil, fmt.Errorf("invalid block number: %w", err)
+	}
+	cfg, err := parseTraceConfig(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	blockNum, err := b.resolveBlockTag(tag)
+	if err != nil {
+		return nil, err
+	}
+	if blockNum > 0 {
+		if err := b.requireState(blockNum - 1); err != nil {
+			return nil, err
+		}
+	}
+	return b.evm.TraceBlock(ctx, b.db, blockNum, cfg, -1)
+}
+
+// TxTraceResult is one entry of a debug_traceBlock* response.
+type TxTraceResult struct {
+	TxHash common.Hash     `json:"txHash"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// TraceBlock re-executes block blockNum on top of its parent's historical
+// state and traces its transactions. If only >= 0, the transactions before it
+// are replayed untraced and only that one is returned. Tracing stops at the
+// config's timeout or when ctx ends, whichever comes first.
+func (ec *EVMContext) TraceBlock(ctx context.Context, db *store.DB, blockNum uint64, cfg *TraceConfig, only int) ([]TxTraceResult, error) {
+	if blockNum == 0 {
+		return nil, fmt.Errorf("genesis is not traceable")
+	}
+	timeout := defaultTraceTimeout
+	if cfg.Timeout != nil {
+		d, err := time.ParseDuration(*cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trace timeout: %w", err)
+		}
+		timeout = d
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	roTx, err := db.BeginRO()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := store.GetBlockByNumber(roTx, db, blockNum)
+	if err != nil {
+		roTx.Abort()
+		return nil, fmt.Errorf("read block %d: %w", blockNum, err)
+	}
+	raw = append([]byte(nil), raw...)
+	// Block 1's parent is genesis, which has no stored container. The root
+	// only labels the state; reads go through flat state + history either way.
+	var parentRaw []byte
+	if blockNum > 1 {
+		p, err := store.GetBlockByNumber(roTx, db, blockNum-1)
+		if err != nil {
+			roTx.Abort()
+			return nil, fmt.Errorf("read parent block %d: %w", blockNum-1, err)
+		}
+		parentRaw = append([]byte(nil), p...)
+	}
+	roTx.Abort()
+
+	ethBlock, err := parseEthBlock(raw)
+	if err != nil {
+		return nil, err
+	}
+	header := ethBlock.Header()
+	txs := ethBlock.Transactions()
+	if only >= len(txs) {
+		return nil, fmt.Errorf("tx index %d out of range for block %d", only, blockNum)
+	}
+
+	var (
+		parentRoot common.Hash
+		parentTime uint64
+	)
+	if parentRaw != nil {
+		parentBlock, err := parseEthBlock(parentRaw)
+		if err != nil {
+			return nil, fmt.Errorf("parse parent block: %w", err)
+		}
+		parentRoot = parentBlock.Root()
+		parentTime = parentBlock.Time()
+	}
+
+	sdb, err := state.New(parentRoot, statetrie.NewHistoricalDatabase(db, blockNum-1), nil)
+	if err != nil {
+		return nil, fmt.Errorf("open state at block %d: %w", blockNum-1, err)
+	}
+	if err := corethcore.ApplyUpgrades(ec.ChainConfig, &parentTime, corethcore.NewBlockContext(header.Number, header.Time), sdb); err != nil {
+		return nil, fmt.Errorf("apply upgrades: %w", err)
+	}
+
+	chainCtx := &traceChainContext{db: db, engine: dummy.NewCoinbaseFaker()}
+	blockCtx := corethcore.NewEVMBlockContext(header, chainCtx, &header.Coinbase)
+	if beaconRoot := ethBlock.BeaconRoot(); beaconRoot != nil {
+		vmenv := vm.NewEVM(blockCtx, vm.TxContext{}, sdb, ec.ChainConfig, vm.Config{})
+		corethcore.ProcessBeaconBlockRoot(*beaconRoot, vmenv, sdb)
+	}
+
+	gp := new(corethcore.GasPool).AddGas(header.GasLimit)
+	usedGas := uint64(0)
+	deadline := time.Now().Add(timeout)
+	var results []TxTraceResult
+
+	for i, tx := range txs {
+		if only >= 0 && i > only {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		sdb.SetTxContext(tx.Hash(), i)
+		if only >= 0 && i < only {
+			if _, err := corethcore.ApplyTransaction(ec.ChainConfig, chainCtx, blockCtx, gp, sdb, header, tx, &usedGas, vm.Config{}); err != nil {
+				return nil, fmt.Errorf("replay tx %d: %w", i, err)
+			}
+			continue
+		}
+
+		tracer, err := tracers.DefaultDirectory.New(*cfg.Tracer, &tracers.Context{
+			BlockHash:   ethBlock.Hash(),
+			BlockNumber: header.Number,
+			TxIndex:     i,
+			TxHash:      tx.Hash(),
+		}, cfg.TracerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("create tracer: %w", err)
+		}
+		timer := time.AfterFunc(time.Until(deadline), func() {
+			tracer.Stop(errors.New("execution timeout"))
+		})
+		stop := context.AfterFunc(ctx, func() {
+			tracer.Stop(ctx.Err())
+		})
+		_, err = corethcore.ApplyTransaction(ec.ChainConfig, chainCtx, blockCtx, gp, sdb, header, tx, &usedGas, vm.Config{Tracer: tracer})
+		timer.Stop()
+		stop()
+		if err != nil {
+			return nil, fmt.Errorf("trace tx %d: %w", i, err)
+		}
+		res, err := tracer.GetResult()
+		entry := TxTraceResult{TxHash: tx.Hash(), Result: res}
+		if err != nil {
+			entry.Result = nil
+			entry.Error = err.Error()
+		}
+		results = append(results, entry)
+	}
+	if results == nil {
+		results = []TxTraceResult{}
+	}
+	return results, nil
+}
+
+// traceChainContext serves parent headers to the EVM (BLOCKHASH) during replay.
+type traceChainContext struct {
+	db     *store.DB
+	engine corethconsensus.Engine
+}
+
+func (c *traceChainContext) Engine() corethconsensus.Engine {
+	return c.engine
+}
+
+func (c *traceChainContext) GetHeader(hash common.Hash, number uint64) *ethtypes.Header {
+	roTx, err := c.db.BeginRO()
+	if err != nil {
+		return nil
+	}
+	defer roTx.Abort()
+	raw, err := store.GetBlockByNumber(roTx, c.db, number)
+	if err != nil {
+		return nil
+	}
+	blk, err := parseEthBlock(raw)
+	if err != nil || blk.Hash() != hash {
+		return nil
+	}
+	return blk.Header()
+}
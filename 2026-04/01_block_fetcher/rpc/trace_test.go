@@ -0,0 +1,106 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ava-labs/libevm/common"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+)
+
+// TestTraceBlock replays block 2 of a chain whose block 1 is a transfer and
+// whose block 2 is a transfer and a call to the counter, and compares the
+// traces with what geth answers for the same block.
+func TestTraceBlock(t *testing.T) {
+	to, counter := common.Address{19: 0x20}, common.Address{19: 0xcc}
+	c := newTestChain(t, ethtypes.GenesisAlloc{
+		testSender: {Balance: big.NewInt(1e18)},
+		counter:    {Code: testCounterCode, Balance: new(big.Int)},
+	})
+	value := big.NewInt(1e15)
+	c.addBlock(t, c.signTx(t, 0, to, value, 21_000, nil))
+	block := c.addBlock(t,
+		c.signTx(t, 1, to, value, 21_000, nil),
+		c.signTx(t, 2, counter, new(big.Int), 100_000, nil),
+	)
+
+	// Fees: 21000 gas at 100 gwei per transfer, all of it to the coinbase as
+	// coreth burns nothing itself. The call costs 22761 gas on top of 21000:
+	// a cold SLOAD, a zero-to-nonzero SSTORE, an MSTORE and a 32-byte LOG0.
+	const (
+		callTrace = `[
+			{"from":"0x71562b71999873db5b286df957af199ec94617f7","gas":"0x5208","gasUsed":"0x5208","to":"0x0000000000000000000000000000000000000020","input":"0x","value":"0x38d7ea4c68000","type":"CALL"},
+			{"from":"0x71562b71999873db5b286df957af199ec94617f7","gas":"0x186a0","gasUsed":"0xaaf1","to":"0x00000000000000000000000000000000000000cc","input":"0x","value":"0x0","type":"CALL"}
+		]`
+		prestateTrace = `[
+			{
+				"0x71562b71999873db5b286df957af199ec94617f7": {"balance":"0xdd5b344a8964000","nonce":1},
+				"0x0000000000000000000000000000000000000020": {"balance":"0x38d7ea4c68000"},
+				"0x00000000000000000000000000000000000000cb": {"balance":"0x775f05a074000"}
+			},
+			{
+				"0x71562b71999873db5b286df957af199ec94617f7": {"balance":"0xdcaafd5a9c88000","nonce":2},
+				"0x00000000000000000000000000000000000000cc": {"balance":"0x0","code":"0x6000546001018060005560005260206000a000","storage":{"0x0000000000000000000000000000000000000000000000000000000000000000":"0x0000000000000000000000000000000000000000000000000000000000000000"}},
+				"0x00000000000000000000000000000000000000cb": {"balance":"0xeebe0b40e8000"}
+			}
+		]`
+	)
+	if strings.ToLower(testSender.Hex()) != "0x71562b71999873db5b286df957af199ec94617f7" {
+		t.Fatalf("test sender is %s", testSender.Hex())
+	}
+
+	b := c.backend()
+	for _, tc := range []struct {
+		tracer, want string
+	}{
+		{"callTracer", callTrace},
+		{"prestateTracer", prestateTrace},
+	} {
+		res, err := callTestMethod(t, withContext(context.Background(), b.TraceBlockByNumber), "0x2", TraceConfig{Tracer: &tc.tracer})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.tracer, err)
+		}
+		results := res.([]TxTraceResult)
+		var want []json.RawMessage
+		if err := json.Unmarshal([]byte(tc.want), &want); err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != len(want) {
+			t.Fatalf("%s: %d results, want %d", tc.tracer, len(results), len(want))
+		}
+		for i, r := range results {
+			if r.TxHash != block.Transactions()[i].Hash() || r.Error != "" {
+				t.Errorf("%s tx %d: hash %s, error %q", tc.tracer, i, r.TxHash.Hex(), r.Error)
+			}
+			if !equalTestJSON(t, r.Result, want[i]) {
+				t.Errorf("%s tx %d:\n got %s\nwant %s", tc.tracer, i, r.Result, want[i])
+			}
+		}
+
+		// debug_traceTransaction replays the transfer before the call.
+		one, err := callTestMethod(t, withContext(context.Background(), b.TraceTransaction), block.Transactions()[1].Hash().Hex(), TraceConfig{Tracer: &tc.tracer})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.tracer, err)
+		}
+		if !equalTestJSON(t, one.(json.RawMessage), want[1]) {
+			t.Errorf("%s of the call alone:\n got %s\nwant %s", tc.tracer, one, want[1])
+		}
+	}
+}
+
+// equalTestJSON reports whether a and b encode the same value.
+func equalTestJSON(t *testing.T, a, b json.RawMessage) bool {
+	t.Helper()
+	var va, vb any
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatal(err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
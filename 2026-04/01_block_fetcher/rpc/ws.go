@@ -0,0 +1,384 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ava-labs/libevm/common/hexutil"
+	"github.com/gorilla/websocket"
+
+	"block_fetcher/store"
+)
+
+const (
+	wsSendBuffer   = 1024 // queued messages per connection before it is dropped
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+	wsReadLimit    = 10 * 1024 * 1024
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(*http.Request) bool { return true },
+}
+
+// subscription is one eth_subscribe registration on a connection.
+type subscription struct {
+	id     string
+	kind   string     // "newHeads" or "logs"
+	filter *LogFilter // logs only; block range fields are ignored
+	conn   *wsConn
+	// active is set once the eth_subscribe response carrying id is queued,
+	// so no notification reaches the client before it knows the id.
+	active bool
+}
+
+// subscriptionHub delivers newHeads/logs notifications. It follows the
+// store's committed head feed, so a block is only announced once the batch
+// containing it has been verified against the header root and committed.
+type subscriptionHub struct {
+	db *store.DB
+
+	mu   sync.Mutex
+	subs map[string]*subscription
+	next uint64
+}
+
+func newSubscriptionHub(db *store.DB) *subscriptionHub {
+	h := &subscriptionHub{db: db, subs: make(map[string]*subscription)}
+	go h.run()
+	return h
+}
+
+// add registers an inactive subscription; activate starts its delivery.
+func (h *subscriptionHub) add(conn *wsConn, kind string, filter *LogFilter) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.next++
+	id := hexutil.EncodeUint64(h.next)
+	h.subs[id] = &subscription{id: id, kind: kind, filter: filter, conn: conn}
+	return id
+}
+
+// activate starts delivering to conn's inactive subscriptions.
+func (h *subscriptionHub) activate(conn *wsConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, sub := range h.subs {
+		if sub.conn == conn {
+			sub.active = true
+		}
+	}
+}
+
+func (h *subscriptionHub) remove(conn *wsConn, id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub, ok := h.subs[id]
+	if !ok || sub.conn != conn {
+		return false
+	}
+	delete(h.subs, id)
+	return true
+}
+
+func (h *subscriptionHub) removeConn(conn *wsConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, sub := range h.subs {
+		if sub.conn == conn {
+			delete(h.subs, id)
+		}
+	}
+}
+
+func (h *subscriptionHub) snapshot() []*subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]*subscription, 0, len(h.subs))
+	for _, sub := range h.subs {
+		if sub.active {
+			out = append(out, sub)
+		}
+	}
+	return out
+}
+
+func (h *subscriptionHub) run() {
+	heads, cancel := h.db.SubscribeHead()
+	defer cancel()
+
+	last, err := h.readHead()
+	if err != nil {
+		log.Printf("rpc ws: read head: %v", err)
+	}
+	// Heads announced while no one is subscribed are skipped, so a new
+	// subscriber only sees blocks committed after its subscription became
+	// active.
+	for head := range heads {
+		if head <= last {
+			continue
+		}
+		from := last + 1
+		last = head
+		subs := h.snapshot()
+		if len(subs) == 0 {
+			continue
+		}
+		for n := from; n <= head; n++ {
+			if err := h.notifyBlock(n, subs); err != nil {
+				log.Printf("rpc ws: notify block %d: %v", n, err)
+			}
+		}
+	}
+}
+
+func (h *subscriptionHub) readHead() (uint64, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := h.db.BeginRO()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Abort()
+	head, _ := store.GetHeadBlock(tx, h.db)
+	return head, nil
+}
+
+// notifyBlock loads block n once and pushes it to every matching subscriber.
+func (h *subscriptionHub) notifyBlock(n uint64, subs []*subscription) error {
+	wantLogs := false
+	for _, sub := range subs {
+		if sub.kind == "logs" {
+			wantLogs = true
+			break
+		}
+	}
+
+	runtime.LockOSThread()
+	tx, err := h.db.BeginRO()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	raw, err := store.GetBlockByNumber(tx, h.db, n)
+	if err != nil {
+		tx.Abort()
+		runtime.UnlockOSThread()
+		return err
+	}
+	raw = append([]byte(nil), raw...)
+	var receipts []store.TxReceipt
+	if wantLogs {
+		receipts, err = store.ReadBlockReceipts(tx, h.db, n)
+	}
+	tx.Abort()
+	runtime.UnlockOSThread()
+	if err != nil {
+		return err
+	}
+
+	ethBlock, err := parseEthBlock(raw)
+	if err != nil {
+		return err
+	}
+	header := formatBlock(ethBlock, false)
+	delete(header, "transactions")
+	delete(header, "uncles")
+	delete(header, "size")
+	delete(header, "totalDifficulty")
+	blockHash := ethBlock.Hash()
+
+	for _, sub := range subs {
+		switch sub.kind {
+		case "newHeads":
+			sub.conn.notify(sub.id, header)
+		case "logs":
+			logIndex := 0
+			for txIdx, r := range receipts {
+				for _, l := range r.Logs {
+					if sub.filter.matchesLog(l) {
+						sub.conn.notify(sub.id, formatLogFromReceipt(l, uint16(txIdx), uint16(logIndex), n, blockHash, r.TxHash))
+					}
+					logIndex++
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// wsConn is one WebSocket client. All writes go through send so the single
+// writer goroutine owns the socket.
+type wsConn struct {
+	ws     *websocket.Conn
+	send   chan any
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *wsConn) close() {
+	c.once.Do(func() {
+		close(c.closed)
+		c.ws.Close()
+	})
+}
+
+// enqueue queues msg for writing. A client that can't keep up is disconnected
+// rather than allowed to stall notification delivery for everyone else.
+func (c *wsConn) enqueue(msg any) {
+	select {
+	case c.send <- msg:
+	case <-c.closed:
+	default:
+		log.Printf("rpc ws: client %s too slow, disconnecting", c.ws.RemoteAddr())
+		c.close()
+	}
+}
+
+type subscriptionParams struct {
+	Subscription string `json:"subscription"`
+	Result       any    `json:"result"`
+}
+
+type notification struct {
+	JSONRPC string             `json:"jsonrpc"`
+	Method  string             `json:"method"`
+	Params  subscriptionParams `json:"params"`
+}
+
+func (c *wsConn) notify(id string, result any) {
+	c.enqueue(notification{
+		JSONRPC: "2.0",
+		Method:  "eth_subscription",
+		Params:  subscriptionParams{Subscription: id, Result: result},
+	})
+}
+
+func (c *wsConn) writeLoop() {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case msg := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.ws.WriteJSON(msg); err != nil {
+				c.close()
+				return
+			}
+		case <-ping.C:
+			c.ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.close()
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
+	ws, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade already replied with an HTTP error.
+	}
+	ws.SetReadLimit(wsReadLimit)
+	conn := &wsConn{ws: ws, send: make(chan any, wsSendBuffer), closed: make(chan struct{})}
+	go conn.writeLoop()
+	defer func() {
+		s.subs.removeConn(conn)
+		conn.close()
+	}()
+	ctx := r.Context()
+
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var batch []Request
+		if err := json.Unmarshal(msg, &batch); err == nil && len(batch) > 0 {
+			conn.enqueue(s.serveBatch(ctx, batch, func(ctx context.Context, req Request) Response {
+				return s.dispatchWS(ctx, conn, req)
+			}))
+			s.subs.activate(conn)
+			continue
+		}
+		var req Request
+		if err := json.Unmarshal(msg, &req); err != nil {
+			conn.enqueue(Response{
+				JSONRPC: "2.0",
+				Error:   &RPCError{Code: -32700, Message: "parse error"},
+			})
+			continue
+		}
+		conn.enqueue(s.dispatchWS(ctx, conn, req))
+		// Requests on a connection are read one at a time, so the only
+		// inactive subscriptions are the ones this response announced.
+		s.subs.activate(conn)
+	}
+}
+
+// dispatchWS handles the subscription methods, which only make sense on a
+// persistent connection, and hands everything else to the regular dispatcher.
+func (s *Server) dispatchWS(ctx context.Context, conn *wsConn, req Request) Response {
+	var result any
+	var err error
+	switch req.Method {
+	case "eth_subscribe":
+		result, err = s.subscribe(conn, req.Params)
+	case "eth_unsubscribe":
+		var id string
+		if len(req.Params) < 1 {
+			err = fmt.Errorf("missing subscription id")
+		} else if err = json.Unmarshal(req.Params[0], &id); err == nil {
+			result = s.subs.remove(conn, id)
+		}
+	default:
+		return s.serve(ctx, req)
+	}
+	if err != nil {
+		return Response{
+			JSONRPC: "2.0",
+			Error:   &RPCError{Code: -32000, Message: err.Error()},
+			ID:      req.ID,
+		}
+	}
+	return Response{JSONRPC: "2.0", Result: result, ID: req.ID}
+}
+
+func (s *Server) subscribe(conn *wsConn, params []json.RawMessage) (string, error) {
+	if len(params) < 1 {
+		return "", fmt.Errorf("missing subscription type")
+	}
+	var kind string
+	if err := json.Unmarshal(params[0], &kind); err != nil {
+		return "", fmt.Errorf("invalid subscription type: %w", err)
+	}
+	switch kind {
+	case "newHeads":
+		return s.subs.add(conn, kind, nil), nil
+	case "logs":
+		if err := s.backend.requireExecuted(); err != nil {
+			return "", err
+		}
+		filter := &LogFilter{}
+		if len(params) > 1 {
+			if err := json.Unmarshal(params[1], filter); err != nil {
+				return "", fmt.Errorf("invalid filter: %w", err)
+			}
+		}
+		filter.parse()
+		return s.subs.add(conn, kind, filter), nil
+	default:
+		return "", fmt.Errorf("unsupported subscription type %q", kind)
+	}
+}
@@ -0,0 +1,114 @@
+package rpc
+
+import (
+	"math/big"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/common/hexutil"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/gorilla/websocket"
+)
+
+func TestWSSubscriptions(t *testing.T) {
+	counter, other := common.Address{19: 0xcc}, common.Address{19: 0xdd}
+	c := newTestChain(t, ethtypes.GenesisAlloc{
+		testSender: {Balance: big.NewInt(1e18)},
+		counter:    {Code: testCounterCode, Balance: new(big.Int)},
+	})
+	s := NewServer(c.backend(), DefaultServerConfig())
+	srv := httptest.NewServer(s.mux)
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ext/bc/C/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	subIDs := make(map[string]string)
+	for i, sub := range []struct{ name, params string }{
+		{"heads", `["newHeads"]`},
+		{"counterLogs", `["logs",{"address":"` + counter.Hex() + `"}]`},
+		{"otherLogs", `["logs",{"address":"` + other.Hex() + `"}]`},
+	} {
+		req := `{"jsonrpc":"2.0","method":"eth_subscribe","params":` + sub.params + `,"id":` + strconv.Itoa(i+1) + `}`
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(req)); err != nil {
+			t.Fatal(err)
+		}
+		var resp struct {
+			Result string
+			Error  *RPCError
+		}
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Error != nil || resp.Result == "" {
+			t.Fatalf("subscribe %s: %+v", sub.name, resp.Error)
+		}
+		subIDs[resp.Result] = sub.name
+	}
+
+	tx := c.signTx(t, 0, counter, new(big.Int), 100_000, nil)
+	block := c.addBlock(t, tx)
+	c.db.PublishHead(1)
+
+	got := make(map[string]map[string]any)
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(got) < 2 {
+		var msg struct {
+			Method string
+			Params struct {
+				Subscription string
+				Result       map[string]any
+			}
+		}
+		if err := ws.ReadJSON(&msg); err != nil {
+			t.Fatalf("waiting for notifications (got %v): %v", got, err)
+		}
+		name := subIDs[msg.Params.Subscription]
+		if msg.Method != "eth_subscription" || name == "" || got[name] != nil {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+		got[name] = msg.Params.Result
+	}
+	if head := got["heads"]; head["number"] != "0x1" || head["hash"] != block.Hash().Hex() {
+		t.Errorf("newHeads notification %v, want block 1 %s", head, block.Hash().Hex())
+	}
+	wantLog := map[string]string{
+		"address":         strings.ToLower(counter.Hex()),
+		"blockNumber":     "0x1",
+		"blockHash":       block.Hash().Hex(),
+		"transactionHash": tx.Hash().Hex(),
+		"data":            hexutil.Encode(common.LeftPadBytes([]byte{1}, 32)),
+	}
+	for k, v := range wantLog {
+		if got, _ := got["counterLogs"][k].(string); !strings.EqualFold(got, v) {
+			t.Errorf("log %s = %v, want %v", k, got, v)
+		}
+	}
+
+	// Nothing for the filter that matches no log.
+	ws.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, extra, err := ws.ReadMessage(); err == nil {
+		t.Fatalf("unexpected message %s", extra)
+	}
+}
+
+// A subscription gets nothing until its eth_subscribe response is queued.
+func TestSubscriptionInactiveUntilActivated(t *testing.T) {
+	s := NewServer(newTestChain(t, nil).backend(), DefaultServerConfig())
+	conn := &wsConn{}
+	id := s.subs.add(conn, "newHeads", nil)
+	if subs := s.subs.snapshot(); len(subs) != 0 {
+		t.Fatalf("new subscription %s is delivered to before activation", id)
+	}
+	s.subs.activate(conn)
+	if subs := s.subs.snapshot(); len(subs) != 1 || subs[0].id != id {
+		t.Fatalf("activated subscriptions %v, want %s", subs, id)
+	}
+}
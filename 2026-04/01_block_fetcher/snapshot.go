@@ -0,0 +1,452 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/libevm/common"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/snapshot"
+	"block_fetcher/statetrie"
+	"block_fetcher/store"
+)
+
+const (
+	// snapshotBlocksTable is the snapshot table holding the containers of
+	// the last snapshotBlocks blocks up to the head, keyed block number ++
+	// container ID: the executor reads the head block for its parent root,
+	// and BLOCKHASH reaches back 256 blocks.
+	snapshotBlocksTable = "Blocks"
+	snapshotBlocks      = 256
+
+	// importBatch is how many records one import write transaction holds.
+	importBatch = 1_000_000
+)
+
+type snapshotTable struct {
+	name string
+	dbi  mdbx.DBI
+}
+
+// snapshotTables returns the tables a state snapshot holds: the flat state
+// and the hashed state the root computation reads. The trie nodes are left
+// out: an import could only trust them by rebuilding them, so it rebuilds
+// them from the hashed state while checking the state root.
+func snapshotTables(db *store.DB) []snapshotTable {
+	return []snapshotTable{
+		{store.TableAccountState, db.AccountState},
+		{store.TableStorageState, db.StorageState},
+		{store.TableCode, db.Code},
+		{store.TableHashedAccountState, db.HashedAccountState},
+		{store.TableHashedStorageState, db.HashedStorageState},
+	}
+}
+
+// runSnapshot implements the snapshot subcommand:
+//
+//	block_fetcher snapshot export -db-dir DIR -out SNAPDIR
+//	block_fetcher snapshot import -db-dir DIR -in SNAPDIR
+func runSnapshot(args []string) error {
+	usage := errors.New("usage: block_fetcher snapshot export|import [flags]")
+	if len(args) == 0 {
+		return usage
+	}
+	fs := flag.NewFlagSet("snapshot "+args[0], flag.ExitOnError)
+	dbDir := fs.String("db-dir", defaultDBDir, "MDBX database directory")
+	switch args[0] {
+	case "export":
+		out := fs.String("out", "", "directory to write the snapshot to (required)")
+		chunkMB := fs.Int("chunk-size", snapshot.DefaultChunkSize>>20, "uncompressed MB per chunk file")
+		verify := fs.Bool("verify", false, "recompute the full state root before exporting")
+		fs.Parse(args[1:])
+		if *out == "" {
+			return errors.New("-out is required")
+		}
+		return exportSnapshot(*dbDir, *out, *chunkMB<<20, *verify)
+	case "import":
+		in := fs.String("in", "", "snapshot directory to import (required)")
+		fs.Parse(args[1:])
+		if *in == "" {
+			return errors.New("-in is required")
+		}
+		return importSnapshot(*dbDir, *in)
+	default:
+		return usage
+	}
+}
+
+// exportSnapshot writes the state at the executed head, which the executor
+// has verified against the block's state root, to dir. Everything is read in
+// one transaction, so the snapshot is consistent.
+func exportSnapshot(dbDir, dir string, chunkSize int, verify bool) error {
+	if _, err := os.Stat(filepath.Join(dir, snapshot.ManifestFile)); err == nil {
+		return fmt.Errorf("%s already holds a snapshot", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// Export only reads, so it can run next to a live fetcher.
+	db, err := store.OpenReadOnly(dbDir)
+	if err != nil {
+		return fmt.Errorf("open MDBX: %w", err)
+	}
+	defer db.Close()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := db.BeginRO()
+	if err != nil {
+		return err
+	}
+	defer tx.Abort()
+
+	head, ok := store.GetHeadBlock(tx, db)
+	if !ok {
+		return errors.New("nothing has been executed")
+	}
+	raw, err := store.GetBlockByNumber(tx, db, head)
+	if err != nil {
+		return fmt.Errorf("read head block %d: %w", head, err)
+	}
+	block, err := executorParseEthBlock(raw)
+	if err != nil {
+		return fmt.Errorf("parse head block %d: %w", head, err)
+	}
+	m := &snapshot.Manifest{
+		Version:     snapshot.Version,
+		BlockNumber: head,
+		BlockHash:   block.Hash(),
+		StateRoot:   block.Root(),
+		Created:     time.Now().UTC(),
+	}
+	if chainID, err := tx.Get(db.Metadata, []byte("blockchain_id")); err == nil {
+		id, err := ids.ToID(chainID)
+		if err != nil {
+			return fmt.Errorf("chain marker: %w", err)
+		}
+		m.BlockchainID = id.String()
+	}
+	log.Printf("snapshot: exporting block %d hash=%s root=%s", head, m.BlockHash, m.StateRoot)
+
+	if verify {
+		start := time.Now()
+		root, err := statetrie.ComputeFullStateRoot(tx, db)
+		if err != nil {
+			return fmt.Errorf("compute state root: %w", err)
+		}
+		if common.Hash(root) != m.StateRoot {
+			return fmt.Errorf("state root %x does not match block %d root %s", root, head, m.StateRoot)
+		}
+		log.Printf("snapshot: state root verified elapsed=%s", time.Since(start).Round(time.Second))
+	}
+
+	for _, st := range snapshotTables(db) {
+		start := time.Now()
+		t, err := exportTable(tx, st.dbi, dir, st.name, chunkSize)
+		if err != nil {
+			return fmt.Errorf("export %s: %w", st.name, err)
+		}
+		m.Tables = append(m.Tables, t)
+		log.Printf("snapshot: exported %s entries=%d chunks=%d elapsed=%s", st.name, t.Entries, len(t.Chunks), time.Since(start).Round(time.Second))
+	}
+
+	w := snapshot.NewTableWriter(dir, snapshotBlocksTable, chunkSize)
+	for n := head - min(head, snapshotBlocks-1); n <= head; n++ {
+		id, err := store.GetContainerIDByNumber(tx, db, n)
+		if mdbx.IsNotFound(err) {
+			continue // genesis has no container
+		}
+		if err != nil {
+			return fmt.Errorf("index of block %d: %w", n, err)
+		}
+		raw, err := store.GetContainer(tx, db, id)
+		if err != nil {
+			return fmt.Errorf("container of block %d: %w", n, err)
+		}
+		key := store.BlockKey(n)
+		if err := w.Add(append(key[:], id[:]...), raw); err != nil {
+			return err
+		}
+	}
+	t, err := w.Close()
+	if err != nil {
+		return fmt.Errorf("export blocks: %w", err)
+	}
+	m.Tables = append(m.Tables, t)
+
+	if err := snapshot.WriteManifest(dir, m); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	log.Printf("snapshot: wrote %s", dir)
+	return nil
+}
+
+func exportTable(tx *mdbx.Txn, dbi mdbx.DBI, dir, name string, chunkSize int) (snapshot.Table, error) {
+	cursor, err := tx.OpenCursor(dbi)
+	if err != nil {
+		return snapshot.Table{}, err
+	}
+	defer cursor.Close()
+	w := snapshot.NewTableWriter(dir, name, chunkSize)
+	k, v, err := cursor.Get(nil, nil, mdbx.First)
+	for err == nil {
+		if err := w.Add(k, v); err != nil {
+			return snapshot.Table{}, err
+		}
+		k, v, err = cursor.Get(nil, nil, mdbx.Next)
+	}
+	if !mdbx.IsNotFound(err) {
+		return snapshot.Table{}, err
+	}
+	return w.Close()
+}
+
+// importSnapshot loads the snapshot in dir into a database without executed
+// state, checks it against the manifest's state root and block, and sets the
+// head to the snapshot's block so the executor resumes after it.
+func importSnapshot(dbDir, dir string) error {
+	m, err := snapshot.ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+	log.Printf("snapshot: importing block %d hash=%s root=%s", m.BlockNumber, m.BlockHash, m.StateRoot)
+	for _, t := range m.Tables {
+		if err := snapshot.VerifyTable(dir, t); err != nil {
+			return fmt.Errorf("verify %s: %w", t.Name, err)
+		}
+	}
+	log.Printf("snapshot: chunk checksums verified")
+
+	db, err := store.Open(dbDir)
+	if err != nil {
+		return fmt.Errorf("open MDBX: %w", err)
+	}
+	defer db.Close()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	var chainID []byte
+	if m.BlockchainID != "" {
+		id, err := ids.FromString(m.BlockchainID)
+		if err != nil {
+			return fmt.Errorf("manifest blockchain ID: %w", err)
+		}
+		chainID = id[:]
+	}
+	if err := prepareSnapshotImport(db, chainID); err != nil {
+		return err
+	}
+
+	for _, t := range m.Tables {
+		start := time.Now()
+		err := errors.New("not a snapshot table")
+		switch t.Name {
+		case snapshotBlocksTable:
+			err = importBlocks(db, dir, t)
+		}
+		for _, st := range snapshotTables(db) {
+			if st.name == t.Name {
+				err = importTable(db, st.dbi, dir, t)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("import %s: %w", t.Name, err)
+		}
+		log.Printf("snapshot: imported %s entries=%d elapsed=%s", t.Name, t.Entries, time.Since(start).Round(time.Second))
+	}
+
+	if err := checkSnapshotImport(db, m); err != nil {
+		return err
+	}
+
+	tx, err := db.BeginRW()
+	if err != nil {
+		return err
+	}
+	defer tx.Abort()
+	// The rebuilt trie nodes are kept, so the executor's first batch hashes
+	// incrementally.
+	log.Printf("snapshot: rebuilding the state trie")
+	start := time.Now()
+	root, err := statetrie.RebuildStateTrie(tx, db)
+	if err != nil {
+		return fmt.Errorf("rebuild state trie: %w", err)
+	}
+	if common.Hash(root) != m.StateRoot {
+		return fmt.Errorf("imported state root %x, want %s", root, m.StateRoot)
+	}
+	log.Printf("snapshot: state root verified elapsed=%s", time.Since(start).Round(time.Second))
+	if err := store.SetHeadBlock(tx, db, m.BlockNumber); err != nil {
+		return err
+	}
+	for _, key := range []string{"genesis_loaded", "trie_v3"} {
+		if err := tx.Put(db.Metadata, []byte(key), []byte{1}, 0); err != nil {
+			return err
+		}
+	}
+	if chainID != nil {
+		if err := tx.Put(db.Metadata, []byte("blockchain_id"), chainID, 0); err != nil {
+			return err
+		}
+	}
+	if err := store.InitIndexWatermarks(tx, db); err != nil {
+		return err
+	}
+	if err := store.MarkHistoryStart(tx, db, m.BlockNumber); err != nil {
+		return err
+	}
+	if _, err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("snapshot: imported block %d; the executor resumes from block %d", m.BlockNumber, m.BlockNumber+1)
+	return nil
+}
+
+// prepareSnapshotImport refuses databases that hold executed state or another
+// chain, and empties the snapshot tables and the trie nodes, which can only
+// hold what an interrupted import left.
+func prepareSnapshotImport(db *store.DB, chainID []byte) error {
+	tx, err := db.BeginRW()
+	if err != nil {
+		return err
+	}
+	defer tx.Abort()
+	if head, ok := store.GetHeadBlock(tx, db); ok {
+		return fmt.Errorf("database is executed to block %d; import into a new database or one cleared with -clean-state", head)
+	}
+	if _, err := tx.Get(db.Metadata, []byte("genesis_loaded")); err == nil {
+		return errors.New("database has genesis state loaded; import into a new database or one cleared with -clean-state")
+	}
+	if stored, err := tx.Get(db.Metadata, []byte("blockchain_id")); err == nil && chainID != nil && string(stored) != string(chainID) {
+		storedID, _ := ids.ToID(stored)
+		return fmt.Errorf("database holds chain %s, the snapshot is of %s", storedID, ids.ID(chainID))
+	}
+	for _, dbi := range []mdbx.DBI{db.AccountTrie, db.StorageTrie} {
+		if err := tx.Drop(dbi, false); err != nil {
+			return err
+		}
+	}
+	for _, st := range snapshotTables(db) {
+		if err := tx.Drop(st.dbi, false); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Commit()
+	return err
+}
+
+func importTable(db *store.DB, dbi mdbx.DBI, dir string, t snapshot.Table) error {
+	tx, err := db.BeginRW()
+	if err != nil {
+		return err
+	}
+	// tx is only replaced by a transaction that began, so the deferred
+	// Abort never sees nil; on a committed one it does nothing.
+	defer func() { tx.Abort() }()
+	n := 0
+	err = snapshot.ReadTable(dir, t, func(k, v []byte) error {
+		if err := tx.Put(dbi, k, v, mdbx.Append); err != nil {
+			return err
+		}
+		if n++; n%importBatch == 0 {
+			if _, err := tx.Commit(); err != nil {
+				return err
+			}
+			next, err := db.BeginRW()
+			if err != nil {
+				return err
+			}
+			tx = next
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tx.Commit()
+	return err
+}
+
+func importBlocks(db *store.DB, dir string, t snapshot.Table) error {
+	tx, err := db.BeginRW()
+	if err != nil {
+		return err
+	}
+	defer tx.Abort()
+	err = snapshot.ReadTable(dir, t, func(k, v []byte) error {
+		if len(k) != 40 {
+			return fmt.Errorf("block key has %d bytes", len(k))
+		}
+		return store.PutContainer(tx, db, [32]byte(k[8:]), binary.BigEndian.Uint64(k[:8]), v)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tx.Commit()
+	return err
+}
+
+// checkSnapshotImport checks the imported head block against the manifest and
+// cross-checks the imported tables with fsck's checks. The state root, which
+// importSnapshot checks while rebuilding the trie, only covers the hashed
+// state; the flat state and the code the executor reads are only covered by
+// the cross-checks.
+func checkSnapshotImport(db *store.DB, m *snapshot.Manifest) error {
+	tx, err := db.BeginRO()
+	if err != nil {
+		return err
+	}
+	defer tx.Abort()
+	raw, err := store.GetBlockByNumber(tx, db, m.BlockNumber)
+	if err != nil {
+		return fmt.Errorf("read block %d: %w", m.BlockNumber, err)
+	}
+	block, err := executorParseEthBlock(raw)
+	if err != nil {
+		return fmt.Errorf("parse block %d: %w", m.BlockNumber, err)
+	}
+	if block.Hash() != m.BlockHash || block.Root() != m.StateRoot {
+		return fmt.Errorf("block %d hash=%s root=%s does not match the manifest", m.BlockNumber, block.Hash(), block.Root())
+	}
+
+	log.Printf("snapshot: cross-checking tables")
+	start := time.Now()
+	checks := []*fsckCheck{
+		{name: "accounts"},
+		{name: "storage"},
+		{name: "storage roots"},
+		{name: "code"},
+	}
+	// The fixes are only collected; an import that fails a check is refused.
+	fixes := newFsckFixes()
+	if err := checkHashedAccounts(tx, db, checks[0], fixes); err != nil {
+		return fmt.Errorf("check accounts: %w", err)
+	}
+	if err := checkHashedStorage(tx, db, checks[1], fixes); err != nil {
+		return fmt.Errorf("check storage: %w", err)
+	}
+	if err := checkStorageRoots(tx, db, 1, checks[2], fixes); err != nil {
+		return fmt.Errorf("check storage roots: %w", err)
+	}
+	if err := checkCode(tx, db, checks[3]); err != nil {
+		return fmt.Errorf("check code: %w", err)
+	}
+	problems := 0
+	for _, c := range checks {
+		problems += c.problems
+	}
+	if problems > 0 {
+		return fmt.Errorf("imported state has %d inconsistencies", problems)
+	}
+	log.Printf("snapshot: tables cross-checked elapsed=%s", time.Since(start).Round(time.Second))
+	return nil
+}
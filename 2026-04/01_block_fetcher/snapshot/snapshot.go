@@ -0,0 +1,278 @@
+// Package snapshot reads and writes state snapshots: MDBX tables exported as
+// ZSTD-compressed, SHA-256-checksummed chunk files plus a JSON manifest that
+// pins the block and state root they were taken at.
+//
+// A chunk is a ZSTD stream of records, each a uvarint key length, the key, a
+// uvarint value length and the value, in table key order.
+package snapshot
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ava-labs/libevm/common"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// ManifestFile is the manifest's name inside a snapshot directory.
+	ManifestFile = "manifest.json"
+	// Version is the snapshot format version this package writes and reads.
+	Version = 1
+	// DefaultChunkSize is how many uncompressed record bytes go into one
+	// chunk file.
+	DefaultChunkSize = 256 << 20
+)
+
+// Manifest describes a snapshot.
+type Manifest struct {
+	Version      int         `json:"version"`
+	BlockchainID string      `json:"blockchainId"`
+	BlockNumber  uint64      `json:"blockNumber"`
+	BlockHash    common.Hash `json:"blockHash"`
+	StateRoot    common.Hash `json:"stateRoot"`
+	Created      time.Time   `json:"created"`
+	Tables       []Table     `json:"tables"`
+}
+
+// Table is one exported table.
+type Table struct {
+	Name    string  `json:"name"`
+	Entries uint64  `json:"entries"`
+	Chunks  []Chunk `json:"chunks"`
+}
+
+// Chunk is one chunk file of a table.
+type Chunk struct {
+	File    string `json:"file"`
+	Entries uint64 `json:"entries"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+}
+
+// WriteManifest writes m into dir. Write it last: a directory without a
+// manifest is an unfinished export.
+func WriteManifest(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, ManifestFile+".tmp")
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, ManifestFile))
+}
+
+// ReadManifest reads the manifest of the snapshot in dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	if m.Version != Version {
+		return nil, fmt.Errorf("snapshot format version %d, want %d", m.Version, Version)
+	}
+	return &m, nil
+}
+
+// TableWriter writes the records of one table into chunk files.
+type TableWriter struct {
+	dir       string
+	table     Table
+	chunkSize int
+
+	file    *os.File
+	hash    hash.Hash
+	enc     *zstd.Encoder
+	written int
+	entries uint64
+	scratch [binary.MaxVarintLen64]byte
+}
+
+// NewTableWriter starts writing table name into dir. Records must be added
+// in key order.
+func NewTableWriter(dir, name string, chunkSize int) *TableWriter {
+	return &TableWriter{dir: dir, table: Table{Name: name}, chunkSize: chunkSize}
+}
+
+// Add appends a record.
+func (w *TableWriter) Add(key, value []byte) error {
+	if w.enc == nil {
+		if err := w.openChunk(); err != nil {
+			return err
+		}
+	}
+	for _, b := range [][]byte{key, value} {
+		n := binary.PutUvarint(w.scratch[:], uint64(len(b)))
+		if _, err := w.enc.Write(w.scratch[:n]); err != nil {
+			return err
+		}
+		if _, err := w.enc.Write(b); err != nil {
+			return err
+		}
+		w.written += n + len(b)
+	}
+	w.entries++
+	if w.written >= w.chunkSize {
+		return w.closeChunk()
+	}
+	return nil
+}
+
+// Close finishes the last chunk and returns the table's manifest entry.
+func (w *TableWriter) Close() (Table, error) {
+	if w.enc != nil {
+		if err := w.closeChunk(); err != nil {
+			return Table{}, err
+		}
+	}
+	return w.table, nil
+}
+
+func (w *TableWriter) openChunk() error {
+	name := fmt.Sprintf("%s-%05d.zst", w.table.Name, len(w.table.Chunks))
+	f, err := os.Create(filepath.Join(w.dir, name))
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	enc, err := zstd.NewWriter(io.MultiWriter(f, h))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.hash, w.enc = f, h, enc
+	w.table.Chunks = append(w.table.Chunks, Chunk{File: name})
+	return nil
+}
+
+func (w *TableWriter) closeChunk() error {
+	if err := w.enc.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	info, err := w.file.Stat()
+	if err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	c := &w.table.Chunks[len(w.table.Chunks)-1]
+	c.Entries = w.entries
+	c.Size = info.Size()
+	c.SHA256 = hex.EncodeToString(w.hash.Sum(nil))
+	w.table.Entries += w.entries
+	w.enc, w.file, w.written, w.entries = nil, nil, 0, 0
+	return nil
+}
+
+// VerifyTable checks the size and checksum of every chunk of t in dir.
+func VerifyTable(dir string, t Table) error {
+	for _, c := range t.Chunks {
+		f, err := os.Open(filepath.Join(dir, c.File))
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("read %s: %w", c.File, err)
+		}
+		if n != c.Size {
+			return fmt.Errorf("%s has %d bytes, manifest says %d", c.File, n, c.Size)
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != c.SHA256 {
+			return fmt.Errorf("%s checksum %s, manifest says %s", c.File, sum, c.SHA256)
+		}
+	}
+	return nil
+}
+
+// ReadTable calls fn for every record of t in dir, in order. The slices are
+// only valid during the call. Run VerifyTable first; ReadTable only checks
+// the record counts.
+func ReadTable(dir string, t Table, fn func(key, value []byte) error) error {
+	for _, c := range t.Chunks {
+		if err := readChunk(filepath.Join(dir, c.File), c.Entries, fn); err != nil {
+			return fmt.Errorf("%s: %w", c.File, err)
+		}
+	}
+	return nil
+}
+
+func readChunk(path string, entries uint64, fn func(key, value []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec, err := zstd.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+	r := bufio.NewReaderSize(dec, 1<<20)
+
+	var key, value []byte
+	var n uint64
+	for {
+		key, err = readField(r, key)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if value, err = readField(r, value); err != nil {
+			return fmt.Errorf("record %d: %w", n, err)
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+		n++
+	}
+	if n != entries {
+		return fmt.Errorf("%d records, manifest says %d", n, entries)
+	}
+	return nil
+}
+
+func readField(r *bufio.Reader, buf []byte) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > 1<<30 {
+		return nil, fmt.Errorf("record field of %d bytes", size)
+	}
+	if uint64(cap(buf)) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"maps"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/core/state"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/crypto"
+	"github.com/ava-labs/libevm/rlp"
+	"github.com/erigontech/mdbx-go/mdbx"
+	"github.com/holiman/uint256"
+
+	"block_fetcher/statetrie"
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+// writeSnapshotTestDB writes state with balances, code and storage to a new
+// database in dir, executed to block 1, and calls tamper, if set, on it
+// before closing it. It returns the state root.
+func writeSnapshotTestDB(t *testing.T, dir string, tamper func(tx *mdbx.Txn, db *store.DB)) common.Hash {
+	t.Helper()
+	db, err := store.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	stateDB := statetrie.NewDatabase(db)
+	sdb, err := state.New(ethtypes.EmptyRootHash, stateDB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := byte(1); i <= 20; i++ {
+		sdb.SetBalance(common.Address{19: i}, uint256.NewInt(uint64(i)*1e9))
+		sdb.SetNonce(common.Address{19: i}, uint64(i))
+	}
+	sdb.SetCode(testCounter, counterCode)
+	for i := byte(1); i <= 10; i++ {
+		sdb.SetState(testCounter, common.Hash{31: i}, common.Hash{31: i * 2})
+	}
+	root := commitParallelTestState(t, db, stateDB, sdb, 1)
+
+	header := &ethtypes.Header{Number: big.NewInt(1), Time: 1, Difficulty: big.NewInt(1), Root: root}
+	block := ethtypes.NewBlockWithHeader(header)
+	raw, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		if err := store.PutContainer(tx, db, block.Hash(), 1, raw); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetHeadBlock(tx, db, 1); err != nil {
+			t.Fatal(err)
+		}
+		if tamper != nil {
+			tamper(tx, db)
+		}
+	})
+	return root
+}
+
+// snapshotTestTables are the snapshot tables and the trie tables.
+func snapshotTestTables(db *store.DB) []snapshotTable {
+	return append(snapshotTables(db),
+		snapshotTable{store.TableAccountTrie, db.AccountTrie},
+		snapshotTable{store.TableStorageTrie, db.StorageTrie},
+	)
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	srcDir, snapDir, dstDir := t.TempDir(), filepath.Join(t.TempDir(), "snap"), t.TempDir()
+	root := writeSnapshotTestDB(t, srcDir, nil)
+	if err := exportSnapshot(srcDir, snapDir, 1<<10, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Trie nodes an interrupted import left are dropped.
+	db, err := store.Open(dstDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		if err := tx.Put(db.AccountTrie, []byte{1}, []byte{2}, 0); err != nil {
+			t.Fatal(err)
+		}
+	})
+	db.Close()
+
+	if err := importSnapshot(dstDir, snapDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rebuilt trie nodes match the ones the source's executor wrote.
+	src, dst := dumpTestTables(t, srcDir, snapshotTestTables, nil), dumpTestTables(t, dstDir, snapshotTestTables, nil)
+	for name, entries := range src {
+		if len(entries) == 0 && name != store.TableStorageTrie {
+			t.Fatalf("source %s is empty", name)
+		}
+		if !maps.Equal(entries, dst[name]) {
+			t.Fatalf("imported %s differs: %d entries, source %d", name, len(dst[name]), len(entries))
+		}
+	}
+
+	db, err = store.Open(dstDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+		if head, ok := store.GetHeadBlock(tx, db); !ok || head != 1 {
+			t.Fatalf("head = %d, %v", head, ok)
+		}
+	})
+
+	// The next block hashes incrementally on the rebuilt nodes.
+	next := applySnapshotTestBlock(t, db, root)
+	srcDB, err := store.Open(srcDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srcDB.Close()
+	if want := applySnapshotTestBlock(t, srcDB, root); next != want {
+		t.Fatalf("block 2 root on the imported state %x, on the source %x", next, want)
+	}
+}
+
+// applySnapshotTestBlock changes an account and a slot on top of the state
+// at root as block 2 and returns the new root.
+func applySnapshotTestBlock(t *testing.T, db *store.DB, root common.Hash) common.Hash {
+	t.Helper()
+	stateDB := statetrie.NewDatabase(db)
+	sdb, err := state.New(root, stateDB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sdb.SetBalance(common.Address{19: 1}, uint256.NewInt(5))
+	sdb.SetState(testCounter, common.Hash{31: 3}, common.Hash{31: 0xff})
+	return commitParallelTestState(t, db, stateDB, sdb, 2)
+}
+
+// The tampered tables all leave the state root unchanged, so only the
+// cross-checks catch them.
+func TestSnapshotImportRefusesInconsistentState(t *testing.T) {
+	counterCodeHash := crypto.Keccak256(counterCode)
+	for _, tc := range []struct {
+		name   string
+		tamper func(tx *mdbx.Txn, db *store.DB)
+	}{
+		{"flat account", func(tx *mdbx.Txn, db *store.DB) {
+			acct := &store.Account{Nonce: 99, CodeHash: store.EmptyCodeHash, StorageRoot: ethtypes.EmptyRootHash}
+			if err := tx.Put(db.AccountState, common.Address{19: 1}.Bytes(), store.EncodeAccountBytes(acct), 0); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"flat storage", func(tx *mdbx.Txn, db *store.DB) {
+			if err := store.PutStorage(tx, db, testCounter, common.Hash{31: 1}, common.Hash{31: 7}); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"code", func(tx *mdbx.Txn, db *store.DB) {
+			if err := tx.Put(db.Code, counterCodeHash, []byte{0x00}, 0); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"missing code", func(tx *mdbx.Txn, db *store.DB) {
+			if err := tx.Del(db.Code, counterCodeHash, nil); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srcDir, snapDir := t.TempDir(), filepath.Join(t.TempDir(), "snap")
+			writeSnapshotTestDB(t, srcDir, tc.tamper)
+			if err := exportSnapshot(srcDir, snapDir, 1<<10, true); err != nil {
+				t.Fatal(err)
+			}
+			if err := importSnapshot(t.TempDir(), snapDir); err == nil {
+				t.Fatal("imported inconsistent state")
+			}
+		})
+	}
+}
@@ -103,7 +103,7 @@ func (t *AccountTrie) GetAccount(address common.Address) (*types.StateAccount, e
 	copy(addr[:], address[:])
 
 	var storeAcct *store.Account
-	if t.stateDB != nil && t.stateDB.historicalBlock > 0 {
+	if t.stateDB != nil && t.stateDB.historical {
 		storeAcct, err = store.LookupHistoricalAccount(tx, t.db, addr, t.stateDB.historicalBlock)
 	} else if t.stateDB != nil && t.stateDB.Overlay != nil {
 		storeAcct, err = t.stateDB.Overlay.GetAccount(tx, t.db, addr)
@@ -117,18 +117,22 @@ func (t *AccountTrie) GetAccount(address common.Address) (*types.StateAccount, e
 		return nil, nil
 	}
 
-	// Convert from store.Account to types.StateAccount.
-	sa := &types.StateAccount{
-		Nonce:    storeAcct.Nonce,
-		Balance:  new(uint256.Int).SetBytes32(storeAcct.Balance[:]),
-		Root:     common.Hash(storeAcct.StorageRoot),
-		CodeHash: storeAcct.CodeHash[:],
-	}
 	// Track isMultiCoin separately — the Extra system can't be set externally.
 	if storeAcct.IsMultiCoin {
 		t.isMultiCoin[address] = true
 	}
-	return sa, nil
+	return toStateAccount(storeAcct), nil
+}
+
+// toStateAccount converts a stored account to the form the StateDB works
+// with. The isMultiCoin flag is left out; see AccountTrie.isMultiCoin.
+func toStateAccount(a *store.Account) *types.StateAccount {
+	return &types.StateAccount{
+		Nonce:    a.Nonce,
+		Balance:  new(uint256.Int).SetBytes32(a.Balance[:]),
+		Root:     common.Hash(a.StorageRoot),
+		CodeHash: a.CodeHash[:],
+	}
 }
 
 // UpdateAccount stores an account in the dirty map.
@@ -312,7 +316,29 @@ func (t *AccountTrie) NodeIterator(startKey []byte) (trie.NodeIterator, error) {
 	return nil, errors.New("NodeIterator not supported")
 }
 
-// Prove is not supported.
+// Prove writes the Merkle proof for the account at address key into proofDb,
+// keyed by node hash. Works on head and historical state; batch overlays and
+// uncommitted changes are rejected.
 func (t *AccountTrie) Prove(key []byte, proofDb ethdb.KeyValueWriter) error {
-	return errors.New("Prove not supported")
+	if t.stateDB != nil && t.stateDB.Overlay != nil {
+		return errors.New("Prove not supported in batch mode")
+	}
+	if len(t.dirtyAccounts) > 0 || len(t.deletedAccounts) > 0 {
+		return errors.New("Prove not supported with uncommitted changes")
+	}
+	tx, done, err := t.getROTx()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	blockNum, _ := store.GetHeadBlock(tx, t.db)
+	if t.stateDB != nil && t.stateDB.historical {
+		blockNum = t.stateDB.historicalBlock
+	}
+	proof, err := ProveAccount(tx, t.db, blockNum, [20]byte(common.BytesToAddress(key)), nil)
+	if err != nil {
+		return err
+	}
+	return writeProof(proofDb, proof.AccountProof)
 }
@@ -27,7 +27,9 @@ type Database struct {
 	ethKV  ethdb.KeyValueStore
 	trieDB *triedb.Database
 
-	// Historical mode: if > 0, reads return state at this block instead of head.
+	// Historical mode: when set, reads return state as of historicalBlock
+	// (after it executed) instead of head. Block 0 means genesis state.
+	historical      bool
 	historicalBlock uint64
 
 	// Batch overlay: when set, all reads/writes go through here instead of MDBX.
@@ -63,6 +65,7 @@ func NewDatabase(mdbxDB *store.DB) *Database {
 // as of a specific block number. Used for eth_call at past blocks.
 func NewHistoricalDatabase(mdbxDB *store.DB, blockNum uint64) *Database {
 	db := NewDatabase(mdbxDB)
+	db.historical = true
 	db.historicalBlock = blockNum
 	return db
 }
@@ -0,0 +1,147 @@
+package statetrie
+
+import (
+	"testing"
+
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+// testWrite is one state change of a test block: an account, or a storage
+// slot if slot is set. A nil account deletes the account; a zero value
+// deletes the slot.
+type testWrite struct {
+	addr    [20]byte
+	account *store.Account
+	slot    *[32]byte
+	value   [32]byte
+}
+
+func testAddr(b byte) [20]byte { return [20]byte{19: b} }
+
+func testSlot(b byte) *[32]byte { return &[32]byte{31: b} }
+
+func testAccount(nonce uint64, balance byte) *store.Account {
+	return &store.Account{
+		Nonce:       nonce,
+		Balance:     [32]byte{31: balance},
+		CodeHash:    store.EmptyCodeHash,
+		StorageRoot: store.EmptyRootHash,
+	}
+}
+
+// applyTestBlock applies writes as block blockNum, the way the executor
+// flushes a one-block batch: changesets captured through the overlay,
+// hashed state flushed, the trie hashed incrementally and head moved. It
+// returns the state root, checked against a full rehash.
+func applyTestBlock(t *testing.T, db *store.DB, blockNum uint64, writes []testWrite) [32]byte {
+	t.Helper()
+	var root [32]byte
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		overlay := NewBatchOverlay()
+		var changes []RawChange
+		for _, w := range writes {
+			if w.slot != nil {
+				old, err := overlay.GetStorage(tx, db, w.addr, *w.slot)
+				if err != nil {
+					t.Fatal(err)
+				}
+				changes = append(changes, RawChange{Addr: w.addr, Slot: *w.slot, OldValue: trimLeadingZerosBytes(old[:])})
+				if w.value == ([32]byte{}) {
+					overlay.DeleteStorage(w.addr, *w.slot)
+				} else {
+					overlay.PutStorage(w.addr, *w.slot, w.value, trimLeadingZerosBytes(w.value[:]))
+				}
+				continue
+			}
+			old, err := overlay.GetAccount(tx, db, w.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var oldValue []byte
+			if old != nil {
+				oldValue = store.EncodeAccountBytes(old)
+			}
+			changes = append(changes, RawChange{Addr: w.addr, Slot: store.AccountSentinelSlot, OldValue: oldValue})
+			if w.account == nil {
+				overlay.DeleteAccount(w.addr)
+				continue
+			}
+			acct := *w.account
+			if old != nil {
+				acct.StorageRoot = old.StorageRoot
+			}
+			overlay.PutAccount(w.addr, store.EncodeAccountBytes(&acct))
+		}
+		overlay.AddRawChangeset(blockNum, changes)
+
+		oldRoots := ReadOldStorageRoots(tx, db, overlay.ChangedAccountHashes())
+		if err := overlay.FlushStateToTx(tx, db); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		if root, _, err = ComputeIncrementalStateRoot(tx, db, overlay, oldRoots); err != nil {
+			t.Fatal(err)
+		}
+		full, err := ComputeFullStateRoot(tx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if full != root {
+			t.Fatalf("block %d: incremental root %x, full root %x", blockNum, root, full)
+		}
+		if err := store.SetHeadBlock(tx, db, blockNum); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return root
+}
+
+// testBlocks are five blocks exercising account creation, update and
+// deletion and storage writes, overwrites and deletes.
+var testBlocks = [][]testWrite{
+	{
+		{addr: testAddr(1), account: testAccount(0, 100)},
+		{addr: testAddr(2), account: testAccount(0, 50)},
+		{addr: testAddr(2), slot: testSlot(1), value: [32]byte{31: 7}},
+		{addr: testAddr(2), slot: testSlot(2), value: [32]byte{31: 8}},
+	},
+	{
+		{addr: testAddr(1), account: testAccount(1, 90)},
+		{addr: testAddr(3), account: testAccount(0, 10)},
+		{addr: testAddr(2), slot: testSlot(1), value: [32]byte{30: 1}},
+	},
+	{
+		{addr: testAddr(2), account: testAccount(1, 50)},
+		{addr: testAddr(2), slot: testSlot(2)},
+		{addr: testAddr(2), slot: testSlot(3), value: [32]byte{0: 0xff, 31: 1}},
+	},
+	{
+		{addr: testAddr(3)},
+		{addr: testAddr(4), account: testAccount(0, 1)},
+		{addr: testAddr(4), slot: testSlot(1), value: [32]byte{31: 1}},
+	},
+	{
+		{addr: testAddr(1), account: testAccount(2, 80)},
+		{addr: testAddr(2), slot: testSlot(1)},
+		{addr: testAddr(4), slot: testSlot(1), value: [32]byte{31: 2}},
+	},
+}
+
+// buildTestChain applies blocks to db as blocks 1, 2, ... and returns the
+// state root after each, roots[0] being the empty state.
+func buildTestChain(t *testing.T, db *store.DB, blocks [][]testWrite) [][32]byte {
+	t.Helper()
+	roots := [][32]byte{store.EmptyRootHash}
+	for i, writes := range blocks {
+		roots = append(roots, applyTestBlock(t, db, uint64(i+1), writes))
+	}
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		if _, err := store.ReverseKeyDict(tx, db, -1); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return roots
+}
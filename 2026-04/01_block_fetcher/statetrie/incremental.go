@@ -3,6 +3,7 @@ package statetrie
 import (
 	"bytes"
 	"encoding/hex"
+	"fmt"
 	"log"
 	"os"
 	"sort"
@@ -907,6 +908,79 @@ func ComputeFullStateRoot(tx *mdbx.Txn, db *store.DB) ([32]byte, error) {
 	return acctHB.Root(), nil
 }
 
+// RebuildStateTrie rebuilds AccountTrie and StorageTrie from scratch from the
+// hashed state, writes every branch node and returns the state root. Each
+// storage root must match the one stored in the account. The trie tables must
+// be empty: stale nodes would be trusted by the walker.
+func RebuildStateTrie(tx *mdbx.Txn, db *store.DB) ([32]byte, error) {
+	for _, dbi := range []mdbx.DBI{db.AccountTrie, db.StorageTrie} {
+		stat, err := tx.StatDBI(dbi)
+		if err != nil {
+			return [32]byte{}, err
+		}
+		if stat.Entries != 0 {
+			return [32]byte{}, fmt.Errorf("trie table holds %d nodes", stat.Entries)
+		}
+	}
+
+	cursor, err := tx.OpenCursor(db.HashedStorageState)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	defer cursor.Close()
+	k, _, err := cursor.Get(nil, nil, mdbx.First)
+	for err == nil && len(k) >= 64 {
+		addrHash := [32]byte(k[:32])
+		root, updates, _, cerr := computeTrieRoot(tx, db.StorageTrie, db.HashedStorageState, addrHash[:], intTrie.NewPrefixSetBuilder().Build(), true, nil)
+		if cerr != nil {
+			return [32]byte{}, cerr
+		}
+		for packedPath, node := range updates {
+			if node == nil {
+				continue
+			}
+			if err := tx.Put(db.StorageTrie, append(addrHash[:], packedPath...), node.Encode(), 0); err != nil {
+				return [32]byte{}, err
+			}
+		}
+		acct, aerr := tx.Get(db.HashedAccountState, addrHash[:])
+		if aerr != nil {
+			return [32]byte{}, fmt.Errorf("account %x of stored storage: %w", addrHash, aerr)
+		}
+		if len(acct) < 104 || !bytes.Equal(acct[72:104], root[:]) {
+			return [32]byte{}, fmt.Errorf("account %x storage hashes to %x, not to its stored storage root", addrHash, root)
+		}
+		// Skip to the next account.
+		next := addrHash
+		for i := len(next) - 1; i >= 0; i-- {
+			if next[i]++; next[i] != 0 {
+				break
+			}
+		}
+		if next == ([32]byte{}) {
+			break
+		}
+		k, _, err = cursor.Get(next[:], nil, mdbx.SetRange)
+	}
+	if err != nil && !mdbx.IsNotFound(err) {
+		return [32]byte{}, err
+	}
+
+	root, updates, _, err := computeTrieRoot(tx, db.AccountTrie, db.HashedAccountState, nil, intTrie.NewPrefixSetBuilder().Build(), false, nil)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	for packedPath, node := range updates {
+		if node == nil {
+			continue
+		}
+		if err := tx.Put(db.AccountTrie, []byte(packedPath), node.Encode(), 0); err != nil {
+			return [32]byte{}, err
+		}
+	}
+	return root, nil
+}
+
 // computeFullAccountRoot computes the account trie root directly from the
 // current HashedAccountState contents in this transaction.
 // Any storage-root patching for changed accounts must already be applied.
@@ -954,6 +1028,13 @@ func (s *singleLeafSource) SeekTo(key []byte) error {
 	return nil
 }
 
+// ComputeStorageRoot computes the storage root of the account with hashed
+// address addrHash from scratch, from its HashedStorageState entries. Stored
+// StorageTrie nodes are not read.
+func ComputeStorageRoot(tx *mdbx.Txn, db *store.DB, addrHash [32]byte) [32]byte {
+	return computeFullStorageRoot(tx, db, addrHash)
+}
+
 // computeFullStorageRoot scans ALL storage for an account and hashes from scratch.
 func computeFullStorageRoot(tx *mdbx.Txn, db *store.DB, addrHash [32]byte) [32]byte {
 	cursor, err := tx.OpenCursor(db.HashedStorageState)
@@ -0,0 +1,91 @@
+package statetrie
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/ava-labs/libevm/crypto"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+// readTestTable returns the entries of dbi.
+func readTestTable(t *testing.T, tx *mdbx.Txn, dbi mdbx.DBI) map[string]string {
+	t.Helper()
+	cursor, err := tx.OpenCursor(dbi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cursor.Close()
+	entries := make(map[string]string)
+	k, v, err := cursor.Get(nil, nil, mdbx.First)
+	for ; err == nil; k, v, err = cursor.Get(nil, nil, mdbx.Next) {
+		entries[string(k)] = string(v)
+	}
+	if !mdbx.IsNotFound(err) {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// A rebuild from scratch writes the nodes the incremental hashing left after
+// the same blocks.
+func TestRebuildStateTrie(t *testing.T) {
+	db := storetest.Open(t, store.Open)
+	// The test blocks' tries are too small to store branch nodes; a block
+	// with a few hundred accounts and slots adds some.
+	var wide []testWrite
+	for i := 0; i < 200; i++ {
+		wide = append(wide,
+			testWrite{addr: [20]byte{0: byte(i), 19: 0xee}, account: testAccount(1, byte(i))},
+			testWrite{addr: testAddr(2), slot: &[32]byte{0: byte(i), 31: 0xee}, value: [32]byte{31: byte(i) | 1}},
+		)
+	}
+	roots := buildTestChain(t, db, append(testBlocks, wide))
+
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		if _, err := RebuildStateTrie(tx, db); err == nil {
+			t.Fatal("rebuilt over stored trie nodes")
+		}
+
+		accountNodes, storageNodes := readTestTable(t, tx, db.AccountTrie), readTestTable(t, tx, db.StorageTrie)
+		if len(accountNodes) == 0 || len(storageNodes) == 0 {
+			t.Fatalf("%d account and %d storage trie nodes to compare", len(accountNodes), len(storageNodes))
+		}
+		for _, dbi := range []mdbx.DBI{db.AccountTrie, db.StorageTrie} {
+			if err := tx.Drop(dbi, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		root, err := RebuildStateTrie(tx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := roots[len(roots)-1]; root != want {
+			t.Fatalf("rebuilt root %x, want %x", root, want)
+		}
+		if got := readTestTable(t, tx, db.AccountTrie); !maps.Equal(got, accountNodes) {
+			t.Errorf("rebuilt %d account trie nodes, incremental hashing left %d", len(got), len(accountNodes))
+		}
+		if got := readTestTable(t, tx, db.StorageTrie); !maps.Equal(got, storageNodes) {
+			t.Errorf("rebuilt %d storage trie nodes, incremental hashing left %d", len(got), len(storageNodes))
+		}
+
+		// A storage root the account does not store is refused.
+		for _, dbi := range []mdbx.DBI{db.AccountTrie, db.StorageTrie} {
+			if err := tx.Drop(dbi, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		addr := testAddr(4)
+		addrHash, slotHash := [32]byte(crypto.Keccak256(addr[:])), [32]byte(crypto.Keccak256(testSlot(9)[:]))
+		if err := store.PutHashedStorage(tx, db, addrHash, slotHash, []byte{1}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := RebuildStateTrie(tx, db); err == nil {
+			t.Fatal("rebuilt over a storage root the account does not store")
+		}
+	})
+}
@@ -51,9 +51,6 @@ type BatchOverlay struct {
 	// Receipts per block (receipts contain logs), captured during execution.
 	blockReceipts map[uint64][]store.TxReceipt
 
-	// Transaction hash index: txHash → (blockNum, txIndex).
-	txHashes []TxHashEntry
-
 	// Block hash index: blockHash → blockNum.
 	blockHashes []BlockHashEntry
 
@@ -62,13 +59,6 @@ type BatchOverlay struct {
 	DebugStep1Roots  map[[32]byte][32]byte
 }
 
-// TxHashEntry records a tx hash and its location.
-type TxHashEntry struct {
-	TxHash   [32]byte
-	BlockNum uint64
-	TxIndex  uint16
-}
-
 // BlockHashEntry records a block hash and its number.
 type BlockHashEntry struct {
 	BlockHash [32]byte
@@ -171,13 +161,6 @@ func (o *BatchOverlay) AddBlockReceipts(blockNum uint64, receipts []store.TxRece
 	o.mu.Unlock()
 }
 
-// AddTxHash records a transaction hash and its location.
-func (o *BatchOverlay) AddTxHash(txHash [32]byte, blockNum uint64, txIndex uint16) {
-	o.mu.Lock()
-	o.txHashes = append(o.txHashes, TxHashEntry{TxHash: txHash, BlockNum: blockNum, TxIndex: txIndex})
-	o.mu.Unlock()
-}
-
 // AddBlockHash records a block hash and its number.
 func (o *BatchOverlay) AddBlockHash(blockHash [32]byte, blockNum uint64) {
 	o.mu.Lock()
@@ -360,8 +343,10 @@ func (o *BatchOverlay) ChangedStoragePlain() map[[20]byte]map[[32]byte][]byte {
 	return result
 }
 
-// FlushStateToTx writes all state (accounts, storage, hashed state, code, changesets)
-// to the given RW transaction. Does NOT set head block or commit.
+// FlushStateToTx writes all state (accounts, storage, hashed state, code),
+// changesets, receipts and block hashes to the given RW transaction. The
+// history, log and tx hash indexes are left to the background indexer (see
+// store.BuildIndex). Does NOT set head block or commit.
 func (o *BatchOverlay) FlushStateToTx(tx *mdbx.Txn, db *store.DB) error {
 	t0 := time.Now()
 
@@ -409,8 +394,7 @@ func (o *BatchOverlay) FlushStateToTx(tx *mdbx.Txn, db *store.DB) error {
 
 	t1 := time.Now()
 	// Convert raw changesets to store.Change (with keyID assignment) and write.
-	// Accumulate history index updates per keyID for batched flush.
-	historyPending := make(map[uint64][]uint64, 4096)
+	// HistoryIndex is built from them later by the background indexer.
 	for blockNum, rawChanges := range o.rawChangesets {
 		if len(rawChanges) == 0 {
 			continue
@@ -426,19 +410,11 @@ func (o *BatchOverlay) FlushStateToTx(tx *mdbx.Txn, db *store.DB) error {
 		if err := store.WriteChangeset(tx, db, blockNum, changes); err != nil {
 			return err
 		}
-		for _, c := range changes {
-			historyPending[c.KeyID] = append(historyPending[c.KeyID], blockNum)
-		}
 	}
 	t2 := time.Now()
-	if err := store.FlushHistoryIndexBatch(tx, db, historyPending); err != nil {
-		return fmt.Errorf("flush history index batch: %w", err)
-	}
-	t3 := time.Now()
 
-	// Write block receipts and accumulate log index updates for batched flush.
-	addrLogPending := make(map[string][]uint64, 4096)
-	topicLogPending := make(map[string][]uint64, 4096)
+	// Write block receipts. The log and tx hash indexes are built from them
+	// later by the background indexer.
 	for blockNum, receipts := range o.blockReceipts {
 		if len(receipts) == 0 {
 			continue
@@ -446,40 +422,8 @@ func (o *BatchOverlay) FlushStateToTx(tx *mdbx.Txn, db *store.DB) error {
 		if err := store.WriteBlockReceipts(tx, db, blockNum, receipts); err != nil {
 			return fmt.Errorf("write block receipts at block %d: %w", blockNum, err)
 		}
-		seen := make(map[[20]byte]bool)
-		seenTopics := make(map[[32]byte]bool)
-		for _, r := range receipts {
-			for _, l := range r.Logs {
-				if !seen[l.Address] {
-					seen[l.Address] = true
-					addrLogPending[string(l.Address[:])] = append(addrLogPending[string(l.Address[:])], blockNum)
-				}
-				for _, t := range l.Topics {
-					if !seenTopics[t] {
-						seenTopics[t] = true
-						topicLogPending[string(t[:])] = append(topicLogPending[string(t[:])], blockNum)
-					}
-				}
-			}
-		}
-	}
-	t4 := time.Now()
-	if err := store.FlushLogIndexBatch(tx, db.AddressLogIndex, addrLogPending); err != nil {
-		return fmt.Errorf("flush address log index batch: %w", err)
-	}
-	if err := store.FlushLogIndexBatch(tx, db.TopicLogIndex, topicLogPending); err != nil {
-		return fmt.Errorf("flush topic log index batch: %w", err)
-	}
-	t5 := time.Now()
-
-	// Write tx hash index — single cursor, sorted by hash.
-	storeEntries := make([]store.TxHashEntry, len(o.txHashes))
-	for i, e := range o.txHashes {
-		storeEntries[i] = store.TxHashEntry{TxHash: e.TxHash, BlockNum: e.BlockNum, TxIndex: e.TxIndex}
-	}
-	if err := store.FlushTxHashBatch(tx, db, storeEntries); err != nil {
-		return fmt.Errorf("flush tx hash batch: %w", err)
 	}
+	t3 := time.Now()
 
 	// Write block hash → block number index — single cursor.
 	{
@@ -498,15 +442,12 @@ func (o *BatchOverlay) FlushStateToTx(tx *mdbx.Txn, db *store.DB) error {
 		cursor.Close()
 	}
 
-	t6 := time.Now()
-	log.Printf("flush-breakdown: state=%s changesets=%s histIdx=%s receipts=%s logIdx=%s txIdx=%s histKeys=%d addrKeys=%d topicKeys=%d",
+	t4 := time.Now()
+	log.Printf("flush-breakdown: state=%s changesets=%s receipts=%s blockHashIdx=%s",
 		t1.Sub(t0).Truncate(time.Millisecond),
 		t2.Sub(t1).Truncate(time.Millisecond),
 		t3.Sub(t2).Truncate(time.Millisecond),
-		t4.Sub(t3).Truncate(time.Millisecond),
-		t5.Sub(t4).Truncate(time.Millisecond),
-		t6.Sub(t5).Truncate(time.Millisecond),
-		len(historyPending), len(addrLogPending), len(topicLogPending))
+		t4.Sub(t3).Truncate(time.Millisecond))
 
 	return nil
 }
@@ -0,0 +1,136 @@
+package statetrie
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/common/hexutil"
+	"github.com/ava-labs/libevm/core/state"
+	"github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/core/vm"
+	"github.com/holiman/uint256"
+)
+
+// OverrideAccount replaces parts of one account for the duration of a call.
+// The JSON layout matches go-ethereum's eth_call state override set.
+type OverrideAccount struct {
+	Nonce     *hexutil.Uint64             `json:"nonce"`
+	Code      *hexutil.Bytes              `json:"code"`
+	Balance   *hexutil.Big                `json:"balance"`
+	State     map[common.Hash]common.Hash `json:"state"`     // replaces all storage
+	StateDiff map[common.Hash]common.Hash `json:"stateDiff"` // patches single slots
+}
+
+// StateOverride is the set of accounts to override before a call.
+type StateOverride map[common.Address]OverrideAccount
+
+// Apply writes the overrides into statedb. A nil set is a no-op.
+func (o *StateOverride) Apply(statedb *state.StateDB) error {
+	if o == nil {
+		return nil
+	}
+	for addr, account := range *o {
+		if account.State != nil && account.StateDiff != nil {
+			return fmt.Errorf("account %s has both 'state' and 'stateDiff'", addr.Hex())
+		}
+		if account.Nonce != nil {
+			statedb.SetNonce(addr, uint64(*account.Nonce))
+		}
+		if account.Code != nil {
+			statedb.SetCode(addr, *account.Code)
+		}
+		if account.Balance != nil {
+			balance, overflow := uint256.FromBig(account.Balance.ToInt())
+			if overflow {
+				return fmt.Errorf("account %s balance overflows 256 bits", addr.Hex())
+			}
+			statedb.SetBalance(addr, balance)
+		}
+		if account.State != nil {
+			statedb.SetStorage(addr, account.State)
+		}
+		for key, value := range account.StateDiff {
+			statedb.SetState(addr, key, value)
+		}
+	}
+	// Finalise as between transactions, as go-ethereum does: the overrides
+	// become the call's committed state, so SSTORE gas and refunds are
+	// priced against the overridden values rather than the stored ones.
+	statedb.Finalise(false)
+	return nil
+}
+
+// BlockOverrides replaces fields of the block context a call runs in. Both
+// the eth_call names (coinbase, random) and the eth_simulateV1 names
+// (feeRecipient, prevRandao) are accepted.
+type BlockOverrides struct {
+	Number       *hexutil.Big    `json:"number"`
+	Difficulty   *hexutil.Big    `json:"difficulty"`
+	Time         *hexutil.Uint64 `json:"time"`
+	GasLimit     *hexutil.Uint64 `json:"gasLimit"`
+	Coinbase     *common.Address `json:"coinbase"`
+	FeeRecipient *common.Address `json:"feeRecipient"`
+	Random       *common.Hash    `json:"random"`
+	PrevRandao   *common.Hash    `json:"prevRandao"`
+	BaseFee      *hexutil.Big    `json:"baseFee"`
+}
+
+// Apply writes the overrides into blockCtx. The context's header is copied
+// and patched too, so fork rules and Avalanche hooks that read it see the
+// overridden number and time. A nil set is a no-op.
+func (o *BlockOverrides) Apply(blockCtx *vm.BlockContext) {
+	if o == nil {
+		return
+	}
+	var header *types.Header
+	if blockCtx.Header != nil {
+		header = types.CopyHeader(blockCtx.Header)
+		blockCtx.Header = header
+	}
+	if o.Number != nil {
+		blockCtx.BlockNumber = new(big.Int).Set(o.Number.ToInt())
+		if header != nil {
+			header.Number = new(big.Int).Set(o.Number.ToInt())
+		}
+	}
+	if o.Difficulty != nil {
+		blockCtx.Difficulty = new(big.Int).Set(o.Difficulty.ToInt())
+	}
+	if o.Time != nil {
+		blockCtx.Time = uint64(*o.Time)
+		if header != nil {
+			header.Time = uint64(*o.Time)
+		}
+	}
+	if o.GasLimit != nil {
+		blockCtx.GasLimit = uint64(*o.GasLimit)
+		if header != nil {
+			header.GasLimit = uint64(*o.GasLimit)
+		}
+	}
+	coinbase := o.Coinbase
+	if o.FeeRecipient != nil {
+		coinbase = o.FeeRecipient
+	}
+	if coinbase != nil {
+		blockCtx.Coinbase = *coinbase
+		if header != nil {
+			header.Coinbase = *coinbase
+		}
+	}
+	random := o.Random
+	if o.PrevRandao != nil {
+		random = o.PrevRandao
+	}
+	if random != nil {
+		r := *random
+		blockCtx.Random = &r
+	}
+	if o.BaseFee != nil {
+		blockCtx.BaseFee = new(big.Int).Set(o.BaseFee.ToInt())
+		if header != nil {
+			header.BaseFee = new(big.Int).Set(o.BaseFee.ToInt())
+		}
+	}
+}
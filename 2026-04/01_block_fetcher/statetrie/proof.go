@@ -0,0 +1,343 @@
+package statetrie
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/ava-labs/libevm/crypto"
+	"github.com/ava-labs/libevm/ethdb"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+	intTrie "block_fetcher/trie"
+)
+
+// AccountProof is the raw material of an eth_getProof response: an account
+// and some of its storage slots as of the state after one block.
+type AccountProof struct {
+	// StateRoot is the account trie root the proofs were built against.
+	// Callers must check it against the block header's stateRoot.
+	StateRoot [32]byte
+
+	Account      *store.Account // nil if the account doesn't exist
+	AccountProof [][]byte       // RLP nodes, root first
+
+	StorageValues [][32]byte
+	StorageProofs [][][]byte
+}
+
+// ProveAccount builds Merkle proofs for addr and slots against the state after
+// blockNum, which must not be beyond the current head.
+//
+// Proofs are rebuilt from the stored AccountTrie/StorageTrie branch nodes and
+// the hashed state tables with the usual walker/NodeIter/HashBuilder pipeline,
+// so only the subtrees on the proven paths are re-hashed. For a past block the
+// current state is rolled back first: every key changed after blockNum (read
+// from Changesets) is overridden with its old value and added to the prefix
+// set, which forces the walker off any cached ref those changes invalidated.
+// Blocks more than maxStateDiffBlocks below head are refused.
+func ProveAccount(tx *mdbx.Txn, db *store.DB, blockNum uint64, addr [20]byte, slots [][32]byte) (*AccountProof, error) {
+	head, _ := store.GetHeadBlock(tx, db)
+	if blockNum > head {
+		return nil, fmt.Errorf("block %d is beyond head %d", blockNum, head)
+	}
+	if head-blockNum > maxStateDiffBlocks {
+		return nil, fmt.Errorf("block %d is more than %d blocks below head %d; proofs are built by rolling the state back", blockNum, maxStateDiffBlocks, head)
+	}
+	diff, err := loadStateDiff(tx, db, blockNum, head, maxStateDiffKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	addrHash := crypto.Keccak256Hash(addr[:])
+	slotHashes := make([][32]byte, len(slots))
+	for i, s := range slots {
+		slotHashes[i] = crypto.Keccak256Hash(s[:])
+	}
+	out := &AccountProof{
+		StorageValues: make([][32]byte, len(slots)),
+		StorageProofs: make([][][]byte, len(slots)),
+	}
+
+	// Roll back storage roots first: the account leaves need them.
+	storageRoots := make(map[[20]byte][32]byte, len(diff.storage))
+	for a, slotDiff := range diff.storage {
+		acct, err := diff.account(tx, db, a)
+		if err != nil {
+			return nil, err
+		}
+		if acct == nil {
+			continue
+		}
+		var targets [][32]byte
+		if a == addr {
+			targets = slotHashes
+		}
+		root, proofs, err := diff.hashStorage(tx, db, a, slotDiff, targets)
+		if err != nil {
+			return nil, fmt.Errorf("storage root of %x at block %d: %w", a, blockNum, err)
+		}
+		storageRoots[a] = root
+		if a == addr {
+			out.StorageProofs = proofs
+		}
+	}
+
+	overrides := make([]leafOverride, 0, len(diff.accounts)+len(diff.storage))
+	seen := make(map[[20]byte]bool, len(diff.accounts)+len(diff.storage))
+	addOverride := func(a [20]byte) error {
+		if seen[a] {
+			return nil
+		}
+		seen[a] = true
+		acct, err := diff.account(tx, db, a)
+		if err != nil {
+			return err
+		}
+		h := crypto.Keccak256Hash(a[:])
+		if acct == nil {
+			overrides = append(overrides, leafOverride{key: h[:]})
+			return nil
+		}
+		if root, ok := storageRoots[a]; ok {
+			acct.StorageRoot = root
+		}
+		overrides = append(overrides, leafOverride{key: h[:], value: store.EncodeAccountBytes(acct)})
+		return nil
+	}
+	for a := range diff.accounts {
+		if err := addOverride(a); err != nil {
+			return nil, err
+		}
+	}
+	for a := range diff.storage {
+		if err := addOverride(a); err != nil {
+			return nil, err
+		}
+	}
+
+	root, proofs, err := hashTrie(tx, db.AccountTrie, db.HashedAccountState, nil, false, true, [][32]byte{addrHash}, overrides)
+	if err != nil {
+		return nil, fmt.Errorf("account trie: %w", err)
+	}
+	out.StateRoot = root
+	out.AccountProof = proofs[0]
+
+	out.Account, err = diff.account(tx, db, addr)
+	if err != nil {
+		return nil, err
+	}
+	if out.Account == nil {
+		return out, nil
+	}
+	if root, ok := storageRoots[addr]; ok {
+		out.Account.StorageRoot = root
+	} else if out.Account.StorageRoot != store.EmptyRootHash && len(slots) > 0 {
+		// Storage unchanged since blockNum: prove against the current trie.
+		sroot, proofs, err := hashTrie(tx, db.StorageTrie, db.HashedStorageState, addrHash[:], true, true, slotHashes, nil)
+		if err != nil {
+			return nil, fmt.Errorf("storage trie: %w", err)
+		}
+		if sroot != out.Account.StorageRoot {
+			return nil, fmt.Errorf("storage trie root %x does not match account storageRoot %x", sroot, out.Account.StorageRoot)
+		}
+		out.StorageProofs = proofs
+	}
+	for i, s := range slots {
+		if out.StorageValues[i], err = diff.storageValue(tx, db, addr, s); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// writeProof writes the Merkle proof nodes of a single key into proofDb, keyed by
+// node hash — the layout trie.VerifyProof expects.
+func writeProof(proofDb ethdb.KeyValueWriter, proof [][]byte) error {
+	for _, node := range proof {
+		if err := proofDb.Put(crypto.Keccak256(node), node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// leafOverride replaces the hashed-state value at key. A nil value deletes it.
+// Values are raw table encodings (105-byte accounts, trimmed storage words).
+type leafOverride struct {
+	key   []byte
+	value []byte
+}
+
+// hashTrie runs the same walker/NodeIter/HashBuilder pipeline as
+// computeTrieRoot, read-only, returning the root and one proof per target.
+// The targets and overridden keys form the prefix set so the walker expands
+// every subtree they touch. With useStored false the stored branch nodes are
+// ignored and the root is built from leaves alone.
+func hashTrie(
+	tx *mdbx.Txn,
+	trieDBI mdbx.DBI,
+	stateDBI mdbx.DBI,
+	prefix []byte,
+	isStorage bool,
+	useStored bool,
+	keys [][32]byte,
+	overrides []leafOverride,
+) ([32]byte, [][][]byte, error) {
+	targets := make([]intTrie.Nibbles, len(keys))
+	psb := intTrie.NewPrefixSetBuilder()
+	for i, k := range keys {
+		targets[i] = intTrie.FromHex(k[:])
+		psb.AddKey(targets[i])
+	}
+	sort.Slice(overrides, func(i, j int) bool {
+		return bytes.Compare(overrides[i].key, overrides[j].key) < 0
+	})
+	for _, o := range overrides {
+		psb.AddKey(intTrie.FromHex(o.key))
+	}
+
+	trieCursorRaw, err := tx.OpenCursor(trieDBI)
+	if err != nil {
+		return [32]byte{}, nil, err
+	}
+	defer trieCursorRaw.Close()
+
+	var trieCursor intTrie.TrieCursor
+	switch {
+	case !useStored:
+		trieCursor = emptyTrieCursor{}
+	case prefix != nil:
+		trieCursor = NewPrefixedTrieCursor(trieCursorRaw, prefix)
+	default:
+		trieCursor = trieCursorRaw
+	}
+	walker := intTrie.NewTrieWalker(trieCursor, psb.Build())
+
+	stateCursor, err := tx.OpenCursor(stateDBI)
+	if err != nil {
+		return [32]byte{}, nil, err
+	}
+	defer stateCursor.Close()
+
+	var leafSource intTrie.LeafSource = intTrie.NewMDBXLeafSource(stateCursor, prefix)
+	if len(overrides) > 0 {
+		leafSource = &overrideLeafSource{inner: leafSource, overrides: overrides}
+	}
+	if isStorage {
+		leafSource = NewStorageLeafSource(leafSource)
+	} else {
+		leafSource = NewAccountLeafSource(leafSource)
+	}
+
+	iter := intTrie.NewNodeIter(walker, leafSource)
+	hb := intTrie.NewHashBuilder().WithProofRetainer(targets)
+	for {
+		elem, err := iter.Next()
+		if err != nil {
+			return [32]byte{}, nil, fmt.Errorf("walk trie: %w", err)
+		}
+		if elem == nil {
+			break
+		}
+		if elem.IsBranch {
+			hb.AddBranchRef(elem.Key, elem.Ref, elem.ChildNodeStored)
+		} else {
+			hb.AddLeaf(elem.Key, elem.Value)
+		}
+	}
+	root := hb.Root()
+
+	nodes := hb.ProofNodes()
+	proofs := make([][][]byte, len(targets))
+	for i, target := range targets {
+		proofs[i] = intTrie.ProofForKey(nodes, target)
+	}
+	return root, proofs, nil
+}
+
+// overrideLeafSource merges a sorted override list into another LeafSource.
+// Overrides win on equal keys; nil-valued overrides hide the inner entry.
+type overrideLeafSource struct {
+	inner     intTrie.LeafSource
+	overrides []leafOverride
+	pos       int
+
+	// One-entry lookahead on inner. The key is copied because MDBXLeafSource
+	// reuses its key buffer.
+	innerKey  []byte
+	innerVal  []byte
+	buffered  bool
+	innerDone bool
+}
+
+func (s *overrideLeafSource) Next() ([]byte, []byte, error) {
+	for {
+		if !s.buffered && !s.innerDone {
+			k, v, err := s.inner.Next()
+			if err != nil {
+				return nil, nil, err
+			}
+			if k == nil {
+				s.innerDone = true
+			} else {
+				s.innerKey = append(s.innerKey[:0], k...)
+				s.innerVal = v
+				s.buffered = true
+			}
+		}
+		hasOverride := s.pos < len(s.overrides)
+		if !hasOverride && !s.buffered {
+			return nil, nil, nil
+		}
+
+		cmp := 1
+		if !hasOverride {
+			cmp = -1
+		} else if s.buffered {
+			cmp = bytes.Compare(s.innerKey, s.overrides[s.pos].key)
+		}
+		if cmp < 0 {
+			s.buffered = false
+			return append([]byte(nil), s.innerKey...), s.innerVal, nil
+		}
+		o := s.overrides[s.pos]
+		s.pos++
+		if cmp == 0 {
+			s.buffered = false
+		}
+		if o.value == nil {
+			continue
+		}
+		return o.key, o.value, nil
+	}
+}
+
+func (s *overrideLeafSource) SeekTo(key []byte) error {
+	if key == nil {
+		return nil
+	}
+	if !s.innerDone {
+		if err := s.inner.SeekTo(key); err != nil {
+			return err
+		}
+		s.buffered = false
+	}
+	i := sort.Search(len(s.overrides), func(i int) bool {
+		return bytes.Compare(s.overrides[i].key, key) >= 0
+	})
+	if i > s.pos {
+		s.pos = i
+	}
+	return nil
+}
+
+// emptyTrieCursor is a TrieCursor over no stored branch nodes.
+type emptyTrieCursor struct{}
+
+func (emptyTrieCursor) Get(key, val []byte, op uint) ([]byte, []byte, error) {
+	return nil, nil, mdbx.ErrNotFound
+}
+
+func (emptyTrieCursor) Close() {}
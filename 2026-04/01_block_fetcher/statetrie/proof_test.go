@@ -0,0 +1,206 @@
+package statetrie
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/crypto"
+	"github.com/ava-labs/libevm/ethdb/memorydb"
+	ethtrie "github.com/ava-labs/libevm/trie"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+// testState replays testWrites in memory: the expected state at each block.
+type testState struct {
+	accounts map[[20]byte]*store.Account
+	storage  map[[20]byte]map[[32]byte][32]byte
+}
+
+func replayTestBlocks(blocks [][]testWrite) *testState {
+	s := &testState{
+		accounts: make(map[[20]byte]*store.Account),
+		storage:  make(map[[20]byte]map[[32]byte][32]byte),
+	}
+	for _, writes := range blocks {
+		for _, w := range writes {
+			switch {
+			case w.slot != nil:
+				if s.storage[w.addr] == nil {
+					s.storage[w.addr] = make(map[[32]byte][32]byte)
+				}
+				s.storage[w.addr][*w.slot] = w.value
+			case w.account == nil:
+				delete(s.accounts, w.addr)
+			default:
+				s.accounts[w.addr] = w.account
+			}
+		}
+	}
+	return s
+}
+
+func TestProveAccountRollback(t *testing.T) {
+	db := storetest.Open(t, store.Open)
+	roots := buildTestChain(t, db, testBlocks)
+	slots := [][32]byte{*testSlot(1), *testSlot(2), *testSlot(3)}
+
+	for blockNum := range roots {
+		want := replayTestBlocks(testBlocks[:blockNum])
+		for _, addr := range [][20]byte{testAddr(1), testAddr(2), testAddr(3), testAddr(4)} {
+			storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+				proof, err := ProveAccount(tx, db, uint64(blockNum), addr, slots)
+				if err != nil {
+					t.Fatalf("block %d account %x: %v", blockNum, addr, err)
+				}
+				if proof.StateRoot != roots[blockNum] {
+					t.Fatalf("block %d account %x: root %x, want %x", blockNum, addr, proof.StateRoot, roots[blockNum])
+				}
+
+				var leaf []byte
+				if proof.StateRoot != store.EmptyRootHash {
+					leaf = verifyTestProof(t, proof.StateRoot, crypto.Keccak256(addr[:]), proof.AccountProof)
+				}
+				wantAcct := want.accounts[addr]
+				if (leaf != nil) != (wantAcct != nil) || (proof.Account != nil) != (wantAcct != nil) {
+					t.Fatalf("block %d account %x: exists in proof %v, in result %v, want %v",
+						blockNum, addr, leaf != nil, proof.Account != nil, wantAcct != nil)
+				}
+				if wantAcct == nil {
+					return
+				}
+				if proof.Account.Nonce != wantAcct.Nonce || proof.Account.Balance != wantAcct.Balance {
+					t.Fatalf("block %d account %x: nonce %d balance %x, want %d %x",
+						blockNum, addr, proof.Account.Nonce, proof.Account.Balance, wantAcct.Nonce, wantAcct.Balance)
+				}
+				for i, slot := range slots {
+					if got, want := proof.StorageValues[i], want.storage[addr][slot]; got != want {
+						t.Fatalf("block %d account %x slot %x: %x, want %x", blockNum, addr, slot, got, want)
+					}
+					if proof.Account.StorageRoot == store.EmptyRootHash {
+						continue
+					}
+					slotHash := crypto.Keccak256(slot[:])
+					got := verifyTestProof(t, proof.Account.StorageRoot, slotHash, proof.StorageProofs[i])
+					if v := want.storage[addr][slot]; v == ([32]byte{}) {
+						if got != nil {
+							t.Fatalf("block %d account %x slot %x: proof of absence holds %x", blockNum, addr, slot, got)
+						}
+					} else if got == nil {
+						t.Fatalf("block %d account %x slot %x: no value in proof", blockNum, addr, slot)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestProveAccountNeedsReverseKeyDict(t *testing.T) {
+	db := storetest.Open(t, store.Open)
+	for i, writes := range testBlocks {
+		applyTestBlock(t, db, uint64(i+1), writes)
+	}
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+		if _, err := ProveAccount(tx, db, uint64(len(testBlocks)), testAddr(1), nil); err != nil {
+			t.Fatalf("proof at head: %v", err)
+		}
+		_, err := ProveAccount(tx, db, 2, testAddr(1), nil)
+		if err == nil || !strings.Contains(err.Error(), "reverse keyID dictionary") {
+			t.Fatalf("proof below head without the reverse dictionary: err = %v", err)
+		}
+	})
+}
+
+// verifyTestProof checks proof against root and returns the value it proves
+// at key, nil for a proof of absence.
+func verifyTestProof(t *testing.T, root [32]byte, key []byte, proof [][]byte) []byte {
+	t.Helper()
+	proofDb := memorydb.New()
+	if err := writeProof(proofDb, proof); err != nil {
+		t.Fatal(err)
+	}
+	val, err := ethtrie.VerifyProof(common.Hash(root), key, proofDb)
+	if err != nil {
+		t.Fatalf("verify proof of %x against %x: %v", key, root, err)
+	}
+	return val
+}
+
+// sliceLeafSource is a LeafSource over sorted in-memory pairs.
+type sliceLeafSource struct {
+	keys, vals [][]byte
+	pos        int
+}
+
+func (s *sliceLeafSource) Next() ([]byte, []byte, error) {
+	if s.pos >= len(s.keys) {
+		return nil, nil, nil
+	}
+	s.pos++
+	return s.keys[s.pos-1], s.vals[s.pos-1], nil
+}
+
+func (s *sliceLeafSource) SeekTo(key []byte) error {
+	s.pos = sort.Search(len(s.keys), func(i int) bool { return bytes.Compare(s.keys[i], key) >= 0 })
+	return nil
+}
+
+func TestOverrideLeafSource(t *testing.T) {
+	newSource := func() *overrideLeafSource {
+		return &overrideLeafSource{
+			inner: &sliceLeafSource{
+				keys: [][]byte{{0x10}, {0x30}, {0x50}, {0x70}},
+				vals: [][]byte{{1}, {3}, {5}, {7}},
+			},
+			overrides: []leafOverride{
+				{key: []byte{0x00}, value: []byte{0}},  // before every inner key
+				{key: []byte{0x20}, value: []byte{2}},  // between inner keys
+				{key: []byte{0x30}, value: []byte{33}}, // replaces an inner value
+				{key: []byte{0x40}},                    // deletes an absent key
+				{key: []byte{0x50}},                    // deletes an inner key
+				{key: []byte{0x80}, value: []byte{8}},  // after every inner key
+			},
+		}
+	}
+	drain := func(s *overrideLeafSource) string {
+		var out []string
+		for {
+			k, v, err := s.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k == nil {
+				return strings.Join(out, " ")
+			}
+			out = append(out, common.Bytes2Hex(k)+"="+common.Bytes2Hex(v))
+		}
+	}
+
+	if got, want := drain(newSource()), "00=00 10=01 20=02 30=21 70=07 80=08"; got != want {
+		t.Fatalf("merged leaves: %s, want %s", got, want)
+	}
+
+	for _, tc := range []struct {
+		seek []byte
+		want string
+	}{
+		{[]byte{0x20}, "20=02 30=21 70=07 80=08"},
+		{[]byte{0x31}, "70=07 80=08"},
+		{[]byte{0x50}, "70=07 80=08"},
+		{[]byte{0x71}, "80=08"},
+		{[]byte{0x90}, ""},
+	} {
+		s := newSource()
+		if err := s.SeekTo(tc.seek); err != nil {
+			t.Fatal(err)
+		}
+		if got := drain(s); got != tc.want {
+			t.Fatalf("after SeekTo(%x): %s, want %s", tc.seek, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package statetrie
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/core/state"
+	"github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/ethdb"
+	"github.com/ava-labs/libevm/trie"
+	"github.com/ava-labs/libevm/trie/trienode"
+	"github.com/ava-labs/libevm/triedb"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+)
+
+// Compile-time check that SpeculativeDatabase implements state.Database.
+var _ state.Database = (*SpeculativeDatabase)(nil)
+
+// ReadSet is the state a speculative run read from below its StateDB: every
+// account and storage slot it loaded, with the account as it was read (nil
+// if it did not exist).
+type ReadSet struct {
+	Accounts map[common.Address]*types.StateAccount
+	Slots    map[common.Address]map[common.Hash]struct{}
+}
+
+// WriteSet is the state changes a StateDB pushes into its tries at
+// IntermediateRoot. Slot keys are trie keys, i.e. after coreth's state key
+// normalization.
+type WriteSet struct {
+	Accounts map[common.Address]*types.StateAccount // nil = deleted
+	Code     map[common.Hash][]byte                 // codeHash → code
+	Slots    map[common.Address]map[common.Hash]common.Hash
+	// Wiped are accounts deleted at some point: their storage is empty except
+	// for what Slots holds.
+	Wiped map[common.Address]bool
+}
+
+// NewWriteSet returns an empty WriteSet.
+func NewWriteSet() *WriteSet {
+	return &WriteSet{
+		Accounts: make(map[common.Address]*types.StateAccount),
+		Code:     make(map[common.Hash][]byte),
+		Slots:    make(map[common.Address]map[common.Hash]common.Hash),
+		Wiped:    make(map[common.Address]bool),
+	}
+}
+
+// Merge applies w's changes on top of ws.
+func (ws *WriteSet) Merge(w *WriteSet) {
+	for addr, acct := range w.Accounts {
+		if acct == nil {
+			ws.Accounts[addr] = nil
+			ws.Wiped[addr] = true
+			delete(ws.Slots, addr)
+			continue
+		}
+		cp := *acct
+		ws.Accounts[addr] = &cp
+	}
+	for hash, code := range w.Code {
+		ws.Code[hash] = code
+	}
+	for addr, slots := range w.Slots {
+		dst := ws.Slots[addr]
+		if dst == nil {
+			dst = make(map[common.Hash]common.Hash, len(slots))
+			ws.Slots[addr] = dst
+		}
+		for k, v := range slots {
+			dst[k] = v
+		}
+	}
+}
+
+// SpeculativeDatabase is a state.Database for running a transaction of a
+// block out of turn. It reads the batch overlay through its own RO
+// transaction, so several can run at once, with base (the block's writes so
+// far, may be nil) layered on top. It records what the StateDB reads and
+// captures what it writes instead of applying it: nothing reaches the
+// overlay or MDBX.
+type SpeculativeDatabase struct {
+	mdbxDB  *store.DB
+	overlay *BatchOverlay
+	tx      *mdbx.Txn
+	base    *WriteSet
+
+	Reads  ReadSet
+	Writes *WriteSet
+}
+
+// NewSpeculativeDatabase creates a SpeculativeDatabase reading overlay, then
+// MDBX through tx, with base on top. base is only read.
+func NewSpeculativeDatabase(mdbxDB *store.DB, overlay *BatchOverlay, tx *mdbx.Txn, base *WriteSet) *SpeculativeDatabase {
+	return &SpeculativeDatabase{
+		mdbxDB:  mdbxDB,
+		overlay: overlay,
+		tx:      tx,
+		base:    base,
+		Reads: ReadSet{
+			Accounts: make(map[common.Address]*types.StateAccount),
+			Slots:    make(map[common.Address]map[common.Hash]struct{}),
+		},
+		Writes: NewWriteSet(),
+	}
+}
+
+// OpenTrie opens the speculative account trie.
+func (db *SpeculativeDatabase) OpenTrie(root common.Hash) (state.Trie, error) {
+	return &speculativeAccountTrie{db: db}, nil
+}
+
+// OpenStorageTrie opens the speculative storage trie of an account.
+func (db *SpeculativeDatabase) OpenStorageTrie(stateRoot common.Hash, address common.Address, root common.Hash, self state.Trie) (state.Trie, error) {
+	return &speculativeStorageTrie{db: db, address: address, root: root}, nil
+}
+
+// CopyTrie returns t: speculative tries keep no state of their own.
+func (db *SpeculativeDatabase) CopyTrie(t state.Trie) state.Trie {
+	return t
+}
+
+// ContractCode retrieves contract bytecode by code hash.
+func (db *SpeculativeDatabase) ContractCode(addr common.Address, codeHash common.Hash) ([]byte, error) {
+	if codeHash == types.EmptyCodeHash {
+		return nil, nil
+	}
+	if db.base != nil {
+		if code, ok := db.base.Code[codeHash]; ok {
+			return code, nil
+		}
+	}
+	return db.overlay.GetCode(db.tx, db.mdbxDB, codeHash)
+}
+
+// ContractCodeSize retrieves the size of a contract's bytecode.
+func (db *SpeculativeDatabase) ContractCodeSize(addr common.Address, codeHash common.Hash) (int, error) {
+	code, err := db.ContractCode(addr, codeHash)
+	if err != nil {
+		return 0, err
+	}
+	return len(code), nil
+}
+
+// DiskDB is not available: a speculative state is never committed.
+func (db *SpeculativeDatabase) DiskDB() ethdb.KeyValueStore {
+	return nil
+}
+
+// TrieDB is not available: a speculative state is never committed.
+func (db *SpeculativeDatabase) TrieDB() *triedb.Database {
+	return nil
+}
+
+func (db *SpeculativeDatabase) getAccount(address common.Address) (*types.StateAccount, error) {
+	if db.base != nil {
+		if acct, ok := db.base.Accounts[address]; ok {
+			if acct == nil {
+				return nil, nil
+			}
+			cp := *acct
+			return &cp, nil
+		}
+	}
+	storeAcct, err := db.overlay.GetAccount(db.tx, db.mdbxDB, address)
+	if err != nil || storeAcct == nil {
+		return nil, err
+	}
+	acct := toStateAccount(storeAcct)
+	if storeAcct.IsMultiCoin {
+		setMultiCoinOnAccount(acct)
+	}
+	return acct, nil
+}
+
+func (db *SpeculativeDatabase) getStorage(address common.Address, slot common.Hash) ([]byte, error) {
+	if db.base != nil {
+		if v, ok := db.base.Slots[address][slot]; ok {
+			return trimSlot(v), nil
+		}
+		if db.base.Wiped[address] {
+			return nil, nil
+		}
+	}
+	val, err := db.overlay.GetStorage(db.tx, db.mdbxDB, address, slot)
+	if err != nil {
+		return nil, err
+	}
+	return trimSlot(val), nil
+}
+
+// trimSlot returns a slot value the way StateTrie.GetStorage does: leading
+// zeros trimmed, nil for an empty slot.
+func trimSlot(val [32]byte) []byte {
+	trimmed := bytes.TrimLeft(val[:], "\x00")
+	if len(trimmed) == 0 {
+		return nil
+	}
+	return trimmed
+}
+
+// speculativeAccountTrie implements state.Trie over a SpeculativeDatabase.
+type speculativeAccountTrie struct {
+	db *SpeculativeDatabase
+}
+
+func (t *speculativeAccountTrie) GetKey([]byte) []byte { return nil }
+
+func (t *speculativeAccountTrie) GetAccount(address common.Address) (*types.StateAccount, error) {
+	acct, err := t.db.getAccount(address)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := t.db.Reads.Accounts[address]; !ok {
+		var read *types.StateAccount
+		if acct != nil {
+			cp := *acct
+			read = &cp
+		}
+		t.db.Reads.Accounts[address] = read
+	}
+	return acct, nil
+}
+
+func (t *speculativeAccountTrie) UpdateAccount(address common.Address, account *types.StateAccount) error {
+	cp := *account
+	t.db.Writes.Accounts[address] = &cp
+	return nil
+}
+
+func (t *speculativeAccountTrie) DeleteAccount(address common.Address) error {
+	t.db.Writes.Accounts[address] = nil
+	delete(t.db.Writes.Slots, address)
+	return nil
+}
+
+func (t *speculativeAccountTrie) GetStorage(addr common.Address, key []byte) ([]byte, error) {
+	return nil, errors.New("GetStorage not supported on the account trie")
+}
+
+func (t *speculativeAccountTrie) UpdateStorage(addr common.Address, key, value []byte) error {
+	return errors.New("UpdateStorage not supported on the account trie")
+}
+
+func (t *speculativeAccountTrie) DeleteStorage(addr common.Address, key []byte) error {
+	return errors.New("DeleteStorage not supported on the account trie")
+}
+
+func (t *speculativeAccountTrie) UpdateContractCode(address common.Address, codeHash common.Hash, code []byte) error {
+	t.db.Writes.Code[codeHash] = code
+	return nil
+}
+
+// Hash returns a dummy root: speculative state is never hashed.
+func (t *speculativeAccountTrie) Hash() common.Hash { return common.Hash{} }
+
+func (t *speculativeAccountTrie) Commit(collectLeaf bool) (common.Hash, *trienode.NodeSet, error) {
+	return common.Hash{}, nil, errors.New("speculative state cannot be committed")
+}
+
+func (t *speculativeAccountTrie) NodeIterator(startKey []byte) (trie.NodeIterator, error) {
+	return nil, errors.New("NodeIterator not supported")
+}
+
+func (t *speculativeAccountTrie) Prove(key []byte, proofDb ethdb.KeyValueWriter) error {
+	return errors.New("Prove not supported on speculative state")
+}
+
+// speculativeStorageTrie implements state.Trie for one account's storage
+// over a SpeculativeDatabase.
+type speculativeStorageTrie struct {
+	db      *SpeculativeDatabase
+	address common.Address
+	root    common.Hash
+}
+
+func (t *speculativeStorageTrie) GetKey([]byte) []byte { return nil }
+
+func (t *speculativeStorageTrie) GetAccount(address common.Address) (*types.StateAccount, error) {
+	return nil, errors.New("GetAccount not supported on a storage trie")
+}
+
+func (t *speculativeStorageTrie) UpdateAccount(address common.Address, account *types.StateAccount) error {
+	return errors.New("UpdateAccount not supported on a storage trie")
+}
+
+func (t *speculativeStorageTrie) DeleteAccount(address common.Address) error {
+	return errors.New("DeleteAccount not supported on a storage trie")
+}
+
+func (t *speculativeStorageTrie) GetStorage(addr common.Address, key []byte) ([]byte, error) {
+	slot := common.BytesToHash(key)
+	slots := t.db.Reads.Slots[t.address]
+	if slots == nil {
+		slots = make(map[common.Hash]struct{})
+		t.db.Reads.Slots[t.address] = slots
+	}
+	slots[slot] = struct{}{}
+	return t.db.getStorage(t.address, slot)
+}
+
+// UpdateStorage captures a slot write. value is the trimmed slot value;
+// empty means the slot is cleared.
+func (t *speculativeStorageTrie) UpdateStorage(addr common.Address, key, value []byte) error {
+	t.setSlot(common.BytesToHash(key), common.BytesToHash(value))
+	return nil
+}
+
+func (t *speculativeStorageTrie) DeleteStorage(addr common.Address, key []byte) error {
+	t.setSlot(common.BytesToHash(key), common.Hash{})
+	return nil
+}
+
+func (t *speculativeStorageTrie) setSlot(slot, value common.Hash) {
+	slots := t.db.Writes.Slots[t.address]
+	if slots == nil {
+		slots = make(map[common.Hash]common.Hash)
+		t.db.Writes.Slots[t.address] = slots
+	}
+	slots[slot] = value
+}
+
+func (t *speculativeStorageTrie) UpdateContractCode(address common.Address, codeHash common.Hash, code []byte) error {
+	return errors.New("UpdateContractCode not supported on a storage trie")
+}
+
+// Hash returns the root the trie was opened with, so the account keeps it.
+func (t *speculativeStorageTrie) Hash() common.Hash { return t.root }
+
+func (t *speculativeStorageTrie) Commit(collectLeaf bool) (common.Hash, *trienode.NodeSet, error) {
+	return common.Hash{}, nil, errors.New("speculative state cannot be committed")
+}
+
+func (t *speculativeStorageTrie) NodeIterator(startKey []byte) (trie.NodeIterator, error) {
+	return nil, errors.New("NodeIterator not supported")
+}
+
+func (t *speculativeStorageTrie) Prove(key []byte, proofDb ethdb.KeyValueWriter) error {
+	return errors.New("Prove not supported on speculative state")
+}
@@ -0,0 +1,130 @@
+package statetrie
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ava-labs/libevm/crypto"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+)
+
+// maxStateDiffKeys caps how many distinct keys a rollback may touch. Every one
+// of them becomes a prefix-set entry and a re-hashed trie path, so old blocks
+// on a busy chain are refused instead of walking most of the trie.
+const maxStateDiffKeys = 1_000_000
+
+// maxStateDiffBlocks caps how many changesets a rollback may read. The keys a
+// rollback must override are only known from the changesets above the block,
+// so without it a proof on a quiet stretch of chain costs the distance to
+// head however few keys changed. It matches go-ethereum's default state
+// history window.
+const maxStateDiffBlocks = 90_000
+
+// stateDiff holds the value, as of some past block, of every key that has
+// changed between that block and head. Values use the changeset encoding:
+// 105-byte accounts and trimmed storage words, empty meaning absent.
+type stateDiff struct {
+	accounts map[[20]byte][]byte
+	storage  map[[20]byte]map[[32]byte][]byte
+}
+
+// loadStateDiff collects the old values of every key changed in blocks
+// (blockNum, head]. The first changeset touching a key after blockNum holds
+// its value at blockNum. More than
+// maxKeys keys is an error unless maxKeys is 0. The keyIDs are resolved
+// through the reverse keyID dictionary, which must be complete.
+func loadStateDiff(tx *mdbx.Txn, db *store.DB, blockNum, head uint64, maxKeys int) (*stateDiff, error) {
+	old := make(map[uint64][]byte)
+	for n := blockNum + 1; n <= head; n++ {
+		changes, err := store.ReadChangeset(tx, db, n)
+		if err != nil {
+			if mdbx.IsNotFound(err) {
+				continue // block touched no state
+			}
+			return nil, fmt.Errorf("read changeset %d: %w", n, err)
+		}
+		for _, c := range changes {
+			if _, ok := old[c.KeyID]; !ok {
+				old[c.KeyID] = c.OldValue
+			}
+		}
+		if maxKeys > 0 && len(old) > maxKeys {
+			return nil, fmt.Errorf("more than %d state keys changed between block %d and head %d", maxKeys, blockNum, head)
+		}
+	}
+
+	diff := &stateDiff{
+		accounts: make(map[[20]byte][]byte),
+		storage:  make(map[[20]byte]map[[32]byte][]byte),
+	}
+	if len(old) > 0 && !store.KeyDictReversed(tx, db) {
+		return nil, fmt.Errorf("the reverse keyID dictionary is still being built")
+	}
+	for keyID, val := range old {
+		addr, slot, err := store.ResolveKeyID(tx, db, keyID)
+		if err != nil {
+			return nil, fmt.Errorf("changesets reference an unknown key: %w", err)
+		}
+		diff.add(addr, slot, val)
+	}
+	return diff, nil
+}
+
+// add records the old value of (addr, slot), slot being AccountSentinelSlot
+// for the account itself.
+func (d *stateDiff) add(addr [20]byte, slot [32]byte, val []byte) {
+	if slot == store.AccountSentinelSlot {
+		d.accounts[addr] = val
+		return
+	}
+	if d.storage[addr] == nil {
+		d.storage[addr] = make(map[[32]byte][]byte)
+	}
+	d.storage[addr][slot] = val
+}
+
+// account returns addr's account at the diff's block.
+func (d *stateDiff) account(tx *mdbx.Txn, db *store.DB, addr [20]byte) (*store.Account, error) {
+	if v, ok := d.accounts[addr]; ok {
+		if len(v) == 0 {
+			return nil, nil
+		}
+		return store.DecodeAccount(v), nil
+	}
+	return store.GetAccount(tx, db, addr)
+}
+
+// storageValue returns a storage word at the diff's block.
+func (d *stateDiff) storageValue(tx *mdbx.Txn, db *store.DB, addr [20]byte, slot [32]byte) ([32]byte, error) {
+	if v, ok := d.storage[addr][slot]; ok {
+		var out [32]byte
+		copy(out[32-len(v):], v)
+		return out, nil
+	}
+	return store.GetStorage(tx, db, addr, slot)
+}
+
+// hashStorage computes addr's storage root at the diff's block, with proofs
+// for targets. If the storage is empty at head, any StorageTrie nodes left
+// under the address are stale and the root is built from leaves alone.
+func (d *stateDiff) hashStorage(tx *mdbx.Txn, db *store.DB, addr [20]byte, slots map[[32]byte][]byte, targets [][32]byte) ([32]byte, [][][]byte, error) {
+	cur, err := store.GetAccount(tx, db, addr)
+	if err != nil {
+		return [32]byte{}, nil, err
+	}
+	useStored := cur != nil && cur.StorageRoot != store.EmptyRootHash
+
+	overrides := make([]leafOverride, 0, len(slots))
+	for slot, v := range slots {
+		h := crypto.Keccak256Hash(slot[:])
+		o := leafOverride{key: h[:]}
+		if len(bytes.TrimLeft(v, "\x00")) > 0 {
+			o.value = v
+		}
+		overrides = append(overrides, o)
+	}
+	addrHash := crypto.Keccak256Hash(addr[:])
+	return hashTrie(tx, db.StorageTrie, db.HashedStorageState, addrHash[:], true, useStored, targets, overrides)
+}
@@ -152,7 +152,7 @@ func (t *StorageTrie) GetStorage(addr common.Address, key []byte) ([]byte, error
 	copy(slotKey[:], slot[:])
 
 	var val [32]byte
-	if t.stateDB != nil && t.stateDB.historicalBlock > 0 {
+	if t.stateDB != nil && t.stateDB.historical {
 		val, err = store.LookupHistoricalStorage(tx, t.db, addrKey, slotKey, t.stateDB.historicalBlock)
 	} else if t.stateDB != nil && t.stateDB.Overlay != nil {
 		val, err = t.stateDB.Overlay.GetStorage(tx, t.db, addrKey, slotKey)
@@ -344,7 +344,28 @@ func (t *StorageTrie) NodeIterator(startKey []byte) (trie.NodeIterator, error) {
 	return nil, errors.New("NodeIterator not supported")
 }
 
-// Prove is not supported.
+// Prove writes the Merkle proof for storage slot key into proofDb, keyed by
+// node hash. Same restrictions as AccountTrie.Prove.
 func (t *StorageTrie) Prove(key []byte, proofDb ethdb.KeyValueWriter) error {
-	return errors.New("Prove not supported")
+	if t.stateDB != nil && t.stateDB.Overlay != nil {
+		return errors.New("Prove not supported in batch mode")
+	}
+	if len(t.dirtySlots) > 0 || len(t.deletedSlots) > 0 {
+		return errors.New("Prove not supported with uncommitted changes")
+	}
+	tx, done, err := t.getROTx()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	blockNum, _ := store.GetHeadBlock(tx, t.db)
+	if t.stateDB != nil && t.stateDB.historical {
+		blockNum = t.stateDB.historicalBlock
+	}
+	proof, err := ProveAccount(tx, t.db, blockNum, [20]byte(t.address), [][32]byte{common.BytesToHash(key)})
+	if err != nil {
+		return err
+	}
+	return writeProof(proofDb, proof.StorageProofs[0])
 }
@@ -0,0 +1,68 @@
+package statetrie
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ava-labs/libevm/crypto"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store"
+)
+
+// UnwindState rolls the flat and hashed state back from head to block to:
+// every key changed in blocks (to, head] gets its value from the first
+// changeset after to. The rolled-back values go through a BatchOverlay and
+// the incremental hasher like an executed batch, so the trie nodes are
+// brought back along the changed paths only. It returns the state root at
+// to. Changesets are read, not deleted; see store.UnwindHistory.
+func UnwindState(tx *mdbx.Txn, db *store.DB, to, head uint64) ([32]byte, error) {
+	diff, err := loadStateDiff(tx, db, to, head, 0)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	overlay := NewBatchOverlay()
+	for addr, v := range diff.accounts {
+		if len(v) == 0 {
+			overlay.DeleteAccount(addr)
+			continue
+		}
+		overlay.PutAccount(addr, store.EncodeAccountBytes(store.DecodeAccount(v)))
+	}
+	for addr, slots := range diff.storage {
+		for slot, v := range slots {
+			trimmed := bytes.TrimLeft(v, "\x00")
+			if len(trimmed) == 0 {
+				overlay.DeleteStorage(addr, slot)
+				continue
+			}
+			var value [32]byte
+			copy(value[32-len(trimmed):], trimmed)
+			overlay.PutStorage(addr, slot, value, trimmed)
+		}
+	}
+
+	oldStorageRoots := ReadOldStorageRoots(tx, db, overlay.ChangedAccountHashes())
+	// An account that is gone at head has no storage root to carry over. If
+	// none of its slots changed, its storage is still what it was at to, so
+	// it is hashed rather than taken as empty.
+	for addr, v := range diff.accounts {
+		if len(v) == 0 || len(diff.storage[addr]) > 0 {
+			continue
+		}
+		ha := [32]byte(crypto.Keccak256(addr[:]))
+		if _, err := tx.Get(db.HashedAccountState, ha[:]); mdbx.IsNotFound(err) {
+			oldStorageRoots[ha] = computeFullStorageRoot(tx, db, ha)
+		}
+	}
+
+	if err := overlay.FlushStateToTx(tx, db); err != nil {
+		return [32]byte{}, fmt.Errorf("flush unwound state: %w", err)
+	}
+	root, _, err := ComputeIncrementalStateRoot(tx, db, overlay, oldStorageRoots)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("incremental state root: %w", err)
+	}
+	return root, nil
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/RoaringBitmap/roaring/v2/roaring64"
+	"github.com/erigontech/mdbx-go/mdbx"
+)
+
+// AtomicTx is an import or export found in a block's ExtData, reduced to
+// what it moved on this chain.
+type AtomicTx struct {
+	TxID   [32]byte
+	Export bool     // false for an import
+	Chain  [32]byte // source chain of an import, destination chain of an export
+	Burned uint64   // AVAX burned as the fee, in nAVAX
+	// Transfers are the EVM outputs of an import, credited, or the EVM
+	// inputs of an export, debited.
+	Transfers []AtomicTransfer
+}
+
+// AtomicTransfer is one EVM output of an import or EVM input of an export.
+type AtomicTransfer struct {
+	Address [20]byte
+	AssetID [32]byte
+	Amount  uint64 // in nAVAX for AVAX
+	Nonce   uint64 // exports only
+}
+
+// encodeAtomicTxs encodes the atomic txs of a block.
+// Format:
+//
+//	numTxs(2)
+//	per tx:
+//	  txID(32) | export(1) | chain(32) | burned(8) | numTransfers(2) |
+//	  per transfer: address(20) | assetID(32) | amount(8) | nonce(8)
+func encodeAtomicTxs(txs []AtomicTx) []byte {
+	size := 2
+	for _, t := range txs {
+		size += 32 + 1 + 32 + 8 + 2 + len(t.Transfers)*(20+32+8+8)
+	}
+	buf := make([]byte, 0, size)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(txs)))
+	for _, t := range txs {
+		buf = append(buf, t.TxID[:]...)
+		if t.Export {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+		buf = append(buf, t.Chain[:]...)
+		buf = binary.BigEndian.AppendUint64(buf, t.Burned)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(t.Transfers)))
+		for _, tr := range t.Transfers {
+			buf = append(buf, tr.Address[:]...)
+			buf = append(buf, tr.AssetID[:]...)
+			buf = binary.BigEndian.AppendUint64(buf, tr.Amount)
+			buf = binary.BigEndian.AppendUint64(buf, tr.Nonce)
+		}
+	}
+	return buf
+}
+
+func decodeAtomicTxs(data []byte) ([]AtomicTx, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("atomic txs too short")
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	pos := 2
+	txs := make([]AtomicTx, n)
+	for i := range txs {
+		if pos+32+1+32+8+2 > len(data) {
+			return nil, fmt.Errorf("atomic tx %d truncated", i)
+		}
+		t := &txs[i]
+		copy(t.TxID[:], data[pos:])
+		t.Export = data[pos+32] != 0
+		copy(t.Chain[:], data[pos+33:])
+		t.Burned = binary.BigEndian.Uint64(data[pos+65:])
+		numTransfers := int(binary.BigEndian.Uint16(data[pos+73:]))
+		pos += 75
+		if pos+numTransfers*68 > len(data) {
+			return nil, fmt.Errorf("atomic tx %d transfers truncated", i)
+		}
+		t.Transfers = make([]AtomicTransfer, numTransfers)
+		for j := range t.Transfers {
+			tr := &t.Transfers[j]
+			copy(tr.Address[:], data[pos:])
+			copy(tr.AssetID[:], data[pos+20:])
+			tr.Amount = binary.BigEndian.Uint64(data[pos+52:])
+			tr.Nonce = binary.BigEndian.Uint64(data[pos+60:])
+			pos += 68
+		}
+	}
+	return txs, nil
+}
+
+// ReadBlockAtomicTxs returns the atomic txs of block blockNum, nil if it has
+// none or IndexAtomic has not reached it.
+func ReadBlockAtomicTxs(tx *mdbx.Txn, db *DB, blockNum uint64) ([]AtomicTx, error) {
+	key := BlockKey(blockNum)
+	data, err := tx.Get(db.AtomicTxs, key[:])
+	if err != nil {
+		if mdbx.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return decodeAtomicTxs(data)
+}
+
+// ReadAtomicAddressIndex returns the blocks in [from, to] with an atomic tx
+// crediting or debiting address.
+func ReadAtomicAddressIndex(tx *mdbx.Txn, db *DB, address [20]byte, from, to uint64) (*roaring64.Bitmap, error) {
+	return readLogIndex(tx, db.AtomicAddressIndex, address[:], from, to)
+}
+
+// WriteAtomicIndex adds blocks [from, to] to IndexAtomic and moves its
+// watermark to to. blocks holds the atomic txs of the blocks in range that
+// have any. Unlike the other indexes it is built from the block containers,
+// whose ExtData only the caller can decode.
+func WriteAtomicIndex(tx *mdbx.Txn, db *DB, from, to uint64, blocks map[uint64][]AtomicTx) error {
+	pending := make(map[string][]uint64)
+	for n := from; n <= to; n++ {
+		txs := blocks[n]
+		if len(txs) == 0 {
+			continue
+		}
+		key := BlockKey(n)
+		if err := tx.Put(db.AtomicTxs, key[:], encodeAtomicTxs(txs), 0); err != nil {
+			return err
+		}
+		seen := make(map[[20]byte]bool)
+		for _, t := range txs {
+			for _, tr := range t.Transfers {
+				if !seen[tr.Address] {
+					seen[tr.Address] = true
+					pending[string(tr.Address[:])] = append(pending[string(tr.Address[:])], n)
+				}
+			}
+		}
+	}
+	if err := FlushLogIndexBatch(tx, db.AtomicAddressIndex, pending); err != nil {
+		return fmt.Errorf("atomic address index: %w", err)
+	}
+	return setIndexedTo(tx, db, IndexAtomic, to)
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store/storetest"
+)
+
+var testAtomicTxs = []AtomicTx{
+	{
+		TxID:   [32]byte{1},
+		Chain:  [32]byte{0x58},
+		Burned: 10,
+		Transfers: []AtomicTransfer{
+			{Address: testAddr(1), AssetID: [32]byte{0xaa}, Amount: 990},
+			{Address: testAddr(2), AssetID: [32]byte{0xbb}, Amount: 5},
+		},
+	},
+	{
+		TxID:      [32]byte{2},
+		Export:    true,
+		Chain:     [32]byte{0x58},
+		Burned:    20,
+		Transfers: []AtomicTransfer{{Address: testAddr(1), AssetID: [32]byte{0xaa}, Amount: 500, Nonce: 7}},
+	},
+}
+
+func TestAtomicTxsEncoding(t *testing.T) {
+	data := encodeAtomicTxs(testAtomicTxs)
+	got, err := decodeAtomicTxs(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, testAtomicTxs) {
+		t.Fatalf("decoded %+v, want %+v", got, testAtomicTxs)
+	}
+	for _, n := range []int{0, 1, 10, len(data) - 1} {
+		if _, err := decodeAtomicTxs(data[:n]); err == nil {
+			t.Fatalf("decoded %d of %d bytes", n, len(data))
+		}
+	}
+}
+
+func TestWriteAtomicIndex(t *testing.T) {
+	db := storetest.Open(t, Open)
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		if err := SetHeadBlock(tx, db, 20); err != nil {
+			t.Fatal(err)
+		}
+		if err := InitIndexWatermarks(tx, db); err != nil {
+			t.Fatal(err)
+		}
+		blocks := map[uint64][]AtomicTx{3: testAtomicTxs[:1], 12: testAtomicTxs[1:]}
+		if err := WriteAtomicIndex(tx, db, 1, 10, blocks); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := GetIndexedTo(tx, db, IndexAtomic); got != 10 {
+			t.Fatalf("watermark %d, want 10", got)
+		}
+		if err := WriteAtomicIndex(tx, db, 11, 20, blocks); err != nil {
+			t.Fatal(err)
+		}
+		if from, to := UnindexedRange(tx, db, IndexAtomic); from <= to {
+			t.Fatalf("unindexed range [%d, %d] after indexing to the head", from, to)
+		}
+	})
+
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+		for _, tc := range []struct {
+			block uint64
+			want  []AtomicTx
+		}{{3, testAtomicTxs[:1]}, {12, testAtomicTxs[1:]}, {4, nil}} {
+			got, err := ReadBlockAtomicTxs(tx, db, tc.block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("block %d: %+v, want %+v", tc.block, got, tc.want)
+			}
+		}
+		for _, tc := range []struct {
+			addr     [20]byte
+			from, to uint64
+			want     []uint64
+		}{
+			{testAddr(1), 0, 20, []uint64{3, 12}},
+			{testAddr(1), 4, 20, []uint64{12}},
+			{testAddr(2), 0, 20, []uint64{3}},
+			{testAddr(3), 0, 20, nil},
+		} {
+			bm, err := ReadAtomicAddressIndex(tx, db, tc.addr, tc.from, tc.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := bm.ToArray()
+			if len(got) != len(tc.want) || (len(got) > 0 && !reflect.DeepEqual(got, tc.want)) {
+				t.Fatalf("address %x in [%d, %d]: %v, want %v", tc.addr, tc.from, tc.to, got, tc.want)
+			}
+		}
+	})
+}
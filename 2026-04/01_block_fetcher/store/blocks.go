@@ -16,14 +16,14 @@ func PutContainer(tx *mdbx.Txn, db *DB, containerID [32]byte, blockNum uint64, r
 	if n == 0 {
 		// Incompressible — store with a 0-byte prefix to distinguish
 		compressed := make([]byte, 1+len(raw))
-		compressed[0] = 0 // flag: uncompressed
+		compressed[0] = containerRaw
 		copy(compressed[1:], raw)
 		if err := tx.Put(db.Containers, containerID[:], compressed, 0); err != nil {
 			return err
 		}
 	} else {
 		compressed := make([]byte, 1+n)
-		compressed[0] = 1 // flag: lz4 compressed
+		compressed[0] = containerLZ4
 		copy(compressed[1:], buf[:n])
 		if err := tx.Put(db.Containers, containerID[:], compressed, 0); err != nil {
 			return err
@@ -33,7 +33,8 @@ func PutContainer(tx *mdbx.Txn, db *DB, containerID [32]byte, blockNum uint64, r
 	return tx.Put(db.ContainerIndex, key[:], containerID[:], 0)
 }
 
-// GetContainer retrieves and decompresses container bytes by container ID.
+// GetContainer retrieves and decompresses container bytes by container ID,
+// from the segment files if the container has been packed.
 func GetContainer(tx *mdbx.Txn, db *DB, containerID [32]byte) ([]byte, error) {
 	data, err := tx.Get(db.Containers, containerID[:])
 	if err != nil {
@@ -42,7 +43,13 @@ func GetContainer(tx *mdbx.Txn, db *DB, containerID [32]byte) ([]byte, error) {
 	if len(data) == 0 {
 		return nil, fmt.Errorf("empty container")
 	}
-	if data[0] == 0 {
+	if data[0] == containerPacked {
+		if len(data) != 9 {
+			return nil, fmt.Errorf("packed container pointer has %d bytes", len(data))
+		}
+		return db.segments.readContainer(binary.BigEndian.Uint64(data[1:]))
+	}
+	if data[0] == containerRaw {
 		// Uncompressed
 		out := make([]byte, len(data)-1)
 		copy(out, data[1:])
@@ -74,6 +81,16 @@ func GetContainerByNumber(tx *mdbx.Txn, db *DB, num uint64) ([]byte, error) {
 	return raw, nil
 }
 
+// GetContainerIDByNumber returns the container ID indexed at block num.
+func GetContainerIDByNumber(tx *mdbx.Txn, db *DB, num uint64) ([32]byte, error) {
+	key := BlockKey(num)
+	containerID, err := tx.Get(db.ContainerIndex, key[:])
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return [32]byte(containerID), nil
+}
+
 // HasContainer checks whether a container exists by ID.
 func HasContainer(tx *mdbx.Txn, db *DB, containerID [32]byte) bool {
 	_, err := tx.Get(db.Containers, containerID[:])
@@ -126,7 +143,7 @@ func GetContainerByBlockHash(tx *mdbx.Txn, db *DB, blockHash [32]byte) ([]byte,
 	if err != nil {
 		return nil, fmt.Errorf("block hash index lookup: %w", err)
 	}
-	return tx.Get(db.Containers, cid)
+	return GetContainer(tx, db, [32]byte(cid))
 }
 
 // GetHeadBlock returns the last processed block number from metadata.
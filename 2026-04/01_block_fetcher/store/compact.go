@@ -0,0 +1,147 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/erigontech/mdbx-go/mdbx"
+)
+
+// compactBatch is how many entries one write transaction of Compact copies.
+const compactBatch = 1_000_000
+
+// Compact rewrites the MDBX env in directory path into a fresh file holding
+// only live pages and swaps it in. MDBX reuses freed pages but never shrinks
+// its file, so this is how the space PackContainers frees is given back.
+// Nothing else may have the database open; Compact takes the lock itself.
+// It needs free disk space for the compacted copy and returns the data file
+// size before and after.
+func Compact(path string) (before, after int64, err error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	dataFile := filepath.Join(path, "mdbx.dat")
+	info, err := os.Stat(dataFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	before = info.Size()
+
+	src, err := Open(path)
+	if err != nil {
+		return before, 0, err
+	}
+	defer func() {
+		if src != nil {
+			src.Close()
+		}
+	}()
+
+	tmpDir := filepath.Join(path, "compact")
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return before, 0, err
+	}
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return before, 0, err
+	}
+	defer os.RemoveAll(tmpDir)
+	dst, err := openEnv(tmpDir)
+	if err != nil {
+		return before, 0, fmt.Errorf("open compacted env: %w", err)
+	}
+	if err := copyTables(src, dst); err != nil {
+		dst.Close()
+		return before, 0, err
+	}
+	if err := dst.Sync(true, false); err != nil {
+		dst.Close()
+		return before, 0, fmt.Errorf("sync compacted env: %w", err)
+	}
+	dst.Close()
+
+	// Swap the compacted file in while still holding the lock. The lock file
+	// describes the old file, so it goes too; MDBX recreates it.
+	src.env.Close()
+	src.env = nil
+	if err := os.Rename(filepath.Join(tmpDir, "mdbx.dat"), dataFile); err != nil {
+		return before, 0, fmt.Errorf("swap in compacted env: %w", err)
+	}
+	if err := os.Remove(filepath.Join(path, "mdbx.lck")); err != nil && !os.IsNotExist(err) {
+		return before, 0, err
+	}
+	src.Close()
+	src = nil
+
+	info, err = os.Stat(dataFile)
+	if err != nil {
+		return before, 0, err
+	}
+	return before, info.Size(), nil
+}
+
+// copyTables copies every table of src into dst in key order.
+func copyTables(src *DB, dst *mdbx.Env) error {
+	srcTx, err := src.BeginRO()
+	if err != nil {
+		return err
+	}
+	defer srcTx.Abort()
+
+	dstTx, err := dst.BeginTxn(nil, mdbx.TxRW)
+	if err != nil {
+		return err
+	}
+	dstDBIs := make([]mdbx.DBI, len(allTables))
+	for i, name := range allTables {
+		if dstDBIs[i], err = dstTx.OpenDBISimple(name, mdbx.Create); err != nil {
+			dstTx.Abort()
+			return err
+		}
+	}
+	if _, err := dstTx.Commit(); err != nil {
+		return err
+	}
+
+	for i, name := range allTables {
+		srcDBI, err := srcTx.OpenDBISimple(name, 0)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", name, err)
+		}
+		if err := copyTable(srcTx, srcDBI, dst, dstDBIs[i]); err != nil {
+			return fmt.Errorf("copy %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func copyTable(srcTx *mdbx.Txn, srcDBI mdbx.DBI, dst *mdbx.Env, dstDBI mdbx.DBI) error {
+	cursor, err := srcTx.OpenCursor(srcDBI)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	k, v, err := cursor.Get(nil, nil, mdbx.First)
+	for err == nil {
+		dstTx, txErr := dst.BeginTxn(nil, mdbx.TxRW)
+		if txErr != nil {
+			return txErr
+		}
+		for n := 0; err == nil && n < compactBatch; n++ {
+			if putErr := dstTx.Put(dstDBI, k, v, mdbx.Append); putErr != nil {
+				dstTx.Abort()
+				return putErr
+			}
+			k, v, err = cursor.Get(nil, nil, mdbx.Next)
+		}
+		if _, txErr := dstTx.Commit(); txErr != nil {
+			return txErr
+		}
+	}
+	if !mdbx.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
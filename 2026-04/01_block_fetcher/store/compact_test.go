@@ -0,0 +1,104 @@
+package store
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store/storetest"
+)
+
+// readTestTables returns the entries of every table of db.
+func readTestTables(t *testing.T, db *DB) map[string]map[string]string {
+	t.Helper()
+	out := make(map[string]map[string]string)
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+		for _, name := range allTables {
+			dbi, err := tx.OpenDBISimple(name, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cursor, err := tx.OpenCursor(dbi)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries := make(map[string]string)
+			k, v, err := cursor.Get(nil, nil, mdbx.First)
+			for ; err == nil; k, v, err = cursor.Get(nil, nil, mdbx.Next) {
+				entries[string(k)] = string(v)
+			}
+			cursor.Close()
+			if !mdbx.IsNotFound(err) {
+				t.Fatal(err)
+			}
+			out[name] = entries
+		}
+	})
+	return out
+}
+
+func TestCompactKeepsBlocks(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { db.Close() }()
+
+	last := uint64(8*PackBlocks + 3)
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		putTestContainers(t, tx, db, 1, last)
+		for num := uint64(1); num <= last; num++ {
+			if err := PutBlockHashIndex(tx, db, [32]byte{31: byte(num), 30: byte(num >> 8)}, testContainerID(num)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := PutAccount(tx, db, testAddr(1), testAccount(1)); err != nil {
+			t.Fatal(err)
+		}
+		if err := PutStorage(tx, db, testAddr(1), [32]byte{31: 1}, [32]byte{31: 2}); err != nil {
+			t.Fatal(err)
+		}
+		if err := SetHeadBlock(tx, db, last); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// Packing frees the pages of the packed containers for Compact to drop.
+	if got := packTestContainers(t, db, 100); got != 8*PackBlocks {
+		t.Fatalf("packed to %d, want %d", got, 8*PackBlocks)
+	}
+	want := readTestTables(t, db)
+	db.Close()
+
+	before, after, err := Compact(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after <= 0 || after > before {
+		t.Fatalf("compacted %d bytes to %d", before, after)
+	}
+
+	if db, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	got := readTestTables(t, db)
+	for _, name := range allTables {
+		if !maps.Equal(got[name], want[name]) {
+			t.Errorf("compacted %s has %d entries, want %d", name, len(got[name]), len(want[name]))
+		}
+	}
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+		checkTestPacked(t, tx, db, 8*PackBlocks, last)
+		checkTestContainers(t, tx, db, 1, last)
+		for num := uint64(1); num <= last; num++ {
+			raw, err := GetContainerByBlockHash(tx, db, [32]byte{31: byte(num), 30: byte(num >> 8)})
+			if err != nil || string(raw) != string(testContainer(num)) {
+				t.Fatalf("block %d by hash reads %x, %v", num, raw, err)
+			}
+		}
+		if head, ok := GetHeadBlock(tx, db); !ok || head != last {
+			t.Fatalf("head = %d, %v", head, ok)
+		}
+	})
+}
@@ -1,8 +1,11 @@
 package store
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -32,6 +35,10 @@ const (
 	TableTxHashIndex        = "TxHashIndex"
 	TableAddressLogIndex    = "AddressLogIndex"
 	TableTopicLogIndex      = "TopicLogIndex"
+	TableAtomicTxs          = "AtomicTxs"
+	TableAtomicAddressIndex = "AtomicAddressIndex"
+	TableAddressByID        = "AddressByID"
+	TableSlotByID           = "SlotByID"
 )
 
 var allTables = []string{
@@ -55,12 +62,28 @@ var allTables = []string{
 	TableTxHashIndex,
 	TableAddressLogIndex,
 	TableTopicLogIndex,
+	TableAtomicTxs,
+	TableAtomicAddressIndex,
+	TableAddressByID,
+	TableSlotByID,
 }
 
 type DB struct {
 	env      *mdbx.Env
 	lockFile *os.File
 
+	// readOnly is set by OpenReadOnly. Another process owns the writes.
+	readOnly bool
+
+	// head fans out committed head-block advances to in-process subscribers.
+	head headFeed
+	// stopWatch and watchDone stop the head watcher of a read-only DB.
+	stopWatch chan struct{}
+	watchDone chan struct{}
+
+	// segments holds the containers moved out of Containers into block packs.
+	segments *segmentStore
+
 	Containers         mdbx.DBI
 	ContainerIndex     mdbx.DBI
 	BlockHashIndex     mdbx.DBI
@@ -81,6 +104,10 @@ type DB struct {
 	TxHashIndex        mdbx.DBI
 	AddressLogIndex    mdbx.DBI
 	TopicLogIndex      mdbx.DBI
+	AtomicTxs          mdbx.DBI
+	AtomicAddressIndex mdbx.DBI
+	AddressByID        mdbx.DBI
+	SlotByID           mdbx.DBI
 }
 
 func Open(path string) (*DB, error) {
@@ -126,64 +153,119 @@ func Open(path string) (*DB, error) {
 		return nil, fmt.Errorf("sync db lock %s: %w", lockPath, err)
 	}
 
-	env, err := mdbx.NewEnv(mdbx.Label("store"))
+	env, err := openEnv(path)
 	if err != nil {
 		_ = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
 		_ = lockFile.Close()
 		return nil, err
 	}
 
-	if err := env.SetOption(mdbx.OptMaxDB, 24); err != nil {
+	db := &DB{env: env, lockFile: lockFile}
+
+	txn, err := env.BeginTxn(nil, mdbx.TxRW)
+	if err != nil {
 		env.Close()
 		_ = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
 		_ = lockFile.Close()
 		return nil, err
 	}
-
-	if err := env.SetGeometry(-1, -1, 1<<40, -1, -1, -1); err != nil {
+	if err := db.openTables(txn, mdbx.Create); err != nil {
+		txn.Abort()
 		env.Close()
 		_ = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
 		_ = lockFile.Close()
 		return nil, err
 	}
-
-	flags := uint(mdbx.NoReadahead | mdbx.WriteMap | mdbx.NoStickyThreads | mdbx.SafeNoSync)
-	if err := env.Open(path, flags, 0644); err != nil {
+	if _, err := txn.Commit(); err != nil {
 		env.Close()
 		_ = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
 		_ = lockFile.Close()
 		return nil, err
 	}
 
-	db := &DB{env: env, lockFile: lockFile}
-
-	txn, err := env.BeginTxn(nil, mdbx.TxRW)
+	txn, err = env.BeginTxn(nil, mdbx.TxRO)
 	if err != nil {
 		env.Close()
 		_ = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
 		_ = lockFile.Close()
 		return nil, err
 	}
+	packedTo := GetPackedTo(txn, db)
+	txn.Abort()
+	db.segments, err = openSegments(filepath.Join(path, "segments"), packedTo, false)
+	if err != nil {
+		env.Close()
+		_ = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		_ = lockFile.Close()
+		return nil, fmt.Errorf("open segments: %w", err)
+	}
 
-	dbis := make([]mdbx.DBI, len(allTables))
-	for i, name := range allTables {
-		dbi, err := txn.OpenDBISimple(name, mdbx.Create)
-		if err != nil {
-			txn.Abort()
-			env.Close()
-			_ = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
-			_ = lockFile.Close()
-			return nil, err
-		}
-		dbis[i] = dbi
+	return db, nil
+}
+
+// OpenReadOnly opens the database at path for reading next to the process
+// that has it open with Open, which keeps syncing and writing. Any number of
+// read-only processes can share the environment. It takes no lock, creates
+// nothing and fails on a database Open has never created. BeginRW returns an
+// error.
+//
+// Each read transaction holds an MDBX reader slot, and an open one keeps the
+// writer from reusing the pages it sees, so keep them short. Slots left by
+// crashed processes are released here. Within one process, use either Open
+// or OpenReadOnly on a path, not both.
+//
+// The head the writer commits is polled every headPollInterval, and changes
+// are published to SubscribeHead as in the writer's process.
+func OpenReadOnly(path string) (*DB, error) {
+	env, err := openEnvReadOnly(path)
+	if err != nil {
+		return nil, err
 	}
+	db := &DB{env: env, readOnly: true}
 
+	txn, err := env.BeginTxn(nil, mdbx.TxRO)
+	if err != nil {
+		env.Close()
+		return nil, err
+	}
+	if err := db.openTables(txn, 0); err != nil {
+		txn.Abort()
+		env.Close()
+		return nil, err
+	}
+	packedTo := GetPackedTo(txn, db)
+	head, _ := GetHeadBlock(txn, db)
+	// Handles opened in a read transaction outlive it only if it commits.
 	if _, err := txn.Commit(); err != nil {
 		env.Close()
-		_ = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
-		_ = lockFile.Close()
 		return nil, err
 	}
+	db.segments, err = openSegments(filepath.Join(path, "segments"), packedTo, true)
+	if err != nil {
+		env.Close()
+		return nil, fmt.Errorf("open segments: %w", err)
+	}
+
+	db.stopWatch = make(chan struct{})
+	db.watchDone = make(chan struct{})
+	go db.watchHead(head)
+	return db, nil
+}
+
+// openTables opens every table in txn, creating missing ones if flags has
+// mdbx.Create.
+func (db *DB) openTables(txn *mdbx.Txn, flags uint) error {
+	dbis := make([]mdbx.DBI, len(allTables))
+	for i, name := range allTables {
+		dbi, err := txn.OpenDBISimple(name, flags)
+		if err != nil {
+			if mdbx.IsNotFound(err) {
+				return fmt.Errorf("table %s does not exist; open the database read-write first", name)
+			}
+			return fmt.Errorf("open table %s: %w", name, err)
+		}
+		dbis[i] = dbi
+	}
 
 	db.Containers = dbis[0]
 	db.ContainerIndex = dbis[1]
@@ -205,24 +287,109 @@ func Open(path string) (*DB, error) {
 	db.TxHashIndex = dbis[17]
 	db.AddressLogIndex = dbis[18]
 	db.TopicLogIndex = dbis[19]
+	db.AtomicTxs = dbis[20]
+	db.AtomicAddressIndex = dbis[21]
+	db.AddressByID = dbis[22]
+	db.SlotByID = dbis[23]
+	return nil
+}
 
-	return db, nil
+// maxReaders is the number of MDBX reader slots, shared by every process
+// with the environment open. Only the first process to open it sets it.
+const maxReaders = 1024
+
+// openEnv opens the MDBX env in directory path with the store's settings.
+func openEnv(path string) (*mdbx.Env, error) {
+	env, err := mdbx.NewEnv(mdbx.Label("store"))
+	if err != nil {
+		return nil, err
+	}
+	if err := env.SetOption(mdbx.OptMaxDB, 32); err != nil {
+		env.Close()
+		return nil, err
+	}
+	if err := env.SetOption(mdbx.OptMaxReaders, maxReaders); err != nil {
+		env.Close()
+		return nil, err
+	}
+	if err := env.SetGeometry(-1, -1, 1<<40, -1, -1, -1); err != nil {
+		env.Close()
+		return nil, err
+	}
+	flags := uint(mdbx.NoReadahead | mdbx.WriteMap | mdbx.NoStickyThreads | mdbx.SafeNoSync)
+	if err := env.Open(path, flags, 0644); err != nil {
+		env.Close()
+		return nil, err
+	}
+	if stale, err := env.ReaderCheck(); err == nil && stale > 0 {
+		log.Printf("store: released %d stale MDBX readers", stale)
+	}
+	return env, nil
+}
+
+// openEnvReadOnly opens the MDBX env in directory path for reading. Accede
+// takes the durability mode the writer opened it with.
+func openEnvReadOnly(path string) (*mdbx.Env, error) {
+	env, err := mdbx.NewEnv(mdbx.Label("store"))
+	if err != nil {
+		return nil, err
+	}
+	if err := env.SetOption(mdbx.OptMaxDB, 32); err != nil {
+		env.Close()
+		return nil, err
+	}
+	if err := env.SetOption(mdbx.OptMaxReaders, maxReaders); err != nil {
+		env.Close()
+		return nil, err
+	}
+	flags := uint(mdbx.Readonly | mdbx.Accede | mdbx.NoReadahead | mdbx.NoStickyThreads)
+	if err := env.Open(path, flags, 0644); err != nil {
+		env.Close()
+		return nil, fmt.Errorf("open %s read-only: %w", path, err)
+	}
+	if stale, err := env.ReaderCheck(); err == nil && stale > 0 {
+		log.Printf("store: released %d stale MDBX readers", stale)
+	}
+	return env, nil
 }
 
 func (db *DB) BeginRO() (*mdbx.Txn, error) {
 	return db.env.BeginTxn(nil, mdbx.TxRO)
 }
 
+// ErrReadOnly is returned by BeginRW on a DB opened with OpenReadOnly.
+var ErrReadOnly = errors.New("database is open read-only")
+
 func (db *DB) BeginRW() (*mdbx.Txn, error) {
+	if db.readOnly {
+		return nil, ErrReadOnly
+	}
 	return db.env.BeginTxn(nil, mdbx.TxRW)
 }
 
+// ReadOnly reports whether db was opened with OpenReadOnly.
+func (db *DB) ReadOnly() bool {
+	return db.readOnly
+}
+
 func (db *DB) Env() *mdbx.Env {
 	return db.env
 }
 
 func (db *DB) Close() {
-	db.env.Close()
+	if db.stopWatch != nil {
+		close(db.stopWatch)
+		<-db.watchDone
+		db.stopWatch = nil
+	}
+	if db.env != nil {
+		db.env.Close()
+		db.env = nil
+	}
+	if db.segments != nil {
+		db.segments.close()
+		db.segments = nil
+	}
 	if db.lockFile != nil {
 		pid := strconv.Itoa(os.Getpid())
 		_ = db.lockFile.Truncate(0)
@@ -242,15 +409,19 @@ func (db *DB) ClearState() error {
 	if err != nil {
 		return err
 	}
+	// packed_to lives in Metadata but belongs to the containers.
+	packedTo := BlockKey(GetPackedTo(tx, db))
 	tables := []mdbx.DBI{
 		db.AccountState, db.Code, db.StorageState,
 		db.AddressIndex, db.SlotIndex,
+		db.AddressByID, db.SlotByID,
 		db.Changesets, db.HistoryIndex,
 		db.AccountTrie, db.StorageTrie,
 		db.Metadata, db.EthDB,
 		db.HashedAccountState, db.HashedStorageState,
 		db.ReceiptsByBlock, db.TxHashIndex,
 		db.AddressLogIndex, db.TopicLogIndex,
+		db.AtomicTxs, db.AtomicAddressIndex,
 	}
 	for _, dbi := range tables {
 		if err := tx.Drop(dbi, false); err != nil {
@@ -258,6 +429,10 @@ func (db *DB) ClearState() error {
 			return err
 		}
 	}
+	if err := tx.Put(db.Metadata, packedToKey, packedTo[:], 0); err != nil {
+		tx.Abort()
+		return err
+	}
 	_, err = tx.Commit()
 	return err
 }
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// writerProcessEnv names the database directory TestWriterProcess writes to
+// when it runs as the writer of TestReadOnlyNextToWriter.
+const writerProcessEnv = "STORE_TEST_WRITER_DIR"
+
+// TestWriterProcess is the writer TestReadOnlyNextToWriter runs in a process
+// of its own, as one process may not open a path both ways. It opens the
+// database, commits head 1 and prints "ready", then commits and publishes
+// each head number read from stdin, printing "head N" after each.
+func TestWriterProcess(t *testing.T) {
+	dir := os.Getenv(writerProcessEnv)
+	if dir == "" {
+		t.Skip("only run by TestReadOnlyNextToWriter")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	setHead := func(head uint64) {
+		tx, err := db.BeginRW()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := SetHeadBlock(tx, db, head); err != nil {
+			tx.Abort()
+			t.Fatal(err)
+		}
+		if _, err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		db.PublishHead(head)
+	}
+	setHead(1)
+	fmt.Println("ready")
+	in := bufio.NewScanner(os.Stdin)
+	for in.Scan() {
+		head, err := strconv.ParseUint(in.Text(), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setHead(head)
+		fmt.Println("head", head)
+	}
+}
+
+// TestReadOnlyNextToWriter opens a database read-only while another process
+// has it open with Open and commits new heads: they reach SubscribeHead, and
+// writes through the read-only handle fail.
+func TestReadOnlyNextToWriter(t *testing.T) {
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWriterProcess$")
+	cmd.Env = append(os.Environ(), writerProcessEnv+"="+dir)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		stdin.Close()
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("writer process: %v", err)
+		}
+	}()
+	// Buffered so the reader never blocks on lines printed after the test
+	// stops waiting, such as the writer's PASS.
+	lines := make(chan string, 16)
+	go func() {
+		defer close(lines)
+		out := bufio.NewScanner(stdout)
+		for out.Scan() {
+			lines <- out.Text()
+		}
+	}()
+	waitFor := func(want string) {
+		t.Helper()
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatalf("writer process exited before %q", want)
+				}
+				if line == want {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("writer process did not print %q", want)
+			}
+		}
+	}
+	waitFor("ready")
+
+	db, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	heads, cancel := db.SubscribeHead()
+	defer cancel()
+
+	for _, head := range []uint64{2, 3} {
+		fmt.Fprintln(stdin, head)
+		waitFor(fmt.Sprintf("head %d", head))
+		select {
+		case got := <-heads:
+			if got != head {
+				t.Fatalf("SubscribeHead gave %d, want %d", got, head)
+			}
+		case <-time.After(10 * headPollInterval):
+			t.Fatalf("head %d not published to the read-only process", head)
+		}
+		runtime.LockOSThread()
+		tx, err := db.BeginRO()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := GetHeadBlock(tx, db)
+		tx.Abort()
+		runtime.UnlockOSThread()
+		if !ok || got != head {
+			t.Fatalf("read-only head %d (%t), want %d", got, ok, head)
+		}
+	}
+
+	if _, err := db.BeginRW(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("BeginRW on a read-only database: err = %v, want ErrReadOnly", err)
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := db.BeginRO()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Abort()
+	if err := SetHeadBlock(tx, db, 9); err == nil {
+		t.Fatal("write in a read transaction succeeded")
+	}
+}
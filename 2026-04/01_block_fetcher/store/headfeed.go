@@ -0,0 +1,95 @@
+package store
+
+import (
+	"log"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// headPollInterval is how often a read-only DB looks for a new head.
+const headPollInterval = 250 * time.Millisecond
+
+// headFeed broadcasts head-block numbers to subscribers. Each subscriber has a
+// 1-slot channel that always holds the newest value: a slow reader skips
+// intermediate heads, never blocks the publisher, and is expected to fill the
+// gap itself from the last head it saw.
+type headFeed struct {
+	mu   sync.Mutex
+	subs map[chan uint64]struct{}
+}
+
+// PublishHead announces that head has been committed. Call it only after the
+// transaction that ran SetHeadBlock has committed, so subscribers reading the
+// DB on wakeup see the new data.
+func (db *DB) PublishHead(head uint64) {
+	f := &db.head
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- head
+	}
+}
+
+// SubscribeHead returns a channel receiving committed head-block numbers and a
+// function that cancels the subscription.
+func (db *DB) SubscribeHead() (<-chan uint64, func()) {
+	f := &db.head
+	ch := make(chan uint64, 1)
+	f.mu.Lock()
+	if f.subs == nil {
+		f.subs = make(map[chan uint64]struct{})
+	}
+	f.subs[ch] = struct{}{}
+	f.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			f.mu.Lock()
+			delete(f.subs, ch)
+			f.mu.Unlock()
+		})
+	}
+}
+
+// watchHead publishes the heads another process commits to a read-only DB,
+// starting from last, until Close. MDBX has no cross-process notification,
+// so the head is polled.
+func (db *DB) watchHead(last uint64) {
+	defer close(db.watchDone)
+	ticker := time.NewTicker(headPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.stopWatch:
+			return
+		case <-ticker.C:
+		}
+		head, err := db.readHead()
+		if err != nil {
+			log.Printf("store: read head: %v", err)
+			continue
+		}
+		if head != last {
+			last = head
+			db.PublishHead(head)
+		}
+	}
+}
+
+func (db *DB) readHead() (uint64, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := db.BeginRO()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Abort()
+	head, _ := GetHeadBlock(tx, db)
+	return head, nil
+}
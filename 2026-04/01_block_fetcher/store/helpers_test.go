@@ -0,0 +1,7 @@
+package store
+
+func testAddr(b byte) [20]byte { return [20]byte{19: b} }
+
+func testAccount(nonce uint64) *Account {
+	return &Account{Nonce: nonce, CodeHash: EmptyCodeHash, StorageRoot: EmptyRootHash}
+}
@@ -99,6 +99,12 @@ func ReadChangeset(tx *mdbx.Txn, db *DB, blockNum uint64) ([]Change, error) {
 	if err != nil {
 		return nil, err
 	}
+	return decodeStoredChangeset(data)
+}
+
+// decodeStoredChangeset decodes a Changesets value: a compression flag byte
+// followed by the (possibly LZ4-compressed) changeset.
+func decodeStoredChangeset(data []byte) ([]Change, error) {
 	var raw []byte
 	if len(data) == 0 {
 		return nil, fmt.Errorf("empty changeset")
@@ -402,6 +408,15 @@ func lookupHistoricalRaw(tx *mdbx.Txn, db *DB, addr [20]byte, slot [32]byte, blo
 	}
 
 	if !hasChange {
+		// HistoryIndex may not reach the head yet, but the changesets above it
+		// are written with the state.
+		old, ok, err := scanUnindexedChangesets(tx, db, keyID, blockNum)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return old, nil
+		}
 		// No block after blockNum changed this key — current flat state is the value.
 		return lookupCurrentFlatValue(tx, db, addr, slot, isAccount)
 	}
@@ -0,0 +1,191 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/erigontech/mdbx-go/mdbx"
+)
+
+// IndexKind names a secondary index that is built behind the executed head
+// from data the executor has already committed.
+type IndexKind string
+
+const (
+	// IndexHistory is HistoryIndex, built from Changesets.
+	IndexHistory IndexKind = "history"
+	// IndexLogs is AddressLogIndex and TopicLogIndex, built from
+	// ReceiptsByBlock.
+	IndexLogs IndexKind = "logs"
+	// IndexTxHashes is TxHashIndex, built from ReceiptsByBlock.
+	IndexTxHashes IndexKind = "txhashes"
+	// IndexAtomic is AtomicTxs and AtomicAddressIndex, built from the
+	// ExtData of the block containers with WriteAtomicIndex.
+	IndexAtomic IndexKind = "atomic"
+)
+
+// IndexKinds lists every deferred index.
+var IndexKinds = []IndexKind{IndexHistory, IndexLogs, IndexTxHashes, IndexAtomic}
+
+func indexedKey(kind IndexKind) []byte {
+	return []byte("indexed_" + string(kind))
+}
+
+// GetIndexedTo returns the last block kind has been built for. Returns
+// 0, false if the watermark is not set, which is the case for databases
+// whose indexes were written with the state.
+func GetIndexedTo(tx *mdbx.Txn, db *DB, kind IndexKind) (uint64, bool) {
+	val, err := tx.Get(db.Metadata, indexedKey(kind))
+	if err != nil || len(val) < 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(val), true
+}
+
+func setIndexedTo(tx *mdbx.Txn, db *DB, kind IndexKind, num uint64) error {
+	key := BlockKey(num)
+	return tx.Put(db.Metadata, indexedKey(kind), key[:], 0)
+}
+
+// UnindexedRange returns the blocks [from, to] the executor has committed
+// but kind has not been built for yet. from > to when kind is caught up.
+func UnindexedRange(tx *mdbx.Txn, db *DB, kind IndexKind) (from, to uint64) {
+	head, _ := GetHeadBlock(tx, db)
+	indexed, ok := GetIndexedTo(tx, db, kind)
+	if !ok || indexed >= head {
+		return head + 1, head
+	}
+	return indexed + 1, head
+}
+
+// InitIndexWatermarks sets every unset watermark to the executed head. Call
+// it before the executor commits anything: until then all indexes are
+// complete up to the head. IndexAtomic was never written with the state, so
+// it starts at 0 and is built for the whole chain.
+func InitIndexWatermarks(tx *mdbx.Txn, db *DB) error {
+	head, _ := GetHeadBlock(tx, db)
+	for _, kind := range IndexKinds {
+		if _, ok := GetIndexedTo(tx, db, kind); ok {
+			continue
+		}
+		start := head
+		if kind == IndexAtomic {
+			start = 0
+		}
+		if err := setIndexedTo(tx, db, kind, start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BuildIndex adds blocks [from, to] to kind and moves its watermark to to.
+func BuildIndex(tx *mdbx.Txn, db *DB, kind IndexKind, from, to uint64) error {
+	var err error
+	switch kind {
+	case IndexHistory:
+		err = buildHistoryIndex(tx, db, from, to)
+	case IndexLogs:
+		err = buildLogIndex(tx, db, from, to)
+	case IndexTxHashes:
+		err = buildTxHashIndex(tx, db, from, to)
+	case IndexAtomic:
+		err = fmt.Errorf("%s index is built with WriteAtomicIndex", kind)
+	default:
+		err = fmt.Errorf("unknown index %q", kind)
+	}
+	if err != nil {
+		return err
+	}
+	return setIndexedTo(tx, db, kind, to)
+}
+
+// scanUnindexedChangesets returns the old value of keyID in the first
+// changeset above blockNum that HistoryIndex does not cover yet. The scan is
+// linear, so it is only cheap while the indexer keeps up.
+func scanUnindexedChangesets(tx *mdbx.Txn, db *DB, keyID, blockNum uint64) ([]byte, bool, error) {
+	from, to := UnindexedRange(tx, db, IndexHistory)
+	for n := max(from, blockNum+1); n <= to; n++ {
+		changes, err := ReadChangeset(tx, db, n)
+		if err != nil {
+			if mdbx.IsNotFound(err) {
+				continue
+			}
+			return nil, false, fmt.Errorf("ReadChangeset at block %d: %w", n, err)
+		}
+		for _, c := range changes {
+			if c.KeyID == keyID {
+				return c.OldValue, true, nil
+			}
+		}
+	}
+	return nil, false, nil
+}
+
+func buildHistoryIndex(tx *mdbx.Txn, db *DB, from, to uint64) error {
+	pending := make(map[uint64][]uint64, 4096)
+	for n := from; n <= to; n++ {
+		changes, err := ReadChangeset(tx, db, n)
+		if err != nil {
+			if mdbx.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("changeset %d: %w", n, err)
+		}
+		for _, c := range changes {
+			blocks := pending[c.KeyID]
+			if len(blocks) == 0 || blocks[len(blocks)-1] != n {
+				pending[c.KeyID] = append(blocks, n)
+			}
+		}
+	}
+	return FlushHistoryIndexBatch(tx, db, pending)
+}
+
+func buildLogIndex(tx *mdbx.Txn, db *DB, from, to uint64) error {
+	addrPending := make(map[string][]uint64, 4096)
+	topicPending := make(map[string][]uint64, 4096)
+	for n := from; n <= to; n++ {
+		receipts, err := ReadBlockReceipts(tx, db, n)
+		if err != nil {
+			return err
+		}
+		seen := make(map[[20]byte]bool)
+		seenTopics := make(map[[32]byte]bool)
+		for _, r := range receipts {
+			for _, l := range r.Logs {
+				if !seen[l.Address] {
+					seen[l.Address] = true
+					addrPending[string(l.Address[:])] = append(addrPending[string(l.Address[:])], n)
+				}
+				for _, t := range l.Topics {
+					if !seenTopics[t] {
+						seenTopics[t] = true
+						topicPending[string(t[:])] = append(topicPending[string(t[:])], n)
+					}
+				}
+			}
+		}
+	}
+	if err := FlushLogIndexBatch(tx, db.AddressLogIndex, addrPending); err != nil {
+		return fmt.Errorf("address log index: %w", err)
+	}
+	if err := FlushLogIndexBatch(tx, db.TopicLogIndex, topicPending); err != nil {
+		return fmt.Errorf("topic log index: %w", err)
+	}
+	return nil
+}
+
+func buildTxHashIndex(tx *mdbx.Txn, db *DB, from, to uint64) error {
+	var entries []TxHashEntry
+	for n := from; n <= to; n++ {
+		receipts, err := ReadBlockReceipts(tx, db, n)
+		if err != nil {
+			return err
+		}
+		for i, r := range receipts {
+			entries = append(entries, TxHashEntry{TxHash: r.TxHash, BlockNum: n, TxIndex: uint16(i)})
+		}
+	}
+	return FlushTxHashBatch(tx, db, entries)
+}
@@ -0,0 +1,273 @@
+package store
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store/storetest"
+)
+
+func TestIndexWatermarks(t *testing.T) {
+	db := storetest.Open(t, Open)
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		if _, ok := GetIndexedTo(tx, db, IndexHistory); ok {
+			t.Fatal("watermark set on a new database")
+		}
+		if err := SetHeadBlock(tx, db, 3); err != nil {
+			t.Fatal(err)
+		}
+		if err := InitIndexWatermarks(tx, db); err != nil {
+			t.Fatal(err)
+		}
+		for _, kind := range IndexKinds {
+			want := uint64(3)
+			if kind == IndexAtomic {
+				want = 0
+			}
+			if got, ok := GetIndexedTo(tx, db, kind); !ok || got != want {
+				t.Fatalf("%s watermark %d (set %v), want %d", kind, got, ok, want)
+			}
+		}
+		if from, to := UnindexedRange(tx, db, IndexLogs); from <= to {
+			t.Fatalf("caught-up index has unindexed range [%d, %d]", from, to)
+		}
+
+		if err := SetHeadBlock(tx, db, 6); err != nil {
+			t.Fatal(err)
+		}
+		if err := InitIndexWatermarks(tx, db); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := GetIndexedTo(tx, db, IndexLogs); got != 3 {
+			t.Fatalf("InitIndexWatermarks moved a set watermark to %d", got)
+		}
+		if from, to := UnindexedRange(tx, db, IndexLogs); from != 4 || to != 6 {
+			t.Fatalf("unindexed range [%d, %d], want [4, 6]", from, to)
+		}
+		if from, to := UnindexedRange(tx, db, IndexAtomic); from != 1 || to != 6 {
+			t.Fatalf("atomic unindexed range [%d, %d], want [1, 6]", from, to)
+		}
+	})
+}
+
+// writeIndexTestBlocks commits blocks 1..n the way the executor does with
+// deferred indexes: a changeset setting testAddr(1)'s nonce to the block
+// number and one receipt with a log, but no index entries.
+func writeIndexTestBlocks(t *testing.T, db *DB, n uint64) {
+	t.Helper()
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		if err := InitIndexWatermarks(tx, db); err != nil {
+			t.Fatal(err)
+		}
+		keyID, err := GetOrAssignKeyID(tx, db, testAddr(1), AccountSentinelSlot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for num := uint64(1); num <= n; num++ {
+			var old []byte
+			if num > 1 {
+				old = EncodeAccountBytes(testAccount(num - 1))
+			}
+			if err := WriteChangeset(tx, db, num, []Change{{KeyID: keyID, OldValue: old}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := PutAccount(tx, db, testAddr(1), testAccount(num)); err != nil {
+				t.Fatal(err)
+			}
+			receipts := []TxReceipt{{
+				TxHash: [32]byte{0: byte(num)},
+				Status: 1,
+				Logs:   []LogEntry{{Address: testAddr(byte(num)), Topics: [][32]byte{{31: byte(num)}}}},
+			}}
+			if err := WriteBlockReceipts(tx, db, num, receipts); err != nil {
+				t.Fatal(err)
+			}
+			if err := SetHeadBlock(tx, db, num); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestBuildIndex(t *testing.T) {
+	db := storetest.Open(t, Open)
+	writeIndexTestBlocks(t, db, 6)
+
+	// Build in two chunks, the way the indexer catches up.
+	for _, chunk := range [][2]uint64{{1, 3}, {4, 6}} {
+		storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+			for _, kind := range []IndexKind{IndexHistory, IndexLogs, IndexTxHashes} {
+				from, to := UnindexedRange(tx, db, kind)
+				if from != chunk[0] || to != 6 {
+					t.Fatalf("%s unindexed range [%d, %d], want [%d, 6]", kind, from, to, chunk[0])
+				}
+				if err := BuildIndex(tx, db, kind, from, chunk[1]); err != nil {
+					t.Fatal(err)
+				}
+				if got, _ := GetIndexedTo(tx, db, kind); got != chunk[1] {
+					t.Fatalf("%s watermark %d, want %d", kind, got, chunk[1])
+				}
+			}
+		})
+	}
+
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+		keyID, _, err := GetKeyID(tx, db, testAddr(1), AccountSentinelSlot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for num := uint64(1); num <= 6; num++ {
+			blockNum, txIndex, err := GetTxLocation(tx, db, [32]byte{0: byte(num)})
+			if err != nil || blockNum != num || txIndex != 0 {
+				t.Fatalf("tx of block %d at %d/%d: %v", num, blockNum, txIndex, err)
+			}
+			bm, err := ReadAddressLogIndex(tx, db, testAddr(byte(num)), 0, 6)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bm.GetCardinality() != 1 || !bm.Contains(num) {
+				t.Fatalf("address log index of block %d: %v", num, bm.ToArray())
+			}
+			bm, err = ReadTopicLogIndex(tx, db, [32]byte{31: byte(num)}, 0, 6)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bm.GetCardinality() != 1 || !bm.Contains(num) {
+				t.Fatalf("topic log index of block %d: %v", num, bm.ToArray())
+			}
+			changed, ok, err := LookupHistoricalBlock(tx, db, keyID, num)
+			if err != nil || !ok || changed != num {
+				t.Fatalf("history of block %d: %d (found %v): %v", num, changed, ok, err)
+			}
+		}
+	})
+}
+
+func TestBuildIndexAtomicRefused(t *testing.T) {
+	db := storetest.Open(t, Open)
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		if err := BuildIndex(tx, db, IndexAtomic, 1, 1); err == nil {
+			t.Fatal("BuildIndex built the atomic index")
+		}
+	})
+}
+
+// Historical reads must see the changesets above the HistoryIndex watermark.
+func TestLookupHistoricalUnindexed(t *testing.T) {
+	db := storetest.Open(t, Open)
+	writeIndexTestBlocks(t, db, 6)
+
+	check := func(stage string) {
+		t.Helper()
+		storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+			for num := uint64(1); num <= 6; num++ {
+				acct, err := LookupHistoricalAccount(tx, db, testAddr(1), num)
+				if err != nil {
+					t.Fatalf("%s: block %d: %v", stage, num, err)
+				}
+				if acct == nil || acct.Nonce != num {
+					t.Fatalf("%s: block %d: account %+v, want nonce %d", stage, num, acct, num)
+				}
+			}
+		})
+	}
+
+	check("unindexed")
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		if err := BuildIndex(tx, db, IndexHistory, 1, 3); err != nil {
+			t.Fatal(err)
+		}
+	})
+	check("indexed to 3")
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		keyID, _, err := GetKeyID(tx, db, testAddr(1), AccountSentinelSlot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old, ok, err := scanUnindexedChangesets(tx, db, keyID, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The scan starts above the watermark: block 4 set nonce 3 to 4.
+		if !ok || DecodeAccount(old).Nonce != 3 {
+			t.Fatalf("scan below the watermark: %x (found %v)", old, ok)
+		}
+		if err := BuildIndex(tx, db, IndexHistory, 4, 6); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, _ := scanUnindexedChangesets(tx, db, keyID, 0); ok {
+			t.Fatal("scan found a changeset with HistoryIndex caught up")
+		}
+	})
+	check("indexed to 6")
+}
+
+// benchIndexBlocks is the synthetic batch BenchmarkDeferredIndexes flushes:
+// per block, 300 changed keys and 50 receipts with two logs each, drawn from
+// a few hundred hot addresses and topics.
+const benchIndexBlocks = 2000
+
+func writeBenchIndexBlocks(b *testing.B, tx *mdbx.Txn, db *DB) {
+	b.Helper()
+	rng := rand.New(rand.NewPCG(1, 2))
+	old := make([]byte, 32)
+	for num := uint64(1); num <= benchIndexBlocks; num++ {
+		changes := make([]Change, 300)
+		for i := range changes {
+			changes[i] = Change{KeyID: rng.Uint64N(200_000), OldValue: old}
+		}
+		if err := WriteChangeset(tx, db, num, changes); err != nil {
+			b.Fatal(err)
+		}
+		receipts := make([]TxReceipt, 50)
+		for i := range receipts {
+			r := &receipts[i]
+			binary.BigEndian.PutUint64(r.TxHash[:], num<<16|uint64(i))
+			r.TxHash[31] = byte(rng.Uint64())
+			for range 2 {
+				r.Logs = append(r.Logs, LogEntry{
+					Address: testAddr(byte(rng.Uint64N(250))),
+					Topics:  [][32]byte{{31: byte(rng.Uint64N(200))}, {0: byte(rng.Uint64())}},
+					Data:    old,
+				})
+			}
+		}
+		if err := WriteBlockReceipts(tx, db, num, receipts); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := SetHeadBlock(tx, db, benchIndexBlocks); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkDeferredIndexes times the writes that stay in the executor's
+// critical flush against the index builds the indexer took off it.
+func BenchmarkDeferredIndexes(b *testing.B) {
+	b.Run("critical", func(b *testing.B) {
+		for b.Loop() {
+			b.StopTimer()
+			db := storetest.Open(b, Open)
+			b.StartTimer()
+			storetest.WithRW(b, db, func(tx *mdbx.Txn) { writeBenchIndexBlocks(b, tx, db) })
+		}
+	})
+	b.Run("deferred", func(b *testing.B) {
+		for b.Loop() {
+			b.StopTimer()
+			db := storetest.Open(b, Open)
+			storetest.WithRW(b, db, func(tx *mdbx.Txn) { writeBenchIndexBlocks(b, tx, db) })
+			b.StartTimer()
+			storetest.WithRW(b, db, func(tx *mdbx.Txn) {
+				for _, kind := range []IndexKind{IndexHistory, IndexLogs, IndexTxHashes} {
+					if err := BuildIndex(tx, db, kind, 1, benchIndexBlocks); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	})
+}
@@ -1,11 +1,22 @@
 package store
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
 
 	"github.com/erigontech/mdbx-go/mdbx"
 )
 
+// Metadata keys of the reverse dictionary backfill. keydictReversedKey is
+// set once AddressByID and SlotByID hold every assigned ID;
+// keydictReversePosKey is where ReverseKeyDict resumes: a phase byte, 0 for
+// AddressIndex and 1 for SlotIndex, followed by the next key to copy.
+var (
+	keydictReversedKey   = []byte("keydict_reversed")
+	keydictReversePosKey = []byte("keydict_reverse_pos")
+)
+
 // GetOrAssignKeyID returns the keyID for (addr, slot), assigning new IDs if needed.
 // This must be called within a RW transaction.
 func GetOrAssignKeyID(tx *mdbx.Txn, db *DB, addr [20]byte, slot [32]byte) (uint64, error) {
@@ -67,6 +78,9 @@ func getOrAssignAddressID(tx *mdbx.Txn, db *DB, addr [20]byte) (uint32, error) {
 	if err := tx.Put(db.AddressIndex, addr[:], buf[:], 0); err != nil {
 		return 0, err
 	}
+	if err := tx.Put(db.AddressByID, buf[:], addr[:], 0); err != nil {
+		return 0, err
+	}
 	if err := setNextCounter(tx, db, "next_address_id", id+1); err != nil {
 		return 0, err
 	}
@@ -97,12 +111,115 @@ func getOrAssignSlotID(tx *mdbx.Txn, db *DB, addressID uint32, slot [32]byte) (u
 	if err := tx.Put(db.SlotIndex, slotKey[:], buf[:], 0); err != nil {
 		return 0, err
 	}
+	reverseKey := KeyIDBytes(KeyIDEncode(addressID, id))
+	if err := tx.Put(db.SlotByID, reverseKey[:], slot[:], 0); err != nil {
+		return 0, err
+	}
 	if err := setNextCounter(tx, db, counterKey, id+1); err != nil {
 		return 0, err
 	}
 	return id, nil
 }
 
+// KeyDictReversed reports whether AddressByID and SlotByID cover every
+// assigned keyID. Databases written before the reverse tables existed are
+// backfilled by ReverseKeyDict.
+func KeyDictReversed(tx *mdbx.Txn, db *DB) bool {
+	_, err := tx.Get(db.Metadata, keydictReversedKey)
+	return err == nil
+}
+
+// ResolveKeyID returns the (address, slot) a keyID was assigned to, slot
+// being AccountSentinelSlot for the account itself. It needs the reverse
+// tables; check KeyDictReversed first on a database that may predate them.
+func ResolveKeyID(tx *mdbx.Txn, db *DB, keyID uint64) ([20]byte, [32]byte, error) {
+	addressID, _ := KeyIDDecode(keyID)
+	var idKey [4]byte
+	binary.BigEndian.PutUint32(idKey[:], addressID)
+	addr, err := tx.Get(db.AddressByID, idKey[:])
+	if err != nil {
+		return [20]byte{}, [32]byte{}, fmt.Errorf("address ID %d: %w", addressID, err)
+	}
+	key := KeyIDBytes(keyID)
+	slot, err := tx.Get(db.SlotByID, key[:])
+	if err != nil {
+		return [20]byte{}, [32]byte{}, fmt.Errorf("keyID %d: %w", keyID, err)
+	}
+	return [20]byte(addr), [32]byte(slot), nil
+}
+
+// ReverseKeyDict copies up to limit AddressIndex and SlotIndex entries into
+// AddressByID and SlotByID, resuming where the previous call stopped, and
+// reports whether the reverse tables are complete. IDs assigned meanwhile
+// are written both ways by GetOrAssignKeyID, so the copy may run alongside
+// the executor in transactions of its own. A negative limit copies the rest.
+func ReverseKeyDict(tx *mdbx.Txn, db *DB, limit int) (bool, error) {
+	if KeyDictReversed(tx, db) {
+		return true, nil
+	}
+	phase, from := byte(0), []byte(nil)
+	if pos, err := tx.Get(db.Metadata, keydictReversePosKey); err == nil && len(pos) > 0 {
+		phase, from = pos[0], bytes.Clone(pos[1:])
+	} else if err != nil && !mdbx.IsNotFound(err) {
+		return false, err
+	}
+
+	for ; phase < 2; phase, from = phase+1, nil {
+		src := db.AddressIndex
+		if phase == 1 {
+			src = db.SlotIndex
+		}
+		next, err := reverseKeyDictRange(tx, db, src, from, &limit)
+		if err != nil {
+			return false, err
+		}
+		if next != nil {
+			pos := append([]byte{phase}, next...)
+			return false, tx.Put(db.Metadata, keydictReversePosKey, pos, 0)
+		}
+	}
+	if err := tx.Del(db.Metadata, keydictReversePosKey, nil); err != nil && !mdbx.IsNotFound(err) {
+		return false, err
+	}
+	return true, tx.Put(db.Metadata, keydictReversedKey, []byte{1}, 0)
+}
+
+// reverseKeyDictRange copies the entries of src from key from on, while
+// *limit allows, and returns the key to resume at, nil once src is done.
+func reverseKeyDictRange(tx *mdbx.Txn, db *DB, src mdbx.DBI, from []byte, limit *int) ([]byte, error) {
+	cursor, err := tx.OpenCursor(src)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var k, v []byte
+	if from == nil {
+		k, v, err = cursor.Get(nil, nil, mdbx.First)
+	} else {
+		k, v, err = cursor.Get(from, nil, mdbx.SetRange)
+	}
+	for ; err == nil; k, v, err = cursor.Get(nil, nil, mdbx.Next) {
+		if *limit == 0 {
+			return bytes.Clone(k), nil
+		}
+		*limit--
+		if src == db.AddressIndex {
+			err = tx.Put(db.AddressByID, v, k, 0)
+		} else {
+			key := KeyIDBytes(KeyIDEncode(binary.BigEndian.Uint32(k[:4]), binary.BigEndian.Uint32(v)))
+			err = tx.Put(db.SlotByID, key[:], k[4:], 0)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !mdbx.IsNotFound(err) {
+		return nil, err
+	}
+	return nil, nil
+}
+
 func getNextCounter(tx *mdbx.Txn, db *DB, key string) (uint32, error) {
 	val, err := tx.Get(db.Metadata, []byte(key))
 	if err != nil {
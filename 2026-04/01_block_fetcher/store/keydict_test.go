@@ -0,0 +1,91 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store/storetest"
+)
+
+// testKey is an (address, slot) pair of the key dictionary.
+type testKey struct {
+	addr [20]byte
+	slot [32]byte
+}
+
+func TestReverseKeyDictResumes(t *testing.T) {
+	for _, limit := range []int{1, 3, 4} {
+		t.Run(fmt.Sprintf("limit %d", limit), func(t *testing.T) {
+			db := storetest.Open(t, Open)
+			ids := make(map[uint64]testKey)
+			assign := func(tx *mdbx.Txn, k testKey) {
+				id, err := GetOrAssignKeyID(tx, db, k.addr, k.slot)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids[id] = k
+			}
+			// A database from before the reverse tables: four accounts with
+			// up to two slots each, and no AddressByID or SlotByID.
+			storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+				for a := byte(1); a <= 4; a++ {
+					assign(tx, testKey{testAddr(a), AccountSentinelSlot})
+					for s := byte(1); s < a && s <= 2; s++ {
+						assign(tx, testKey{testAddr(a), [32]byte{31: s}})
+					}
+				}
+				for _, dbi := range []mdbx.DBI{db.AddressByID, db.SlotByID} {
+					if err := tx.Drop(dbi, false); err != nil {
+						t.Fatal(err)
+					}
+				}
+			})
+
+			passes := 0
+			for done := false; !done; passes++ {
+				storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+					var err error
+					if done, err = ReverseKeyDict(tx, db, limit); err != nil {
+						t.Fatal(err)
+					}
+					if KeyDictReversed(tx, db) != done {
+						t.Fatalf("reversed = %v after a pass that returned %v", !done, done)
+					}
+					// The executor assigns IDs between the first passes,
+					// writing both tables itself.
+					if !done && passes < 3 {
+						assign(tx, testKey{testAddr(byte(0x10 + passes)), [32]byte{31: 1}})
+					}
+				})
+				if passes > 100 {
+					t.Fatal("backfill does not finish")
+				}
+			}
+			if passes < 2 {
+				t.Fatalf("backfill finished in %d pass with limit %d", passes, limit)
+			}
+
+			storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+				for id, want := range ids {
+					addr, slot, err := ResolveKeyID(tx, db, id)
+					if err != nil {
+						t.Fatalf("keyID %d: %v", id, err)
+					}
+					if addr != want.addr || slot != want.slot {
+						t.Fatalf("keyID %d resolves to %x/%x, want %x/%x", id, addr, slot, want.addr, want.slot)
+					}
+				}
+				if _, err := tx.Get(db.Metadata, keydictReversePosKey); !mdbx.IsNotFound(err) {
+					t.Fatalf("resume position left behind: %v", err)
+				}
+			})
+			storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+				if done, err := ReverseKeyDict(tx, db, limit); err != nil || !done {
+					t.Fatalf("finished backfill resumed: %v, %v", done, err)
+				}
+			})
+		})
+	}
+}
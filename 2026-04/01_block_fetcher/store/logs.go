@@ -512,3 +512,66 @@ func updateLogIndex(tx *mdbx.Txn, dbi mdbx.DBI, prefix []byte, blockNum uint64)
 	binary.BigEndian.PutUint64(sentinel[len(prefix):], 0xFFFFFFFFFFFFFFFF)
 	return tx.Put(dbi, sentinel, logBuf.Bytes(), 0)
 }
+
+// ReadAddressLogIndex returns the blocks in [from, to] that contain at least one
+// log emitted by address.
+func ReadAddressLogIndex(tx *mdbx.Txn, db *DB, address [20]byte, from, to uint64) (*roaring64.Bitmap, error) {
+	return readLogIndex(tx, db.AddressLogIndex, address[:], from, to)
+}
+
+// ReadTopicLogIndex returns the blocks in [from, to] that contain at least one
+// log carrying topic at any position. Callers must re-check the position.
+func ReadTopicLogIndex(tx *mdbx.Txn, db *DB, topic [32]byte, from, to uint64) (*roaring64.Bitmap, error) {
+	return readLogIndex(tx, db.TopicLogIndex, topic[:], from, to)
+}
+
+// readLogIndex unions every shard of prefix that can overlap [from, to].
+// Shards are keyed by their max block, so the first candidate is found with
+// SetRange on prefix++from and the walk stops after the shard covering to.
+func readLogIndex(tx *mdbx.Txn, dbi mdbx.DBI, prefix []byte, from, to uint64) (*roaring64.Bitmap, error) {
+	result := roaring64.NewBitmap()
+	if from > to {
+		return result, nil
+	}
+	cursor, err := tx.OpenCursor(dbi)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	seekKey := make([]byte, len(prefix)+8)
+	copy(seekKey, prefix)
+	binary.BigEndian.PutUint64(seekKey[len(prefix):], from)
+
+	shard := roaring64.NewBitmap()
+	k, v, err := cursor.Get(seekKey, nil, mdbx.SetRange)
+	for {
+		if err != nil {
+			if mdbx.IsNotFound(err) {
+				break
+			}
+			return nil, err
+		}
+		if len(k) != len(prefix)+8 || !bytes.HasPrefix(k, prefix) {
+			break
+		}
+		shard.Clear()
+		if _, err := shard.ReadFrom(bytes.NewReader(v)); err != nil {
+			return nil, fmt.Errorf("decode log bitmap: %w", err)
+		}
+		result.Or(shard)
+		if binary.BigEndian.Uint64(k[len(prefix):]) >= to {
+			break
+		}
+		k, v, err = cursor.Get(nil, nil, mdbx.Next)
+	}
+
+	// Trim to the requested range; shards straddle the boundaries.
+	if from > 0 {
+		result.RemoveRange(0, from)
+	}
+	if to < ^uint64(0) {
+		result.RemoveRange(to+1, ^uint64(0))
+	}
+	return result, nil
+}
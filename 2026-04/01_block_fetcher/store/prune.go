@@ -0,0 +1,243 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/RoaringBitmap/roaring/v2/roaring64"
+	"github.com/erigontech/mdbx-go/mdbx"
+)
+
+// PruneKind names a group of history tables that is pruned as one.
+type PruneKind string
+
+const (
+	// PruneHistory covers Changesets and HistoryIndex: historical state.
+	PruneHistory PruneKind = "history"
+	// PruneReceipts covers ReceiptsByBlock and the log indexes built from
+	// them, AddressLogIndex and TopicLogIndex.
+	PruneReceipts PruneKind = "receipts"
+	// PruneTxIndex covers TxHashIndex.
+	PruneTxIndex PruneKind = "txindex"
+)
+
+func prunedKey(kind PruneKind) []byte {
+	return []byte("pruned_" + string(kind))
+}
+
+// GetPrunedBelow returns the lowest block whose kind of history is still
+// kept. Everything under it has been pruned. Returns 0 if nothing has been.
+func GetPrunedBelow(tx *mdbx.Txn, db *DB, kind PruneKind) (uint64, error) {
+	val, err := tx.Get(db.Metadata, prunedKey(kind))
+	if err != nil {
+		if mdbx.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if len(val) < 8 {
+		return 0, fmt.Errorf("%s prune marker too short", kind)
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
+
+func setPrunedBelow(tx *mdbx.Txn, db *DB, kind PruneKind, num uint64) error {
+	key := BlockKey(num)
+	return tx.Put(db.Metadata, prunedKey(kind), key[:], 0)
+}
+
+// PruneStateHistory deletes the changesets of blocks [from, to) and drops
+// those blocks from the HistoryIndex bitmaps of the keys they touched. State
+// stays readable at every block from `to` on: a lookup only needs the
+// changesets above the block it asks about.
+func PruneStateHistory(tx *mdbx.Txn, db *DB, from, to uint64) error {
+	touched := make(map[uint64]struct{})
+	for n := from; n < to; n++ {
+		key := BlockKey(n)
+		data, err := tx.Get(db.Changesets, key[:])
+		if err != nil {
+			if mdbx.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		changes, err := decodeStoredChangeset(data)
+		if err != nil {
+			return fmt.Errorf("changeset %d: %w", n, err)
+		}
+		for _, c := range changes {
+			touched[c.KeyID] = struct{}{}
+		}
+		if err := tx.Del(db.Changesets, key[:], nil); err != nil {
+			return err
+		}
+	}
+
+	keyIDs := make([]uint64, 0, len(touched))
+	for id := range touched {
+		keyIDs = append(keyIDs, id)
+	}
+	sort.Slice(keyIDs, func(i, j int) bool { return keyIDs[i] < keyIDs[j] })
+	for _, id := range keyIDs {
+		prefix := KeyIDBytes(id)
+		if err := trimShards(tx, db.HistoryIndex, prefix[:], to); err != nil {
+			return fmt.Errorf("trim history of keyID %d: %w", id, err)
+		}
+	}
+	return setPrunedBelow(tx, db, PruneHistory, to)
+}
+
+// PruneBlockReceipts deletes the receipts of blocks [from, to) and drops those
+// blocks from the address and topic log indexes.
+func PruneBlockReceipts(tx *mdbx.Txn, db *DB, from, to uint64) error {
+	addresses := make(map[[20]byte]struct{})
+	topics := make(map[[32]byte]struct{})
+	for n := from; n < to; n++ {
+		receipts, err := ReadBlockReceipts(tx, db, n)
+		if err != nil {
+			return err
+		}
+		if receipts == nil {
+			continue
+		}
+		for _, r := range receipts {
+			for _, l := range r.Logs {
+				addresses[l.Address] = struct{}{}
+				for _, t := range l.Topics {
+					topics[t] = struct{}{}
+				}
+			}
+		}
+		key := BlockKey(n)
+		if err := tx.Del(db.ReceiptsByBlock, key[:], nil); err != nil {
+			return err
+		}
+	}
+
+	addrKeys := make([][]byte, 0, len(addresses))
+	for a := range addresses {
+		addrKeys = append(addrKeys, bytes.Clone(a[:]))
+	}
+	topicKeys := make([][]byte, 0, len(topics))
+	for t := range topics {
+		topicKeys = append(topicKeys, bytes.Clone(t[:]))
+	}
+	for _, idx := range []struct {
+		dbi      mdbx.DBI
+		prefixes [][]byte
+	}{
+		{db.AddressLogIndex, addrKeys},
+		{db.TopicLogIndex, topicKeys},
+	} {
+		sort.Slice(idx.prefixes, func(i, j int) bool {
+			return bytes.Compare(idx.prefixes[i], idx.prefixes[j]) < 0
+		})
+		for _, prefix := range idx.prefixes {
+			if err := trimShards(tx, idx.dbi, prefix, to); err != nil {
+				return fmt.Errorf("trim log index %x: %w", prefix, err)
+			}
+		}
+	}
+	return setPrunedBelow(tx, db, PruneReceipts, to)
+}
+
+// PruneTxHashes deletes the TxHashIndex entries of txHashes, the transactions
+// of blocks below `to`. Entries pointing at a block from `to` on are kept.
+func PruneTxHashes(tx *mdbx.Txn, db *DB, txHashes [][32]byte, to uint64) error {
+	for _, h := range txHashes {
+		blockNum, _, err := GetTxLocation(tx, db, h)
+		if err != nil {
+			if mdbx.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if blockNum >= to {
+			continue
+		}
+		if err := tx.Del(db.TxHashIndex, h[:], nil); err != nil {
+			return err
+		}
+	}
+	return setPrunedBelow(tx, db, PruneTxIndex, to)
+}
+
+// MarkHistoryStart records that a database holds no history below block
+// num, as after loading a state snapshot taken at num: state is readable from
+// num on, receipts and the tx index from num+1.
+func MarkHistoryStart(tx *mdbx.Txn, db *DB, num uint64) error {
+	if err := setPrunedBelow(tx, db, PruneHistory, num); err != nil {
+		return err
+	}
+	if err := setPrunedBelow(tx, db, PruneReceipts, num+1); err != nil {
+		return err
+	}
+	return setPrunedBelow(tx, db, PruneTxIndex, num+1)
+}
+
+// trimShards removes the blocks under `below` from the bitmap shards of
+// prefix. Shards are keyed prefix++maxBlock, so the walk goes up from the
+// lowest shard: shards that end under `below` are deleted whole, and the
+// first one reaching it is cut down and rewritten.
+func trimShards(tx *mdbx.Txn, dbi mdbx.DBI, prefix []byte, below uint64) error {
+	cursor, err := tx.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	seekKey := make([]byte, len(prefix)+8)
+	copy(seekKey, prefix)
+
+	var (
+		dead     [][]byte
+		cutKey   []byte
+		cutShard *roaring64.Bitmap
+	)
+	k, v, err := cursor.Get(seekKey, nil, mdbx.SetRange)
+	for {
+		if err != nil {
+			if mdbx.IsNotFound(err) {
+				break
+			}
+			return err
+		}
+		if len(k) != len(prefix)+8 || !bytes.HasPrefix(k, prefix) {
+			break
+		}
+		if binary.BigEndian.Uint64(k[len(prefix):]) < below {
+			dead = append(dead, bytes.Clone(k))
+			k, v, err = cursor.Get(nil, nil, mdbx.Next)
+			continue
+		}
+		bm := roaring64.NewBitmap()
+		if _, err := bm.ReadFrom(bytes.NewReader(v)); err != nil {
+			return fmt.Errorf("decode bitmap: %w", err)
+		}
+		if !bm.IsEmpty() && bm.Minimum() < below {
+			bm.RemoveRange(0, below)
+			if bm.IsEmpty() {
+				dead = append(dead, bytes.Clone(k))
+			} else {
+				cutKey, cutShard = bytes.Clone(k), bm
+			}
+		}
+		break
+	}
+
+	for _, key := range dead {
+		if err := tx.Del(dbi, key, nil); err != nil {
+			return err
+		}
+	}
+	if cutShard == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if _, err := cutShard.WriteTo(&buf); err != nil {
+		return err
+	}
+	return tx.Put(dbi, cutKey, buf.Bytes(), 0)
+}
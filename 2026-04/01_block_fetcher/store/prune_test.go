@@ -0,0 +1,169 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring/v2/roaring64"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store/storetest"
+)
+
+// putTestShards writes HistoryIndex shards of keyID, keyed by their maximum
+// block, with the given blocks.
+func putTestShards(t *testing.T, tx *mdbx.Txn, db *DB, keyID uint64, shards map[uint64][]uint64) {
+	t.Helper()
+	for shardMax, blocks := range shards {
+		var buf bytes.Buffer
+		if _, err := roaring64.BitmapOf(blocks...).WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		key := HistoryKey(keyID, shardMax)
+		if err := tx.Put(db.HistoryIndex, key[:], buf.Bytes(), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTestShards returns the HistoryIndex shards of keyID.
+func readTestShards(t *testing.T, tx *mdbx.Txn, db *DB, keyID uint64) map[uint64][]uint64 {
+	t.Helper()
+	cursor, err := tx.OpenCursor(db.HistoryIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cursor.Close()
+	out := make(map[uint64][]uint64)
+	start := HistoryKey(keyID, 0)
+	k, v, err := cursor.Get(start[:], nil, mdbx.SetRange)
+	for ; err == nil && bytes.HasPrefix(k, start[:8]); k, v, err = cursor.Get(nil, nil, mdbx.Next) {
+		bm := roaring64.NewBitmap()
+		if _, err := bm.ReadFrom(bytes.NewReader(v)); err != nil {
+			t.Fatal(err)
+		}
+		out[binary.BigEndian.Uint64(k[8:])] = bm.ToArray()
+	}
+	if err != nil && !mdbx.IsNotFound(err) {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestTrimShards(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		shards map[uint64][]uint64
+		want   map[uint64][]uint64
+	}{
+		{
+			name:   "shard cut at the horizon",
+			shards: map[uint64][]uint64{200: {120, 180, 200}, math.MaxUint64: {250}},
+			want:   map[uint64][]uint64{200: {180, 200}, math.MaxUint64: {250}},
+		},
+		{
+			name:   "dead shards removed",
+			shards: map[uint64][]uint64{100: {10, 100}, 140: {120, 140}, 200: {150, 200}, math.MaxUint64: {250}},
+			want:   map[uint64][]uint64{200: {150, 200}, math.MaxUint64: {250}},
+		},
+		{
+			name:   "shard ending past the horizon emptied",
+			shards: map[uint64][]uint64{100: {10, 100}, 200: {120, 140}, math.MaxUint64: {250}},
+			want:   map[uint64][]uint64{math.MaxUint64: {250}},
+		},
+		{
+			name:   "sentinel shard cut",
+			shards: map[uint64][]uint64{math.MaxUint64: {10, 140, 150, 300}},
+			want:   map[uint64][]uint64{math.MaxUint64: {150, 300}},
+		},
+		{
+			name:   "dead sentinel shard removed",
+			shards: map[uint64][]uint64{100: {10}, math.MaxUint64: {120, 149}},
+			want:   map[uint64][]uint64{},
+		},
+		{
+			name:   "nothing under the horizon",
+			shards: map[uint64][]uint64{200: {150, 200}, math.MaxUint64: {250}},
+			want:   map[uint64][]uint64{200: {150, 200}, math.MaxUint64: {250}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := storetest.Open(t, Open)
+			// The neighbours' shards share no prefix with keyID 5's.
+			neighbours := map[uint64][]uint64{100: {1, 100}, math.MaxUint64: {140}}
+			storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+				putTestShards(t, tx, db, 4, neighbours)
+				putTestShards(t, tx, db, 5, tc.shards)
+				putTestShards(t, tx, db, 6, neighbours)
+				prefix := KeyIDBytes(5)
+				if err := trimShards(tx, db.HistoryIndex, prefix[:], 150); err != nil {
+					t.Fatal(err)
+				}
+				if got := readTestShards(t, tx, db, 5); !equalTestShards(got, tc.want) {
+					t.Errorf("shards %v, want %v", got, tc.want)
+				}
+				for _, id := range []uint64{4, 6} {
+					if got := readTestShards(t, tx, db, id); !equalTestShards(got, neighbours) {
+						t.Errorf("keyID %d shards %v, want them untouched", id, got)
+					}
+				}
+			})
+		})
+	}
+}
+
+func equalTestShards(a, b map[uint64][]uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if !slices.Equal(v, b[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Pruned blocks lose their changesets and index entries; state stays
+// readable at and above the horizon.
+func TestPruneStateHistory(t *testing.T) {
+	db := storetest.Open(t, Open)
+	writeIndexTestBlocks(t, db, 6)
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		if err := BuildIndex(tx, db, IndexHistory, 1, 6); err != nil {
+			t.Fatal(err)
+		}
+		if err := PruneStateHistory(tx, db, 0, 4); err != nil {
+			t.Fatal(err)
+		}
+	})
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+		if below, err := GetPrunedBelow(tx, db, PruneHistory); err != nil || below != 4 {
+			t.Fatalf("pruned below %d, %v; want 4", below, err)
+		}
+		for num := uint64(1); num <= 6; num++ {
+			_, err := ReadChangeset(tx, db, num)
+			if kept := err == nil; kept != (num >= 4) {
+				t.Errorf("changeset %d kept = %v (%v)", num, kept, err)
+			}
+		}
+		keyID, _, err := GetKeyID(tx, db, testAddr(1), AccountSentinelSlot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, blocks := range readTestShards(t, tx, db, keyID) {
+			if blocks[0] < 4 {
+				t.Errorf("history index still holds block %d", blocks[0])
+			}
+		}
+		for num := uint64(4); num <= 6; num++ {
+			acct, err := LookupHistoricalAccount(tx, db, testAddr(1), num)
+			if err != nil || acct == nil || acct.Nonce != num {
+				t.Fatalf("block %d: account %+v, %v; want nonce %d", num, acct, err, num)
+			}
+		}
+	})
+}
@@ -0,0 +1,475 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/erigontech/mdbx-go/mdbx"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Block packs.
+//
+// The containers of stored block ranges can be moved out of the Containers
+// table into append-only segment files in <db-dir>/segments:
+//
+//	blocks-NNNNNN.seg  ZSTD frames ("packs") of PackBlocks consecutive containers
+//	blocks-NNNNNN.idx  big-endian uint64 end offset of every pack in the .seg
+//
+// Pack p holds blocks p*PackBlocks+1 .. (p+1)*PackBlocks and is pack
+// p%segmentPacks of segment p/segmentPacks. Decompressed, a pack is
+// PackBlocks big-endian uint32 container lengths followed by the containers.
+//
+// The Containers entry of a packed block is only a pointer (flag
+// containerPacked and the block number), so GetContainer and everything built
+// on it read packed blocks transparently. Metadata "packed_to" is the last
+// packed block and commits with the pointers. Packs past it were written by a
+// transaction that did not commit; they are cut off before the next append.
+const (
+	// PackBlocks is how many consecutive blocks one pack holds.
+	PackBlocks = 64
+
+	segmentPacks  = 4096
+	packCacheSize = 16
+
+	// Containers value flags.
+	containerRaw    = 0
+	containerLZ4    = 1
+	containerPacked = 2
+)
+
+var packedToKey = []byte("packed_to")
+
+// GetPackedTo returns the last block whose container lives in a segment
+// file, 0 if none does.
+func GetPackedTo(tx *mdbx.Txn, db *DB) uint64 {
+	val, err := tx.Get(db.Metadata, packedToKey)
+	if err != nil || len(val) < 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(val)
+}
+
+// PackContainers moves up to maxPacks packs of containers following
+// packed_to into the segment files. A pack is only written once all of its
+// blocks are stored. The packs are synced to disk before it returns; the
+// caller commits tx, which publishes them. Returns the new packed_to.
+func PackContainers(tx *mdbx.Txn, db *DB, maxPacks int) (uint64, error) {
+	packedTo := GetPackedTo(tx, db)
+	segs := db.segments
+	if err := segs.truncate(packedTo / PackBlocks); err != nil {
+		return packedTo, err
+	}
+
+	start := packedTo
+	ids := make([][32]byte, PackBlocks)
+	raws := make([][]byte, PackBlocks)
+	for n := 0; n < maxPacks; n++ {
+		from := packedTo + 1
+		complete := true
+		for i := range ids {
+			id, err := GetContainerIDByNumber(tx, db, from+uint64(i))
+			if mdbx.IsNotFound(err) {
+				complete = false
+				break
+			}
+			if err != nil {
+				return start, fmt.Errorf("index of block %d: %w", from+uint64(i), err)
+			}
+			raw, err := GetContainer(tx, db, id)
+			if err != nil {
+				return start, fmt.Errorf("container of block %d: %w", from+uint64(i), err)
+			}
+			ids[i], raws[i] = id, raw
+		}
+		if !complete {
+			break
+		}
+		if err := segs.appendPack(packedTo/PackBlocks, segs.enc.EncodeAll(encodePack(raws), nil)); err != nil {
+			return start, fmt.Errorf("append pack at block %d: %w", from, err)
+		}
+		for i, id := range ids {
+			var ptr [9]byte
+			ptr[0] = containerPacked
+			binary.BigEndian.PutUint64(ptr[1:], from+uint64(i))
+			if err := tx.Put(db.Containers, id[:], ptr[:], 0); err != nil {
+				return start, err
+			}
+		}
+		packedTo += PackBlocks
+	}
+	if packedTo == start {
+		return start, nil
+	}
+	if err := segs.sync(); err != nil {
+		return start, fmt.Errorf("sync segments: %w", err)
+	}
+	key := BlockKey(packedTo)
+	if err := tx.Put(db.Metadata, packedToKey, key[:], 0); err != nil {
+		return start, err
+	}
+	return packedTo, nil
+}
+
+func encodePack(raws [][]byte) []byte {
+	size := 4 * len(raws)
+	for _, raw := range raws {
+		size += len(raw)
+	}
+	out := make([]byte, 4*len(raws), size)
+	for i, raw := range raws {
+		binary.BigEndian.PutUint32(out[4*i:], uint32(len(raw)))
+		out = append(out, raw...)
+	}
+	return out
+}
+
+// segmentStore reads and appends the segment files. Appends only happen
+// under the MDBX write lock.
+//
+// A read-only store shares the files with a writer in another process, so
+// the pack ends it loaded can be stale or belong to packs that never commit.
+// Packs below committed are known to be committed and their ends current;
+// reading a pack at or past it reloads the index files first.
+type segmentStore struct {
+	dir string
+	enc *zstd.Encoder // nil if readOnly
+	dec *zstd.Decoder
+
+	readOnly bool
+
+	mu        sync.RWMutex
+	segs      []*segment // by segment number; all but the last are full
+	committed uint64     // readOnly: packs whose ends are known to be valid
+
+	cacheMu    sync.Mutex
+	cache      map[uint64][]byte // pack number → decompressed pack
+	cacheOrder []uint64
+}
+
+type segment struct {
+	seg  *os.File
+	idx  *os.File
+	ends []uint64
+}
+
+// openSegments opens the segment files in dir and cuts them back to the
+// packs packedTo covers. A read-only store leaves the files as they are.
+func openSegments(dir string, packedTo uint64, readOnly bool) (*segmentStore, error) {
+	s := &segmentStore{dir: dir, readOnly: readOnly, committed: packedTo / PackBlocks, cache: make(map[uint64][]byte)}
+	if !readOnly {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+		if err != nil {
+			return nil, err
+		}
+		s.enc = enc
+	}
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	if err != nil {
+		return nil, err
+	}
+	s.dec = dec
+	if err := s.openFrom(0); err != nil {
+		s.close()
+		return nil, err
+	}
+	if have := s.packs(); have < packedTo/PackBlocks {
+		s.close()
+		return nil, fmt.Errorf("segments hold %d packs, packed_to %d needs %d", have, packedTo, packedTo/PackBlocks)
+	}
+	if readOnly {
+		return s, nil
+	}
+	if err := s.truncate(packedTo / PackBlocks); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// openFrom (re)opens the segment files from segment number n on. Callers
+// other than openSegments hold mu.
+func (s *segmentStore) openFrom(n int) error {
+	for ; ; n++ {
+		if _, err := os.Stat(s.path(n, ".seg")); errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		seg, err := s.openSegment(n)
+		if err != nil {
+			return err
+		}
+		if n < len(s.segs) {
+			s.segs[n].close()
+			s.segs[n] = seg
+		} else {
+			s.segs = append(s.segs, seg)
+		}
+	}
+}
+
+// refresh reloads the index files of a read-only store once pack p, which
+// the caller has seen committed, is not covered by the ends it has. Full
+// segments never change, so only the last one known and any new ones are
+// reopened.
+func (s *segmentStore) refresh(p uint64) error {
+	s.mu.RLock()
+	current := p < s.committed
+	s.mu.RUnlock()
+	if current {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p < s.committed {
+		return nil
+	}
+	if err := s.openFrom(max(len(s.segs)-1, 0)); err != nil {
+		return err
+	}
+	s.committed = p + 1
+	return nil
+}
+
+func (s *segmentStore) path(n int, ext string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("blocks-%06d%s", n, ext))
+}
+
+func (s *segmentStore) openSegment(n int) (*segment, error) {
+	mode := os.O_RDWR | os.O_CREATE
+	if s.readOnly {
+		mode = os.O_RDONLY
+	}
+	segFile, err := os.OpenFile(s.path(n, ".seg"), mode, 0644)
+	if err != nil {
+		return nil, err
+	}
+	idxFile, err := os.OpenFile(s.path(n, ".idx"), mode, 0644)
+	if err != nil {
+		segFile.Close()
+		return nil, err
+	}
+	seg := &segment{seg: segFile, idx: idxFile}
+	raw, err := io.ReadAll(idxFile)
+	if err != nil {
+		seg.close()
+		return nil, fmt.Errorf("read %s: %w", idxFile.Name(), err)
+	}
+	info, err := segFile.Stat()
+	if err != nil {
+		seg.close()
+		return nil, err
+	}
+	// A torn tail (partial entry, or an entry past the end of the data) is
+	// from an append that never committed.
+	for i := 0; i+8 <= len(raw); i += 8 {
+		end := binary.BigEndian.Uint64(raw[i:])
+		if end > uint64(info.Size()) || (len(seg.ends) > 0 && end < seg.ends[len(seg.ends)-1]) {
+			break
+		}
+		seg.ends = append(seg.ends, end)
+	}
+	return seg, nil
+}
+
+// packs returns how many consecutive packs from pack 0 the segments hold.
+func (s *segmentStore) packs() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var total uint64
+	for _, seg := range s.segs {
+		total += uint64(len(seg.ends))
+		if len(seg.ends) < segmentPacks {
+			break
+		}
+	}
+	return total
+}
+
+// truncate drops every pack from pack number packs on.
+func (s *segmentStore) truncate(packs uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for n, seg := range s.segs {
+		first := uint64(n) * segmentPacks
+		keep := uint64(len(seg.ends))
+		if packs <= first {
+			keep = 0
+		} else if packs-first < keep {
+			keep = packs - first
+		}
+		if keep == uint64(len(seg.ends)) {
+			continue
+		}
+		var size uint64
+		if keep > 0 {
+			size = seg.ends[keep-1]
+		}
+		if err := seg.seg.Truncate(int64(size)); err != nil {
+			return err
+		}
+		if err := seg.idx.Truncate(int64(keep * 8)); err != nil {
+			return err
+		}
+		seg.ends = seg.ends[:keep]
+	}
+	s.cacheMu.Lock()
+	for p := range s.cache {
+		if p >= packs {
+			delete(s.cache, p)
+		}
+	}
+	s.cacheMu.Unlock()
+	return nil
+}
+
+// appendPack appends pack number p, which must directly follow the last one.
+func (s *segmentStore) appendPack(p uint64, frame []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := int(p / segmentPacks)
+	for len(s.segs) <= n {
+		seg, err := s.openSegment(len(s.segs))
+		if err != nil {
+			return err
+		}
+		s.segs = append(s.segs, seg)
+	}
+	seg := s.segs[n]
+	if uint64(len(seg.ends)) != p%segmentPacks {
+		return fmt.Errorf("pack %d out of order: segment %d holds %d packs", p, n, len(seg.ends))
+	}
+	var offset uint64
+	if len(seg.ends) > 0 {
+		offset = seg.ends[len(seg.ends)-1]
+	}
+	if _, err := seg.seg.WriteAt(frame, int64(offset)); err != nil {
+		return err
+	}
+	end := offset + uint64(len(frame))
+	var entry [8]byte
+	binary.BigEndian.PutUint64(entry[:], end)
+	if _, err := seg.idx.WriteAt(entry[:], int64(len(seg.ends)*8)); err != nil {
+		return err
+	}
+	seg.ends = append(seg.ends, end)
+	return nil
+}
+
+// sync flushes the last segment, the only one appends write to.
+func (s *segmentStore) sync() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.segs) == 0 {
+		return nil
+	}
+	seg := s.segs[len(s.segs)-1]
+	if err := seg.seg.Sync(); err != nil {
+		return err
+	}
+	return seg.idx.Sync()
+}
+
+// readContainer returns a copy of the container of block num.
+func (s *segmentStore) readContainer(num uint64) ([]byte, error) {
+	if num == 0 {
+		return nil, fmt.Errorf("block 0 is never packed")
+	}
+	pack, err := s.readPack((num - 1) / PackBlocks)
+	if err != nil {
+		return nil, err
+	}
+	k := int((num - 1) % PackBlocks)
+	if len(pack) < 4*PackBlocks {
+		return nil, fmt.Errorf("pack of block %d is truncated", num)
+	}
+	offset := 4 * PackBlocks
+	for i := 0; i < k; i++ {
+		offset += int(binary.BigEndian.Uint32(pack[4*i:]))
+	}
+	size := int(binary.BigEndian.Uint32(pack[4*k:]))
+	if offset+size > len(pack) {
+		return nil, fmt.Errorf("pack of block %d is truncated", num)
+	}
+	return append([]byte(nil), pack[offset:offset+size]...), nil
+}
+
+// readPack returns decompressed pack p. Callers must not modify it.
+func (s *segmentStore) readPack(p uint64) ([]byte, error) {
+	s.cacheMu.Lock()
+	pack, ok := s.cache[p]
+	s.cacheMu.Unlock()
+	if ok {
+		return pack, nil
+	}
+
+	frame, err := s.readFrame(p)
+	if err != nil {
+		return nil, err
+	}
+	pack, err = s.dec.DecodeAll(frame, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decompress pack %d: %w", p, err)
+	}
+
+	s.cacheMu.Lock()
+	if _, ok := s.cache[p]; !ok {
+		if len(s.cacheOrder) >= packCacheSize {
+			delete(s.cache, s.cacheOrder[0])
+			s.cacheOrder = s.cacheOrder[1:]
+		}
+		s.cache[p] = pack
+		s.cacheOrder = append(s.cacheOrder, p)
+	}
+	s.cacheMu.Unlock()
+	return pack, nil
+}
+
+func (s *segmentStore) readFrame(p uint64) ([]byte, error) {
+	if s.readOnly {
+		if err := s.refresh(p); err != nil {
+			return nil, fmt.Errorf("reload segments: %w", err)
+		}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n, i := int(p/segmentPacks), int(p%segmentPacks)
+	if n >= len(s.segs) || i >= len(s.segs[n].ends) {
+		return nil, fmt.Errorf("pack %d is not in the segments", p)
+	}
+	seg := s.segs[n]
+	var start uint64
+	if i > 0 {
+		start = seg.ends[i-1]
+	}
+	frame := make([]byte, seg.ends[i]-start)
+	if _, err := seg.seg.ReadAt(frame, int64(start)); err != nil {
+		return nil, fmt.Errorf("read pack %d: %w", p, err)
+	}
+	return frame, nil
+}
+
+func (s *segmentStore) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, seg := range s.segs {
+		seg.close()
+	}
+	s.segs = nil
+	s.dec.Close()
+	if s.enc != nil {
+		_ = s.enc.Close()
+	}
+}
+
+func (seg *segment) close() {
+	_ = seg.seg.Close()
+	_ = seg.idx.Close()
+}
@@ -0,0 +1,236 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/store/storetest"
+)
+
+// testContainerID is the container ID of test block num.
+func testContainerID(num uint64) [32]byte {
+	key := BlockKey(num)
+	return sha256.Sum256(key[:])
+}
+
+// testContainer is the container of test block num. Odd blocks are
+// incompressible, so both Containers encodings get packed, and the lengths
+// vary so a wrong offset within a pack reads the wrong bytes.
+func testContainer(num uint64) []byte {
+	if num%2 == 0 {
+		return bytes.Repeat(binary.BigEndian.AppendUint64(nil, num), 1+int(num%5))
+	}
+	var out []byte
+	for seed := testContainerID(num); len(out) < 32+int(num%7)*8; seed = sha256.Sum256(seed[:]) {
+		out = append(out, seed[:]...)
+	}
+	return out
+}
+
+// putTestContainers stores and indexes the containers of blocks from..to.
+func putTestContainers(t *testing.T, tx *mdbx.Txn, db *DB, from, to uint64) {
+	t.Helper()
+	for num := from; num <= to; num++ {
+		if err := PutContainer(tx, db, testContainerID(num), num, testContainer(num)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkTestContainers reads blocks from..to by number and by container ID.
+func checkTestContainers(t *testing.T, tx *mdbx.Txn, db *DB, from, to uint64) {
+	t.Helper()
+	for num := from; num <= to; num++ {
+		raw, err := GetBlockByNumber(tx, db, num)
+		if err != nil {
+			t.Fatalf("block %d: %v", num, err)
+		}
+		if !bytes.Equal(raw, testContainer(num)) {
+			t.Fatalf("block %d reads %x, want %x", num, raw, testContainer(num))
+		}
+		if raw, err := GetContainer(tx, db, testContainerID(num)); err != nil || !bytes.Equal(raw, testContainer(num)) {
+			t.Fatalf("container of block %d reads %x, %v", num, raw, err)
+		}
+	}
+}
+
+// checkTestPacked checks that exactly the blocks up to packedTo of 1..to
+// are pointers into the segments.
+func checkTestPacked(t *testing.T, tx *mdbx.Txn, db *DB, packedTo, to uint64) {
+	t.Helper()
+	if got := GetPackedTo(tx, db); got != packedTo {
+		t.Fatalf("packed_to = %d, want %d", got, packedTo)
+	}
+	for num := uint64(1); num <= to; num++ {
+		id := testContainerID(num)
+		val, err := tx.Get(db.Containers, id[:])
+		if err != nil {
+			t.Fatalf("block %d: %v", num, err)
+		}
+		if packed := val[0] == containerPacked; packed != (num <= packedTo) {
+			t.Fatalf("block %d packed = %v with packed_to %d", num, packed, packedTo)
+		}
+	}
+}
+
+func packTestContainers(t *testing.T, db *DB, maxPacks int) uint64 {
+	t.Helper()
+	var packedTo uint64
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		var err error
+		if packedTo, err = PackContainers(tx, db, maxPacks); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return packedTo
+}
+
+func TestPackContainersRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { db.Close() }()
+
+	// Three complete packs and a partial one.
+	last := uint64(3*PackBlocks + 10)
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		putTestContainers(t, tx, db, 1, last)
+	})
+
+	if got := packTestContainers(t, db, 2); got != 2*PackBlocks {
+		t.Fatalf("packed to %d, want %d", got, 2*PackBlocks)
+	}
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+		checkTestPacked(t, tx, db, 2*PackBlocks, last)
+		checkTestContainers(t, tx, db, 1, last)
+	})
+
+	// The partial pack stays in MDBX.
+	if got := packTestContainers(t, db, 100); got != 3*PackBlocks {
+		t.Fatalf("packed to %d, want %d", got, 3*PackBlocks)
+	}
+	if got := packTestContainers(t, db, 100); got != 3*PackBlocks {
+		t.Fatalf("packed to %d with no complete pack left, want %d", got, 3*PackBlocks)
+	}
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+		checkTestPacked(t, tx, db, 3*PackBlocks, last)
+		checkTestContainers(t, tx, db, 1, last)
+	})
+
+	// A pack whose transaction aborts is cut off again and repacked.
+	last = 4*PackBlocks + 1
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		putTestContainers(t, tx, db, 3*PackBlocks+11, last)
+	})
+	func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		tx, err := db.BeginRW()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Abort()
+		if got, err := PackContainers(tx, db, 100); err != nil || got != 4*PackBlocks {
+			t.Fatalf("packed to %d, %v", got, err)
+		}
+		checkTestContainers(t, tx, db, 3*PackBlocks-1, 4*PackBlocks+1)
+	}()
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+		checkTestPacked(t, tx, db, 3*PackBlocks, last)
+		checkTestContainers(t, tx, db, 1, last)
+	})
+	if got := packTestContainers(t, db, 100); got != 4*PackBlocks {
+		t.Fatalf("packed to %d, want %d", got, 4*PackBlocks)
+	}
+
+	// The segments are read back from disk after reopening, read-write and
+	// read-only.
+	db.Close()
+	for _, open := range []func(string) (*DB, error){Open, OpenReadOnly} {
+		if db, err = open(dir); err != nil {
+			t.Fatal(err)
+		}
+		storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+			checkTestPacked(t, tx, db, 4*PackBlocks, last)
+			checkTestContainers(t, tx, db, 1, last)
+		})
+		db.Close()
+	}
+}
+
+func TestPackContainersSegmentEdge(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { db.Close() }()
+
+	edge := uint64(segmentPacks * PackBlocks)
+	last := edge + PackBlocks + 1
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		putTestContainers(t, tx, db, 1, PackBlocks+1)
+		// The blocks in between are not read back, so they skip
+		// PutContainer's compression, which dominates the test otherwise.
+		for num := uint64(PackBlocks + 2); num < edge-PackBlocks; num++ {
+			id, key := testContainerID(num), BlockKey(num)
+			if err := tx.Put(db.Containers, id[:], append([]byte{containerRaw}, testContainer(num)...), 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Put(db.ContainerIndex, key[:], id[:], 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		putTestContainers(t, tx, db, edge-PackBlocks, last)
+	})
+	if got := packTestContainers(t, db, segmentPacks); got != edge {
+		t.Fatalf("packed to %d, want %d", got, edge)
+	}
+	second := filepath.Join(dir, "segments", "blocks-000001.seg")
+	if _, err := os.Stat(second); !os.IsNotExist(err) {
+		t.Fatalf("second segment exists before its first pack: %v", err)
+	}
+
+	// An aborted first pack of the second segment is cut off again.
+	func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		tx, err := db.BeginRW()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Abort()
+		if got, err := PackContainers(tx, db, 1); err != nil || got != edge+PackBlocks {
+			t.Fatalf("packed to %d, %v", got, err)
+		}
+	}()
+	if got := packTestContainers(t, db, 1); got != edge+PackBlocks {
+		t.Fatalf("packed to %d, want %d", got, edge+PackBlocks)
+	}
+	if info, err := os.Stat(second); err != nil || info.Size() == 0 {
+		t.Fatalf("second segment not written: %v", err)
+	}
+
+	db.Close()
+	if db, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	storetest.WithRO(t, db, func(tx *mdbx.Txn) {
+		checkTestPacked(t, tx, db, edge+PackBlocks, last)
+		for _, r := range [][2]uint64{
+			{1, PackBlocks + 1},
+			{edge - PackBlocks, last},
+		} {
+			checkTestContainers(t, tx, db, r[0], r[1])
+		}
+	})
+}
@@ -0,0 +1,59 @@
+// Package storetest has the helpers tests use to open a store.DB and run
+// transactions on it. It does not import store, so store's own tests can use
+// it too: the helpers take the store.DB methods they need as an interface.
+package storetest
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/erigontech/mdbx-go/mdbx"
+)
+
+// DB is the part of *store.DB the helpers use.
+type DB interface {
+	BeginRO() (*mdbx.Txn, error)
+	BeginRW() (*mdbx.Txn, error)
+	Close()
+}
+
+// Open opens a database with open in a fresh temporary directory and closes
+// it when the test ends. Callers pass store.Open.
+func Open[D DB](t testing.TB, open func(path string) (D, error)) D {
+	t.Helper()
+	db, err := open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+// WithRW runs fn in a committed RW transaction.
+func WithRW(t testing.TB, db DB, fn func(tx *mdbx.Txn)) {
+	t.Helper()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := db.BeginRW()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Abort()
+	fn(tx)
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// WithRO runs fn in a RO transaction.
+func WithRO(t testing.TB, db DB, fn func(tx *mdbx.Txn)) {
+	t.Helper()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := db.BeginRO()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Abort()
+	fn(tx)
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/RoaringBitmap/roaring/v2/roaring64"
+	"github.com/erigontech/mdbx-go/mdbx"
+)
+
+// UnwindHistory deletes the changesets and receipts of blocks (to, head] and
+// drops those blocks from the history, log, tx hash and atomic tx indexes. Index
+// watermarks above to are moved down to it. The state itself is unwound by
+// statetrie.UnwindState, which reads the changesets, so call this after it.
+func UnwindHistory(tx *mdbx.Txn, db *DB, to, head uint64) error {
+	indexedTo := func(kind IndexKind) uint64 {
+		// Without a watermark the index was written with the state.
+		if indexed, ok := GetIndexedTo(tx, db, kind); ok {
+			return indexed
+		}
+		return head
+	}
+	historyTo := indexedTo(IndexHistory)
+	logsTo := indexedTo(IndexLogs)
+	txHashesTo := indexedTo(IndexTxHashes)
+	// The atomic tx index starts empty rather than with the state.
+	atomicTo, _ := GetIndexedTo(tx, db, IndexAtomic)
+
+	keyIDs := make(map[uint64]struct{})
+	addresses := make(map[[20]byte]struct{})
+	topics := make(map[[32]byte]struct{})
+	atomicAddresses := make(map[[20]byte]struct{})
+	for n := to + 1; n <= head; n++ {
+		key := BlockKey(n)
+		if n <= atomicTo {
+			atomicTxs, err := ReadBlockAtomicTxs(tx, db, n)
+			if err != nil {
+				return fmt.Errorf("atomic txs %d: %w", n, err)
+			}
+			if atomicTxs != nil {
+				for _, t := range atomicTxs {
+					for _, tr := range t.Transfers {
+						atomicAddresses[tr.Address] = struct{}{}
+					}
+				}
+				if err := tx.Del(db.AtomicTxs, key[:], nil); err != nil {
+					return err
+				}
+			}
+		}
+
+		data, err := tx.Get(db.Changesets, key[:])
+		switch {
+		case err == nil:
+			if n <= historyTo {
+				changes, err := decodeStoredChangeset(data)
+				if err != nil {
+					return fmt.Errorf("changeset %d: %w", n, err)
+				}
+				for _, c := range changes {
+					keyIDs[c.KeyID] = struct{}{}
+				}
+			}
+			if err := tx.Del(db.Changesets, key[:], nil); err != nil {
+				return err
+			}
+		case !mdbx.IsNotFound(err):
+			return err
+		}
+
+		receipts, err := ReadBlockReceipts(tx, db, n)
+		if err != nil {
+			return err
+		}
+		if receipts == nil {
+			continue
+		}
+		for _, r := range receipts {
+			if n <= logsTo {
+				for _, l := range r.Logs {
+					addresses[l.Address] = struct{}{}
+					for _, t := range l.Topics {
+						topics[t] = struct{}{}
+					}
+				}
+			}
+			if n <= txHashesTo {
+				if at, _, err := GetTxLocation(tx, db, r.TxHash); err == nil && at == n {
+					if err := tx.Del(db.TxHashIndex, r.TxHash[:], nil); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if err := tx.Del(db.ReceiptsByBlock, key[:], nil); err != nil {
+			return err
+		}
+	}
+
+	historyKeys := make([][]byte, 0, len(keyIDs))
+	for id := range keyIDs {
+		prefix := KeyIDBytes(id)
+		historyKeys = append(historyKeys, prefix[:])
+	}
+	addrKeys := make([][]byte, 0, len(addresses))
+	for a := range addresses {
+		addrKeys = append(addrKeys, bytes.Clone(a[:]))
+	}
+	topicKeys := make([][]byte, 0, len(topics))
+	for t := range topics {
+		topicKeys = append(topicKeys, bytes.Clone(t[:]))
+	}
+	atomicKeys := make([][]byte, 0, len(atomicAddresses))
+	for a := range atomicAddresses {
+		atomicKeys = append(atomicKeys, bytes.Clone(a[:]))
+	}
+	for _, idx := range []struct {
+		dbi      mdbx.DBI
+		prefixes [][]byte
+	}{
+		{db.HistoryIndex, historyKeys},
+		{db.AddressLogIndex, addrKeys},
+		{db.TopicLogIndex, topicKeys},
+		{db.AtomicAddressIndex, atomicKeys},
+	} {
+		sort.Slice(idx.prefixes, func(i, j int) bool {
+			return bytes.Compare(idx.prefixes[i], idx.prefixes[j]) < 0
+		})
+		for _, prefix := range idx.prefixes {
+			if err := trimShardsAbove(tx, idx.dbi, prefix, to); err != nil {
+				return fmt.Errorf("trim index %x: %w", prefix, err)
+			}
+		}
+	}
+
+	for _, kind := range IndexKinds {
+		if indexed, ok := GetIndexedTo(tx, db, kind); ok && indexed > to {
+			if err := setIndexedTo(tx, db, kind, to); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// trimShardsAbove removes the blocks above `above` from the bitmap shards of
+// prefix, the counterpart of trimShards. The first shard reaching past it is
+// cut down and becomes the open sentinel shard new blocks are added to; the
+// shards after it only hold higher blocks and are deleted whole.
+func trimShardsAbove(tx *mdbx.Txn, dbi mdbx.DBI, prefix []byte, above uint64) error {
+	cursor, err := tx.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	seekKey := make([]byte, len(prefix)+8)
+	copy(seekKey, prefix)
+	binary.BigEndian.PutUint64(seekKey[len(prefix):], above+1)
+
+	var (
+		dead     [][]byte
+		cutShard *roaring64.Bitmap
+	)
+	k, v, err := cursor.Get(seekKey, nil, mdbx.SetRange)
+	for ; err == nil; k, v, err = cursor.Get(nil, nil, mdbx.Next) {
+		if len(k) != len(prefix)+8 || !bytes.HasPrefix(k, prefix) {
+			break
+		}
+		dead = append(dead, bytes.Clone(k))
+		if len(dead) > 1 {
+			continue
+		}
+		bm := roaring64.NewBitmap()
+		if _, err := bm.ReadFrom(bytes.NewReader(v)); err != nil {
+			return fmt.Errorf("decode bitmap: %w", err)
+		}
+		bm.RemoveRange(above+1, 0xFFFFFFFFFFFFFFFF)
+		bm.Remove(0xFFFFFFFFFFFFFFFF)
+		if !bm.IsEmpty() {
+			cutShard = bm
+		}
+	}
+	if err != nil && !mdbx.IsNotFound(err) {
+		return err
+	}
+
+	for _, key := range dead {
+		if err := tx.Del(dbi, key, nil); err != nil {
+			return err
+		}
+	}
+	if cutShard == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if _, err := cutShard.WriteTo(&buf); err != nil {
+		return err
+	}
+	sentinel := make([]byte, len(prefix)+8)
+	copy(sentinel, prefix)
+	binary.BigEndian.PutUint64(sentinel[len(prefix):], 0xFFFFFFFFFFFFFFFF)
+	return tx.Put(dbi, sentinel, buf.Bytes(), 0)
+}
@@ -29,6 +29,9 @@ type HashBuilder struct {
 
 	// Collected updates for persistence
 	updates map[string]*BranchNodeCompact
+
+	// Nodes retained for Merkle proofs (nil unless WithProofRetainer)
+	proof *proofRetainer
 }
 
 type stackItem struct {
@@ -178,6 +181,9 @@ func (h *HashBuilder) update(succeeding Nibbles) {
 			if !h.isReference {
 				// Leaf node
 				rlpData := rlpEncodeLeafNode(shortNodeKey, h.value)
+				if h.proof != nil {
+					h.proof.retain(current.Prefix(lenFrom), rlpData)
+				}
 				h.stack = append(h.stack, stackItem{
 					ref:          rlpNodeFromRLP(rlpData),
 					rootIsBranch: false,
@@ -205,6 +211,9 @@ func (h *HashBuilder) update(succeeding Nibbles) {
 			stackLast := h.stack[len(h.stack)-1].ref
 			h.stack = h.stack[:len(h.stack)-1]
 			rlpData := rlpEncodeExtensionNode(shortNodeKey, stackLast)
+			if h.proof != nil {
+				h.proof.retain(current.Prefix(lenFrom), rlpData)
+			}
 			h.stack = append(h.stack, stackItem{
 				ref:          rlpNodeFromRLP(rlpData),
 				rootIsBranch: false,
@@ -274,6 +283,9 @@ func (h *HashBuilder) pushBranchNode(current Nibbles, length int) [][]byte {
 	// RLP-encode the branch node from the stack
 	rlpData := rlpEncodeBranchNodeFromStack(h.stack[firstChildIdx:], stateMask)
 	rlpNode := rlpNodeFromRLP(rlpData)
+	if h.proof != nil {
+		h.proof.retain(current.Prefix(length), rlpData)
+	}
 
 	// Pop children from the stack
 	h.stack = h.stack[:firstChildIdx]
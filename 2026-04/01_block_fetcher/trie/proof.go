@@ -0,0 +1,76 @@
+package trie
+
+import "sort"
+
+// ProofNode is one RLP-encoded trie node retained while hashing, keyed by the
+// nibble path at which it sits.
+type ProofNode struct {
+	Path Nibbles
+	RLP  []byte
+}
+
+// proofRetainer records every node the HashBuilder produces on the path to one
+// of its target keys. Port of alloy-trie's ProofRetainer.
+type proofRetainer struct {
+	targets []Nibbles
+	nodes   map[string]ProofNode
+}
+
+func (p *proofRetainer) retain(path Nibbles, rlpData []byte) {
+	if path.Len() > 0 {
+		match := false
+		for _, target := range p.targets {
+			if target.HasPrefix(path) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return
+		}
+	}
+	node := make([]byte, len(rlpData))
+	copy(node, rlpData)
+	p.nodes[path.String()] = ProofNode{Path: path, RLP: node}
+}
+
+// WithProofRetainer makes the builder keep the RLP of every node whose path
+// is a prefix of one of targets (plus the root), for use in Merkle proofs.
+// Subtrees the walker replays as cached refs are not expanded, so the keys
+// being proven must be in the prefix set that drives the walk.
+func (h *HashBuilder) WithProofRetainer(targets []Nibbles) *HashBuilder {
+	h.proof = &proofRetainer{targets: targets, nodes: make(map[string]ProofNode)}
+	return h
+}
+
+// ProofNodes returns the retained nodes sorted by path. Call after Root().
+func (h *HashBuilder) ProofNodes() []ProofNode {
+	if h.proof == nil {
+		return nil
+	}
+	out := make([]ProofNode, 0, len(h.proof.nodes))
+	for _, n := range h.proof.nodes {
+		out = append(out, n)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Path.Compare(out[j].Path) < 0
+	})
+	return out
+}
+
+// ProofForKey picks the proof for key out of nodes (as returned by
+// ProofNodes), root first. Like go-ethereum, nodes small enough to be
+// embedded in their parent are not listed separately, except the root.
+func ProofForKey(nodes []ProofNode, key Nibbles) [][]byte {
+	var proof [][]byte
+	for _, n := range nodes {
+		if !key.HasPrefix(n.Path) {
+			continue
+		}
+		if n.Path.Len() > 0 && len(n.RLP) < 32 {
+			continue
+		}
+		proof = append(proof, n.RLP)
+	}
+	return proof
+}
@@ -0,0 +1,60 @@
+package trie
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/crypto"
+	"github.com/ava-labs/libevm/ethdb/memorydb"
+	ethtrie "github.com/ava-labs/libevm/trie"
+)
+
+func TestProofRetainerVerifies(t *testing.T) {
+	var pairs []testKV
+	for i := 0; i < 200; i++ {
+		k := make([]byte, 32)
+		k[30], k[31] = byte(i>>8), byte(i)
+		pairs = append(pairs, testKV{FromHex(crypto.Keccak256(k)), rlpEncodeU256(uint64(i + 1))})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].key.Compare(pairs[j].key) < 0
+	})
+
+	present := crypto.Keccak256(make([]byte, 32)) // key of i=0
+	absent := crypto.Keccak256([]byte("absent"))
+	targets := []Nibbles{FromHex(present), FromHex(absent)}
+
+	hb := NewHashBuilder().WithProofRetainer(targets)
+	for _, p := range pairs {
+		hb.AddLeaf(p.key, p.val)
+	}
+	root := hb.Root()
+	nodes := hb.ProofNodes()
+
+	for _, tc := range []struct {
+		key  []byte
+		want []byte
+	}{
+		{present, rlpEncodeU256(1)},
+		{absent, nil},
+	} {
+		proof := ProofForKey(nodes, FromHex(tc.key))
+		if len(proof) == 0 {
+			t.Fatalf("no proof nodes for %x", tc.key)
+		}
+		db := memorydb.New()
+		for _, n := range proof {
+			if err := db.Put(crypto.Keccak256(n), n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := ethtrie.VerifyProof(common.Hash(root), tc.key, db)
+		if err != nil {
+			t.Fatalf("verify %x: %v", tc.key, err)
+		}
+		if string(got) != string(tc.want) {
+			t.Fatalf("verify %x: got %x, want %x", tc.key, got, tc.want)
+		}
+	}
+}
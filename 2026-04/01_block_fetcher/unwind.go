@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+
+	"github.com/ava-labs/libevm/common"
+	"github.com/erigontech/mdbx-go/mdbx"
+
+	"block_fetcher/statetrie"
+	"block_fetcher/store"
+)
+
+// runUnwind implements the unwind subcommand:
+//
+//	block_fetcher unwind -db-dir DIR -to N
+//
+// It rolls the executed state back to block N, so the next normal start
+// re-executes from N+1. The node must be stopped.
+func runUnwind(args []string) error {
+	fs := flag.NewFlagSet("unwind", flag.ExitOnError)
+	dbDir := fs.String("db-dir", defaultDBDir, "MDBX database directory")
+	to := fs.Int64("to", -1, "block to roll the executed state back to (required)")
+	fs.Parse(args)
+	if *to < 0 {
+		return errors.New("-to is required")
+	}
+	if *to == 0 {
+		return errors.New("cannot unwind to genesis; use -clean-state")
+	}
+	return unwind(*dbDir, uint64(*to))
+}
+
+// unwind rolls the state back to block to and drops everything the executor
+// wrote for the blocks above it, in one transaction. The unwound state must
+// hash to the root of block to or nothing is written.
+func unwind(dbDir string, to uint64) error {
+	db, err := store.Open(dbDir)
+	if err != nil {
+		return fmt.Errorf("open MDBX: %w", err)
+	}
+	defer db.Close()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tx, err := db.BeginRW()
+	if err != nil {
+		return err
+	}
+	defer tx.Abort()
+
+	head, ok := store.GetHeadBlock(tx, db)
+	if !ok {
+		return errors.New("nothing has been executed")
+	}
+	if to > head {
+		return fmt.Errorf("block %d is beyond the head %d", to, head)
+	}
+	if to == head {
+		log.Printf("unwind: head is already %d", head)
+		return nil
+	}
+	prunedBelow, err := store.GetPrunedBelow(tx, db, store.PruneHistory)
+	if err != nil {
+		return err
+	}
+	if to < prunedBelow {
+		return fmt.Errorf("state history below block %d has been pruned", prunedBelow)
+	}
+	raw, err := store.GetBlockByNumber(tx, db, to)
+	if err != nil {
+		return fmt.Errorf("read block %d: %w", to, err)
+	}
+	block, err := executorParseEthBlock(raw)
+	if err != nil {
+		return fmt.Errorf("parse block %d: %w", to, err)
+	}
+	expectedRoot := block.Root()
+	log.Printf("unwind: rolling back blocks %d-%d to block %d root=%s", to+1, head, to, expectedRoot)
+
+	start := time.Now()
+	// The changesets are resolved through the reverse keyID dictionary; finish
+	// it here if the executor has not yet.
+	if _, err := store.ReverseKeyDict(tx, db, -1); err != nil {
+		return fmt.Errorf("reverse keyID dictionary: %w", err)
+	}
+	root, err := statetrie.UnwindState(tx, db, to, head)
+	if err != nil {
+		return fmt.Errorf("unwind state: %w", err)
+	}
+	if common.Hash(root) != expectedRoot {
+		// The hashed state may be right and the trie nodes stale, the
+		// failure the executor itself hits. Then the nodes are dropped and
+		// rebuilt by the next batch.
+		full, err := statetrie.ComputeFullStateRoot(tx, db)
+		if err != nil {
+			return fmt.Errorf("compute state root: %w", err)
+		}
+		if common.Hash(full) != expectedRoot {
+			return fmt.Errorf("unwound state root %x does not match block %d root %s", full, to, expectedRoot)
+		}
+		log.Printf("unwind: stored trie nodes are stale (incremental root %x); dropping them", root)
+		if err := tx.Drop(db.AccountTrie, false); err != nil {
+			return fmt.Errorf("drop AccountTrie: %w", err)
+		}
+		if err := tx.Drop(db.StorageTrie, false); err != nil {
+			return fmt.Errorf("drop StorageTrie: %w", err)
+		}
+	}
+	log.Printf("unwind: state root verified elapsed=%s", time.Since(start).Round(time.Second))
+
+	if err := unwindBlockHashes(tx, db, to, head); err != nil {
+		return fmt.Errorf("unwind block hash index: %w", err)
+	}
+	if err := store.UnwindHistory(tx, db, to, head); err != nil {
+		return fmt.Errorf("unwind history: %w", err)
+	}
+	if err := store.SetHeadBlock(tx, db, to); err != nil {
+		return err
+	}
+	if _, err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("unwind: head is now %d elapsed=%s", to, time.Since(start).Round(time.Second))
+	return nil
+}
+
+// unwindBlockHashes deletes the BlockHashIndex entries the executor wrote
+// for blocks (to, head]. The containers stay, so each block's hash is read
+// from its own container.
+func unwindBlockHashes(tx *mdbx.Txn, db *store.DB, to, head uint64) error {
+	for n := to + 1; n <= head; n++ {
+		raw, err := store.GetBlockByNumber(tx, db, n)
+		if err != nil {
+			return fmt.Errorf("read block %d: %w", n, err)
+		}
+		block, err := executorParseEthBlock(raw)
+		if err != nil {
+			return fmt.Errorf("parse block %d: %w", n, err)
+		}
+		hash := block.Hash()
+		val, err := tx.Get(db.BlockHashIndex, hash[:])
+		if mdbx.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(val) != 8 || binary.BigEndian.Uint64(val) != n {
+			continue
+		}
+		if err := tx.Del(db.BlockHashIndex, hash[:], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
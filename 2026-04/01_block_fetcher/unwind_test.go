@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"maps"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring/v2/roaring64"
+	corethcore "github.com/ava-labs/avalanchego/graft/coreth/core"
+	cparams "github.com/ava-labs/avalanchego/graft/coreth/params"
+	"github.com/ava-labs/libevm/common"
+	"github.com/ava-labs/libevm/core/state"
+	ethtypes "github.com/ava-labs/libevm/core/types"
+	"github.com/ava-labs/libevm/crypto"
+	"github.com/ava-labs/libevm/rlp"
+	"github.com/ava-labs/libevm/trie"
+	"github.com/erigontech/mdbx-go/mdbx"
+	"github.com/holiman/uint256"
+
+	"block_fetcher/statetrie"
+	"block_fetcher/store"
+	"block_fetcher/store/storetest"
+)
+
+// unwindTestChain is a funded pre-state and a chain of blocks on it. Every
+// block creates an account, moves balances between the keys and calls the
+// counter contract, which writes a slot and logs.
+type unwindTestChain struct {
+	keys        []*ecdsa.PrivateKey
+	genesisRoot common.Hash
+	blocks      []*ethtypes.Block
+}
+
+func newUnwindTestChain(t *testing.T, n int) *unwindTestChain {
+	t.Helper()
+	c := &unwindTestChain{}
+	for range 3 {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.keys = append(c.keys, key)
+	}
+
+	// The roots in the headers come from executing the blocks on a scratch
+	// database.
+	db, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	stateDB := statetrie.NewDatabase(db)
+	c.genesisRoot = c.writeGenesis(t, db, stateDB)
+
+	chainCfg := cparams.TestChainConfig
+	chainCtx := newExecutorChainContext(db)
+	signer := ethtypes.LatestSignerForChainID(chainCfg.ChainID)
+	root, parentHash := c.genesisRoot, common.Hash{}
+	for num := uint64(1); num <= uint64(n); num++ {
+		header := &ethtypes.Header{
+			ParentHash: parentHash,
+			Number:     new(big.Int).SetUint64(num),
+			Time:       num,
+			GasLimit:   15_000_000,
+			BaseFee:    big.NewInt(25_000_000_000),
+			Difficulty: big.NewInt(1),
+			Coinbase:   testCoinbase,
+		}
+		var txs ethtypes.Transactions
+		for from, to := range []common.Address{
+			{19: 0x20, 18: byte(num)},
+			crypto.PubkeyToAddress(c.keys[2].PublicKey),
+			testCounter,
+		} {
+			tx, err := ethtypes.SignNewTx(c.keys[from], signer, &ethtypes.LegacyTx{
+				Nonce:    num - 1,
+				To:       &to,
+				Value:    big.NewInt(int64(num) * 1e15),
+				Gas:      100_000,
+				GasPrice: big.NewInt(100_000_000_000),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			txs = append(txs, tx)
+		}
+
+		sdb, err := state.New(root, stateDB, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blockCtx := corethcore.NewEVMBlockContext(header, chainCtx, &header.Coinbase)
+		receipts, err := applyTxsSerially(chainCfg, chainCtx, blockCtx, header, txs, sdb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, r := range receipts {
+			if r.Status != ethtypes.ReceiptStatusSuccessful {
+				t.Fatalf("block %d tx %d failed", num, i)
+			}
+		}
+		root = commitParallelTestState(t, db, stateDB, sdb, num)
+		header.Root = root
+		header.GasUsed = receipts[len(receipts)-1].CumulativeGasUsed
+		block := ethtypes.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil))
+		c.blocks = append(c.blocks, block)
+		parentHash = block.Hash()
+	}
+	return c
+}
+
+// writeGenesis funds the keys, deploys the counter and returns the state
+// root.
+func (c *unwindTestChain) writeGenesis(t *testing.T, db *store.DB, stateDB *statetrie.Database) common.Hash {
+	t.Helper()
+	sdb, err := state.New(ethtypes.EmptyRootHash, stateDB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range c.keys {
+		sdb.SetBalance(crypto.PubkeyToAddress(key.PublicKey), uint256.NewInt(1e18))
+	}
+	sdb.SetCode(testCounter, counterCode)
+	return commitParallelTestState(t, db, stateDB, sdb, 0)
+}
+
+// writeUnwindTestDB stores every block of c in a new database in dir and
+// executes and indexes it up to block to, in batches of at most two blocks.
+func writeUnwindTestDB(t *testing.T, dir string, c *unwindTestChain, to uint64) {
+	t.Helper()
+	db, err := store.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	stateDB := statetrie.NewDatabase(db)
+	if root := c.writeGenesis(t, db, stateDB); root != c.genesisRoot {
+		t.Fatalf("genesis root %x, want %x", root, c.genesisRoot)
+	}
+	storetest.WithRW(t, db, func(tx *mdbx.Txn) {
+		for _, block := range c.blocks {
+			raw, err := rlp.EncodeToBytes(block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.PutContainer(tx, db, block.Hash(), block.NumberU64(), raw); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	if err := prepareIndexes(db); err != nil {
+		t.Fatal(err)
+	}
+	for from := uint64(1); from <= to; from += 2 {
+		if err := executeBatch(db, stateDB, cparams.TestChainConfig, nil, c.genesisRoot, from, min(from+1, to), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := indexToHead(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// unwindTestTables are every table the executor and the indexer write,
+// except the changesets, the history index and the key dictionaries:
+// keyIDs are assigned in flush order, and keys first changed above the
+// unwind target keep their IDs. unwindTestHistory adds the first two with
+// the keyIDs resolved to their keys.
+func unwindTestTables(db *store.DB) []snapshotTable {
+	return []snapshotTable{
+		{store.TableAccountState, db.AccountState},
+		{store.TableStorageState, db.StorageState},
+		{store.TableHashedAccountState, db.HashedAccountState},
+		{store.TableHashedStorageState, db.HashedStorageState},
+		{store.TableCode, db.Code},
+		{store.TableAccountTrie, db.AccountTrie},
+		{store.TableStorageTrie, db.StorageTrie},
+		{store.TableReceiptsByBlock, db.ReceiptsByBlock},
+		{store.TableTxHashIndex, db.TxHashIndex},
+		{store.TableAddressLogIndex, db.AddressLogIndex},
+		{store.TableTopicLogIndex, db.TopicLogIndex},
+		{store.TableBlockHashIndex, db.BlockHashIndex},
+	}
+}
+
+// unwindTestHistory adds the changesets and the history index, keyed by
+// resolved keys, and the head and index watermarks to out.
+func unwindTestHistory(t *testing.T, tx *mdbx.Txn, db *store.DB, out map[string]map[string]string) {
+	resolve := func(keyID uint64) string {
+		addr, slot, err := store.ResolveKeyID(tx, db, keyID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(addr[:]) + string(slot[:])
+	}
+	changesets := make(map[string]string)
+	for n := uint64(1); ; n++ {
+		changes, err := store.ReadChangeset(tx, db, n)
+		if mdbx.IsNotFound(err) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resolved []string
+		for _, c := range changes {
+			resolved = append(resolved, resolve(c.KeyID)+string(c.OldValue))
+		}
+		slices.Sort(resolved)
+		changesets[strconv.FormatUint(n, 10)] = strings.Join(resolved, "|")
+	}
+	out[store.TableChangesets] = changesets
+	history := make(map[string]string)
+	cursor, err := tx.OpenCursor(db.HistoryIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cursor.Close()
+	k, v, err := cursor.Get(nil, nil, mdbx.First)
+	for ; err == nil; k, v, err = cursor.Get(nil, nil, mdbx.Next) {
+		bm := roaring64.NewBitmap()
+		if _, err := bm.ReadFrom(bytes.NewReader(v)); err != nil {
+			t.Fatal(err)
+		}
+		history[resolve(binary.BigEndian.Uint64(k))+string(k[8:])] = bm.String()
+	}
+	if !mdbx.IsNotFound(err) {
+		t.Fatal(err)
+	}
+	out[store.TableHistoryIndex] = history
+
+	head, _ := store.GetHeadBlock(tx, db)
+	meta := map[string]string{"head": strconv.FormatUint(head, 10)}
+	for _, kind := range store.IndexKinds {
+		indexed, ok := store.GetIndexedTo(tx, db, kind)
+		if ok {
+			meta[string(kind)] = strconv.FormatUint(indexed, 10)
+		}
+	}
+	out[store.TableMetadata] = meta
+}
+
+func TestUnwindMatchesShorterExecution(t *testing.T) {
+	const head, to = 6, 3
+	c := newUnwindTestChain(t, head)
+	unwound, want := t.TempDir(), t.TempDir()
+	writeUnwindTestDB(t, unwound, c, head)
+	writeUnwindTestDB(t, want, c, to)
+
+	if err := unwind(unwound, to); err != nil {
+		t.Fatal(err)
+	}
+	got, exp := dumpTestTables(t, unwound, unwindTestTables, unwindTestHistory), dumpTestTables(t, want, unwindTestTables, unwindTestHistory)
+	for name, entries := range exp {
+		// The counter logs without topics and its storage trie is a
+		// single leaf.
+		if len(entries) == 0 && name != store.TableTopicLogIndex && name != store.TableStorageTrie {
+			t.Errorf("%s is empty at block %d", name, to)
+		}
+		if !maps.Equal(got[name], entries) {
+			t.Errorf("unwound %s has %d entries, executed to %d has %d", name, len(got[name]), to, len(entries))
+		}
+	}
+
+	// The unwound database executes the blocks above to again.
+	db, err := store.Open(unwound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := executeBatch(db, statetrie.NewDatabase(db), cparams.TestChainConfig, nil, c.genesisRoot, to+1, head, 1); err != nil {
+		t.Fatal(err)
+	}
+}
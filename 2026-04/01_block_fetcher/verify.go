@@ -0,0 +1,639 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ava-labs/libevm/common/hexutil"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcomes of one verified request.
+const (
+	verifyMatch          = "match"
+	verifyMismatch       = "mismatch"
+	verifyTargetError    = "target_error"    // only the target answered with an error
+	verifyReferenceError = "reference_error" // only the reference did; not held against the target
+	verifyTransportError = "transport_error" // either endpoint could not be reached
+)
+
+// runVerify implements the verify subcommand:
+//
+//	block_fetcher verify -target URL -reference URL [-out DIR] [-replay FILE]
+//	    [-rate N] [-workers N] [-window N] [-duration D] [-metrics-addr ADDR]
+//	    [-ignore-fields F,...]
+//
+// It runs until interrupted. Each round samples a block from the target and
+// derives requests from it: the block itself, one of its transactions and
+// receipts, its logs, the balance, nonce and code of the accounts the
+// transaction touched, a storage slot the block changed, and the
+// transaction replayed as an eth_call on the parent block. Every request goes
+// to both endpoints; differing answers are appended to DIR/mismatches.jsonl
+// with the request and both responses. With -replay, JSON-RPC requests
+// captured one per line are verified too, ahead of the sampled ones;
+// requests for "latest" may differ while the two heads are apart.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	target := fs.String("target", "http://localhost:9670", "JSON-RPC endpoint under test")
+	reference := fs.String("reference", "", "reference JSON-RPC endpoint, an archive node (required)")
+	outDir := fs.String("out", "verify", "directory the mismatches are written to")
+	replay := fs.String("replay", "", "file of captured JSON-RPC requests, one per line, to verify")
+	rate := fs.Float64("rate", 10, "requests per second sent to each endpoint")
+	workers := fs.Int("workers", 4, "requests verified at once")
+	window := fs.Uint64("window", 0, "sample blocks among the newest N only (0 = the whole chain)")
+	duration := fs.Duration("duration", 0, "stop after this long (0 = until interrupted)")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of one request")
+	metricsAddr := fs.String("metrics-addr", ":9671", "address the Prometheus metrics are served on (empty = none)")
+	ignore := fs.String("ignore-fields", "", "comma-separated result fields not compared, e.g. blockExtraData,blockGasCost")
+	fs.Parse(args)
+	if *reference == "" {
+		return errors.New("-reference is required")
+	}
+	if *rate <= 0 || *workers <= 0 {
+		return errors.New("-rate and -workers must be positive")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		return err
+	}
+	mismatches, err := os.OpenFile(filepath.Join(*outDir, "mismatches.jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer mismatches.Close()
+
+	reg := prometheus.NewRegistry()
+	v := &verifier{
+		target:     &rpcClient{url: *target, http: &http.Client{Timeout: *timeout}},
+		reference:  &rpcClient{url: *reference, http: &http.Client{Timeout: *timeout}},
+		window:     *window,
+		mismatches: mismatches,
+		metrics:    newVerifyMetrics(reg),
+		compared:   make(map[string]*verifyCount),
+		ignore:     make(map[string]bool),
+	}
+	for _, f := range strings.Split(*ignore, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			v.ignore[f] = true
+		}
+	}
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+		srv := &http.Server{Addr: *metricsAddr, Handler: mux}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("verify: metrics server: %v", err)
+			}
+		}()
+		defer srv.Close()
+	}
+
+	reqs := make(chan verifyRequest)
+	var wg sync.WaitGroup
+	for range *workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range reqs {
+				v.verify(ctx, req)
+			}
+		}()
+	}
+
+	log.Printf("verify: target=%s reference=%s rate=%.1f/s mismatches=%s", *target, *reference, *rate, mismatches.Name())
+	err = v.feed(ctx, reqs, *replay, time.Duration(float64(time.Second) / *rate))
+	close(reqs)
+	wg.Wait()
+	v.logSummary()
+	if err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// verifyRequest is one JSON-RPC call, as sent and as read from a -replay
+// file.
+type verifyRequest struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// verifyMismatchRecord is one line of mismatches.jsonl.
+type verifyMismatchRecord struct {
+	Time      time.Time       `json:"time"`
+	Outcome   string          `json:"outcome"`
+	Path      string          `json:"path,omitempty"` // first differing field of the results
+	Request   verifyRequest   `json:"request"`
+	Target    json.RawMessage `json:"target"`
+	Reference json.RawMessage `json:"reference"`
+}
+
+type verifyCount struct{ total, mismatched uint64 }
+
+type verifier struct {
+	target, reference *rpcClient
+	window            uint64
+	ignore            map[string]bool // object keys diffJSON skips
+	metrics           *verifyMetrics
+
+	mu         sync.Mutex // guards mismatches and compared
+	mismatches *os.File
+	compared   map[string]*verifyCount // by method
+}
+
+// feed sends the replayed requests, then sampled ones, to reqs at one
+// request per interval until ctx ends.
+func (v *verifier) feed(ctx context.Context, reqs chan<- verifyRequest, replay string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	send := func(req verifyRequest) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case reqs <- req:
+			return nil
+		}
+	}
+
+	if replay != "" {
+		n, err := v.feedReplay(replay, send)
+		if err != nil {
+			return fmt.Errorf("replay %s: %w", replay, err)
+		}
+		log.Printf("verify: replayed %d requests from %s", n, replay)
+	}
+	for {
+		round, err := v.sample(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("verify: sample: %v", err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		for _, req := range round {
+			if err := send(req); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// feedReplay sends the requests of a capture file. Lines that are not a
+// JSON-RPC request are skipped.
+func (v *verifier) feedReplay(path string, send func(verifyRequest) error) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 1<<20), 64<<20)
+	n := 0
+	for sc.Scan() {
+		var req verifyRequest
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil || req.Method == "" {
+			continue
+		}
+		if err := send(req); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, sc.Err()
+}
+
+// sample picks a block from the target and returns the requests derived
+// from it.
+func (v *verifier) sample(ctx context.Context) ([]verifyRequest, error) {
+	var headHex hexutil.Uint64
+	if err := v.target.callResult(ctx, "eth_blockNumber", nil, &headHex); err != nil {
+		return nil, err
+	}
+	head := uint64(headHex)
+	lo := uint64(1)
+	if v.window > 0 && head > v.window {
+		lo = head - v.window + 1
+	}
+	if head < lo {
+		return nil, fmt.Errorf("target head %d has no blocks to sample", head)
+	}
+	num := lo + rand.Uint64N(head-lo+1)
+	numHex := hexutil.EncodeUint64(num)
+
+	var block struct {
+		Hash         string `json:"hash"`
+		Transactions []struct {
+			Hash  string  `json:"hash"`
+			From  string  `json:"from"`
+			To    *string `json:"to"`
+			Input string  `json:"input"`
+			Value string  `json:"value"`
+			Gas   string  `json:"gas"`
+		} `json:"transactions"`
+	}
+	if err := v.target.callResult(ctx, "eth_getBlockByNumber", []any{numHex, true}, &block); err != nil {
+		return nil, fmt.Errorf("block %d: %w", num, err)
+	}
+
+	reqs := []verifyRequest{
+		newVerifyRequest("eth_getBlockByNumber", numHex, true),
+		newVerifyRequest("eth_getBlockByHash", block.Hash, false),
+		newVerifyRequest("eth_getLogs", map[string]any{"fromBlock": numHex, "toBlock": numHex}),
+	}
+	if len(block.Transactions) > 0 {
+		t := block.Transactions[rand.IntN(len(block.Transactions))]
+		reqs = append(reqs,
+			newVerifyRequest("eth_getTransactionByHash", t.Hash),
+			newVerifyRequest("eth_getTransactionReceipt", t.Hash),
+			newVerifyRequest("eth_getBalance", t.From, numHex),
+			newVerifyRequest("eth_getTransactionCount", t.From, numHex),
+		)
+		if t.To != nil {
+			parentHex := hexutil.EncodeUint64(num - 1)
+			reqs = append(reqs,
+				newVerifyRequest("eth_getBalance", *t.To, numHex),
+				newVerifyRequest("eth_getCode", *t.To, numHex),
+				newVerifyRequest("eth_call", map[string]any{
+					"from":  t.From,
+					"to":    *t.To,
+					"input": t.Input,
+					"value": t.Value,
+					"gas":   t.Gas,
+				}, parentHex),
+			)
+		}
+		var receipt struct {
+			Logs []struct {
+				Address string `json:"address"`
+			} `json:"logs"`
+		}
+		if err := v.target.callResult(ctx, "eth_getTransactionReceipt", []any{t.Hash}, &receipt); err == nil && len(receipt.Logs) > 0 {
+			l := receipt.Logs[rand.IntN(len(receipt.Logs))]
+			reqs = append(reqs, newVerifyRequest("eth_getLogs", map[string]any{
+				"fromBlock": numHex, "toBlock": numHex, "address": l.Address,
+			}))
+		}
+	}
+	if slot, ok := v.sampleSlot(ctx, numHex); ok {
+		reqs = append(reqs, slot)
+	}
+	return reqs, nil
+}
+
+// sampleSlot picks a storage slot the block changed, from the target's
+// debug_getStateDiff, and returns the eth_getStorageAt reading it. It
+// reports false if the target cannot say or the block changed no slot.
+// Multi-coin balance keys are left out: eth_getStorageAt normalizes the
+// key it is given, so they cannot be read back through it.
+func (v *verifier) sampleSlot(ctx context.Context, numHex string) (verifyRequest, bool) {
+	var diff map[string]struct {
+		Storage map[string]json.RawMessage `json:"storage"`
+	}
+	if err := v.target.callResult(ctx, "debug_getStateDiff", []any{numHex}, &diff); err != nil {
+		return verifyRequest{}, false
+	}
+	var candidates [][2]string
+	for addr, d := range diff {
+		for slot := range d.Storage {
+			if b, err := hexutil.Decode(slot); err == nil && len(b) == 32 && b[0]&0x01 == 0 {
+				candidates = append(candidates, [2]string{addr, slot})
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return verifyRequest{}, false
+	}
+	// Map order is random, but not uniformly; sort before picking.
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i][0]+candidates[i][1] < candidates[j][0]+candidates[j][1]
+	})
+	c := candidates[rand.IntN(len(candidates))]
+	return newVerifyRequest("eth_getStorageAt", c[0], c[1], numHex), true
+}
+
+func newVerifyRequest(method string, params ...any) verifyRequest {
+	req := verifyRequest{Method: method}
+	for _, p := range params {
+		raw, _ := json.Marshal(p)
+		req.Params = append(req.Params, raw)
+	}
+	return req
+}
+
+// verify sends req to both endpoints and records the outcome.
+func (v *verifier) verify(ctx context.Context, req verifyRequest) {
+	var (
+		wg                  sync.WaitGroup
+		targetResp, refResp *rpcResponse
+		targetErr, refErr   error
+	)
+	params := make([]any, len(req.Params))
+	for i, p := range req.Params {
+		params[i] = p
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		start := time.Now()
+		targetResp, targetErr = v.target.call(ctx, req.Method, params)
+		v.metrics.duration.WithLabelValues("target").Observe(time.Since(start).Seconds())
+	}()
+	go func() {
+		defer wg.Done()
+		start := time.Now()
+		refResp, refErr = v.reference.call(ctx, req.Method, params)
+		v.metrics.duration.WithLabelValues("reference").Observe(time.Since(start).Seconds())
+	}()
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	var outcome, path string
+	switch {
+	case targetErr != nil || refErr != nil:
+		outcome = verifyTransportError
+		log.Printf("verify: %s: target: %v reference: %v", req.Method, targetErr, refErr)
+	case targetResp.Error != nil && refResp.Error != nil:
+		outcome = verifyMatch
+	case refResp.Error != nil:
+		outcome = verifyReferenceError
+	case targetResp.Error != nil:
+		outcome = verifyTargetError
+	default:
+		var err error
+		path, err = diffJSON(targetResp.Result, refResp.Result, v.ignore)
+		switch {
+		case err != nil:
+			outcome, path = verifyMismatch, err.Error()
+		case path != "":
+			outcome = verifyMismatch
+		default:
+			outcome = verifyMatch
+		}
+	}
+	v.record(req, outcome, path, targetResp, refResp)
+}
+
+// record counts an outcome and writes mismatches and target errors out.
+func (v *verifier) record(req verifyRequest, outcome, path string, targetResp, refResp *rpcResponse) {
+	v.metrics.requests.WithLabelValues(req.Method, outcome).Inc()
+	if outcome == verifyTransportError || outcome == verifyReferenceError {
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c := v.compared[req.Method]
+	if c == nil {
+		c = &verifyCount{}
+		v.compared[req.Method] = c
+	}
+	c.total++
+	if outcome == verifyMatch {
+		v.metrics.ratio.WithLabelValues(req.Method).Set(float64(c.mismatched) / float64(c.total))
+		return
+	}
+	c.mismatched++
+	v.metrics.ratio.WithLabelValues(req.Method).Set(float64(c.mismatched) / float64(c.total))
+
+	rec := verifyMismatchRecord{
+		Time:      time.Now().UTC(),
+		Outcome:   outcome,
+		Path:      path,
+		Request:   req,
+		Target:    targetResp.raw,
+		Reference: refResp.raw,
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("verify: encode mismatch: %v", err)
+		return
+	}
+	if _, err := v.mismatches.Write(append(line, '\n')); err != nil {
+		log.Printf("verify: write mismatch: %v", err)
+	}
+	if path != "" {
+		log.Printf("verify: %s %s at %s", outcome, req.Method, path)
+	} else {
+		log.Printf("verify: %s %s", outcome, req.Method)
+	}
+}
+
+// logSummary logs the mismatch rate of every method compared.
+func (v *verifier) logSummary() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	methods := make([]string, 0, len(v.compared))
+	for m := range v.compared {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	for _, m := range methods {
+		c := v.compared[m]
+		log.Printf("verify: %-28s compared=%d mismatched=%d (%.3f%%)", m, c.total, c.mismatched, 100*float64(c.mismatched)/float64(c.total))
+	}
+}
+
+// diffJSON returns the path of the first value that differs between two
+// JSON results, "" if they are equal. Object keys only the target returns
+// are ignored, since clients differ in the optional fields they add; keys
+// only the reference returns are a difference. Numbers and strings compare
+// as written, so hex quantities must match exactly. Keys in ignore are
+// skipped at any depth.
+func diffJSON(target, reference json.RawMessage, ignore map[string]bool) (string, error) {
+	var t, r any
+	if err := json.Unmarshal(target, &t); err != nil {
+		return "", fmt.Errorf("decode target result: %w", err)
+	}
+	if err := json.Unmarshal(reference, &r); err != nil {
+		return "", fmt.Errorf("decode reference result: %w", err)
+	}
+	return diffValue(t, r, "$", ignore), nil
+}
+
+func diffValue(t, r any, path string, ignore map[string]bool) string {
+	switch rv := r.(type) {
+	case map[string]any:
+		tv, ok := t.(map[string]any)
+		if !ok {
+			return path
+		}
+		keys := make([]string, 0, len(rv))
+		for k := range rv {
+			if !ignore[k] {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			tval, ok := tv[k]
+			if !ok {
+				return path + "." + k
+			}
+			if p := diffValue(tval, rv[k], path+"."+k, ignore); p != "" {
+				return p
+			}
+		}
+		return ""
+	case []any:
+		tv, ok := t.([]any)
+		if !ok || len(tv) != len(rv) {
+			return path
+		}
+		for i := range rv {
+			if p := diffValue(tv[i], rv[i], path+"["+strconv.Itoa(i)+"]", ignore); p != "" {
+				return p
+			}
+		}
+		return ""
+	case string:
+		// Hex data is case-insensitive; checksummed addresses are not
+		// what is being verified.
+		if ts, ok := t.(string); ok && strings.HasPrefix(rv, "0x") && strings.EqualFold(ts, rv) {
+			return ""
+		}
+	}
+	if !reflect.DeepEqual(t, r) {
+		return path
+	}
+	return ""
+}
+
+// rpcClient is a minimal JSON-RPC 2.0 client over HTTP.
+type rpcClient struct {
+	url  string
+	http *http.Client
+}
+
+// rpcResponse is a decoded JSON-RPC response; raw is the body as received.
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	raw json.RawMessage
+}
+
+// call sends one request. A JSON-RPC error is returned in the response; the
+// error is for requests that got no JSON-RPC response at all.
+func (c *rpcClient) call(ctx context.Context, method string, params []any) (*rpcResponse, error) {
+	if params == nil {
+		params = []any{}
+	}
+	body, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	out := &rpcResponse{raw: raw}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return nil, fmt.Errorf("HTTP %d: %w", resp.StatusCode, err)
+	}
+	return out, nil
+}
+
+// callResult sends one request and decodes its result into out. JSON-RPC
+// errors and null results are errors.
+func (c *rpcClient) callResult(ctx context.Context, method string, params []any, out any) error {
+	resp, err := c.call(ctx, method, params)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s: %s (code %d)", method, resp.Error.Message, resp.Error.Code)
+	}
+	if len(resp.Result) == 0 || string(resp.Result) == "null" {
+		return fmt.Errorf("%s: no result", method)
+	}
+	return json.Unmarshal(resp.Result, out)
+}
+
+// verifyMetrics instruments the verify subcommand. The mismatch ratio
+// counts only requests both endpoints answered with a result or which the
+// target alone failed.
+type verifyMetrics struct {
+	requests *prometheus.CounterVec   // by method and outcome
+	ratio    *prometheus.GaugeVec     // by method
+	duration *prometheus.HistogramVec // by endpoint (target, reference)
+}
+
+func newVerifyMetrics(reg prometheus.Registerer) *verifyMetrics {
+	m := &verifyMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "block_fetcher",
+			Subsystem: "verify",
+			Name:      "requests_total",
+			Help:      "Requests sent to both endpoints, by method and outcome.",
+		}, []string{"method", "outcome"}),
+		ratio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "block_fetcher",
+			Subsystem: "verify",
+			Name:      "mismatch_ratio",
+			Help:      "Fraction of compared requests whose answers differed since the start.",
+		}, []string{"method"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "block_fetcher",
+			Subsystem: "verify",
+			Name:      "request_duration_seconds",
+			Help:      "Time one endpoint took to answer a request.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"endpoint"}),
+	}
+	reg.MustRegister(m.requests, m.ratio, m.duration)
+	return m
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// verifyTestServer answers each method with the raw JSON-RPC result or
+// error body in answers. Methods not in answers get a "method not found"
+// error.
+func verifyTestServer(t *testing.T, answers map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		answer, ok := answers[req.Method]
+		if !ok {
+			answer = `"error":{"code":-32601,"message":"method not found"}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,` + answer + `}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVerifyRecordsMismatches(t *testing.T) {
+	const refused = `"error":{"code":-32000,"message":"refused"}`
+	target := verifyTestServer(t, map[string]string{
+		// A head of 0 leaves nothing to sample, so only the replayed
+		// requests are verified.
+		"eth_blockNumber": `"result":"0x0"`,
+		// Differs only in an ignored field, the case of a hex string and
+		// a field the reference does not return.
+		"eth_getBlockByNumber":      `"result":{"hash":"0xABCD","blockExtraData":"0x01","extra":"0x02"}`,
+		"eth_getBalance":            `"result":"0x1"`,
+		"eth_getTransactionReceipt": `"result":{"status":"0x1","logs":[{"data":"0x"}]}`,
+		"eth_getCode":               `"result":"0x"`,
+		"eth_call":                  refused,
+		"eth_getStorageAt":          refused,
+	})
+	reference := verifyTestServer(t, map[string]string{
+		"eth_blockNumber":           `"result":"0x0"`,
+		"eth_getBlockByNumber":      `"result":{"hash":"0xabcd","blockExtraData":"0x03"}`,
+		"eth_getBalance":            `"result":"0x2"`,
+		"eth_getTransactionReceipt": `"result":{"status":"0x1","logs":[{"data":"0x01"}]}`,
+		"eth_getCode":               refused,
+		"eth_call":                  `"result":"0x"`,
+		"eth_getStorageAt":          refused,
+	})
+
+	dir := t.TempDir()
+	replay := filepath.Join(dir, "replay.jsonl")
+	var lines []string
+	for _, req := range []verifyRequest{
+		newVerifyRequest("eth_getBlockByNumber", "0x1", false),
+		newVerifyRequest("eth_getBalance", "0x01", "0x1"),
+		newVerifyRequest("eth_getTransactionReceipt", "0x02"),
+		newVerifyRequest("eth_getCode", "0x01", "0x1"),
+		newVerifyRequest("eth_call", map[string]any{"to": "0x01"}, "0x1"),
+		newVerifyRequest("eth_getStorageAt", "0x01", "0x0", "0x1"),
+	} {
+		line, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(line))
+	}
+	if err := os.WriteFile(replay, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out")
+	err := runVerify([]string{
+		"-target", target.URL,
+		"-reference", reference.URL,
+		"-out", out,
+		"-replay", replay,
+		"-rate", "1000",
+		"-workers", "1",
+		"-duration", "1s",
+		"-metrics-addr", "",
+		"-ignore-fields", "blockExtraData",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(out, "mismatches.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records := make(map[string]verifyMismatchRecord)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec verifyMismatchRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := records[rec.Request.Method]; ok {
+			t.Fatalf("%s recorded twice", rec.Request.Method)
+		}
+		records[rec.Request.Method] = rec
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The block differs only where it is not compared, the reference alone
+	// refused eth_getCode, and both refused eth_getStorageAt.
+	for _, method := range []string{"eth_getBlockByNumber", "eth_getCode", "eth_getStorageAt"} {
+		if rec, ok := records[method]; ok {
+			t.Errorf("%s recorded as %s at %q", method, rec.Outcome, rec.Path)
+		}
+	}
+	for _, want := range []struct {
+		method, outcome, path string
+		target, reference     string
+	}{
+		{"eth_getBalance", verifyMismatch, "$", `"0x1"`, `"0x2"`},
+		{"eth_getTransactionReceipt", verifyMismatch, "$.logs[0].data", `{"status":"0x1","logs":[{"data":"0x"}]}`, `{"status":"0x1","logs":[{"data":"0x01"}]}`},
+		{"eth_call", verifyTargetError, "", "", `"0x"`},
+	} {
+		rec, ok := records[want.method]
+		if !ok {
+			t.Errorf("%s not recorded", want.method)
+			continue
+		}
+		if rec.Outcome != want.outcome || rec.Path != want.path {
+			t.Errorf("%s recorded as %s at %q, want %s at %q", want.method, rec.Outcome, rec.Path, want.outcome, want.path)
+		}
+		checkVerifyTestResponse(t, want.method+" target", rec.Target, want.target)
+		checkVerifyTestResponse(t, want.method+" reference", rec.Reference, want.reference)
+	}
+	if len(records) != 3 {
+		t.Errorf("%d records, want 3", len(records))
+	}
+
+	// Without -ignore-fields the block would have been a mismatch.
+	path, err := diffJSON(json.RawMessage(`{"hash":"0xABCD","blockExtraData":"0x01"}`), json.RawMessage(`{"hash":"0xabcd","blockExtraData":"0x03"}`), nil)
+	if err != nil || path != "$.blockExtraData" {
+		t.Errorf("block without ignored fields differs at %q, %v", path, err)
+	}
+}
+
+func TestVerifyReferenceErrorsNotCounted(t *testing.T) {
+	mismatches, err := os.Create(filepath.Join(t.TempDir(), "mismatches.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mismatches.Close()
+	v := &verifier{
+		mismatches: mismatches,
+		metrics:    newVerifyMetrics(prometheus.NewRegistry()),
+		compared:   make(map[string]*verifyCount),
+	}
+	req := newVerifyRequest("eth_getCode", "0x01", "latest")
+	ok := &rpcResponse{Result: json.RawMessage(`"0x"`), raw: json.RawMessage(`{"result":"0x"}`)}
+
+	v.record(req, verifyReferenceError, "", ok, ok)
+	if c := v.compared[req.Method]; c != nil {
+		t.Fatalf("reference error counted: %+v", *c)
+	}
+	v.record(req, verifyMatch, "", ok, ok)
+	v.record(req, verifyReferenceError, "", ok, ok)
+	if c := v.compared[req.Method]; c == nil || c.total != 1 || c.mismatched != 0 {
+		t.Fatalf("counts %+v, want 1 compared", c)
+	}
+	if ratio := testutil.ToFloat64(v.metrics.ratio.WithLabelValues(req.Method)); ratio != 0 {
+		t.Fatalf("mismatch ratio %v, want 0", ratio)
+	}
+	if got := testutil.ToFloat64(v.metrics.requests.WithLabelValues(req.Method, verifyReferenceError)); got != 2 {
+		t.Fatalf("%v reference errors counted, want 2", got)
+	}
+}
+
+// checkVerifyTestResponse checks that raw is a whole JSON-RPC response with
+// result, or with an error if result is empty.
+func checkVerifyTestResponse(t *testing.T, name string, raw json.RawMessage, result string) {
+	t.Helper()
+	var resp struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Result  json.RawMessage `json:"result"`
+		Error   json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		t.Errorf("%s response %s: %v", name, raw, err)
+		return
+	}
+	switch {
+	case resp.JSONRPC != "2.0":
+		t.Errorf("%s response %s is not the whole response", name, raw)
+	case result == "" && resp.Error == nil:
+		t.Errorf("%s response %s has no error", name, raw)
+	case result != "" && string(resp.Result) != result:
+		t.Errorf("%s result %s, want %s", name, resp.Result, result)
+	}
+}